@@ -0,0 +1,133 @@
+// Package bytecode compiles lowered Clockwise programs to a compact
+// bytecode, reads and writes it as .cwb files, and runs it on a stack
+// machine. Values, operators and runtime helpers are shared with package
+// interp, so a program behaves the same compiled, interpreted or run here.
+package bytecode
+
+import (
+    "codeberg.org/clockwise-lang/clockwise/interp"
+    "codeberg.org/clockwise-lang/clockwise/ir"
+)
+
+// Op is an instruction opcode. An instruction is its opcode byte followed
+// by its operands: jump targets are 4-byte little-endian code offsets,
+// every other operand an unsigned varint.
+type Op byte
+
+const (
+    OpConst       Op = iota // k: push Consts[k]
+    OpLoad                  // slot: push a local
+    OpStore                 // slot: pop into a local
+    OpPop                   // discard the top of the stack
+    OpBinary                // op: pop y, x; push x op y (Operators[op])
+    OpUnary                 // op: pop x; push op x (Operators[op])
+    OpConvert               // t: pop an integer, push it converted to Types[t]
+    OpSome                  // t: pop x, push it wrapped in the optional Types[t]
+    OpIsNone                // pop an optional, push whether it is none
+    OpUnwrap                // pop an optional that is not none, push its value
+    OpInterp                // n: pop n values, push their string forms joined
+    OpJump                  // target
+    OpJumpFalse             // target: pop a bool, jump if false
+    OpJumpTrue              // target: pop a bool, jump if true
+    OpCall                  // f: call Funcs[f], push its result
+    OpNative                // n: pop the arguments, call Natives[n], push its result
+    OpDefer                 // f: schedule a call of Funcs[f] for function exit
+    OpDeferNative           // n: pop the arguments, schedule a call of Natives[n]
+    OpPanic                 // pop a string, panic with it
+    OpReturn                // pop the result and return
+    numOps
+)
+
+// operand kinds
+const (
+    varint = iota
+    target
+)
+
+var opInfo = [numOps]struct {
+    name     string
+    operands []int
+}{
+    OpConst:       {"const", []int{varint}},
+    OpLoad:        {"load", []int{varint}},
+    OpStore:       {"store", []int{varint}},
+    OpPop:         {"pop", nil},
+    OpBinary:      {"binary", []int{varint}},
+    OpUnary:       {"unary", []int{varint}},
+    OpConvert:     {"convert", []int{varint}},
+    OpSome:        {"some", []int{varint}},
+    OpIsNone:      {"isnone", nil},
+    OpUnwrap:      {"unwrap", nil},
+    OpInterp:      {"interp", []int{varint}},
+    OpJump:        {"jump", []int{target}},
+    OpJumpFalse:   {"jumpfalse", []int{target}},
+    OpJumpTrue:    {"jumptrue", []int{target}},
+    OpCall:        {"call", []int{varint}},
+    OpNative:      {"native", []int{varint}},
+    OpDefer:       {"defer", []int{varint}},
+    OpDeferNative: {"defernative", []int{varint}},
+    OpPanic:       {"panic", nil},
+    OpReturn:      {"return", nil},
+}
+
+func (op Op) String() string {
+    if op < numOps {
+        return opInfo[op].name
+    }
+    return "op?"
+}
+
+// Operators lists the operators of OpBinary and OpUnary by operand value.
+var Operators = []string{"+", "-", "*", "/", "%", "&", "|", "^", "<<", ">>", "==", "!=", "<", ">", "<=", ">=", "~"}
+
+// Program is a compiled program. Instructions refer to types, constants,
+// helpers and functions by their index in its tables.
+type Program struct {
+    Types   []ir.Type
+    Consts  []interp.Value
+    Natives []Native
+    Funcs   []*Func
+}
+
+// Native is a runtime helper called by the program, with its checked
+// signature.
+type Native struct {
+    Name   string
+    Params int
+    Result ir.Type
+    // Missing is the Go literal the helper returns for "no result" when
+    // Result is optional.
+    Missing string
+}
+
+// Func is a compiled function.
+type Func struct {
+    Name   string
+    File   string
+    Line   int
+    Result ir.Type
+    // Locals is the number of local variable slots.
+    Locals int
+    Code   []byte
+    // Lines maps code offsets to source lines: each entry gives the line
+    // of the instructions from its PC up to the next entry's.
+    Lines []Line
+}
+
+// Line is one entry of a function's line table.
+type Line struct {
+    PC   int
+    Line int
+}
+
+// LineAt returns the source line of the instruction at pc.
+func (f *Func) LineAt(pc int) int {
+    line := f.Line
+    for _, l := range f.Lines {
+        if l.PC > pc {
+            break
+        }
+        line = l.Line
+    }
+    return line
+}
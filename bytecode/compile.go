@@ -0,0 +1,315 @@
+package bytecode
+
+import (
+    "encoding/binary"
+    "fmt"
+
+    "codeberg.org/clockwise-lang/clockwise/checker"
+    "codeberg.org/clockwise-lang/clockwise/interp"
+    "codeberg.org/clockwise-lang/clockwise/ir"
+)
+
+type compiler struct {
+    prog    *Program
+    funcs   map[string]int
+    types   map[ir.Type]int
+    natives map[string]int
+    fn      *Func
+    slots   map[*ir.Local]int
+    // starts holds the code offset of each block placed so far; jumps the
+    // offsets of the jumps to patch once every block is placed
+    starts map[*ir.Block]int
+    jumps  map[int]*ir.Block
+    line   int
+}
+
+// Compile translates a lowered program to bytecode: every IR instruction
+// pushes its operands, operates on them and stores the result in the slot
+// of its destination. Only the runtime helpers package interp implements
+// can be called.
+func Compile(p *ir.Program) (*Program, error) {
+    c := &compiler{
+        prog:    &Program{},
+        funcs:   map[string]int{},
+        types:   map[ir.Type]int{},
+        natives: map[string]int{},
+    }
+    for i, fn := range p.Funcs {
+        c.funcs[fn.Name] = i
+        c.typeIndex(fn.Result)
+        c.prog.Funcs = append(c.prog.Funcs, &Func{Name: fn.Name, File: fn.File, Line: fn.Line, Result: fn.Result, Locals: len(fn.Locals)})
+    }
+    if _, ok := c.funcs["main"]; !ok {
+        return nil, fmt.Errorf("function main is not declared")
+    }
+    for i, fn := range p.Funcs {
+        if err := c.function(c.prog.Funcs[i], fn); err != nil {
+            return nil, fmt.Errorf("%s:%d: %v", fn.File, c.line, err)
+        }
+    }
+    return c.prog, nil
+}
+
+// function compiles the blocks of fn in order into f; a jump to the block
+// placed next is left out.
+func (c *compiler) function(f *Func, fn *ir.Func) error {
+    c.fn, c.line = f, fn.Line
+    c.slots = map[*ir.Local]int{}
+    for i, l := range fn.Locals {
+        c.slots[l] = i
+    }
+    c.starts = map[*ir.Block]int{}
+    c.jumps = map[int]*ir.Block{}
+    for i, b := range fn.Blocks {
+        c.starts[b] = len(f.Code)
+        for _, in := range b.Instrs {
+            c.at(in.SourceLine())
+            if err := c.instr(in); err != nil {
+                return err
+            }
+        }
+        var next *ir.Block
+        if i+1 < len(fn.Blocks) {
+            next = fn.Blocks[i+1]
+        }
+        c.at(b.Term.SourceLine())
+        if err := c.terminator(b.Term, next); err != nil {
+            return err
+        }
+    }
+    for pc, b := range c.jumps {
+        binary.LittleEndian.PutUint32(f.Code[pc+1:], uint32(c.starts[b]))
+    }
+    return nil
+}
+
+// at sets the source line of the instructions emitted next.
+func (c *compiler) at(line int) {
+    if line != 0 {
+        c.line = line
+    }
+}
+
+// emit appends an instruction and returns its offset.
+func (c *compiler) emit(op Op, args ...int) int {
+    f := c.fn
+    pc := len(f.Code)
+    if n := len(f.Lines); n == 0 || f.Lines[n-1].Line != c.line {
+        f.Lines = append(f.Lines, Line{PC: pc, Line: c.line})
+    }
+    f.Code = append(f.Code, byte(op))
+    for i, kind := range opInfo[op].operands {
+        if kind == target {
+            f.Code = binary.LittleEndian.AppendUint32(f.Code, uint32(args[i]))
+        } else {
+            f.Code = binary.AppendUvarint(f.Code, uint64(args[i]))
+        }
+    }
+    return pc
+}
+
+// jump emits a jump to the start of b, patched once b is placed.
+func (c *compiler) jump(op Op, b *ir.Block) {
+    c.jumps[c.emit(op, 0)] = b
+}
+
+func (c *compiler) typeIndex(t ir.Type) int {
+    if i, ok := c.types[t]; ok {
+        return i
+    }
+    c.types[t] = len(c.prog.Types)
+    c.prog.Types = append(c.prog.Types, t)
+    return c.types[t]
+}
+
+// load pushes v; equal constants share a pool entry.
+func (c *compiler) load(v ir.Value) error {
+    switch v := v.(type) {
+    case *ir.Local:
+        c.emit(OpLoad, c.slots[v])
+        return nil
+    case *ir.Const:
+        k := constValue(v)
+        for i, p := range c.prog.Consts {
+            if p == k {
+                c.emit(OpConst, i)
+                return nil
+            }
+        }
+        c.typeIndex(k.Type)
+        c.prog.Consts = append(c.prog.Consts, k)
+        c.emit(OpConst, len(c.prog.Consts)-1)
+        return nil
+    }
+    return fmt.Errorf("cannot load %T", v)
+}
+
+func (c *compiler) loadAll(vs ...ir.Value) error {
+    for _, v := range vs {
+        if err := c.load(v); err != nil {
+            return err
+        }
+    }
+    return nil
+}
+
+func constValue(k *ir.Const) interp.Value {
+    switch {
+    case k.Typ.IsOptional():
+        return interp.Value{Type: k.Typ}
+    case k.Typ == ir.String:
+        return interp.StringValue(k.Str)
+    case k.Typ == ir.Bool:
+        return interp.BoolValue(k.Bool)
+    }
+    return interp.ConstValue(k.Typ, k.Int)
+}
+
+func (c *compiler) operator(op string) (int, error) {
+    for i, o := range Operators {
+        if o == op {
+            return i, nil
+        }
+    }
+    return 0, fmt.Errorf("unknown operator %s", op)
+}
+
+// unary pushes x and applies op, with operands args, to it.
+func (c *compiler) unary(x ir.Value, op Op, args ...int) error {
+    if err := c.load(x); err != nil {
+        return err
+    }
+    c.emit(op, args...)
+    return nil
+}
+
+func (c *compiler) instr(in ir.Instr) error {
+    var dst *ir.Local
+    var err error
+    switch in := in.(type) {
+    case *ir.Copy:
+        dst, err = in.Dst, c.load(in.Src)
+    case *ir.BinOp:
+        op, err := c.operator(in.Op)
+        if err != nil {
+            return err
+        }
+        if err := c.loadAll(in.X, in.Y); err != nil {
+            return err
+        }
+        c.emit(OpBinary, op)
+        dst = in.Dst
+    case *ir.UnOp:
+        op, err := c.operator(in.Op)
+        if err != nil {
+            return err
+        }
+        if err := c.unary(in.X, OpUnary, op); err != nil {
+            return err
+        }
+        dst = in.Dst
+    case *ir.Convert:
+        dst, err = in.Dst, c.unary(in.X, OpConvert, c.typeIndex(in.Dst.Typ))
+    case *ir.Some:
+        dst, err = in.Dst, c.unary(in.X, OpSome, c.typeIndex(in.Dst.Typ))
+    case *ir.IsNone:
+        dst, err = in.Dst, c.unary(in.X, OpIsNone)
+    case *ir.Unwrap:
+        dst, err = in.Dst, c.unary(in.X, OpUnwrap)
+    case *ir.ToString:
+        dst, err = in.Dst, c.unary(in.X, OpInterp, 1)
+    case *ir.Concat:
+        if err := c.loadAll(in.Parts...); err != nil {
+            return err
+        }
+        c.emit(OpInterp, len(in.Parts))
+        dst = in.Dst
+    case *ir.Call:
+        if err := c.call(in, false); err != nil {
+            return err
+        }
+        if in.Dst == nil {
+            c.emit(OpPop)
+            return nil
+        }
+        dst = in.Dst
+    case *ir.Defer:
+        return c.call(in.Call, true)
+    default:
+        return fmt.Errorf("cannot compile instruction %T", in)
+    }
+    if err != nil {
+        return err
+    }
+    c.emit(OpStore, c.slots[dst])
+    return nil
+}
+
+// call pushes the arguments of a call of a function or runtime helper and
+// makes the call, or with deferred set schedules it.
+func (c *compiler) call(in *ir.Call, deferred bool) error {
+    if err := c.loadAll(in.Args...); err != nil {
+        return err
+    }
+    if f, ok := c.funcs[in.Func]; ok {
+        if deferred {
+            c.emit(OpDefer, f)
+        } else {
+            c.emit(OpCall, f)
+        }
+        return nil
+    }
+    b, ok := checker.LookupBuiltin(in.Func)
+    if _, native := interp.LookupNative(in.Func); !ok || !native {
+        return fmt.Errorf("runtime helper %s is not available to bytecode", in.Func)
+    }
+    n, ok := c.natives[in.Func]
+    if !ok {
+        n = len(c.prog.Natives)
+        c.natives[in.Func] = n
+        c.typeIndex(ir.Type(b.Result))
+        c.prog.Natives = append(c.prog.Natives, Native{Name: in.Func, Params: len(b.Params), Result: ir.Type(b.Result), Missing: b.Missing})
+    }
+    if deferred {
+        c.emit(OpDeferNative, n)
+    } else {
+        c.emit(OpNative, n)
+    }
+    return nil
+}
+
+// terminator compiles the end of a block that is followed by next.
+func (c *compiler) terminator(t ir.Terminator, next *ir.Block) error {
+    switch t := t.(type) {
+    case *ir.Jump:
+        if t.Target != next {
+            c.jump(OpJump, t.Target)
+        }
+    case *ir.Branch:
+        if err := c.load(t.Cond); err != nil {
+            return err
+        }
+        switch next {
+        case t.Then:
+            c.jump(OpJumpFalse, t.Else)
+        case t.Else:
+            c.jump(OpJumpTrue, t.Then)
+        default:
+            c.jump(OpJumpFalse, t.Else)
+            c.jump(OpJump, t.Then)
+        }
+    case *ir.Return:
+        if err := c.load(t.Value); err != nil {
+            return err
+        }
+        c.emit(OpReturn)
+    case *ir.Panic:
+        if err := c.load(t.Msg); err != nil {
+            return err
+        }
+        c.emit(OpPanic)
+    default:
+        return fmt.Errorf("cannot compile terminator %T", t)
+    }
+    return nil
+}
@@ -0,0 +1,61 @@
+package bytecode
+
+import (
+    "fmt"
+    "io"
+    "strconv"
+    "strings"
+)
+
+// Disassemble writes a listing of p: each function's header, then one line
+// per instruction with its offset, its source line where that changes, and
+// its operands annotated with what they refer to.
+func Disassemble(w io.Writer, p *Program) error {
+    for i, f := range p.Funcs {
+        if i > 0 {
+            fmt.Fprintln(w)
+        }
+        fmt.Fprintf(w, "func %s() -> %s  ; %s:%d, %d locals, %d bytes\n", f.Name, f.Result, f.File, f.Line, f.Locals, len(f.Code))
+        line := 0
+        for pc := 0; pc < len(f.Code); {
+            op, args, next, err := instr(f.Code, pc)
+            if err != nil {
+                return err
+            }
+            src := ""
+            if l := f.LineAt(pc); l != line {
+                line = l
+                src = strconv.Itoa(l)
+            }
+            listing := fmt.Sprintf("  %5d %5s  %-12s%s", pc, src, op, operands(p, op, args))
+            fmt.Fprintln(w, strings.TrimRight(listing, " "))
+            pc = next
+        }
+    }
+    return nil
+}
+
+func operands(p *Program, op Op, args []int) string {
+    switch op {
+    case OpConst:
+        k := p.Consts[args[0]]
+        v := k.String()
+        if k.Type == "string" {
+            v = strconv.Quote(v)
+        }
+        return fmt.Sprintf("#%d  ; %s %s", args[0], k.Type, v)
+    case OpBinary, OpUnary:
+        return Operators[args[0]]
+    case OpConvert, OpSome:
+        return string(p.Types[args[0]])
+    case OpCall, OpDefer:
+        return p.Funcs[args[0]].Name
+    case OpNative, OpDeferNative:
+        n := p.Natives[args[0]]
+        return fmt.Sprintf("%s  ; %d args -> %s", n.Name, n.Params, n.Result)
+    }
+    if len(args) > 0 {
+        return strconv.Itoa(args[0])
+    }
+    return ""
+}
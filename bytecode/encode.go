@@ -0,0 +1,297 @@
+package bytecode
+
+import (
+    "bufio"
+    "encoding/binary"
+    "errors"
+    "fmt"
+    "io"
+
+    "codeberg.org/clockwise-lang/clockwise/interp"
+    "codeberg.org/clockwise-lang/clockwise/ir"
+)
+
+// A .cwb file is the magic "CWB", a format version byte, then the tables of
+// a Program in order: types, constants, helpers and functions. Counts,
+// integers and indices are unsigned varints, strings are length-prefixed.
+const (
+    magic   = "CWB"
+    version = 1
+)
+
+// Encode writes p in the .cwb format.
+func Encode(w io.Writer, p *Program) error {
+    e := &encoder{w: bufio.NewWriter(w), types: map[ir.Type]int{}}
+    e.bytes([]byte(magic))
+    e.bytes([]byte{version})
+    e.uint(len(p.Types))
+    for i, t := range p.Types {
+        e.types[t] = i
+        e.string(string(t))
+    }
+    e.uint(len(p.Consts))
+    for _, k := range p.Consts {
+        e.typ(k.Type)
+        switch {
+        case k.Type == ir.String:
+            e.string(k.Str)
+        case k.Type == ir.Bool:
+            b := 0
+            if k.Bool {
+                b = 1
+            }
+            e.uint(b)
+        case k.Type.IsInteger():
+            e.buf = binary.AppendUvarint(e.buf[:0], k.Bits)
+            e.bytes(e.buf)
+        case k.Type.IsOptional() && k.Elem == nil:
+        default:
+            return fmt.Errorf("cannot encode constant of type %s", k.Type)
+        }
+    }
+    e.uint(len(p.Natives))
+    for _, n := range p.Natives {
+        e.string(n.Name)
+        e.uint(n.Params)
+        e.typ(n.Result)
+        e.string(n.Missing)
+    }
+    e.uint(len(p.Funcs))
+    for _, f := range p.Funcs {
+        e.string(f.Name)
+        e.string(f.File)
+        e.uint(f.Line)
+        e.typ(f.Result)
+        e.uint(f.Locals)
+        e.uint(len(f.Code))
+        e.bytes(f.Code)
+        e.uint(len(f.Lines))
+        for _, l := range f.Lines {
+            e.uint(l.PC)
+            e.uint(l.Line)
+        }
+    }
+    if e.err != nil {
+        return e.err
+    }
+    return e.w.Flush()
+}
+
+type encoder struct {
+    w     *bufio.Writer
+    types map[ir.Type]int
+    buf   []byte
+    err   error
+}
+
+func (e *encoder) bytes(b []byte) {
+    if e.err == nil {
+        _, e.err = e.w.Write(b)
+    }
+}
+
+func (e *encoder) uint(n int) {
+    e.buf = binary.AppendUvarint(e.buf[:0], uint64(n))
+    e.bytes(e.buf)
+}
+
+func (e *encoder) string(s string) {
+    e.uint(len(s))
+    e.bytes([]byte(s))
+}
+
+func (e *encoder) typ(t ir.Type) {
+    i, ok := e.types[t]
+    if !ok && e.err == nil {
+        e.err = fmt.Errorf("type %s is missing from the type table", t)
+    }
+    e.uint(i)
+}
+
+// Decode reads a program in the .cwb format and checks that its code is
+// well formed, so that running it cannot go out of bounds.
+func Decode(r io.Reader) (*Program, error) {
+    d := &decoder{r: bufio.NewReader(r)}
+    head := make([]byte, len(magic)+1)
+    if _, err := io.ReadFull(d.r, head); err != nil || string(head[:len(magic)]) != magic {
+        return nil, errors.New("not a Clockwise bytecode file")
+    }
+    if head[len(magic)] != version {
+        return nil, fmt.Errorf("unsupported bytecode version %d (want %d)", head[len(magic)], version)
+    }
+    p := &Program{}
+    for i, n := 0, d.count(); i < n; i++ {
+        p.Types = append(p.Types, ir.Type(d.string()))
+    }
+    for i, n := 0, d.count(); i < n; i++ {
+        k := interp.Value{Type: d.typ(p)}
+        switch {
+        case k.Type == ir.String:
+            k.Str = d.string()
+        case k.Type == ir.Bool:
+            k.Bool = d.uint() != 0
+        case k.Type.IsInteger():
+            k.Bits = d.uint64()
+        case k.Type.IsOptional():
+        default:
+            d.fail("constant of type %s", k.Type)
+        }
+        p.Consts = append(p.Consts, k)
+    }
+    for i, n := 0, d.count(); i < n; i++ {
+        p.Natives = append(p.Natives, Native{Name: d.string(), Params: d.uint(), Result: d.typ(p), Missing: d.string()})
+    }
+    for i, n := 0, d.count(); i < n; i++ {
+        f := &Func{Name: d.string(), File: d.string(), Line: d.uint(), Result: d.typ(p), Locals: d.uint()}
+        f.Code = make([]byte, d.count())
+        if d.err == nil {
+            _, d.err = io.ReadFull(d.r, f.Code)
+        }
+        for j, m := 0, d.count(); j < m; j++ {
+            f.Lines = append(f.Lines, Line{PC: d.uint(), Line: d.uint()})
+        }
+        p.Funcs = append(p.Funcs, f)
+    }
+    if d.err != nil {
+        return nil, fmt.Errorf("malformed bytecode file: %v", d.err)
+    }
+    for _, f := range p.Funcs {
+        if err := verify(p, f); err != nil {
+            return nil, fmt.Errorf("malformed bytecode in function %s: %v", f.Name, err)
+        }
+    }
+    return p, nil
+}
+
+type decoder struct {
+    r   *bufio.Reader
+    err error
+}
+
+func (d *decoder) fail(format string, args ...interface{}) {
+    if d.err == nil {
+        d.err = fmt.Errorf(format, args...)
+    }
+}
+
+func (d *decoder) uint64() uint64 {
+    if d.err != nil {
+        return 0
+    }
+    n, err := binary.ReadUvarint(d.r)
+    if err != nil {
+        d.err = err
+    }
+    return n
+}
+
+func (d *decoder) uint() int {
+    n := d.uint64()
+    if n > 1<<31 {
+        d.fail("value %d out of range", n)
+        return 0
+    }
+    return int(n)
+}
+
+// count reads a table or string length, which cannot exceed the data.
+func (d *decoder) count() int {
+    n := d.uint()
+    if n > 1<<24 {
+        d.fail("length %d out of range", n)
+        return 0
+    }
+    return n
+}
+
+func (d *decoder) string() string {
+    b := make([]byte, d.count())
+    if d.err == nil {
+        _, d.err = io.ReadFull(d.r, b)
+    }
+    return string(b)
+}
+
+func (d *decoder) typ(p *Program) ir.Type {
+    i := d.uint()
+    if i >= len(p.Types) {
+        d.fail("type index %d out of range", i)
+        return ""
+    }
+    return p.Types[i]
+}
+
+// instr decodes the instruction at pc and returns its operands and the
+// offset of the next instruction.
+func instr(code []byte, pc int) (Op, []int, int, error) {
+    op := Op(code[pc])
+    if op >= numOps {
+        return 0, nil, 0, fmt.Errorf("%d: invalid opcode %d", pc, op)
+    }
+    next := pc + 1
+    var args []int
+    for _, kind := range opInfo[op].operands {
+        if kind == target {
+            if next+4 > len(code) {
+                return 0, nil, 0, fmt.Errorf("%d: truncated instruction", pc)
+            }
+            args = append(args, int(binary.LittleEndian.Uint32(code[next:])))
+            next += 4
+            continue
+        }
+        n, size := binary.Uvarint(code[next:])
+        if size <= 0 || n > 1<<31 {
+            return 0, nil, 0, fmt.Errorf("%d: bad operand", pc)
+        }
+        args = append(args, int(n))
+        next += size
+    }
+    return op, args, next, nil
+}
+
+// verify checks that every instruction of f decodes and that its operands
+// index existing entries; jumps must land on an instruction, and the last
+// instruction must not continue past the end of the code.
+func verify(p *Program, f *Func) error {
+    starts := map[int]bool{}
+    var jumps []int
+    var last Op
+    for pc := 0; pc < len(f.Code); {
+        op, args, next, err := instr(f.Code, pc)
+        if err != nil {
+            return err
+        }
+        starts[pc] = true
+        last = op
+        limit := -1
+        switch op {
+        case OpConst:
+            limit = len(p.Consts)
+        case OpLoad, OpStore:
+            limit = f.Locals
+        case OpBinary, OpUnary:
+            limit = len(Operators)
+        case OpConvert, OpSome:
+            limit = len(p.Types)
+        case OpCall, OpDefer:
+            limit = len(p.Funcs)
+        case OpNative, OpDeferNative:
+            limit = len(p.Natives)
+        case OpJump, OpJumpFalse, OpJumpTrue:
+            jumps = append(jumps, args[0])
+        }
+        if limit >= 0 && args[0] >= limit {
+            return fmt.Errorf("%d: %s operand %d out of range", pc, op, args[0])
+        }
+        pc = next
+    }
+    for _, t := range jumps {
+        if !starts[t] {
+            return fmt.Errorf("jump to %d is not an instruction", t)
+        }
+    }
+    if len(f.Code) == 0 || (last != OpReturn && last != OpPanic && last != OpJump) {
+        return fmt.Errorf("code ends without a return")
+    }
+    return nil
+}
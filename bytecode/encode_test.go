@@ -0,0 +1,131 @@
+package bytecode
+
+import (
+    "bytes"
+    "strings"
+    "testing"
+
+    "codeberg.org/clockwise-lang/clockwise/checker"
+    "codeberg.org/clockwise-lang/clockwise/ir"
+    "codeberg.org/clockwise-lang/clockwise/lexer"
+    "codeberg.org/clockwise-lang/clockwise/parser"
+)
+
+// src exercises every table of the format: types, constants of each kind,
+// helpers with an optional result, and functions with jumps and defers.
+const src = `
+fn half() -> u8 {
+    return u8(84 / 2);
+}
+
+fn main() -> int {
+    var s: string = "cw" + "b";
+    var home: string? = GetEnv("CWB_TEST_UNSET");
+    var n: int = 3;
+    defer Trim(" done ");
+    while n > 5 {
+        Print("never");
+    }
+    if let h = home {
+        return 1;
+    }
+    if s == "cwb" {
+        return int(half()) - n + 3;
+    }
+    return 0;
+}
+`
+
+func compile(t *testing.T) *Program {
+    t.Helper()
+    prog, err := parser.New(lexer.New(src).Tokenize()).ParseProgram()
+    if err != nil {
+        t.Fatal(err)
+    }
+    if err := checker.CheckProgram(prog); err != nil {
+        t.Fatal(err)
+    }
+    lowered, err := ir.Lower(prog)
+    if err != nil {
+        t.Fatal(err)
+    }
+    p, err := Compile(lowered)
+    if err != nil {
+        t.Fatal(err)
+    }
+    return p
+}
+
+func encode(t *testing.T, p *Program) []byte {
+    t.Helper()
+    var buf bytes.Buffer
+    if err := Encode(&buf, p); err != nil {
+        t.Fatal(err)
+    }
+    return buf.Bytes()
+}
+
+func TestRoundTrip(t *testing.T) {
+    data := encode(t, compile(t))
+    p, err := Decode(bytes.NewReader(data))
+    if err != nil {
+        t.Fatal(err)
+    }
+    if again := encode(t, p); !bytes.Equal(again, data) {
+        t.Errorf("encoding the decoded program gave\n%x\nwant\n%x", again, data)
+    }
+    vm, err := New(p)
+    if err != nil {
+        t.Fatal(err)
+    }
+    status, err := vm.Run()
+    if err != nil {
+        t.Fatal(err)
+    }
+    if status != 42 {
+        t.Errorf("decoded program exited with %d, want 42", status)
+    }
+}
+
+func TestDecodeHeader(t *testing.T) {
+    data := encode(t, compile(t))
+    for name, tt := range map[string]struct {
+        data []byte
+        want string
+    }{
+        "empty":   {nil, "not a Clockwise bytecode file"},
+        "magic":   {append([]byte("CWX"), data[3:]...), "not a Clockwise bytecode file"},
+        "version": {append([]byte("CWB\x09"), data[4:]...), "unsupported bytecode version 9"},
+    } {
+        _, err := Decode(bytes.NewReader(tt.data))
+        if err == nil || !strings.Contains(err.Error(), tt.want) {
+            t.Errorf("%s: Decode error = %v, want %q", name, err, tt.want)
+        }
+    }
+}
+
+// TestDecodeCorrupt decodes every truncation of a valid file and the file
+// with each byte changed: Decode must return an error or a program that
+// passed verification, never panic.
+func TestDecodeCorrupt(t *testing.T) {
+    data := encode(t, compile(t))
+    for n := 0; n < len(data); n++ {
+        if _, err := Decode(bytes.NewReader(data[:n])); err == nil {
+            t.Errorf("Decode of the first %d of %d bytes succeeded", n, len(data))
+        }
+    }
+    for i := range data {
+        for _, b := range []byte{0x00, 0x7f, 0x80, 0xff, data[i] ^ 0x01} {
+            corrupt := append([]byte(nil), data...)
+            corrupt[i] = b
+            func() {
+                defer func() {
+                    if r := recover(); r != nil {
+                        t.Fatalf("Decode panicked with byte %d set to %#x: %v", i, b, r)
+                    }
+                }()
+                Decode(bytes.NewReader(corrupt))
+            }()
+        }
+    }
+}
@@ -0,0 +1,219 @@
+package bytecode
+
+import (
+    "encoding/binary"
+    "fmt"
+    "strings"
+
+    "codeberg.org/clockwise-lang/clockwise/interp"
+)
+
+// VM runs one bytecode program.
+type VM struct {
+    prog    *Program
+    natives []interp.Native
+    frames  []*frame
+}
+
+// frame is one active call; pc is the offset of the instruction being run.
+type frame struct {
+    fn     *Func
+    pc     int
+    defers []func() error
+}
+
+// New prepares p for running, binding its helpers to their Go
+// implementations.
+func New(p *Program) (*VM, error) {
+    vm := &VM{prog: p}
+    for _, n := range p.Natives {
+        f, ok := interp.LookupNative(n.Name)
+        if !ok {
+            return nil, fmt.Errorf("runtime helper %s is not available", n.Name)
+        }
+        vm.natives = append(vm.natives, f)
+    }
+    return vm, nil
+}
+
+// Run runs the program like interp.Interpreter.Run.
+func (vm *VM) Run() (status int, err error) {
+    for _, f := range vm.prog.Funcs {
+        if f.Name == "main" {
+            defer func() {
+                // only malformed code can fault the machine itself
+                if r := recover(); r != nil {
+                    status, err = 2, fmt.Errorf("bytecode fault: %v", r)
+                }
+            }()
+            v, err := vm.call(f)
+            if err != nil {
+                return 2, err
+            }
+            return int(v.Int()), nil
+        }
+    }
+    return 0, fmt.Errorf("function main is not declared")
+}
+
+// panic raises a Clockwise panic at the current instruction.
+func (vm *VM) panic(msg string) error {
+    p := &interp.Panic{Msg: msg}
+    for i := len(vm.frames) - 1; i >= 0; i-- {
+        f := vm.frames[i]
+        p.Trace = append(p.Trace, interp.Frame{Func: f.fn.Name, File: f.fn.File, Line: f.fn.LineAt(f.pc)})
+    }
+    return p
+}
+
+// result turns the error of an operation into a panic or fault at the
+// current instruction.
+func (vm *VM) result(v interp.Value, err error) (interp.Value, error) {
+    if re, ok := err.(interp.RuntimeError); ok {
+        return interp.Value{}, vm.panic(string(re))
+    } else if err != nil {
+        f := vm.frames[len(vm.frames)-1]
+        return interp.Value{}, fmt.Errorf("%s:%d: %v", f.fn.File, f.fn.LineAt(f.pc), err)
+    }
+    return v, nil
+}
+
+func (vm *VM) call(fn *Func) (interp.Value, error) {
+    if len(vm.frames) >= interp.MaxDepth {
+        return interp.Value{}, vm.panic("stack overflow")
+    }
+    f := &frame{fn: fn}
+    vm.frames = append(vm.frames, f)
+    ret, err := vm.exec(f)
+    // deferred calls run last-in, first-out, also when panicking; one that
+    // panics replaces the panic in flight
+    for i := len(f.defers) - 1; i >= 0; i-- {
+        if derr := f.defers[i](); derr != nil {
+            err = derr
+        }
+    }
+    vm.frames = vm.frames[:len(vm.frames)-1]
+    return ret, err
+}
+
+// callNative calls helper n with the top arguments of stack.
+func (vm *VM) callNative(n int, args []interp.Value) (interp.Value, error) {
+    h := vm.prog.Natives[n]
+    return vm.result(interp.CallNative(vm.natives[n], args, h.Result, h.Missing))
+}
+
+// exec runs the code of f's function until it returns.
+func (vm *VM) exec(f *frame) (interp.Value, error) {
+    code := f.fn.Code
+    locals := make([]interp.Value, f.fn.Locals)
+    var stack []interp.Value
+    pop := func() interp.Value {
+        v := stack[len(stack)-1]
+        stack = stack[:len(stack)-1]
+        return v
+    }
+    // popArgs removes the n top values, in push order
+    popArgs := func(n int) []interp.Value {
+        args := make([]interp.Value, n)
+        copy(args, stack[len(stack)-n:])
+        stack = stack[:len(stack)-n]
+        return args
+    }
+    for pc := 0; pc < len(code); {
+        f.pc = pc
+        op := Op(code[pc])
+        pc++
+        // every instruction has at most one operand
+        var a int
+        if ops := opInfo[op].operands; len(ops) > 0 {
+            if ops[0] == target {
+                a = int(binary.LittleEndian.Uint32(code[pc:]))
+                pc += 4
+            } else {
+                u, size := binary.Uvarint(code[pc:])
+                a = int(u)
+                pc += size
+            }
+        }
+        switch op {
+        case OpConst:
+            stack = append(stack, vm.prog.Consts[a])
+        case OpLoad:
+            stack = append(stack, locals[a])
+        case OpStore:
+            locals[a] = pop()
+        case OpPop:
+            pop()
+        case OpBinary:
+            y := pop()
+            v, err := vm.result(interp.Binary(Operators[a], pop(), y))
+            if err != nil {
+                return interp.Value{}, err
+            }
+            stack = append(stack, v)
+        case OpUnary:
+            v, err := vm.result(interp.Unary(Operators[a], pop()))
+            if err != nil {
+                return interp.Value{}, err
+            }
+            stack = append(stack, v)
+        case OpConvert:
+            stack = append(stack, interp.Convert(pop(), vm.prog.Types[a]))
+        case OpSome:
+            x := pop()
+            stack = append(stack, interp.Value{Type: vm.prog.Types[a], Elem: &x})
+        case OpIsNone:
+            stack = append(stack, interp.BoolValue(pop().Elem == nil))
+        case OpUnwrap:
+            stack = append(stack, *pop().Elem)
+        case OpInterp:
+            var sb strings.Builder
+            for _, v := range popArgs(a) {
+                sb.WriteString(v.String())
+            }
+            stack = append(stack, interp.StringValue(sb.String()))
+        case OpJump:
+            pc = a
+        case OpJumpFalse:
+            if !pop().Bool {
+                pc = a
+            }
+        case OpJumpTrue:
+            if pop().Bool {
+                pc = a
+            }
+        case OpCall:
+            v, err := vm.call(vm.prog.Funcs[a])
+            if err != nil {
+                return interp.Value{}, err
+            }
+            stack = append(stack, v)
+        case OpNative:
+            v, err := vm.callNative(a, popArgs(vm.prog.Natives[a].Params))
+            if err != nil {
+                return interp.Value{}, err
+            }
+            stack = append(stack, v)
+        case OpDefer:
+            fn := vm.prog.Funcs[a]
+            f.defers = append(f.defers, func() error {
+                _, err := vm.call(fn)
+                return err
+            })
+        case OpDeferNative:
+            args := popArgs(vm.prog.Natives[a].Params)
+            f.defers = append(f.defers, func() error {
+                _, err := vm.callNative(a, args)
+                return err
+            })
+        case OpPanic:
+            return interp.Value{}, vm.panic(pop().Str)
+        case OpReturn:
+            return pop(), nil
+        default:
+            return interp.Value{}, fmt.Errorf("%s: invalid opcode %d", f.fn.Name, op)
+        }
+    }
+    // Decode rejects code that can run off its end
+    return interp.Value{}, fmt.Errorf("%s: code ends without a return", f.fn.Name)
+}
@@ -0,0 +1,73 @@
+package checker
+
+// Builtin is the Clockwise-visible signature of a runtime helper.
+type Builtin struct {
+    Params []Type
+    Result Type
+    // Missing is the Go expression for the sentinel value a helper with an
+    // optional Result returns to mean "absent". Code generators turn that
+    // value into none.
+    Missing string
+}
+
+// builtins lists the runtime helpers whose signatures can be expressed with
+// the current type system. Calls to helpers missing from this table are not
+// checked and are assumed to return int. Helpers that report a missing
+// result through a sentinel value are declared with an optional Result.
+var builtins = map[string]Builtin{
+    "print":           {Params: []Type{TString}, Result: TInt},
+    "Print":           {Params: []Type{TString}, Result: TInt},
+    "Sconcat":         {Params: []Type{TString, TString}, Result: TString},
+    "Concat":          {Params: []Type{TString, TString}, Result: TString},
+    "ToUpper":         {Params: []Type{TString}, Result: TString},
+    "Trim":            {Params: []Type{TString}, Result: TString},
+    "Slice":           {Params: []Type{TString, TInt, TInt}, Result: TString},
+    "SplitFirstTwo":   {Params: []Type{TString, TString}, Result: TString},
+    "HexEncode":       {Params: []Type{TString}, Result: TString},
+    "HexDecode":       {Params: []Type{TString}, Result: TString},
+    "Base64Encode":    {Params: []Type{TString}, Result: TString},
+    "Base64Decode":    {Params: []Type{TString}, Result: "string?", Missing: `""`},
+    "UUIDv4":          {Result: TString},
+    "JSONEscape":      {Params: []Type{TString}, Result: TString},
+    "CRC32Hex":        {Params: []Type{TString}, Result: TString},
+    "SHA256Hex":       {Params: []Type{TString}, Result: TString},
+    "HMACSHA256":      {Params: []Type{TString, TString}, Result: TString},
+    "URLEncode":       {Params: []Type{TString}, Result: TString},
+    "URLDecode":       {Params: []Type{TString}, Result: TString},
+    "JoinURL":         {Params: []Type{TString, TString}, Result: TString},
+    "GzipBase64":      {Params: []Type{TString}, Result: TString},
+    "Gzip":            {Params: []Type{TString}, Result: TString},
+    "Gunzip":          {Params: []Type{TString}, Result: TString},
+    "HttpGet":         {Params: []Type{TString}, Result: "string?", Missing: `""`},
+    "HttpPost":        {Params: []Type{TString, TString}, Result: TString},
+    "LookupHost":      {Params: []Type{TString}, Result: TString},
+    "NowISO":          {Result: TString},
+    "ParseISO":        {Params: []Type{TString}, Result: TString},
+    "GetEnv":          {Params: []Type{TString}, Result: "string?", Missing: `""`},
+    "Getenv":          {Params: []Type{TString}, Result: TString},
+    "ParseINI":        {Params: []Type{TString, TString}, Result: "string?", Missing: `""`},
+    "RegexReplaceAll": {Params: []Type{TString, TString, TString}, Result: TString},
+    "BaseName":        {Params: []Type{TString}, Result: TString},
+    "DirName":         {Params: []Type{TString}, Result: TString},
+    "CreateTemp":      {Params: []Type{TString}, Result: TString},
+    "WriteTemp":       {Params: []Type{TString, TString}, Result: TString},
+    "Remove":          {Params: []Type{TString}, Result: TInt},
+    "Abs":             {Params: []Type{TInt}, Result: TInt},
+    "Min":             {Params: []Type{TInt, TInt}, Result: TInt},
+    "Max":             {Params: []Type{TInt, TInt}, Result: TInt},
+    "SumInts":         {Params: []Type{TInt, TInt}, Result: TInt},
+    "MeanInts":        {Params: []Type{TInt, TInt}, Result: TInt},
+    "RandInt":         {Params: []Type{TInt}, Result: TInt},
+    "FileSize":        {Params: []Type{TString}, Result: "i64?", Missing: "-1"},
+    "TimestampMs":     {Result: TI64},
+    "IsEmail":         {Params: []Type{TString}, Result: TBool},
+    "Exists":          {Params: []Type{TString}, Result: TBool},
+    "RegexMatch":      {Params: []Type{TString, TString}, Result: TBool},
+    "FormatISO":       {Params: []Type{TI64}, Result: TString},
+}
+
+// LookupBuiltin returns the signature of the named runtime helper.
+func LookupBuiltin(name string) (Builtin, bool) {
+    b, ok := builtins[name]
+    return b, ok
+}
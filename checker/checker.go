@@ -1,104 +1,595 @@
 package checker
 
 import (
+    "errors"
     "fmt"
+    "sort"
+    "strings"
     "codeberg.org/clockwise-lang/clockwise/parser"
 )
 
-// Very small type system: only 'int' and 'string' for now
+// Small type system: strings, booleans, int and the sized integer types,
+// plus an optional `T?` of each
 type Type string
 
 const (
     TInt    Type = "int"
     TString Type = "string"
+    TBool   Type = "bool"
+    TI8     Type = "i8"
+    TI16    Type = "i16"
+    TI32    Type = "i32"
+    TI64    Type = "i64"
+    TU8     Type = "u8"
+    TU16    Type = "u16"
+    TU32    Type = "u32"
+    TU64    Type = "u64"
+    // TNone is the type of the `none` literal; it converts to any optional.
+    TNone Type = "none"
 )
 
 func typeFromIdent(s string) (Type, error) {
+    if elem, ok := strings.CutSuffix(s, "?"); ok {
+        t, err := typeFromIdent(elem)
+        if err != nil {
+            return "", err
+        }
+        if isOptional(t) {
+            return "", fmt.Errorf("unknown type: %s (optionals cannot be nested)", s)
+        }
+        return optionalOf(t), nil
+    }
     switch s {
-    case "int":
-        return TInt, nil
     case "string":
         return TString, nil
-    default:
-        return "", fmt.Errorf("unknown type: %s", s)
+    case "bool":
+        return TBool, nil
+    case "byte":
+        return TU8, nil
+    case "rune":
+        return TI32, nil
+    }
+    if _, ok := intTypes[Type(s)]; ok {
+        return Type(s), nil
     }
+    return "", fmt.Errorf("unknown type: %s", s)
+}
+
+// ParseType resolves a type as written in the source, such as `u8`, `byte`
+// or `string?`, to its canonical name.
+func ParseType(s string) (Type, error) {
+    return typeFromIdent(s)
+}
+
+// isPrintable reports whether values of t can be embedded in an
+// interpolated string.
+func isPrintable(t Type) bool {
+    return t == TString || t == TBool || isInteger(t)
+}
+
+// checker holds the state used while checking a program: the declared
+// functions, the return type of the function being checked and a stack of
+// block scopes mapping variable names to types.
+type checker struct {
+    funcs  map[string]*parser.Function
+    retT   Type
+    scopes []map[string]Type
+    // at is the line ScopeAt asks about, and visible the variables in
+    // scope there
+    at      int
+    visible map[string]Type
 }
 
 // CheckProgram runs basic type checking and returns error if any
 func CheckProgram(p *parser.Program) error {
+    c := &checker{funcs: map[string]*parser.Function{}}
+    for _, fn := range p.Functions {
+        c.funcs[fn.Name] = fn
+    }
     for _, fn := range p.Functions {
-        if err := checkFunction(fn); err != nil {
+        if err := c.checkFunction(fn); err != nil {
             return fmt.Errorf("in function %s: %w", fn.Name, err)
         }
     }
     return nil
 }
 
-func checkFunction(fn *parser.Function) error {
-    // currently only verify return statements match declared return type
+// CheckStatement checks s as a statement outside any function, as typed at
+// an interactive prompt, against the functions of p and the session
+// variables in env. A variable it declares is added to env, replacing one
+// of the same name. For an expression statement the expression's type is
+// returned.
+func CheckStatement(p *parser.Program, env map[string]Type, s parser.Statement) (Type, error) {
+    c := &checker{funcs: map[string]*parser.Function{}}
+    for _, fn := range p.Functions {
+        c.funcs[fn.Name] = fn
+    }
+    c.scopes = []map[string]Type{env, {}}
+    switch st := s.(type) {
+    case *parser.ReturnStatement:
+        return "", fmt.Errorf("return outside a function")
+    case *parser.ExpressionStatement:
+        if call, ok := st.Expr.(*parser.CallExpression); !ok || !isPanic(call) {
+            return c.inferExprType(st.Expr)
+        }
+    }
+    if err := c.checkStatement(s); err != nil {
+        return "", err
+    }
+    for name, t := range c.scopes[1] {
+        env[name] = t
+    }
+    return "", nil
+}
+
+// CheckFunctions checks every function of p and returns the errors by
+// function. Unlike CheckProgram it does not stop at the first function that
+// fails, so an editor can report them all.
+func CheckFunctions(p *parser.Program) map[*parser.Function]error {
+    c := &checker{funcs: map[string]*parser.Function{}}
+    for _, fn := range p.Functions {
+        c.funcs[fn.Name] = fn
+    }
+    errs := map[*parser.Function]error{}
+    for _, fn := range p.Functions {
+        if err := c.checkFunction(fn); err != nil {
+            errs[fn] = err
+        }
+    }
+    return errs
+}
+
+// ScopeAt returns the variables visible on line of fn, one of the functions
+// of p, with their types. Statements that fail to check are skipped, and a
+// variable whose initializer fails keeps its declared type.
+func ScopeAt(p *parser.Program, fn *parser.Function, line int) map[string]Type {
+    c := &checker{funcs: map[string]*parser.Function{}, at: line, visible: map[string]Type{}}
+    for _, f := range p.Functions {
+        c.funcs[f.Name] = f
+    }
+    c.checkFunction(fn)
+    return c.visible
+}
+
+// mark records the variables in scope when a statement on line is reached
+// on the way to the line ScopeAt asks about.
+func (c *checker) mark(line int) {
+    if c.at == 0 || line > c.at {
+        return
+    }
+    c.visible = map[string]Type{}
+    for _, scope := range c.scopes {
+        for name, t := range scope {
+            c.visible[name] = t
+        }
+    }
+}
+
+// Builtins returns the names of the runtime helpers with known signatures,
+// sorted.
+func Builtins() []string {
+    names := make([]string, 0, len(builtins))
+    for name := range builtins {
+        names = append(names, name)
+    }
+    sort.Strings(names)
+    return names
+}
+
+func (c *checker) checkFunction(fn *parser.Function) error {
     retT, err := typeFromIdent(fn.ReturnType)
     if err != nil {
         return err
     }
-    for _, s := range fn.Body.Statements {
+    if fn.Export && fn.Name == "main" {
+        // the host starts main itself
+        return fmt.Errorf("main cannot be exported")
+    }
+    c.retT = retT
+    c.scopes = nil
+    if err := c.checkBlock(fn.Body); err != nil {
+        return err
+    }
+    if !terminates(fn.Body) {
+        return fmt.Errorf("missing return at end of function")
+    }
+    return nil
+}
+
+// terminates reports whether control cannot run off the end of b: one of
+// its statements returns or panics, or is an if with an else whose arms
+// both terminate. Conditions are not evaluated, so a while loop never
+// terminates, even `while true`.
+func terminates(b *parser.BlockStatement) bool {
+    if b == nil {
+        return false
+    }
+    for _, s := range b.Statements {
         switch st := s.(type) {
         case *parser.ReturnStatement:
-            t, err := inferExprType(st.Value)
-            if err != nil {
-                return err
-            }
-            if t != retT {
-                return fmt.Errorf("return type mismatch: want %s, got %s", retT, t)
+            return true
+        case *parser.ExpressionStatement:
+            if call, ok := st.Expr.(*parser.CallExpression); ok && isPanic(call) {
+                return true
             }
-        case *parser.VarStatement:
-            declared, err := typeFromIdent(st.Type)
-            if err != nil {
-                return err
+        case *parser.IfStatement:
+            if terminates(st.Consequent) && terminates(st.Alternative) {
+                return true
             }
-            t, err := inferExprType(st.Value)
-            if err != nil {
-                return err
+        case *parser.IfLetStatement:
+            if terminates(st.Consequent) && terminates(st.Alternative) {
+                return true
             }
-            if t != declared {
-                return fmt.Errorf("variable %s: type mismatch: declared %s but assigned %s", st.Name, declared, t)
+        }
+    }
+    return false
+}
+
+func (c *checker) pushScope() { c.scopes = append(c.scopes, map[string]Type{}) }
+func (c *checker) popScope()  { c.scopes = c.scopes[:len(c.scopes)-1] }
+
+// declare adds a variable to the innermost scope.
+func (c *checker) declare(name string, t Type) error {
+    scope := c.scopes[len(c.scopes)-1]
+    if _, exists := scope[name]; exists {
+        return fmt.Errorf("%s redeclared in this block", name)
+    }
+    scope[name] = t
+    return nil
+}
+
+// lookup resolves a variable from the innermost scope outwards.
+func (c *checker) lookup(name string) (Type, bool) {
+    for i := len(c.scopes) - 1; i >= 0; i-- {
+        if t, ok := c.scopes[i][name]; ok {
+            return t, true
+        }
+    }
+    return "", false
+}
+
+func (c *checker) checkBlock(b *parser.BlockStatement) error {
+    c.pushScope()
+    defer c.popScope()
+    for _, s := range b.Statements {
+        err := c.checkStatement(s)
+        if err == nil {
+            continue
+        }
+        if c.at > 0 {
+            if v, ok := s.(*parser.VarStatement); ok {
+                if t, err := typeFromIdent(v.Type); err == nil {
+                    c.scopes[len(c.scopes)-1][v.Name] = t
+                    c.mark(v.Line)
+                }
             }
+            continue
+        }
+        var le *LineError
+        if !errors.As(err, &le) {
+            err = &LineError{Line: parser.LineOf(s), Err: err}
         }
+        return err
     }
     return nil
 }
 
-func inferExprType(e parser.Expression) (Type, error) {
+func (c *checker) checkStatement(s parser.Statement) error {
+    c.mark(parser.LineOf(s))
+    switch st := s.(type) {
+    case *parser.ReturnStatement:
+        t, err := c.inferExprType(st.Value)
+        if err != nil {
+            return err
+        }
+        if st.Value, err = coerce(st.Value, t, c.retT); err != nil {
+            return fmt.Errorf("return type mismatch: %w", err)
+        }
+    case *parser.VarStatement:
+        declared, err := typeFromIdent(st.Type)
+        if err != nil {
+            return err
+        }
+        t, err := c.inferExprType(st.Value)
+        if err != nil {
+            return err
+        }
+        if st.Value, err = coerce(st.Value, t, declared); err != nil {
+            return fmt.Errorf("variable %s: %w", st.Name, err)
+        }
+        if err := c.declare(st.Name, declared); err != nil {
+            return err
+        }
+        c.mark(st.Line)
+    case *parser.ExpressionStatement:
+        if call, ok := st.Expr.(*parser.CallExpression); ok && isPanic(call) {
+            return c.checkPanic(call)
+        }
+        _, err := c.inferExprType(st.Expr)
+        return err
+    case *parser.AssertStatement:
+        if err := c.checkCondition(st.Condition); err != nil {
+            return fmt.Errorf("assert: %w", err)
+        }
+        if st.Message == nil {
+            return nil
+        }
+        t, err := c.inferExprType(st.Message)
+        if err != nil {
+            return err
+        }
+        if st.Message, err = coerce(st.Message, t, TString); err != nil {
+            return fmt.Errorf("assert message: %w", err)
+        }
+    case *parser.IfStatement:
+        if err := c.checkCondition(st.Condition); err != nil {
+            return err
+        }
+        return c.checkBranches(st.Consequent, st.Alternative)
+    case *parser.IfLetStatement:
+        t, err := c.inferExprType(st.Value)
+        if err != nil {
+            return err
+        }
+        if !isOptional(t) {
+            return fmt.Errorf("if let %s: value of type %s is not optional", st.Name, t)
+        }
+        c.pushScope()
+        c.declare(st.Name, elemType(t))
+        c.mark(st.Line)
+        err = c.checkBlock(st.Consequent)
+        c.popScope()
+        if err != nil {
+            return err
+        }
+        return c.checkBranches(nil, st.Alternative)
+    case *parser.WhileStatement:
+        if err := c.checkCondition(st.Condition); err != nil {
+            return err
+        }
+        return c.checkBlock(st.Body)
+    case *parser.DeferStatement:
+        call, ok := st.Call.(*parser.CallExpression)
+        if !ok {
+            return fmt.Errorf("defer requires a function call")
+        }
+        if to, ok := isConversion(call); ok {
+            return fmt.Errorf("defer requires a function call, not a conversion to %s", to)
+        }
+        _, err := c.inferCallType(call)
+        return err
+    }
+    return nil
+}
+
+// checkBranches checks the (possibly nil) blocks of an if statement.
+func (c *checker) checkBranches(blocks ...*parser.BlockStatement) error {
+    for _, b := range blocks {
+        if b == nil {
+            continue
+        }
+        if err := c.checkBlock(b); err != nil {
+            return err
+        }
+    }
+    return nil
+}
+
+func (c *checker) checkCondition(e parser.Expression) error {
+    t, err := c.inferExprType(e)
+    if err != nil {
+        return err
+    }
+    if t != TBool {
+        return fmt.Errorf("condition must be bool, got %s", t)
+    }
+    return nil
+}
+
+func (c *checker) inferExprType(e parser.Expression) (Type, error) {
+    // integer constant expressions are evaluated exactly; they default to
+    // int but only have to fit the type they are eventually used as, which
+    // coerce checks
+    if _, ok, err := constValue(e); err != nil {
+        return "", err
+    } else if ok {
+        return TInt, nil
+    }
     switch ex := e.(type) {
     case *parser.IntegerLiteral:
         return TInt, nil
     case *parser.StringLiteral:
         return TString, nil
+    case *parser.BooleanLiteral:
+        return TBool, nil
+    case *parser.NoneLiteral:
+        return TNone, nil
+    case *parser.InterpolatedString:
+        ex.PartTypes = make([]string, len(ex.Parts))
+        for i, part := range ex.Parts {
+            t, err := c.inferExprType(part)
+            if err != nil {
+                return "", err
+            }
+            if isOptional(t) {
+                return "", errUnwrap(t)
+            }
+            if !isPrintable(t) {
+                return "", fmt.Errorf("cannot interpolate value of type %s", t)
+            }
+            if _, err := coerce(part, t, t); err != nil {
+                return "", err
+            }
+            ex.PartTypes[i] = string(t)
+        }
+        return TString, nil
     case *parser.Identifier:
-        // identifiers assumed to be functions or variables; for now assume int
-        return TInt, nil
+        if t, ok := c.lookup(ex.Value); ok {
+            return t, nil
+        }
+        return "", fmt.Errorf("undefined: %s", ex.Value)
     case *parser.InfixExpression:
-        lt, err := inferExprType(ex.Left)
+        lt, err := c.inferExprType(ex.Left)
         if err != nil {
             return "", err
         }
-        rt, err := inferExprType(ex.Right)
+        rt, err := c.inferExprType(ex.Right)
         if err != nil {
             return "", err
         }
-        if lt != rt {
-            return "", fmt.Errorf("type mismatch in infix: left %s right %s", lt, rt)
+        for _, t := range []Type{lt, rt} {
+            if isOptional(t) {
+                return "", errUnwrap(t)
+            }
+            if t == TNone {
+                return "", fmt.Errorf("operator %s not defined on none", ex.Operator)
+            }
+        }
+        if ex.Operator == "<<" || ex.Operator == ">>" {
+            // the shift count may have any integer type
+            if !isInteger(lt) || !isInteger(rt) {
+                return "", fmt.Errorf("operator %s not defined on %s and %s", ex.Operator, lt, rt)
+            }
+            return lt, checkIntOperands(ex)
         }
-        // arithmetic operators produce int
-        return lt, nil
-    case *parser.CallExpression:
-        // special-case 'print' returns int 0 for now
-        if id, ok := ex.Function.(*parser.Identifier); ok {
-            if id.Value == "print" {
-                return TInt, nil
+        t, err := unifyOperands(ex, lt, rt)
+        if err != nil {
+            return "", err
+        }
+        switch ex.Operator {
+        case "==", "!=":
+            return TBool, nil
+        case "<", ">":
+            if t == TBool {
+                return "", fmt.Errorf("operator %s not defined on %s", ex.Operator, t)
+            }
+            return TBool, nil
+        case "+":
+            if t == TString {
+                return t, nil
             }
         }
-        return TInt, nil
+        if !isInteger(t) {
+            return "", fmt.Errorf("operator %s not defined on %s", ex.Operator, t)
+        }
+        // arithmetic operators produce the operand type
+        return t, checkIntOperands(ex)
+    case *parser.PrefixExpression:
+        t, err := c.inferExprType(ex.Right)
+        if err != nil {
+            return "", err
+        }
+        if isOptional(t) {
+            return "", errUnwrap(t)
+        }
+        if !isInteger(t) {
+            return "", fmt.Errorf("operator %s not defined on %s", ex.Operator, t)
+        }
+        return t, nil
+    case *parser.CoalesceExpression:
+        return c.inferCoalesceType(ex)
+    case *parser.CallExpression:
+        return c.inferCallType(ex)
     default:
         return "", fmt.Errorf("unknown expression type")
     }
 }
+
+// checkIntOperands rejects integer operations whose right operand is a
+// constant that is invalid regardless of the left operand: dividing by zero
+// and shifting by a negative count.
+func checkIntOperands(ex *parser.InfixExpression) error {
+    v, ok, err := constValue(ex.Right)
+    if err != nil || !ok {
+        return err
+    }
+    switch ex.Operator {
+    case "/", "%":
+        if v.Sign() == 0 {
+            return fmt.Errorf("division by zero")
+        }
+    case "<<", ">>":
+        if v.Sign() < 0 {
+            return fmt.Errorf("negative shift count %s", v)
+        }
+    }
+    return nil
+}
+
+// isPanic reports whether call invokes the panic builtin.
+func isPanic(call *parser.CallExpression) bool {
+    id, ok := call.Function.(*parser.Identifier)
+    return ok && id.Value == "panic"
+}
+
+// checkPanic checks a `panic(msg)` statement, which takes a single string.
+func (c *checker) checkPanic(call *parser.CallExpression) error {
+    if len(call.Args) != 1 {
+        return fmt.Errorf("panic expects 1 argument, got %d", len(call.Args))
+    }
+    t, err := c.inferExprType(call.Args[0])
+    if err != nil {
+        return err
+    }
+    if call.Args[0], err = coerce(call.Args[0], t, TString); err != nil {
+        return fmt.Errorf("argument 1 of panic: %w", err)
+    }
+    return nil
+}
+
+// inferCallType checks the arguments of a call and returns its result type.
+// Conversions such as `u8(x)` yield the named type. User-defined functions,
+// which take no arguments, and known runtime helpers are typed from their
+// declarations; other helpers are assumed to return int.
+func (c *checker) inferCallType(call *parser.CallExpression) (Type, error) {
+    argTypes := make([]Type, len(call.Args))
+    for i, a := range call.Args {
+        t, err := c.inferExprType(a)
+        if err != nil {
+            return "", err
+        }
+        argTypes[i] = t
+    }
+    if isPanic(call) {
+        return "", fmt.Errorf("panic does not produce a value; call it as a statement")
+    }
+    if to, ok := isConversion(call); ok {
+        if len(argTypes) != 1 || !isInteger(argTypes[0]) {
+            return "", fmt.Errorf("conversion to %s takes one integer argument", to)
+        }
+        if v, ok, _ := constValue(call.Args[0]); ok && !fitsType(v, to) {
+            return "", fmt.Errorf("constant %s overflows %s", v, to)
+        }
+        return to, nil
+    }
+    id, ok := call.Function.(*parser.Identifier)
+    if !ok {
+        return TInt, nil
+    }
+    if fn, ok := c.funcs[id.Value]; ok {
+        if len(call.Args) > 0 {
+            return "", fmt.Errorf("%s takes no arguments", id.Value)
+        }
+        return typeFromIdent(fn.ReturnType)
+    }
+    b, ok := LookupBuiltin(id.Value)
+    if !ok {
+        for _, t := range argTypes {
+            if isOptional(t) {
+                return "", errUnwrap(t)
+            }
+        }
+        return TInt, nil
+    }
+    if len(argTypes) != len(b.Params) {
+        return "", fmt.Errorf("%s expects %d arguments, got %d", id.Value, len(b.Params), len(argTypes))
+    }
+    for i, want := range b.Params {
+        arg, err := coerce(call.Args[i], argTypes[i], want)
+        if err != nil {
+            return "", fmt.Errorf("argument %d of %s: %w", i+1, id.Value, err)
+        }
+        call.Args[i] = arg
+    }
+    return b.Result, nil
+}
@@ -0,0 +1,97 @@
+package checker
+
+import (
+    "fmt"
+    "math/big"
+
+    "codeberg.org/clockwise-lang/clockwise/lexer"
+    "codeberg.org/clockwise-lang/clockwise/parser"
+)
+
+// maxConstShift bounds constant shift counts so a typo like `1 << 1000000`
+// cannot make the checker allocate huge integers.
+const maxConstShift = 1024
+
+// constValue evaluates e exactly if it is an integer constant expression:
+// literals combined with prefix and infix integer operators. The boolean
+// result is false for anything that depends on run-time values. Errors are
+// reported for constant expressions that cannot be evaluated, such as a
+// division by zero.
+func constValue(e parser.Expression) (*big.Int, bool, error) {
+    switch ex := e.(type) {
+    case *parser.IntegerLiteral:
+        v, ok := lexer.IntValue(ex.Value)
+        if !ok {
+            return nil, false, fmt.Errorf("malformed integer literal %s", ex.Value)
+        }
+        return v, true, nil
+    case *parser.PrefixExpression:
+        v, ok, err := constValue(ex.Right)
+        if !ok || err != nil {
+            return nil, false, err
+        }
+        switch ex.Operator {
+        case "-":
+            return new(big.Int).Neg(v), true, nil
+        case "~":
+            return new(big.Int).Not(v), true, nil
+        }
+    case *parser.InfixExpression:
+        l, lok, err := constValue(ex.Left)
+        if err != nil {
+            return nil, false, err
+        }
+        r, rok, err := constValue(ex.Right)
+        if err != nil || !lok || !rok {
+            return nil, false, err
+        }
+        return foldInt(ex.Operator, l, r)
+    }
+    return nil, false, nil
+}
+
+// ConstValue reports the exact value of an integer constant expression, as
+// the checker evaluates it. Optimization passes use it to fold constants.
+func ConstValue(e parser.Expression) (*big.Int, bool, error) {
+    return constValue(e)
+}
+
+// foldInt applies a binary integer operator to two constants. Division and
+// remainder truncate toward zero, matching the run-time behaviour.
+func foldInt(op string, l, r *big.Int) (*big.Int, bool, error) {
+    out := new(big.Int)
+    switch op {
+    case "+":
+        return out.Add(l, r), true, nil
+    case "-":
+        return out.Sub(l, r), true, nil
+    case "*":
+        return out.Mul(l, r), true, nil
+    case "/", "%":
+        if r.Sign() == 0 {
+            return nil, false, fmt.Errorf("division by zero")
+        }
+        if op == "/" {
+            return out.Quo(l, r), true, nil
+        }
+        return out.Rem(l, r), true, nil
+    case "&":
+        return out.And(l, r), true, nil
+    case "|":
+        return out.Or(l, r), true, nil
+    case "^":
+        return out.Xor(l, r), true, nil
+    case "<<", ">>":
+        if r.Sign() < 0 {
+            return nil, false, fmt.Errorf("negative shift count %s", r)
+        }
+        if !r.IsInt64() || r.Int64() > maxConstShift {
+            return nil, false, fmt.Errorf("shift count %s too large", r)
+        }
+        if op == "<<" {
+            return out.Lsh(l, uint(r.Int64())), true, nil
+        }
+        return out.Rsh(l, uint(r.Int64())), true, nil
+    }
+    return nil, false, nil
+}
@@ -1,7 +1,7 @@
 package checker
 
 import (
-    "fmt"
+    "errors"
     "codeberg.org/clockwise-lang/clockwise/parser"
 )
 
@@ -21,8 +21,20 @@ func ReportError(d Diagnostics) error {
         return nil
     }
     // return first error for simplicity
-    return fmt.Errorf(d[0].Msg)
+    return errors.New(d[0].Msg)
 }
 
 // Diagnostics is a slice of Diagnostic
 type Diagnostics []Diagnostic
+
+// LineError is an error found checking the statement on Line. Its message
+// is that of Err alone; tools that place diagnostics recover the line with
+// errors.As.
+type LineError struct {
+    Line int
+    Err  error
+}
+
+func (e *LineError) Error() string { return e.Err.Error() }
+
+func (e *LineError) Unwrap() error { return e.Err }
@@ -0,0 +1,138 @@
+package checker
+
+import (
+    "fmt"
+    "math/big"
+
+    "codeberg.org/clockwise-lang/clockwise/parser"
+)
+
+// intType describes the representation of an integer type.
+type intType struct {
+    signed bool
+    bits   uint
+}
+
+// intTypes lists the integer types. `byte` and `rune` are aliases for u8
+// and i32 and are resolved by typeFromIdent.
+var intTypes = map[Type]intType{
+    TInt: {signed: true, bits: 64},
+    TI8:  {signed: true, bits: 8},
+    TI16: {signed: true, bits: 16},
+    TI32: {signed: true, bits: 32},
+    TI64: {signed: true, bits: 64},
+    TU8:  {bits: 8},
+    TU16: {bits: 16},
+    TU32: {bits: 32},
+    TU64: {bits: 64},
+}
+
+func isInteger(t Type) bool {
+    _, ok := intTypes[t]
+    return ok
+}
+
+// fitsType reports whether the constant v is representable in integer type t.
+func fitsType(v *big.Int, t Type) bool {
+    it := intTypes[t]
+    if it.signed {
+        limit := new(big.Int).Lsh(big.NewInt(1), it.bits-1)
+        return v.Cmp(new(big.Int).Neg(limit)) >= 0 && v.Cmp(limit) < 0
+    }
+    limit := new(big.Int).Lsh(big.NewInt(1), it.bits)
+    return v.Sign() >= 0 && v.Cmp(limit) < 0
+}
+
+// widens reports whether every value of integer type from is representable
+// in integer type to, so the conversion may happen implicitly.
+func widens(from, to Type) bool {
+    f, t := intTypes[from], intTypes[to]
+    if f.signed == t.signed {
+        return t.bits >= f.bits
+    }
+    return !f.signed && t.signed && t.bits > f.bits
+}
+
+// isConversion reports whether call converts its argument to an integer
+// type, e.g. `u8(x)`.
+func isConversion(call *parser.CallExpression) (Type, bool) {
+    id, ok := call.Function.(*parser.Identifier)
+    if !ok {
+        return "", false
+    }
+    t, err := typeFromIdent(id.Value)
+    if err != nil || !isInteger(t) {
+        return "", false
+    }
+    return t, true
+}
+
+// IsConversion reports whether call is an integer conversion such as
+// `u8(x)` rather than a function call.
+func IsConversion(call *parser.CallExpression) bool {
+    _, ok := isConversion(call)
+    return ok
+}
+
+// coerce checks that e, of type from, may be used where a value of type to
+// is expected and returns the expression to use in its place. Integer
+// constants adopt the target type if they fit; other integer values are
+// widened with an explicit conversion so the generated code type-checks.
+// Narrowing must be spelled out by the programmer. Optionals are handled by
+// coerceOptional.
+func coerce(e parser.Expression, from, to Type) (parser.Expression, error) {
+    if isOptional(to) {
+        return coerceOptional(e, from, to)
+    }
+    if isOptional(from) {
+        return nil, errUnwrap(from)
+    }
+    if from == TNone {
+        return nil, fmt.Errorf("cannot use none as %s; only optional types can be none", to)
+    }
+    if isInteger(to) {
+        v, ok, err := constValue(e)
+        if err != nil {
+            return nil, err
+        }
+        if ok {
+            if !fitsType(v, to) {
+                return nil, fmt.Errorf("constant %s overflows %s", v, to)
+            }
+            return e, nil
+        }
+    }
+    if from == to {
+        return e, nil
+    }
+    if !isInteger(from) || !isInteger(to) {
+        return nil, fmt.Errorf("cannot use %s value as %s", from, to)
+    }
+    if !widens(from, to) {
+        return nil, fmt.Errorf("cannot use %s value as %s without an explicit conversion %s(...)", from, to, to)
+    }
+    return &parser.CallExpression{Function: &parser.Identifier{Value: string(to)}, Args: []parser.Expression{e}}, nil
+}
+
+// unifyOperands returns the type of a binary integer or string operation.
+// A constant operand adopts the type of the other operand when it fits.
+func unifyOperands(ex *parser.InfixExpression, lt, rt Type) (Type, error) {
+    if lt == rt {
+        return lt, nil
+    }
+    if isInteger(lt) && isInteger(rt) {
+        if _, ok, _ := constValue(ex.Left); ok {
+            if _, err := coerce(ex.Left, lt, rt); err != nil {
+                return "", err
+            }
+            return rt, nil
+        }
+        if _, ok, _ := constValue(ex.Right); ok {
+            if _, err := coerce(ex.Right, rt, lt); err != nil {
+                return "", err
+            }
+            return lt, nil
+        }
+    }
+    return "", fmt.Errorf("type mismatch in infix: left %s right %s", lt, rt)
+}
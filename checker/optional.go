@@ -0,0 +1,71 @@
+package checker
+
+import (
+    "fmt"
+    "strings"
+
+    "codeberg.org/clockwise-lang/clockwise/parser"
+)
+
+func isOptional(t Type) bool {
+    return strings.HasSuffix(string(t), "?")
+}
+
+func optionalOf(t Type) Type {
+    return t + "?"
+}
+
+// elemType returns T for an optional T?.
+func elemType(t Type) Type {
+    return Type(strings.TrimSuffix(string(t), "?"))
+}
+
+// errUnwrap is reported when an optional value is used where its contained
+// value is required.
+func errUnwrap(t Type) error {
+    return fmt.Errorf("value of type %s must be unwrapped with `if let` or `??` before use", t)
+}
+
+// coerceOptional converts e, of type from, to the optional type to. `none`
+// and values that are already of type to are used as they are; a plain
+// value is coerced to the contained type and then wrapped in a conversion
+// call to the optional type, which code generators lower to "some value".
+func coerceOptional(e parser.Expression, from, to Type) (parser.Expression, error) {
+    if from == TNone || from == to {
+        return e, nil
+    }
+    if isOptional(from) {
+        return nil, fmt.Errorf("cannot use %s value as %s", from, to)
+    }
+    inner, err := coerce(e, from, elemType(to))
+    if err != nil {
+        return nil, err
+    }
+    return &parser.CallExpression{Function: &parser.Identifier{Value: string(to)}, Args: []parser.Expression{inner}}, nil
+}
+
+// inferCoalesceType checks `left ?? right`. The left operand must be
+// optional; the result is the contained type, or stays optional when the
+// default is itself optional.
+func (c *checker) inferCoalesceType(ex *parser.CoalesceExpression) (Type, error) {
+    lt, err := c.inferExprType(ex.Left)
+    if err != nil {
+        return "", err
+    }
+    if !isOptional(lt) {
+        return "", fmt.Errorf("left operand of ?? must be optional, got %s", lt)
+    }
+    rt, err := c.inferExprType(ex.Right)
+    if err != nil {
+        return "", err
+    }
+    result := elemType(lt)
+    if isOptional(rt) || rt == TNone {
+        result = lt
+    }
+    if ex.Right, err = coerce(ex.Right, rt, result); err != nil {
+        return "", fmt.Errorf("right operand of ??: %w", err)
+    }
+    ex.Type = string(result)
+    return result, nil
+}
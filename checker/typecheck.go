@@ -28,7 +28,7 @@ func RunChecks(p *parser.Program) error {
         if f.Body != nil {
             for _, s := range f.Body.Statements {
                 if vs, ok := s.(*parser.VarStatement); ok {
-                    if vs.Type != "" && vs.Type != "int" && vs.Type != "string" {
+                    if _, err := typeFromIdent(vs.Type); vs.Type != "" && err != nil {
                         diags = append(diags, Diagnostic{Msg: fmt.Sprintf("unsupported var type '%s' for %s", vs.Type, vs.Name), Node: f})
                     }
                     st.RegisterVar(f.Name, vs.Name, vs.Type)
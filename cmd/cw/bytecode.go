@@ -0,0 +1,148 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"codeberg.org/clockwise-lang/clockwise/bytecode"
+	"codeberg.org/clockwise-lang/clockwise/interp"
+)
+
+// compileCmd compiles Clockwise sources to a .cwb bytecode file.
+func compileCmd() {
+	fs := flag.NewFlagSet("compile", flag.ExitOnError)
+	outputFile := fs.String("o", "", "Output file (default: input filename with .cwb)")
+	verbose := fs.Bool("v", false, "Enable verbose output")
+	toBytecode := fs.Bool("bytecode", false, "Compile to a .cwb bytecode file for 'cwc exec'")
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: cwc compile --bytecode [-o output.cwb] [input1.cw input2.cw ...]\n")
+		fmt.Fprintf(os.Stderr, "  If multiple input files are provided, they will be compiled together.\n")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(os.Args[2:]); err != nil {
+		log.Fatalf("Error parsing flags: %v", err)
+	}
+	if fs.NArg() == 0 {
+		fs.Usage()
+		os.Exit(1)
+	}
+	if !*toBytecode {
+		log.Fatal("cwc compile needs --bytecode; use 'cwc build' for executables")
+	}
+	inputFiles := fs.Args()
+	if *outputFile == "" {
+		if len(inputFiles) == 1 {
+			*outputFile = strings.TrimSuffix(inputFiles[0], filepath.Ext(inputFiles[0])) + ".cwb"
+		} else {
+			*outputFile = "program.cwb"
+		}
+	}
+
+	prog, err := compileBytecode(inputFiles, *verbose)
+	if err != nil {
+		log.Fatalf("Compilation failed: %v", err)
+	}
+	var buf bytes.Buffer
+	if err := bytecode.Encode(&buf, prog); err != nil {
+		log.Fatalf("Compilation failed: %v", err)
+	}
+	if err := os.WriteFile(*outputFile, buf.Bytes(), 0644); err != nil {
+		log.Fatalf("Failed to write output file: %v", err)
+	}
+	if *verbose {
+		fmt.Printf("Successfully compiled %d files to %s (%d bytes)\n", len(inputFiles), *outputFile, buf.Len())
+	}
+}
+
+// compileBytecode checks and lowers the input files like a build and
+// compiles them to bytecode.
+// Functions are named by the source paths as given, so a .cwb file reports
+// the same locations wherever it runs.
+func compileBytecode(inputFiles []string, verbose bool) (*bytecode.Program, error) {
+	comp := newCompiler(inputFiles, "")
+	comp.Verbose = verbose
+	comp.Reproducible = true
+	program, err := comp.Lower()
+	if err != nil {
+		return nil, err
+	}
+	return bytecode.Compile(program)
+}
+
+// loadBytecode reads a .cwb file, or compiles a .cw source.
+func loadBytecode(path string) (*bytecode.Program, error) {
+	if strings.HasSuffix(path, ".cw") {
+		return compileBytecode([]string{path}, false)
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	prog, err := bytecode.Decode(f)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return prog, nil
+}
+
+// execCmd runs a .cwb file. Like the executable of the program, it exits
+// with main's result, or reports a panic and exits with status 2.
+func execCmd() {
+	fs := flag.NewFlagSet("exec", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: cwc exec program.cwb [-- program args...]\n")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(os.Args[2:]); err != nil {
+		log.Fatalf("Error parsing flags: %v", err)
+	}
+	if fs.NArg() == 0 {
+		fs.Usage()
+		os.Exit(1)
+	}
+	prog, err := loadBytecode(fs.Arg(0))
+	if err != nil {
+		log.Fatal(err)
+	}
+	vm, err := bytecode.New(prog)
+	if err != nil {
+		log.Fatal(err)
+	}
+	status, err := vm.Run()
+	if p, ok := err.(*interp.Panic); ok {
+		fmt.Fprintln(os.Stderr, p)
+	} else if err != nil {
+		log.Fatalf("Bytecode error: %v", err)
+	}
+	os.Exit(status & 0xff)
+}
+
+// disasmCmd prints a listing of a .cwb file, or of the bytecode a .cw
+// source compiles to.
+func disasmCmd() {
+	fs := flag.NewFlagSet("disasm", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: cwc disasm program.cwb|program.cw\n")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(os.Args[2:]); err != nil {
+		log.Fatalf("Error parsing flags: %v", err)
+	}
+	if fs.NArg() != 1 {
+		fs.Usage()
+		os.Exit(1)
+	}
+	prog, err := loadBytecode(fs.Arg(0))
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := bytecode.Disassemble(os.Stdout, prog); err != nil {
+		log.Fatal(err)
+	}
+}
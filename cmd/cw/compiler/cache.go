@@ -0,0 +1,206 @@
+package compiler
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+	"time"
+)
+
+// cacheFormat is mixed into every key; bump it when the cache layout or the
+// build procedure changes in a way that invalidates existing entries.
+const cacheFormat = "clockwise-build-v1"
+
+// staleLock is how long a work directory lock may be held before it is
+// assumed to belong to a crashed build.
+const staleLock = 10 * time.Minute
+
+// DefaultCacheDir returns $XDG_CACHE_HOME/clockwise, falling back to the
+// platform's user cache directory.
+func DefaultCacheDir() (string, error) {
+	if dir := os.Getenv("XDG_CACHE_HOME"); dir != "" {
+		return filepath.Join(dir, "clockwise"), nil
+	}
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "clockwise"), nil
+}
+
+// CleanCache removes the build cache at dir and reports how many bytes were
+// freed.
+func CleanCache(dir string) (int64, error) {
+	var size int64
+	filepath.Walk(dir, func(_ string, info os.FileInfo, err error) error {
+		if err == nil && !info.IsDir() {
+			size += info.Size()
+		}
+		return nil
+	})
+	if err := os.RemoveAll(dir); err != nil {
+		return 0, err
+	}
+	return size, nil
+}
+
+// targetPair returns the os/arch the executable is built for: Target, or the
+// GOOS/GOARCH environment, or the host.
+func (c *Compiler) targetPair() string {
+	if c.Target != "" {
+		return c.Target
+	}
+	goos, goarch := os.Getenv("GOOS"), os.Getenv("GOARCH")
+	if goos == "" {
+		goos = runtime.GOOS
+	}
+	if goarch == "" {
+		goarch = runtime.GOARCH
+	}
+	return goos + "/" + goarch
+}
+
+// cacheKey hashes everything the built executable depends on: the compiler
+// version, the target, the build settings, the .cw inputs sorted by path (with
+// their absolute paths) and every runtime library source. Development builds share
+// a version string, so for them the cwc executable itself is hashed too.
+func (c *Compiler) cacheKey(runtimeDir string) (string, error) {
+	h := sha256.New()
+	fmt.Fprintf(h, "%s\x00%s\x00%s\x00%s\x00%s\x00", cacheFormat, c.Version, c.Commit, c.targetPair(), cgoEnabled())
+	fmt.Fprintf(h, "reproducible=%t opt=%d strip=%t debug=%t\x00", c.Reproducible, c.OptLevel, c.Strip, c.Debug)
+	if c.Version == "" || strings.HasPrefix(c.Version, "dev") {
+		if exe, err := os.Executable(); err == nil {
+			if err := hashFile(h, exe); err != nil {
+				return "", err
+			}
+		}
+	}
+	for _, in := range c.sortedInputs() {
+		// the absolute path is embedded in line directives
+		abs, err := filepath.Abs(in)
+		if err != nil {
+			return "", err
+		}
+		fmt.Fprintf(h, "input %s\x00", abs)
+		if err := hashFile(h, in); err != nil {
+			return "", err
+		}
+	}
+	libs, err := filepath.Glob(filepath.Join(runtimeDir, "*", "*.go"))
+	if err != nil {
+		return "", err
+	}
+	sort.Strings(libs)
+	for _, lib := range libs {
+		rel, _ := filepath.Rel(runtimeDir, lib)
+		fmt.Fprintf(h, "runtime %s\x00", filepath.ToSlash(rel))
+		if err := hashFile(h, lib); err != nil {
+			return "", err
+		}
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+func hashFile(w io.Writer, path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = io.Copy(w, f)
+	return err
+}
+
+// cachedBinary returns the cache path for key.
+func (c *Compiler) cachedBinary(key string) string {
+	return filepath.Join(c.CacheDir, "bin", key[:2], key)
+}
+
+// storeBinary copies a freshly built executable into the cache. The copy is
+// renamed into place so concurrent builds never see a partial file.
+func (c *Compiler) storeBinary(key, built string) error {
+	dst := c.cachedBinary(key)
+	if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+		return err
+	}
+	tmp := fmt.Sprintf("%s.tmp%d", dst, os.Getpid())
+	if err := copyExecutable(built, tmp); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Rename(tmp, dst)
+}
+
+func copyExecutable(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+		return err
+	}
+	out, err := os.OpenFile(dst, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0755)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+// workDir returns the directory the Go module is built in, and a release
+// function. With a cache the same directory is reused between builds so the
+// Go build cache stays warm; it is guarded by a lock file, and a concurrent
+// build falls back to a fresh temporary directory.
+func (c *Compiler) workDir() (string, func(), error) {
+	if c.CacheDir != "" {
+		dir := filepath.Join(c.CacheDir, "work")
+		lock := dir + ".lock"
+		if info, err := os.Stat(lock); err == nil && time.Since(info.ModTime()) > staleLock {
+			os.Remove(lock)
+		}
+		if err := os.MkdirAll(c.CacheDir, 0755); err == nil {
+			if f, err := os.OpenFile(lock, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644); err == nil {
+				f.Close()
+				release := func() { os.Remove(lock) }
+				if err := resetWorkDir(dir); err != nil {
+					release()
+					return "", nil, err
+				}
+				return dir, release, nil
+			}
+		}
+	}
+	dir, err := os.MkdirTemp("", "clockwise-build-")
+	if err != nil {
+		return "", nil, err
+	}
+	return dir, func() { os.RemoveAll(dir) }, nil
+}
+
+// resetWorkDir removes the Go files left by the previous build so that
+// runtime libraries it used do not leak into this one.
+func resetWorkDir(dir string) error {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	old, err := filepath.Glob(filepath.Join(dir, "*.go"))
+	if err != nil {
+		return err
+	}
+	for _, f := range old {
+		if err := os.Remove(f); err != nil {
+			return err
+		}
+	}
+	return nil
+}
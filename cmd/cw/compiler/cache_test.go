@@ -0,0 +1,81 @@
+package compiler
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// writeFile creates path, and its directory, with content.
+func writeFile(t *testing.T, path, content string) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// TestCacheKey checks that the key changes with every input of the build and
+// only with those.
+func TestCacheKey(t *testing.T) {
+	dir := t.TempDir()
+	runtimeDir := filepath.Join(dir, "runtime")
+	a, b := filepath.Join(dir, "a.cw"), filepath.Join(dir, "b.cw")
+	lib := filepath.Join(runtimeDir, "textlib", "textlib.go")
+	reset := func() {
+		writeFile(t, a, "fn main() -> int { return 0; }\n")
+		writeFile(t, b, "fn helper() -> int { return 1; }\n")
+		writeFile(t, lib, "package textlib\n")
+	}
+	newCompiler := func() *Compiler {
+		c := NewCompiler([]string{a, b}, filepath.Join(dir, "out"))
+		c.Version, c.Commit, c.Target = "1.0.0", "abc123", "linux/amd64"
+		return c
+	}
+	key := func(c *Compiler) string {
+		t.Helper()
+		k, err := c.cacheKey(runtimeDir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return k
+	}
+
+	reset()
+	base := key(newCompiler())
+	if k := key(newCompiler()); k != base {
+		t.Errorf("key is not stable: %s, then %s", base, k)
+	}
+	c := newCompiler()
+	c.InputFiles = []string{b, a}
+	if k := key(c); k != base {
+		t.Errorf("key depends on the order of the inputs")
+	}
+
+	tests := []struct {
+		name   string
+		change func(c *Compiler)
+	}{
+		{"input", func(*Compiler) { writeFile(t, b, "fn helper() -> int { return 2; }\n") }},
+		{"runtime source", func(*Compiler) { writeFile(t, lib, "package textlib\n\nfunc Ready() bool { return true }\n") }},
+		{"new runtime library", func(*Compiler) { writeFile(t, filepath.Join(runtimeDir, "otherlib", "otherlib.go"), "package otherlib\n") }},
+		{"target", func(c *Compiler) { c.Target = "linux/arm64" }},
+		{"version", func(c *Compiler) { c.Version = "1.0.1" }},
+		{"commit", func(c *Compiler) { c.Commit = "def456" }},
+		{"optimization level", func(c *Compiler) { c.OptLevel = 2 }},
+		{"reproducible", func(c *Compiler) { c.Reproducible = true }},
+		{"strip", func(c *Compiler) { c.Strip = true }},
+		{"debug", func(c *Compiler) { c.Debug = true }},
+	}
+	for _, test := range tests {
+		reset()
+		os.RemoveAll(filepath.Join(runtimeDir, "otherlib"))
+		c := newCompiler()
+		test.change(c)
+		if k := key(c); k == base {
+			t.Errorf("changing the %s does not change the key", test.name)
+		}
+	}
+}
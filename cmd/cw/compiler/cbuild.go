@@ -0,0 +1,105 @@
+package compiler
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"codeberg.org/clockwise-lang/clockwise/codegen/cgen"
+	"codeberg.org/clockwise-lang/clockwise/ir"
+)
+
+// compileC generates C for program and either writes it, when the output is
+// a .c file, or builds an executable from it with the host C compiler.
+func (c *Compiler) compileC(program *ir.Program) error {
+	src, err := cgen.Generate(program)
+	if err != nil {
+		return fmt.Errorf("C code generation failed: %w", err)
+	}
+	runtime := map[string][]byte{
+		cgen.RuntimeHeaderName: []byte(cgen.RuntimeHeader),
+		cgen.RuntimeSourceName: []byte(cgen.RuntimeSource),
+	}
+
+	// a .c output gets the runtime written next to it, ready for any
+	// C build system
+	if strings.HasSuffix(c.OutputFile, ".c") {
+		if err := c.writeOutput([]byte(src)); err != nil {
+			return err
+		}
+		if c.OutputFile == "-" {
+			return nil
+		}
+		for name, data := range runtime {
+			if err := os.WriteFile(filepath.Join(filepath.Dir(c.OutputFile), name), data, 0644); err != nil {
+				return fmt.Errorf("failed to write C runtime: %w", err)
+			}
+		}
+		return nil
+	}
+
+	cc, err := FindCCompiler()
+	if err != nil {
+		return err
+	}
+	tmpDir, release, err := c.workDir()
+	if err != nil {
+		return err
+	}
+	defer release()
+	runtime["main.c"] = []byte(src)
+	for name, data := range runtime {
+		if err := os.WriteFile(filepath.Join(tmpDir, name), data, 0644); err != nil {
+			return err
+		}
+	}
+
+	out, err := filepath.Abs(c.OutputFile)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(out), 0755); err != nil {
+		return fmt.Errorf("failed to create output directory: %w", err)
+	}
+	args := []string{"-std=c99", "-O2"}
+	if c.Debug {
+		args = []string{"-std=c99", "-O0", "-g"}
+	}
+	if c.Strip {
+		args = append(args, "-s")
+	}
+	args = append(args, "-o", out, "main.c", cgen.RuntimeSourceName)
+	cmd := exec.Command(cc, args...)
+	cmd.Dir = tmpDir
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	if c.Verbose {
+		fmt.Printf("Building with %s\n", cc)
+	}
+	if err := cmd.Run(); err != nil {
+		if stderr.Len() == 0 {
+			return fmt.Errorf("%s failed: %w", cc, err)
+		}
+		// the line directives make most diagnostics refer to .cw files
+		return errors.New(strings.TrimSpace(stderr.String()))
+	}
+	return nil
+}
+
+// FindCCompiler returns the C compiler named by $CC, or the first of cc,
+// gcc and clang on PATH.
+func FindCCompiler() (string, error) {
+	if cc := os.Getenv("CC"); cc != "" {
+		return cc, nil
+	}
+	for _, name := range []string{"cc", "gcc", "clang"} {
+		if path, err := exec.LookPath(name); err == nil {
+			return path, nil
+		}
+	}
+	return "", fmt.Errorf("no C compiler found; install cc or set CC")
+}
@@ -1,17 +1,22 @@
 package compiler
 
 import (
+	"errors"
 	"fmt"
 	"go/format"
+	"io"
 	"io/ioutil"
 	"os"
 	"path/filepath"
+	"sort"
 	"strings"
 
 	"codeberg.org/clockwise-lang/clockwise/checker"
 	"codeberg.org/clockwise-lang/clockwise/codegen"
+	"codeberg.org/clockwise-lang/clockwise/ir"
 	"codeberg.org/clockwise-lang/clockwise/lexer"
 	"codeberg.org/clockwise-lang/clockwise/parser"
+	"codeberg.org/clockwise-lang/clockwise/parser/transform"
 )
 
 type Compiler struct {
@@ -19,7 +24,34 @@ type Compiler struct {
 	InputFiles []string
 	OutputFile string
 	Verbose    bool
-	Optimize   bool
+	// OptLevel selects the AST optimization passes: 0 runs none, 1 folds
+	// constants and drops unreachable code, 2 also removes unused locals
+	// and uncalled functions.
+	OptLevel int
+	// Version and Commit identify the compiler in build cache keys.
+	Version string
+	Commit  string
+	// CacheDir holds cached executables; "" disables the build cache.
+	CacheDir string
+	// Target is the os/arch pair to build for; "" builds for the host.
+	Target string
+	// Reproducible makes identical inputs produce identical executables:
+	// sources are named by relative path and the Go build is run with
+	// -trimpath and a fixed build ID.
+	Reproducible bool
+	// Strip drops the symbol table and DWARF from executables (release).
+	Strip bool
+	// Debug disables Go optimizations and inlining so executables step
+	// cleanly in a debugger; DWARF and bounds checks are kept.
+	Debug bool
+	// SizeReport, if set, receives a breakdown of the executable's size.
+	SizeReport io.Writer
+	// Emit selects an intermediate form to write instead of building:
+	// "ir" writes the optimized IR to OutputFile, or stdout if it is "-".
+	Emit string
+	// Backend selects the code generator: "go" (the default) or "c", which
+	// emits C99 and builds it with the host C compiler.
+	Backend string
 
 	// Internal state
 	errors   []error
@@ -32,7 +64,7 @@ func NewCompiler(inputFiles []string, outputFile string) *Compiler {
 		InputFiles: inputFiles,
 		OutputFile: outputFile,
 		Verbose:    false,
-		Optimize:   true,
+		OptLevel:   1,
 	}
 }
 
@@ -47,11 +79,137 @@ func (c *Compiler) Compile() error {
 		return fmt.Errorf("no input files specified")
 	}
 
+	cBackend := c.Backend == "c"
+	if cBackend && (c.Target != "" || c.SizeReport != nil) {
+		return fmt.Errorf("--target and --size-report are not supported with --backend=c")
+	}
+
+	// The program is checked and optimized even when the executable is
+	// cached, so that its warnings are reported on every build
+	program, err := c.Lower()
+	if err != nil {
+		return err
+	}
+
+	// Executables are served from the build cache when nothing they depend
+	// on has changed
+	var runtimeDir, cacheKey string
+	if c.Emit == "" && !cBackend && !strings.HasSuffix(c.OutputFile, ".go") {
+		if runtimeDir, err = FindRuntimeDir(); err != nil {
+			return err
+		}
+		if c.CacheDir != "" {
+			if cacheKey, err = c.cacheKey(runtimeDir); err != nil {
+				return err
+			}
+			cached := c.cachedBinary(cacheKey)
+			if _, err := os.Stat(cached); err == nil {
+				if c.Verbose {
+					fmt.Printf("Using cached build %s\n", cached)
+				}
+				if err := copyExecutable(cached, c.OutputFile); err != nil {
+					return err
+				}
+				if err := c.writeSupportFiles(); err != nil {
+					return err
+				}
+				return c.writeSizeReport(runtimeDir)
+			}
+		}
+	}
+
+	if c.Emit == "ir" {
+		return c.writeOutput([]byte(ir.Format(program)))
+	}
+	if cBackend {
+		if err := c.compileC(program); err != nil {
+			return err
+		}
+		if c.Verbose {
+			fmt.Printf("Successfully compiled %d files to %s\n", len(c.InputFiles), c.OutputFile)
+		}
+		return nil
+	}
+
+	// 9. Code generation
+	goos, _, _ := strings.Cut(c.targetPair(), "/")
+	if err := codegen.CheckExports(program, goos); err != nil {
+		return err
+	}
+	goCode := codegen.Generate(program, goos)
+
+	// 10. Format the generated Go code
+	formatted, err := format.Source([]byte(goCode))
+	if err != nil {
+		return fmt.Errorf("failed to format generated code: %w", err)
+	}
+
+	// 11. Write the Go source when asked for a .go file, otherwise build
+	// an executable
+	if strings.HasSuffix(c.OutputFile, ".go") {
+		if err := c.writeOutput(formatted); err != nil {
+			return err
+		}
+	} else {
+		if err := c.buildExecutable(formatted, program, runtimeDir); err != nil {
+			return err
+		}
+		if err := c.writeSupportFiles(); err != nil {
+			return err
+		}
+		if cacheKey != "" {
+			// a failure to cache does not fail the build
+			if err := c.storeBinary(cacheKey, c.OutputFile); err != nil && c.Verbose {
+				fmt.Printf("Could not cache build: %v\n", err)
+			}
+		}
+		if err := c.writeSizeReport(runtimeDir); err != nil {
+			return err
+		}
+	}
+
+	if c.Verbose {
+		fmt.Printf("Successfully compiled %d files to %s\n", len(c.InputFiles), c.OutputFile)
+	}
+
+	return nil
+}
+
+// Lower checks the program, lowers it to IR and runs the IR optimization
+// passes of the optimization level.
+func (c *Compiler) Lower() (*ir.Program, error) {
+	unifiedProgram, err := c.Check()
+	if err != nil {
+		return nil, err
+	}
+
+	// 8. Lowering to IR and IR optimization passes
+	program, err := ir.Lower(unifiedProgram)
+	if err != nil {
+		return nil, fmt.Errorf("lowering failed: %w", err)
+	}
+	changes := ir.Optimize(program, c.OptLevel)
+	if c.Verbose {
+		for _, change := range changes {
+			fmt.Printf("Optimized %s\n", change)
+		}
+	}
+	return program, nil
+}
+
+// Check reads, parses and checks the input files and runs the AST
+// optimization passes, printing their warnings. The result is the unified
+// program the back ends lower, or that the interpreter runs.
+func (c *Compiler) Check() (*parser.Program, error) {
+	if len(c.InputFiles) == 0 {
+		return nil, fmt.Errorf("no input files specified")
+	}
+
 	// Create a module to hold all files
 	module := parser.NewModule("main")
 
-	// Process each input file
-	for _, inputFile := range c.InputFiles {
+	// Process each input file, in path order
+	for _, inputFile := range c.sortedInputs() {
 		if c.Verbose {
 			fmt.Printf("Processing file: %s\n", inputFile)
 		}
@@ -59,7 +217,7 @@ func (c *Compiler) Compile() error {
 		// 1. Read input file
 		src, err := ioutil.ReadFile(inputFile)
 		if err != nil {
-			return fmt.Errorf("failed to read input file %s: %w", inputFile, err)
+			return nil, fmt.Errorf("failed to read input file %s: %w", inputFile, err)
 		}
 
 		// 2. Lexical analysis
@@ -70,7 +228,21 @@ func (c *Compiler) Compile() error {
 		p := parser.New(tokens)
 		program, err := p.ParseProgram()
 		if err != nil {
-			return fmt.Errorf("parse error in %s: %w", inputFile, err)
+			return nil, fmt.Errorf("parse error in %s: %w", inputFile, err)
+		}
+
+		// line directives name sources by absolute path, which stays valid
+		// wherever go build runs. Reproducible builds use the relative path
+		// instead, which go build resolves against its work directory.
+		srcPath, err := filepath.Abs(inputFile)
+		if err != nil {
+			return nil, err
+		}
+		if c.Reproducible {
+			srcPath = filepath.ToSlash(filepath.Clean(inputFile))
+		}
+		for _, fn := range program.Functions {
+			fn.File = srcPath
 		}
 
 		// 4. Add file to module
@@ -80,37 +252,41 @@ func (c *Compiler) Compile() error {
 	// 5. Resolve imports and create unified program
 	unifiedProgram, err := c.resolveImports(module)
 	if err != nil {
-		return fmt.Errorf("import resolution failed: %w", err)
+		return nil, fmt.Errorf("import resolution failed: %w", err)
 	}
 
 	// 6. Semantic analysis
 	if err := checker.CheckProgram(unifiedProgram); err != nil {
-		return fmt.Errorf("semantic error: %w", err)
+		return nil, fmt.Errorf("semantic error: %w", err)
 	}
 
-	// 7. Code generation
-	goCode := codegen.Generate(unifiedProgram)
-
-	// 8. Format the generated Go code
-	formatted, err := format.Source([]byte(goCode))
-	if err != nil {
-		return fmt.Errorf("failed to format generated code: %w", err)
+	// 7. AST optimization passes; unreachable code is reported even at -O0
+	report := transform.Optimize(unifiedProgram, c.OptLevel)
+	for _, w := range report.Warnings {
+		fmt.Fprintf(os.Stderr, "%s:%d: warning: %s\n", c.displayPath(w.File), w.Line, w.Msg)
 	}
-
-	// 9. Write output file
-	if err := os.MkdirAll(filepath.Dir(c.OutputFile), 0755); err != nil {
-		return fmt.Errorf("failed to create output directory: %w", err)
+	if c.Verbose {
+		for _, change := range report.Changes {
+			fmt.Printf("Optimized %s\n", change)
+		}
 	}
+	return unifiedProgram, nil
+}
 
-	if err := ioutil.WriteFile(c.OutputFile, formatted, 0644); err != nil {
-		return fmt.Errorf("failed to write output file: %w", err)
+// writeSupportFiles writes the files the host needs to run the output
+// beside it: wasm_exec.js for js/wasm.
+func (c *Compiler) writeSupportFiles() error {
+	if strings.HasPrefix(c.targetPair(), "js/") {
+		return writeWasmExec(c.OutputFile)
 	}
+	return nil
+}
 
-	if c.Verbose {
-		fmt.Printf("Successfully compiled %d files to %s\n", len(c.InputFiles), c.OutputFile)
+func (c *Compiler) writeSizeReport(runtimeDir string) error {
+	if c.SizeReport == nil {
+		return nil
 	}
-
-	return nil
+	return WriteSizeReport(c.SizeReport, c.OutputFile, runtimeDir)
 }
 
 // resolveImports resolves imports across all files in the module and creates a unified program
@@ -157,6 +333,11 @@ func (c *Compiler) resolveImports(module *parser.Module) (*parser.Program, error
 		// Merge functions
 		unifiedProgram.Functions = append(unifiedProgram.Functions, program.Functions...)
 	}
+
+	// the program does not depend on the order the files were given in
+	sort.SliceStable(unifiedProgram.Functions, func(i, j int) bool {
+		return unifiedProgram.Functions[i].File < unifiedProgram.Functions[j].File
+	})
 	
 	return unifiedProgram, nil
 }
@@ -186,5 +367,34 @@ func (c *Compiler) compileError() error {
 		sb.WriteString(fmt.Sprintf("  warning %d: %s\n", i+1, warn))
 	}
 
-	return fmt.Errorf(sb.String())
+	return errors.New(sb.String())
+}
+
+// writeOutput writes data to OutputFile, or to stdout if it is "-".
+func (c *Compiler) writeOutput(data []byte) error {
+	if c.OutputFile == "-" {
+		_, err := os.Stdout.Write(data)
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(c.OutputFile), 0755); err != nil {
+		return fmt.Errorf("failed to create output directory: %w", err)
+	}
+	if err := ioutil.WriteFile(c.OutputFile, data, 0644); err != nil {
+		return fmt.Errorf("failed to write output file: %w", err)
+	}
+	return nil
+}
+
+// displayPath names a source file in diagnostics: relative to the working
+// directory when it is below it, as given otherwise.
+func (c *Compiler) displayPath(file string) string {
+	if !filepath.IsAbs(file) {
+		return file
+	}
+	if wd, err := os.Getwd(); err == nil {
+		if rel, err := filepath.Rel(wd, file); err == nil && !strings.HasPrefix(rel, "..") {
+			return rel
+		}
+	}
+	return file
 }
@@ -0,0 +1,364 @@
+package compiler
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"go/ast"
+	"go/build"
+	goparser "go/parser"
+	"go/token"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"codeberg.org/clockwise-lang/clockwise/ir"
+)
+
+// FindRuntimeDir locates the runtime helper libraries: $CLOCKWISE_RUNTIME,
+// ./runtime, or a runtime directory next to (or one level above) the cwc
+// executable.
+func FindRuntimeDir() (string, error) {
+	candidates := []string{os.Getenv("CLOCKWISE_RUNTIME"), "runtime"}
+	if exe, err := os.Executable(); err == nil {
+		dir := filepath.Dir(exe)
+		candidates = append(candidates, filepath.Join(dir, "runtime"), filepath.Join(dir, "..", "runtime"))
+	}
+	for _, c := range candidates {
+		if c == "" {
+			continue
+		}
+		if info, err := os.Stat(c); err == nil && info.IsDir() {
+			return c, nil
+		}
+	}
+	return "", fmt.Errorf("runtime helpers not found; set CLOCKWISE_RUNTIME to the runtime directory")
+}
+
+// runtimeLib is one helper library under runtime/, with its sources rewritten
+// into package main.
+type runtimeLib struct {
+	name  string
+	funcs map[string]bool
+	files map[string][]byte
+	// unavailable holds the functions defined only in files whose build
+	// constraints exclude the target.
+	unavailable map[string]bool
+}
+
+// loadRuntimeLibs parses every library directory under dir for target, an
+// os/arch pair ("" for the host). Directories that are commands themselves
+// (package main) are skipped, as are files whose build constraints, such as
+// `//go:build !wasm`, exclude the target.
+func loadRuntimeLibs(dir, target string) ([]*runtimeLib, error) {
+	ctx := build.Default
+	if goos, goarch, ok := strings.Cut(target, "/"); ok {
+		ctx.GOOS, ctx.GOARCH = goos, goarch
+	}
+	ctx.CgoEnabled = false
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var libs []*runtimeLib
+	for _, e := range entries {
+		if !e.IsDir() {
+			continue
+		}
+		lib := &runtimeLib{name: e.Name(), funcs: map[string]bool{}, files: map[string][]byte{}, unavailable: map[string]bool{}}
+		paths, _ := filepath.Glob(filepath.Join(dir, e.Name(), "*.go"))
+		for _, path := range paths {
+			if strings.HasSuffix(path, "_test.go") {
+				continue
+			}
+			src, err := os.ReadFile(path)
+			if err != nil {
+				return nil, err
+			}
+			fset := token.NewFileSet()
+			f, err := goparser.ParseFile(fset, path, src, goparser.SkipObjectResolution)
+			if err != nil {
+				return nil, fmt.Errorf("runtime library %s: %w", e.Name(), err)
+			}
+			if f.Name.Name == "main" {
+				lib = nil
+				break
+			}
+			match, err := ctx.MatchFile(filepath.Dir(path), filepath.Base(path))
+			if err != nil {
+				return nil, fmt.Errorf("runtime library %s: %w", e.Name(), err)
+			}
+			for _, d := range f.Decls {
+				if fn, ok := d.(*ast.FuncDecl); ok && fn.Recv == nil {
+					if match {
+						lib.funcs[fn.Name.Name] = true
+					} else {
+						lib.unavailable[fn.Name.Name] = true
+					}
+				}
+			}
+			if !match {
+				continue
+			}
+			// the helpers are merged into the program's package main
+			start := fset.Position(f.Package).Offset
+			end := fset.Position(f.Name.End()).Offset
+			var out bytes.Buffer
+			out.Write(src[:start])
+			out.WriteString("package main")
+			out.Write(src[end:])
+			lib.files[e.Name()+"_"+filepath.Base(path)] = out.Bytes()
+		}
+		if lib != nil && (len(lib.files) > 0 || len(lib.unavailable) > 0) {
+			for name := range lib.funcs {
+				delete(lib.unavailable, name)
+			}
+			libs = append(libs, lib)
+		}
+	}
+	sort.Slice(libs, func(i, j int) bool { return libs[i].name < libs[j].name })
+	return libs, nil
+}
+
+// selectRuntimeLibs picks, for each called name, the first library that
+// defines it. Libraries are all merged into one package, so two selected
+// libraries must not define the same function.
+func selectRuntimeLibs(libs []*runtimeLib, called map[string]bool) ([]*runtimeLib, error) {
+	var selected []*runtimeLib
+	for _, lib := range libs {
+		for name := range called {
+			if lib.funcs[name] && !providedBy(selected, name) {
+				selected = append(selected, lib)
+				break
+			}
+		}
+	}
+	owner := map[string]string{}
+	for _, lib := range selected {
+		for name := range lib.funcs {
+			if other, ok := owner[name]; ok {
+				return nil, fmt.Errorf("runtime helper %s is defined by both %s and %s, which this program both needs", name, other, lib.name)
+			}
+			owner[name] = lib.name
+		}
+	}
+	return selected, nil
+}
+
+func providedBy(libs []*runtimeLib, name string) bool {
+	for _, lib := range libs {
+		if lib.funcs[name] {
+			return true
+		}
+	}
+	return false
+}
+
+// calledNames returns the names of all functions called in the program.
+func calledNames(p *ir.Program) map[string]bool {
+	names := map[string]bool{}
+	forEachCall(p, func(_ *ir.Func, call *ir.Call) {
+		names[call.Func] = true
+	})
+	return names
+}
+
+// forEachCall calls f for every call and deferred call in p.
+func forEachCall(p *ir.Program, f func(fn *ir.Func, call *ir.Call)) {
+	for _, fn := range p.Funcs {
+		for _, b := range fn.Blocks {
+			for _, in := range b.Instrs {
+				switch in := in.(type) {
+				case *ir.Call:
+					f(fn, in)
+				case *ir.Defer:
+					f(fn, in.Call)
+				}
+			}
+		}
+	}
+}
+
+// checkAvailable reports calls to runtime helpers that no library provides
+// for target, because the only definitions are excluded by build
+// constraints.
+func checkAvailable(libs []*runtimeLib, p *ir.Program, target string) error {
+	var diags []string
+	forEachCall(p, func(fn *ir.Func, call *ir.Call) {
+		if providedBy(libs, call.Func) {
+			return
+		}
+		for _, lib := range libs {
+			if lib.unavailable[call.Func] {
+				diags = append(diags, fmt.Sprintf("%s:%d: %s is not available on %s", fn.File, call.SourceLine(), call.Func, target))
+				return
+			}
+		}
+	})
+	if len(diags) > 0 {
+		return errors.New(strings.Join(diags, "\n"))
+	}
+	return nil
+}
+
+// buildExecutable compiles the generated Go source together with the
+// runtime helpers it calls into an executable at c.OutputFile.
+func (c *Compiler) buildExecutable(goSrc []byte, program *ir.Program, runtimeDir string) error {
+	libs, err := loadRuntimeLibs(runtimeDir, c.targetPair())
+	if err != nil {
+		return err
+	}
+	if err := checkAvailable(libs, program, c.targetPair()); err != nil {
+		return err
+	}
+	libs, err = selectRuntimeLibs(libs, calledNames(program))
+	if err != nil {
+		return err
+	}
+	prov, err := c.provenance(libs)
+	if err != nil {
+		return err
+	}
+	provSrc, err := provenanceSource(prov)
+	if err != nil {
+		return err
+	}
+
+	tmpDir, release, err := c.workDir()
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	files := map[string][]byte{
+		"main.go": append(goSrc, provSrc...),
+		"go.mod":  []byte("module cwtemp\n\ngo 1.21\n"),
+	}
+	for _, lib := range libs {
+		for name, src := range lib.files {
+			files[name] = src
+		}
+	}
+	for name, src := range files {
+		if err := os.WriteFile(filepath.Join(tmpDir, name), src, 0644); err != nil {
+			return err
+		}
+	}
+
+	out, err := filepath.Abs(c.OutputFile)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(out), 0755); err != nil {
+		return fmt.Errorf("failed to create output directory: %w", err)
+	}
+	args := []string{"build", "-o", out}
+	if strings.HasPrefix(c.targetPair(), "wasip1/") && hasExports(program) {
+		// a reactor module: the host calls _initialize, then the exports
+		args = append(args, "-buildmode=c-shared")
+	}
+	var ldflags []string
+	if c.Reproducible {
+		// no local paths, VCS stamps or per-build IDs in the output
+		args = append(args, "-trimpath", "-buildvcs=false")
+		ldflags = append(ldflags, "-buildid=")
+	}
+	if c.Strip {
+		ldflags = append(ldflags, "-s", "-w")
+	}
+	if c.Debug {
+		args = append(args, "-gcflags=all=-N -l")
+	}
+	if len(ldflags) > 0 {
+		args = append(args, "-ldflags="+strings.Join(ldflags, " "))
+	}
+	cmd := exec.Command("go", append(args, ".")...)
+	cmd.Dir = tmpDir
+	cmd.Env = goBuildEnv(c.targetPair())
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	if c.Verbose {
+		fmt.Printf("Building for %s with runtime libraries from %s\n", c.targetPair(), runtimeDir)
+	}
+	if err := cmd.Run(); err != nil {
+		if stderr.Len() == 0 {
+			return fmt.Errorf("go build failed: %w", err)
+		}
+		// reproducible builds name sources relative to the work directory
+		base := tmpDir
+		if !c.Reproducible {
+			if base, err = os.Getwd(); err != nil {
+				base = tmpDir
+			}
+		}
+		return rewriteGoErrors(stderr.String(), tmpDir, base)
+	}
+	return nil
+}
+
+// hasExports reports whether p declares any `export fn`.
+func hasExports(p *ir.Program) bool {
+	for _, fn := range p.Funcs {
+		if fn.Export {
+			return true
+		}
+	}
+	return false
+}
+
+// goErrorLine matches a `go build` diagnostic such as
+// "/src/app.cw:12:5: undefined: Foo".
+var goErrorLine = regexp.MustCompile(`^(.+?):(\d+)(?::\d+)?: (.*)$`)
+
+// goTypeNames maps Go spellings in toolchain messages back to Clockwise.
+var goTypeNames = strings.NewReplacer(
+	"int8", "i8", "int16", "i16", "int32", "i32", "int64", "i64",
+	"uint8", "u8", "uint16", "u16", "uint32", "u32", "uint64", "u64",
+)
+
+// optionalPointer matches Go pointer types, which represent optionals.
+var optionalPointer = regexp.MustCompile(`\*([a-z][a-z0-9]*)`)
+
+// rewriteGoErrors turns `go build` output into Clockwise diagnostics. Thanks
+// to the line directives in the generated code most errors already refer to
+// .cw files; the rest point into generated support code or runtime helpers
+// and are reported as internal errors. The go command shortens paths relative
+// to dir, the directory it ran in; .cw paths are shown relative to base.
+func rewriteGoErrors(out, dir, base string) error {
+	var diags []string
+	for _, line := range strings.Split(strings.TrimSpace(out), "\n") {
+		if line == "" || strings.HasPrefix(line, "# ") || strings.HasPrefix(line, "note: ") {
+			continue
+		}
+		m := goErrorLine.FindStringSubmatch(strings.TrimSpace(line))
+		if m == nil {
+			diags = append(diags, line)
+			continue
+		}
+		file, lineNo, msg := m[1], m[2], m[3]
+		if !strings.HasSuffix(file, ".cw") {
+			diags = append(diags, fmt.Sprintf("internal error in generated code (%s:%s): %s", filepath.Base(file), lineNo, msg))
+			continue
+		}
+		if !filepath.IsAbs(file) {
+			file = filepath.Join(dir, file)
+		}
+		if rel, err := filepath.Rel(base, file); err == nil && (base == dir || !strings.HasPrefix(rel, "..")) {
+			file = rel
+		}
+		diags = append(diags, fmt.Sprintf("%s:%s: %s", file, lineNo, translateGoMessage(msg)))
+	}
+	return errors.New(strings.Join(diags, "\n"))
+}
+
+// translateGoMessage rewords a Go compiler message in Clockwise terms.
+func translateGoMessage(msg string) string {
+	if name, ok := strings.CutPrefix(msg, "undefined: "); ok {
+		return fmt.Sprintf("undefined function %s: not declared in the program and not provided by any runtime library", name)
+	}
+	msg = optionalPointer.ReplaceAllString(msg, "$1?")
+	return goTypeNames.Replace(msg)
+}
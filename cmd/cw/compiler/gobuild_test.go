@@ -0,0 +1,49 @@
+package compiler
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// TestCheckAvailable calls a helper whose library is excluded on wasip1 by
+// its build constraint.
+func TestCheckAvailable(t *testing.T) {
+	runtimeDir, err := filepath.Abs(filepath.Join("..", "..", "..", "runtime"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Chdir(t.TempDir())
+	src := "fn main() -> int {\n    Print(\"fetching\\n\");\n    Print(HttpGet(\"http://example.com/\") ?? \"\");\n    return 0;\n}\n"
+	if err := os.WriteFile("main.cw", []byte(src), 0644); err != nil {
+		t.Fatal(err)
+	}
+	c := NewCompiler([]string{"main.cw"}, "main")
+	c.Reproducible = true
+	program, err := c.Lower()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		target string
+		want   string
+	}{
+		{"linux/amd64", ""},
+		{"js/wasm", ""},
+		{"wasip1/wasm", "main.cw:3: HttpGet is not available on wasip1/wasm"},
+	}
+	for _, test := range tests {
+		libs, err := loadRuntimeLibs(runtimeDir, test.target)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got := ""
+		if err := checkAvailable(libs, program, test.target); err != nil {
+			got = err.Error()
+		}
+		if got != test.want {
+			t.Errorf("%s: got %q, want %q", test.target, got, test.want)
+		}
+	}
+}
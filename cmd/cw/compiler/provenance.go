@@ -0,0 +1,113 @@
+package compiler
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+)
+
+// provenanceMarker precedes the JSON provenance record embedded in every
+// executable; the record is terminated by a NUL byte.
+const provenanceMarker = "\x00clockwise-provenance:"
+
+// Provenance records how an executable was built. It is embedded in the
+// binary and printed by `cwc version -m`.
+type Provenance struct {
+	Compiler     string       `json:"compiler"`
+	Commit       string       `json:"commit"`
+	Target       string       `json:"target"`
+	Reproducible bool         `json:"reproducible"`
+	Sources      []SourceHash `json:"sources"`
+	// Runtime is the hash of the runtime library sources linked in.
+	Runtime     string   `json:"runtime"`
+	RuntimeLibs []string `json:"runtime_libs"`
+}
+
+// SourceHash is the SHA-256 of one .cw input.
+type SourceHash struct {
+	Path   string `json:"path"`
+	SHA256 string `json:"sha256"`
+}
+
+// provenance describes the build of c's inputs against libs.
+func (c *Compiler) provenance(libs []*runtimeLib) (*Provenance, error) {
+	p := &Provenance{
+		Compiler:     c.Version,
+		Commit:       c.Commit,
+		Target:       c.targetPair(),
+		Reproducible: c.Reproducible,
+	}
+	for _, in := range c.sortedInputs() {
+		h := sha256.New()
+		if err := hashFile(h, in); err != nil {
+			return nil, err
+		}
+		p.Sources = append(p.Sources, SourceHash{Path: filepath.ToSlash(filepath.Clean(in)), SHA256: hex.EncodeToString(h.Sum(nil))})
+	}
+	h := sha256.New()
+	for _, lib := range libs {
+		p.RuntimeLibs = append(p.RuntimeLibs, lib.name)
+		names := make([]string, 0, len(lib.files))
+		for name := range lib.files {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			fmt.Fprintf(h, "%s\x00%d\x00", name, len(lib.files[name]))
+			h.Write(lib.files[name])
+		}
+	}
+	p.Runtime = hex.EncodeToString(h.Sum(nil))
+	return p, nil
+}
+
+// sortedInputs returns the input files ordered by their cleaned paths, so
+// that what is built from them does not depend on the order they were
+// given in.
+func (c *Compiler) sortedInputs() []string {
+	inputs := append([]string(nil), c.InputFiles...)
+	sort.SliceStable(inputs, func(i, j int) bool {
+		return filepath.ToSlash(filepath.Clean(inputs[i])) < filepath.ToSlash(filepath.Clean(inputs[j]))
+	})
+	return inputs
+}
+
+// provenanceSource renders p as Go code for the generated package. The init
+// function keeps the linker from discarding the otherwise unused string.
+func provenanceSource(p *Provenance) (string, error) {
+	data, err := json.Marshal(p)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("\n// cwProvenance records how this program was built; see `cwc version -m`.\nvar cwProvenance = %s\n\nfunc init() { runtime.KeepAlive(cwProvenance) }\n",
+		strconv.Quote(provenanceMarker+string(data)+"\x00")), nil
+}
+
+// ReadProvenance extracts the provenance record from an executable built by
+// cwc.
+func ReadProvenance(path string) (*Provenance, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	i := bytes.Index(data, []byte(provenanceMarker+"{"))
+	if i < 0 {
+		return nil, fmt.Errorf("%s: no Clockwise provenance record (not built by cwc?)", path)
+	}
+	data = data[i+len(provenanceMarker):]
+	end := bytes.IndexByte(data, 0)
+	if end < 0 {
+		return nil, fmt.Errorf("%s: truncated provenance record", path)
+	}
+	var p Provenance
+	if err := json.Unmarshal(data[:end], &p); err != nil {
+		return nil, fmt.Errorf("%s: malformed provenance record: %w", path, err)
+	}
+	return &p, nil
+}
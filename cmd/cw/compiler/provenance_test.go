@@ -0,0 +1,54 @@
+package compiler
+
+import (
+	"bytes"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+)
+
+// TestReproducible builds the same program from two directories and expects
+// byte-identical executables with matching provenance.
+func TestReproducible(t *testing.T) {
+	if testing.Short() {
+		t.Skip("builds executables")
+	}
+	if _, err := exec.LookPath("go"); err != nil {
+		t.Skip("go is not installed")
+	}
+	runtimeDir, err := filepath.Abs(filepath.Join("..", "..", "..", "runtime"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("CLOCKWISE_RUNTIME", runtimeDir)
+
+	var outputs [][]byte
+	var records []*Provenance
+	for i := 0; i < 2; i++ {
+		dir := t.TempDir()
+		writeFile(t, filepath.Join(dir, "src", "main.cw"), "fn main() -> int {\n    Print(\"hello\\n\");\n    return 0;\n}\n")
+		t.Chdir(dir)
+		c := NewCompiler([]string{filepath.Join("src", "main.cw")}, "main")
+		c.Version, c.Reproducible = "1.0.0", true
+		if err := c.Compile(); err != nil {
+			t.Fatal(err)
+		}
+		data, err := os.ReadFile(filepath.Join(dir, "main"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		p, err := ReadProvenance(filepath.Join(dir, "main"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		outputs, records = append(outputs, data), append(records, p)
+	}
+	if !bytes.Equal(outputs[0], outputs[1]) {
+		t.Errorf("reproducible builds differ (%d and %d bytes)", len(outputs[0]), len(outputs[1]))
+	}
+	p := records[0]
+	if !p.Reproducible || len(p.Sources) != 1 || p.Sources[0].Path != "src/main.cw" {
+		t.Errorf("provenance = %+v, want a reproducible build of src/main.cw", p)
+	}
+}
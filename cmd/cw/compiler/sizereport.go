@@ -0,0 +1,193 @@
+package compiler
+
+import (
+	"debug/elf"
+	"debug/gosym"
+	"debug/macho"
+	"debug/pe"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+)
+
+// supportFuncs are the helpers codegen emits into every program, plus the
+// Go entry points wrapping the Clockwise main.
+var supportFuncs = map[string]bool{
+	"main": true, "init": true, "cwTrap": true, "cwFuncName": true,
+	"cwSome": true, "cwOptional": true, "cwUnwrap": true,
+}
+
+// funcSize is the machine code size of one Go function.
+type funcSize struct {
+	name string
+	size uint64
+}
+
+// readFuncSizes returns the code size of every function in an executable,
+// using the pclntab the Go linker keeps even in stripped binaries.
+func readFuncSizes(path string) ([]funcSize, error) {
+	pclntab, textStart, err := readPclntab(path)
+	if err != nil {
+		return nil, err
+	}
+	table, err := gosym.NewTable(nil, gosym.NewLineTable(pclntab, textStart))
+	if err != nil {
+		return nil, fmt.Errorf("%s: reading function table: %w", path, err)
+	}
+	sizes := make([]funcSize, 0, len(table.Funcs))
+	for _, fn := range table.Funcs {
+		sizes = append(sizes, funcSize{name: fn.Name, size: fn.End - fn.Entry})
+	}
+	return sizes, nil
+}
+
+func readPclntab(path string) ([]byte, uint64, error) {
+	if f, err := elf.Open(path); err == nil {
+		defer f.Close()
+		tab, text := f.Section(".gopclntab"), f.Section(".text")
+		if tab != nil && text != nil {
+			data, err := tab.Data()
+			return data, text.Addr, err
+		}
+	}
+	if f, err := macho.Open(path); err == nil {
+		defer f.Close()
+		tab, text := f.Section("__gopclntab"), f.Section("__text")
+		if tab != nil && text != nil {
+			data, err := tab.Data()
+			return data, text.Addr, err
+		}
+	}
+	if f, err := pe.Open(path); err == nil {
+		f.Close()
+		return nil, 0, fmt.Errorf("%s: size reports are not supported for Windows executables", path)
+	}
+	return nil, 0, fmt.Errorf("%s: no Go function table found", path)
+}
+
+// WriteSizeReport breaks the code size of an executable built by cwc down
+// by Clockwise function, runtime library, generated support code and the Go
+// packages linked in.
+func WriteSizeReport(w io.Writer, path, runtimeDir string) error {
+	prov, err := ReadProvenance(path)
+	if err != nil {
+		return err
+	}
+	sizes, err := readFuncSizes(path)
+	if err != nil {
+		return err
+	}
+	libs, err := loadRuntimeLibs(runtimeDir, "")
+	if err != nil {
+		return err
+	}
+	// every linked library is listed, even when the Go compiler inlined all
+	// of its helpers into their callers and left no symbols behind
+	libOf := map[string]string{}
+	modules := map[string]uint64{}
+	for _, lib := range libs {
+		for _, used := range prov.RuntimeLibs {
+			if lib.name == used {
+				modules[lib.name] = 0
+				for name := range lib.funcs {
+					libOf[name] = lib.name
+				}
+			}
+		}
+	}
+
+	cwFuncs := map[string]uint64{}
+	goPkgs := map[string]uint64{}
+	var support, total uint64
+	for _, fs := range sizes {
+		total += fs.size
+		name, ok := strings.CutPrefix(fs.name, "main.")
+		if !ok {
+			goPkgs[goPackage(fs.name)] += fs.size
+			continue
+		}
+		// closures and generic instances belong to their enclosing function
+		if i := strings.IndexAny(name, ".["); i >= 0 {
+			name = name[:i]
+		}
+		switch {
+		case supportFuncs[name]:
+			support += fs.size
+		case libOf[name] != "":
+			modules[libOf[name]] += fs.size
+		case name == "cwMain":
+			cwFuncs["main"] += fs.size
+		default:
+			cwFuncs[name] += fs.size
+		}
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(w, "Size report for %s: %s on disk, %s of code\n", path, formatSize(uint64(info.Size())), formatSize(total))
+	writeSizeSection(w, "Clockwise functions", cwFuncs, 0)
+	writeSizeSection(w, "Runtime modules", modules, 0)
+	fmt.Fprintf(w, "\nGenerated support code: %s\n", formatSize(support))
+	writeSizeSection(w, "Go runtime and standard library (largest packages)", goPkgs, 10)
+	return nil
+}
+
+// goPackage returns the import path of a Go function symbol such as
+// net/http.(*Client).Do.
+func goPackage(sym string) string {
+	slash := strings.LastIndex(sym, "/")
+	if dot := strings.Index(sym[slash+1:], "."); dot >= 0 {
+		return sym[:slash+1+dot]
+	}
+	return sym
+}
+
+// writeSizeSection prints sizes largest first; limit > 0 caps the rows and
+// folds the rest into one line. Entries of size 0 have no code of their own.
+func writeSizeSection(w io.Writer, title string, sizes map[string]uint64, limit int) {
+	if len(sizes) == 0 {
+		return
+	}
+	names := make([]string, 0, len(sizes))
+	var sum uint64
+	for name, size := range sizes {
+		names = append(names, name)
+		sum += size
+	}
+	sort.Slice(names, func(i, j int) bool {
+		if sizes[names[i]] != sizes[names[j]] {
+			return sizes[names[i]] > sizes[names[j]]
+		}
+		return names[i] < names[j]
+	})
+	fmt.Fprintf(w, "\n%s: %s\n", title, formatSize(sum))
+	var rest uint64
+	for i, name := range names {
+		if limit > 0 && i >= limit {
+			rest += sizes[name]
+			continue
+		}
+		if sizes[name] == 0 {
+			fmt.Fprintf(w, "  %10s  %s (inlined into callers)\n", formatSize(0), name)
+			continue
+		}
+		fmt.Fprintf(w, "  %10s  %s\n", formatSize(sizes[name]), name)
+	}
+	if rest > 0 {
+		fmt.Fprintf(w, "  %10s  (%d more)\n", formatSize(rest), len(names)-limit)
+	}
+}
+
+func formatSize(n uint64) string {
+	switch {
+	case n >= 1<<20:
+		return fmt.Sprintf("%.1f MiB", float64(n)/(1<<20))
+	case n >= 1<<10:
+		return fmt.Sprintf("%.1f KiB", float64(n)/(1<<10))
+	}
+	return fmt.Sprintf("%d B", n)
+}
@@ -0,0 +1,42 @@
+package compiler
+
+import (
+	"bytes"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// TestSizeReportInlined builds a program whose only runtime helper, Print,
+// is small enough to be inlined, so its library has no symbols left.
+func TestSizeReportInlined(t *testing.T) {
+	if testing.Short() {
+		t.Skip("builds executables")
+	}
+	if _, err := exec.LookPath("go"); err != nil {
+		t.Skip("go is not installed")
+	}
+	runtimeDir, err := filepath.Abs(filepath.Join("..", "..", "..", "runtime"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("CLOCKWISE_RUNTIME", runtimeDir)
+	t.Chdir(t.TempDir())
+	if err := os.WriteFile("main.cw", []byte("fn main() -> int {\n    Print(\"hello\\n\");\n    return 0;\n}\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	var report bytes.Buffer
+	c := NewCompiler([]string{"main.cw"}, "main")
+	c.SizeReport = &report
+	if err := c.Compile(); err != nil {
+		t.Fatal(err)
+	}
+	out := report.String()
+	for _, want := range []string{"\nRuntime modules: ", "cwlib (inlined into callers)\n", "\nClockwise functions: "} {
+		if !strings.Contains(out, want) {
+			t.Errorf("report lacks %q:\n%s", want, out)
+		}
+	}
+}
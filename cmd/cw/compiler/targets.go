@@ -0,0 +1,114 @@
+package compiler
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// Targets lists the os/arch pairs cwc can build for. Every runtime library
+// is pure Go, so each pair builds with cgo disabled into a static binary, or
+// a WebAssembly module for js/wasm and wasip1/wasm.
+var Targets = []string{
+	"darwin/amd64",
+	"darwin/arm64",
+	"freebsd/amd64",
+	"freebsd/arm64",
+	"linux/386",
+	"linux/amd64",
+	"linux/arm",
+	"linux/arm64",
+	"linux/ppc64le",
+	"linux/riscv64",
+	"linux/s390x",
+	"netbsd/amd64",
+	"openbsd/amd64",
+	"windows/386",
+	"windows/amd64",
+	"windows/arm64",
+	"js/wasm",
+	"wasip1/wasm",
+}
+
+// targetAliases are short names accepted for --target.
+var targetAliases = map[string]string{
+	"wasm": "wasip1/wasm",
+	"wasi": "wasip1/wasm",
+	"js":   "js/wasm",
+}
+
+// CanonicalTarget resolves a target alias such as "wasm" to its os/arch
+// pair; other names are returned unchanged.
+func CanonicalTarget(target string) string {
+	if pair, ok := targetAliases[target]; ok {
+		return pair
+	}
+	return target
+}
+
+// cgoEnabled returns the CGO_ENABLED setting for builds. Unless the user sets
+// it explicitly cgo is off, so outputs are standalone static binaries.
+func cgoEnabled() string {
+	if v := os.Getenv("CGO_ENABLED"); v != "" {
+		return v
+	}
+	return "0"
+}
+
+// goBuildEnv returns the environment for building for target.
+func goBuildEnv(target string) []string {
+	goos, goarch, _ := strings.Cut(target, "/")
+	return append(os.Environ(), "GOOS="+goos, "GOARCH="+goarch, "CGO_ENABLED="+cgoEnabled())
+}
+
+// ValidTarget reports an error unless target is one of Targets.
+func ValidTarget(target string) error {
+	for _, t := range Targets {
+		if t == target {
+			return nil
+		}
+	}
+	return fmt.Errorf("unsupported target %q; run 'cwc targets' for the list", target)
+}
+
+// TargetOutput names the executable built for target. When several targets
+// are built at once each output gets an -os-arch suffix; Windows outputs get
+// an .exe extension and WebAssembly modules a .wasm extension.
+func TargetOutput(output, target string, multiple bool) string {
+	goos, goarch, _ := strings.Cut(target, "/")
+	ext := filepath.Ext(output)
+	if ext == ".exe" || ext == ".wasm" {
+		output = strings.TrimSuffix(output, ext)
+	}
+	if multiple {
+		output = fmt.Sprintf("%s-%s-%s", output, goos, goarch)
+	}
+	switch {
+	case goarch == "wasm":
+		output += ".wasm"
+	case goos == "windows" || ext == ".exe":
+		output += ".exe"
+	}
+	return output
+}
+
+// writeWasmExec copies wasm_exec.js, the JavaScript glue that loads js/wasm
+// modules, from the Go installation next to output.
+func writeWasmExec(output string) error {
+	goroot, err := exec.Command("go", "env", "GOROOT").Output()
+	if err != nil {
+		return fmt.Errorf("locating wasm_exec.js: %w", err)
+	}
+	root := strings.TrimSpace(string(goroot))
+	// Go 1.24 moved the file from misc/wasm to lib/wasm
+	for _, rel := range []string{"lib/wasm/wasm_exec.js", "misc/wasm/wasm_exec.js"} {
+		data, err := os.ReadFile(filepath.Join(root, rel))
+		if err != nil {
+			continue
+		}
+		return os.WriteFile(filepath.Join(filepath.Dir(output), "wasm_exec.js"), data, 0644)
+	}
+	return fmt.Errorf("wasm_exec.js not found in %s", root)
+}
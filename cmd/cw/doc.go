@@ -0,0 +1,110 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+
+	cwcompiler "codeberg.org/clockwise-lang/clockwise/cmd/cw/compiler"
+	"codeberg.org/clockwise-lang/clockwise/cmd/cw/docgen"
+)
+
+// docCmd documents Clockwise sources and the files they import, or with
+// --runtime the runtime helpers. Without -o the documentation is printed as
+// one page; with -o a page per file and an index are written to that
+// directory.
+func docCmd() {
+	fs := flag.NewFlagSet("doc", flag.ExitOnError)
+	output := fs.String("o", "", "write a page per file and an index to `dir` instead of printing")
+	format := fs.String("format", "markdown", "output format: markdown, html or json")
+	runtime := fs.Bool("runtime", false, "document the runtime helper libraries instead")
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: cwc doc [-o dir] [-format markdown|html|json] [--runtime | input.cw | dir ...]\n")
+		fmt.Fprintf(os.Stderr, "  With no path the .cw files under the current directory are documented.\n")
+		fs.PrintDefaults()
+	}
+
+	if err := fs.Parse(os.Args[2:]); err != nil {
+		log.Fatalf("Error parsing flags: %v", err)
+	}
+
+	if *runtime {
+		dir, err := cwcompiler.FindRuntimeDir()
+		if err != nil {
+			log.Fatal(err)
+		}
+		set, errs := docgen.LoadRuntime(dir)
+		for _, err := range errs {
+			log.Printf("Error documenting %v", err)
+		}
+		writeDocs(set, *output, *format)
+		if len(errs) > 0 {
+			os.Exit(1)
+		}
+		return
+	}
+
+	args := fs.Args()
+	if len(args) == 0 {
+		args = []string{"."}
+	}
+	// paths are shown relative to a single directory argument
+	root := "."
+	if len(args) == 1 {
+		if info, err := os.Stat(args[0]); err == nil && info.IsDir() {
+			root = args[0]
+		}
+	}
+
+	failed := false
+	var files []string
+	for _, arg := range args {
+		info, err := os.Stat(arg)
+		if err != nil {
+			log.Printf("Error reading %s: %v", arg, err)
+			failed = true
+			continue
+		}
+		if !info.IsDir() {
+			files = append(files, arg)
+			continue
+		}
+		dirFiles, err := findCWFiles(arg)
+		if err != nil {
+			log.Printf("Error finding .cw files in %s: %v", arg, err)
+			failed = true
+			continue
+		}
+		files = append(files, dirFiles...)
+	}
+
+	set, errs := docgen.Load(root, files)
+	for _, err := range errs {
+		log.Printf("Error documenting %v", err)
+		failed = true
+	}
+	writeDocs(set, *output, *format)
+	if failed {
+		os.Exit(1)
+	}
+}
+
+// writeDocs prints set as one page, or writes its pages to dir if given.
+func writeDocs(set *docgen.Set, dir, format string) {
+	if dir == "" {
+		data, err := docgen.Render(set, format)
+		if err != nil {
+			log.Fatalf("Error rendering documentation: %v", err)
+		}
+		os.Stdout.Write(data)
+		return
+	}
+	written, err := docgen.Write(set, dir, format)
+	for _, file := range written {
+		fmt.Println(file)
+	}
+	if err != nil {
+		log.Fatalf("Error writing documentation: %v", err)
+	}
+}
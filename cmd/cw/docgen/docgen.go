@@ -0,0 +1,284 @@
+// Package docgen documents Clockwise sources: the functions of each file
+// with their `///` doc comments, the files it imports and is imported by,
+// the functions each one calls, linked to where they are defined, and an
+// index of every function. Sources are read with the lexer and parser, so
+// documentation follows the language rather than the layout of the text.
+package docgen
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"codeberg.org/clockwise-lang/clockwise/lexer"
+	"codeberg.org/clockwise-lang/clockwise/parser"
+)
+
+// Set is the documentation of a group of files and the files they import.
+type Set struct {
+	GeneratedAt time.Time       `json:"generatedAt"`
+	SourceRoot  string          `json:"sourceRoot"`
+	Documents   []Documentation `json:"documents"`
+	Index       []IndexEntry    `json:"index"`
+}
+
+// Documentation represents the extracted information for a single source
+// file, or for a runtime library.
+type Documentation struct {
+	FilePath string `json:"filePath"`
+	// Comment is the `///` comment that opens the file, when it is not
+	// the doc comment of the first function
+	Comment    string        `json:"comment,omitempty"`
+	Imports    []Link        `json:"imports,omitempty"`
+	ImportedBy []Link        `json:"importedBy,omitempty"`
+	Functions  []FunctionDoc `json:"functions"`
+}
+
+type FunctionDoc struct {
+	Name       string `json:"name"`
+	Signature  string `json:"signature"`
+	ReturnType string `json:"returnType"`
+	Export     bool   `json:"export,omitempty"`
+	Line       int    `json:"line"`
+	Comment    string `json:"comment"`
+	// Calls are the functions of the set this one calls, in order of
+	// first call; runtime helpers are not listed
+	Calls []Link `json:"calls,omitempty"`
+
+	// Params, GoSignature and Source describe runtime helpers, with
+	// Source the Go file and line. Unusable says why Clockwise cannot
+	// call a helper or use its result.
+	Params      []Param `json:"params,omitempty"`
+	GoSignature string  `json:"goSignature,omitempty"`
+	Source      string  `json:"source,omitempty"`
+	Unusable    string  `json:"unusable,omitempty"`
+}
+
+// Link names an import or a function and the document of the set that
+// defines it; File is empty for an import that could not be loaded.
+type Link struct {
+	Name string `json:"name"`
+	File string `json:"file,omitempty"`
+}
+
+// IndexEntry locates a function in the set.
+type IndexEntry struct {
+	Name string `json:"name"`
+	File string `json:"file"`
+	Line int    `json:"line"`
+}
+
+// Collect returns the files under root with one of extensions, sorted.
+func Collect(root string, extensions []string) ([]string, error) {
+	var files []string
+	extMap := make(map[string]struct{}, len(extensions))
+	for _, ext := range extensions {
+		extMap[strings.ToLower(ext)] = struct{}{}
+	}
+	err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			return nil
+		}
+		if _, ok := extMap[strings.ToLower(filepath.Ext(d.Name()))]; ok {
+			files = append(files, path)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(files)
+	return files, nil
+}
+
+// source is a file being documented.
+type source struct {
+	abs     string
+	display string
+	file    *parser.File
+	// imports holds the absolute path of each import of file
+	imports []string
+}
+
+// Load documents files and, transitively, the files they import, which are
+// found relative to the importing file as in a build. Paths are shown
+// relative to root. Files that cannot be read or parsed are returned as
+// errors and left out.
+func Load(root string, files []string) (*Set, []error) {
+	var errs []error
+	var sources []*source
+	seen := map[string]bool{}
+	queue := append([]string(nil), files...)
+	for len(queue) > 0 {
+		path := queue[0]
+		queue = queue[1:]
+		abs, err := filepath.Abs(path)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		if seen[abs] {
+			continue
+		}
+		seen[abs] = true
+		s := &source{abs: abs, display: displayPath(root, abs)}
+		src, err := os.ReadFile(path)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		if s.file, err = parser.ParseFile(string(src)); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", s.display, err))
+			continue
+		}
+		for _, imp := range s.file.Program.Imports {
+			target := imp
+			if !filepath.IsAbs(target) {
+				target = filepath.Join(filepath.Dir(abs), imp)
+			}
+			s.imports = append(s.imports, target)
+			queue = append(queue, target)
+		}
+		sources = append(sources, s)
+	}
+	sort.Slice(sources, func(i, j int) bool { return sources[i].display < sources[j].display })
+
+	byPath := map[string]*source{}
+	for _, s := range sources {
+		byPath[s.abs] = s
+	}
+	importedBy := map[string][]Link{}
+	for _, s := range sources {
+		for _, imp := range s.imports {
+			if t := byPath[imp]; t != nil {
+				importedBy[t.abs] = append(importedBy[t.abs], Link{Name: s.display, File: s.display})
+			}
+		}
+	}
+
+	set := &Set{GeneratedAt: time.Now().UTC(), SourceRoot: root}
+	for _, s := range sources {
+		doc := Documentation{FilePath: s.display, ImportedBy: importedBy[s.abs], Comment: fileComment(s.file)}
+		for i, imp := range s.file.Program.Imports {
+			link := Link{Name: imp}
+			if t := byPath[s.imports[i]]; t != nil {
+				link.File = t.display
+			}
+			doc.Imports = append(doc.Imports, link)
+		}
+		// a call reaches the functions of the file and of the files it
+		// imports, directly or not, which a build of it merges
+		defs := definitions(s, byPath)
+		for _, fn := range s.file.Program.Functions {
+			fd := FunctionDoc{
+				Name:       fn.Name,
+				Signature:  signature(fn),
+				ReturnType: fn.ReturnType,
+				Export:     fn.Export,
+				Line:       fn.Line,
+			}
+			if fn.Comments != nil {
+				fd.Comment = strings.Join(fn.Comments.Doc, "\n")
+			}
+			for _, name := range calls(fn) {
+				if file, ok := defs[name]; ok {
+					fd.Calls = append(fd.Calls, Link{Name: name, File: file})
+				}
+			}
+			doc.Functions = append(doc.Functions, fd)
+			set.Index = append(set.Index, IndexEntry{Name: fn.Name, File: s.display, Line: fn.Line})
+		}
+		set.Documents = append(set.Documents, doc)
+	}
+	sort.SliceStable(set.Index, func(i, j int) bool { return set.Index[i].Name < set.Index[j].Name })
+	return set, errs
+}
+
+// definitions maps the functions a build of s would contain to the files
+// that define them.
+func definitions(s *source, byPath map[string]*source) map[string]string {
+	defs := map[string]string{}
+	seen := map[string]bool{}
+	var visit func(s *source)
+	visit = func(s *source) {
+		if s == nil || seen[s.abs] {
+			return
+		}
+		seen[s.abs] = true
+		for _, fn := range s.file.Program.Functions {
+			if _, ok := defs[fn.Name]; !ok {
+				defs[fn.Name] = s.display
+			}
+		}
+		for _, imp := range s.imports {
+			visit(byPath[imp])
+		}
+	}
+	visit(s)
+	return defs
+}
+
+// calls returns the names of the functions fn calls, in order of first
+// call.
+func calls(fn *parser.Function) []string {
+	var names []string
+	seen := map[string]bool{}
+	var walk func(n parser.Node)
+	walk = func(n parser.Node) {
+		if c, ok := n.(*parser.CallExpression); ok && !c.Index {
+			if id, ok := c.Function.(*parser.Identifier); ok && !seen[id.Value] {
+				seen[id.Value] = true
+				names = append(names, id.Value)
+			}
+		}
+		for _, ch := range n.Children() {
+			walk(ch)
+		}
+	}
+	walk(fn)
+	return names
+}
+
+func signature(fn *parser.Function) string {
+	sig := "fn " + fn.Name + "() -> " + fn.ReturnType
+	if fn.Export {
+		sig = "export " + sig
+	}
+	return sig
+}
+
+// fileComment returns the `///` lines that open f. Those directly above a
+// first function are its doc comment instead.
+func fileComment(f *parser.File) string {
+	first := f.Tokens[0]
+	lines := strings.Split(first.Leading, "\n")
+	var doc []string
+	for _, line := range lines {
+		if !strings.HasPrefix(strings.TrimSpace(line), "///") {
+			break
+		}
+		doc = append(doc, lexer.DocText(line))
+	}
+	// the last line of Leading is the indentation before the token
+	if len(doc) == len(lines)-1 && first.Type != lexer.IMPORT && first.Type != lexer.EOF {
+		return ""
+	}
+	return strings.Join(doc, "\n")
+}
+
+// displayPath shows path relative to root, or as is outside it.
+func displayPath(root, path string) string {
+	if absRoot, err := filepath.Abs(root); err == nil {
+		if rel, err := filepath.Rel(absRoot, path); err == nil && !strings.HasPrefix(rel, "..") {
+			return filepath.ToSlash(rel)
+		}
+	}
+	return filepath.ToSlash(path)
+}
@@ -0,0 +1,138 @@
+package docgen
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+var update = flag.Bool("update", false, "rewrite the golden files")
+
+// generated replaces the time of generation, so the output is stable.
+var generated = time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+
+// golden compares got with testdata/golden/name, or rewrites it with
+// -update.
+func golden(t *testing.T, name string, got []byte) {
+	t.Helper()
+	path := filepath.Join("testdata", "golden", name)
+	if *update {
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, got, 0o644); err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+	want, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != string(want) {
+		t.Errorf("%s differs; got:\n%s\nwant:\n%s", name, got, want)
+	}
+}
+
+func loadSources(t *testing.T) *Set {
+	t.Helper()
+	root := filepath.Join("testdata", "src")
+	files, err := Collect(root, []string{".cw"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	set, errs := Load(root, files)
+	if len(errs) > 0 {
+		t.Fatal(errs)
+	}
+	set.GeneratedAt = generated
+	return set
+}
+
+// TestRender renders a file importing another in every format.
+func TestRender(t *testing.T) {
+	set := loadSources(t)
+	for _, format := range []string{"markdown", "json", "html"} {
+		out, err := Render(set, format)
+		if err != nil {
+			t.Fatalf("%s: %v", format, err)
+		}
+		golden(t, "src."+Extension(format), out)
+	}
+}
+
+// TestHTMLEscaping checks that doc comments and signatures are escaped in
+// HTML pages.
+func TestHTMLEscaping(t *testing.T) {
+	out, err := Render(loadSources(t), "html")
+	if err != nil {
+		t.Fatal(err)
+	}
+	html := string(out)
+	for _, raw := range []string{"<b>everyone</b>", "greeting & returns", `"hi"`} {
+		if strings.Contains(html, raw) {
+			t.Errorf("HTML contains %q unescaped", raw)
+		}
+	}
+	for _, escaped := range []string{"&lt;b&gt;everyone&lt;/b&gt;", "greeting &amp; returns", "-&gt; string"} {
+		if !strings.Contains(html, escaped) {
+			t.Errorf("HTML lacks %q", escaped)
+		}
+	}
+}
+
+// TestWrite writes a page per file and an index that links to them.
+func TestWrite(t *testing.T) {
+	dir := t.TempDir()
+	written, err := Write(loadSources(t), dir, "markdown")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, w := range written {
+		rel, _ := filepath.Rel(dir, w)
+		names = append(names, filepath.ToSlash(rel))
+	}
+	if got, want := strings.Join(names, " "), "lib.md main.md index.md"; got != want {
+		t.Errorf("wrote %s, want %s", got, want)
+	}
+	index, err := os.ReadFile(filepath.Join(dir, "index.md"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	golden(t, "index.md", index)
+}
+
+// TestLoadRuntime documents a runtime library, translating the Go
+// signatures of its helpers to Clockwise and flagging those Clockwise cannot
+// use.
+func TestLoadRuntime(t *testing.T) {
+	set, errs := LoadRuntime(filepath.Join("testdata", "runtime"))
+	if len(errs) > 0 {
+		t.Fatal(errs)
+	}
+	set.GeneratedAt = generated
+	want := map[string]string{
+		"GetEnv": "fn GetEnv(name: string) -> string?",
+		"Sum":    "fn Sum(xs: ...i64) -> i64",
+		"Ready":  "fn Ready() -> bool",
+		"Fields": "fn Fields(s: string) -> []string",
+		"Lookup": "fn Lookup(m: map[string]int, key: string) -> (int, error)",
+	}
+	if len(set.Documents) != 1 || len(set.Documents[0].Functions) != len(want) {
+		t.Fatalf("documented %+v, want the %d exported helpers of textlib", set.Documents, len(want))
+	}
+	for _, fn := range set.Documents[0].Functions {
+		if fn.Signature != want[fn.Name] {
+			t.Errorf("%s: signature %q, want %q", fn.Name, fn.Signature, want[fn.Name])
+		}
+	}
+	out, err := Render(set, "markdown")
+	if err != nil {
+		t.Fatal(err)
+	}
+	golden(t, "runtime.md", out)
+}
@@ -0,0 +1,354 @@
+package docgen
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Extension returns the file extension of format.
+func Extension(format string) string {
+	switch strings.ToLower(format) {
+	case "markdown", "md":
+		return "md"
+	case "html", "htm":
+		return "html"
+	case "json":
+		return "json"
+	default:
+		return format
+	}
+}
+
+// Render renders the whole set as one page: the index, then every
+// document.
+func Render(set *Set, format string) ([]byte, error) {
+	if Extension(format) == "json" {
+		return marshal(set)
+	}
+	return renderPage(set.page("", set.Documents, true), format)
+}
+
+// Write writes one page per document under dir, mirroring the source
+// tree, and an index page linking them. It returns the files written.
+func Write(set *Set, dir, format string) ([]string, error) {
+	ext := Extension(format)
+	if ext != "md" && ext != "html" && ext != "json" {
+		return nil, fmt.Errorf("unknown format %q", format)
+	}
+	var written []string
+	write := func(name string, data []byte) error {
+		out := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(out), 0o755); err != nil {
+			return err
+		}
+		if err := os.WriteFile(out, data, 0o644); err != nil {
+			return err
+		}
+		written = append(written, out)
+		return nil
+	}
+	for _, doc := range set.Documents {
+		name := pageName(doc.FilePath, ext)
+		var data []byte
+		var err error
+		if ext == "json" {
+			data, err = marshal(doc)
+		} else {
+			data, err = renderPage(set.page(name, []Documentation{doc}, false), format)
+		}
+		if err != nil {
+			return written, err
+		}
+		if err := write(name, data); err != nil {
+			return written, err
+		}
+	}
+	index := "index." + ext
+	var data []byte
+	var err error
+	if ext == "json" {
+		data, err = marshal(Set{GeneratedAt: set.GeneratedAt, SourceRoot: set.SourceRoot, Index: set.Index})
+	} else {
+		data, err = renderPage(set.page(index, nil, false), format)
+	}
+	if err != nil {
+		return written, err
+	}
+	return written, write(index, data)
+}
+
+// marshal writes v as indented JSON, leaving signatures such as `-> int`
+// unescaped.
+func marshal(v interface{}) ([]byte, error) {
+	var b bytes.Buffer
+	enc := json.NewEncoder(&b)
+	enc.SetEscapeHTML(false)
+	enc.SetIndent("", "  ")
+	err := enc.Encode(v)
+	return b.Bytes(), err
+}
+
+func renderPage(p *page, format string) ([]byte, error) {
+	switch Extension(format) {
+	case "md":
+		return []byte(markdown(p)), nil
+	case "html":
+		var b strings.Builder
+		if err := htmlTemplate.Execute(&b, p); err != nil {
+			return nil, err
+		}
+		return []byte(b.String()), nil
+	}
+	return nil, fmt.Errorf("unknown format %q", format)
+}
+
+// pageName is the page Write makes for the document of file.
+func pageName(file, ext string) string {
+	file = strings.ReplaceAll(file, "../", "")
+	if i := strings.Index(file, ":"); i >= 0 {
+		// a drive letter
+		file = file[i+1:]
+	}
+	return strings.TrimLeft(strings.TrimSuffix(file, path.Ext(file)), "/") + "." + ext
+}
+
+// page is what markdown and HTML pages show, with links resolved.
+type page struct {
+	Title     string
+	Generated string
+	Root      string
+	// Files and Index are shown on an index page
+	Files []link
+	Index []indexLink
+	Docs  []docView
+}
+
+type link struct {
+	Name string
+	Href string
+}
+
+type indexLink struct {
+	link
+	File string
+}
+
+type docView struct {
+	FilePath   string
+	Anchor     string
+	Comment    string
+	Imports    []link
+	ImportedBy []link
+	Functions  []functionView
+}
+
+type functionView struct {
+	FunctionDoc
+	Anchor string
+	Calls  []link
+}
+
+// page builds the page named name showing docs; the whole set is a single
+// page, and otherwise pages link to each other by relative path. A page
+// without documents is the index.
+func (set *Set) page(name string, docs []Documentation, single bool) *page {
+	ext := path.Ext(name)
+	href := func(file, anchor string) string {
+		if file == "" {
+			return ""
+		}
+		if single {
+			return "#" + anchor
+		}
+		target := pageName(file, strings.TrimPrefix(ext, "."))
+		rel := target
+		if dir := path.Dir(name); dir != "." {
+			rel = strings.Repeat("../", strings.Count(dir, "/")+1) + target
+		}
+		return rel + "#" + anchor
+	}
+	p := &page{
+		Title:     "Clockwise Documentation",
+		Generated: set.GeneratedAt.Format(time.RFC3339),
+		Root:      set.SourceRoot,
+	}
+	if single || len(docs) == 0 {
+		for _, doc := range set.Documents {
+			p.Files = append(p.Files, link{doc.FilePath, href(doc.FilePath, fileAnchor(doc.FilePath))})
+		}
+		for _, e := range set.Index {
+			p.Index = append(p.Index, indexLink{link{e.Name, href(e.File, functionAnchor(e.File, e.Name))}, e.File})
+		}
+	}
+	if !single && len(docs) == 1 {
+		p.Title = docs[0].FilePath
+	}
+	fileLinks := func(links []Link) []link {
+		var out []link
+		for _, l := range links {
+			out = append(out, link{l.Name, href(l.File, fileAnchor(l.File))})
+		}
+		return out
+	}
+	for _, doc := range docs {
+		v := docView{
+			FilePath:   doc.FilePath,
+			Anchor:     fileAnchor(doc.FilePath),
+			Comment:    doc.Comment,
+			Imports:    fileLinks(doc.Imports),
+			ImportedBy: fileLinks(doc.ImportedBy),
+		}
+		for _, fn := range doc.Functions {
+			fv := functionView{FunctionDoc: fn, Anchor: functionAnchor(doc.FilePath, fn.Name)}
+			for _, c := range fn.Calls {
+				fv.Calls = append(fv.Calls, link{c.Name, href(c.File, functionAnchor(c.File, c.Name))})
+			}
+			v.Functions = append(v.Functions, fv)
+		}
+		p.Docs = append(p.Docs, v)
+	}
+	return p
+}
+
+func fileAnchor(file string) string {
+	return "file-" + slug(file)
+}
+
+func functionAnchor(file, name string) string {
+	return slug(file) + "-" + name
+}
+
+// slug replaces the characters of s that are not letters or digits.
+func slug(s string) string {
+	return strings.Map(func(r rune) rune {
+		if 'a' <= r && r <= 'z' || 'A' <= r && r <= 'Z' || '0' <= r && r <= '9' {
+			return r
+		}
+		return '-'
+	}, s)
+}
+
+func markdown(p *page) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "# %s\n\nGenerated %s from %s\n\n", p.Title, p.Generated, p.Root)
+	if len(p.Files) > 0 {
+		b.WriteString("## Files\n\n")
+		for _, f := range p.Files {
+			fmt.Fprintf(&b, "- [%s](%s)\n", f.Name, f.Href)
+		}
+		b.WriteString("\n")
+	}
+	if len(p.Index) > 0 {
+		b.WriteString("## Index\n\n")
+		for _, e := range p.Index {
+			fmt.Fprintf(&b, "- [`%s`](%s) — %s\n", e.Name, e.Href, e.File)
+		}
+		b.WriteString("\n")
+	}
+	for _, doc := range p.Docs {
+		fmt.Fprintf(&b, "<a id=\"%s\"></a>\n\n## %s\n\n", doc.Anchor, doc.FilePath)
+		if doc.Comment != "" {
+			b.WriteString(doc.Comment + "\n\n")
+		}
+		if len(doc.Imports) > 0 {
+			b.WriteString("Imports: " + markdownLinks(doc.Imports) + "\n\n")
+		}
+		if len(doc.ImportedBy) > 0 {
+			b.WriteString("Imported by: " + markdownLinks(doc.ImportedBy) + "\n\n")
+		}
+		if len(doc.Functions) > 0 {
+			b.WriteString("### Functions\n\n")
+		}
+		for _, fn := range doc.Functions {
+			fmt.Fprintf(&b, "<a id=\"%s\"></a>\n\n#### `%s`\n\n", fn.Anchor, fn.Signature)
+			if fn.GoSignature != "" {
+				fmt.Fprintf(&b, "Go: `%s` (%s)\n\n", fn.GoSignature, fn.Source)
+			}
+			if fn.Unusable != "" {
+				fmt.Fprintf(&b, "**Unusable:** %s.\n\n", fn.Unusable)
+			}
+			b.WriteString(sanitize(fn.Comment) + "\n\n")
+			if len(fn.Calls) > 0 {
+				b.WriteString("Calls: " + markdownLinks(fn.Calls) + "\n\n")
+			}
+		}
+	}
+	return b.String()
+}
+
+// markdownLinks lists links, showing a name without a target as code.
+func markdownLinks(links []link) string {
+	out := make([]string, len(links))
+	for i, l := range links {
+		if l.Href == "" {
+			out[i] = "`" + l.Name + "`"
+		} else {
+			out[i] = "[`" + l.Name + "`](" + l.Href + ")"
+		}
+	}
+	return strings.Join(out, ", ")
+}
+
+func sanitize(s string) string {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return "(no summary)"
+	}
+	return s
+}
+
+var htmlTemplate = template.Must(template.New("cwdoc").Funcs(template.FuncMap{"summary": sanitize}).Parse(`<!doctype html>
+<html lang="en">
+<head>
+  <meta charset="utf-8" />
+  <title>{{ .Title }}</title>
+  <style>
+  body { font-family: -apple-system, BlinkMacSystemFont, "Segoe UI", sans-serif; margin: 2rem; }
+  code { background: #f3f3f3; padding: 0 .25rem; }
+  h2 { margin-top: 2rem; }
+  ul { line-height: 1.6; }
+  .doc { white-space: pre-line; }
+  </style>
+</head>
+<body>
+  <h1>{{ .Title }}</h1>
+  <p>Generated {{ .Generated }} from {{ .Root }}</p>
+  {{ if .Files }}
+    <h2>Files</h2>
+    <ul>
+    {{ range .Files }}<li><a href="{{ .Href }}">{{ .Name }}</a></li>{{ end }}
+    </ul>
+  {{ end }}
+  {{ if .Index }}
+    <h2>Index</h2>
+    <ul>
+    {{ range .Index }}<li><a href="{{ .Href }}"><code>{{ .Name }}</code></a> — {{ .File }}</li>{{ end }}
+    </ul>
+  {{ end }}
+  {{ define "links" }}{{ range $i, $l := . }}{{ if $i }}, {{ end }}{{ if .Href }}<a href="{{ .Href }}"><code>{{ .Name }}</code></a>{{ else }}<code>{{ .Name }}</code>{{ end }}{{ end }}{{ end }}
+  {{ range .Docs }}
+    <h2 id="{{ .Anchor }}">{{ .FilePath }}</h2>
+    {{ if .Comment }}<p class="doc">{{ .Comment }}</p>{{ end }}
+    {{ if .Imports }}<p>Imports: {{ template "links" .Imports }}</p>{{ end }}
+    {{ if .ImportedBy }}<p>Imported by: {{ template "links" .ImportedBy }}</p>{{ end }}
+    {{ if .Functions }}
+      <h3>Functions</h3>
+      {{ range .Functions }}
+        <h4 id="{{ .Anchor }}"><code>{{ .Signature }}</code></h4>
+        {{ if .GoSignature }}<p>Go: <code>{{ .GoSignature }}</code> ({{ .Source }})</p>{{ end }}
+        {{ if .Unusable }}<p><strong>Unusable:</strong> {{ .Unusable }}.</p>{{ end }}
+        <p class="doc">{{ summary .Comment }}</p>
+        {{ if .Calls }}<p>Calls: {{ template "links" .Calls }}</p>{{ end }}
+      {{ end }}
+    {{ end }}
+  {{ end }}
+</body>
+</html>
+`))
@@ -0,0 +1,199 @@
+package docgen
+
+import (
+	"bytes"
+	"fmt"
+	"go/ast"
+	goparser "go/parser"
+	"go/printer"
+	"go/token"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"codeberg.org/clockwise-lang/clockwise/checker"
+)
+
+// Param is a parameter of a runtime helper, with its Clockwise type.
+type Param struct {
+	Name string `json:"name"`
+	Type string `json:"type"`
+}
+
+// goTypes maps the Go types with a Clockwise equivalent to it.
+var goTypes = map[string]string{
+	"string": "string",
+	"bool":   "bool",
+	"int":    "int",
+	"int8":   "i8",
+	"int16":  "i16",
+	"int32":  "i32",
+	"rune":   "i32",
+	"int64":  "i64",
+	"uint8":  "u8",
+	"byte":   "u8",
+	"uint16": "u16",
+	"uint32": "u32",
+	"uint64": "u64",
+}
+
+// LoadRuntime documents the runtime helper libraries under dir, one
+// document per library, from their Go sources. Go types are shown as the
+// Clockwise types they are called with; where the checker declares a
+// helper, as with helpers returning optionals, its declaration is used.
+// Helpers that Clockwise cannot call, or whose result it cannot use, are
+// flagged in Unusable.
+func LoadRuntime(dir string) (*Set, []error) {
+	var errs []error
+	set := &Set{GeneratedAt: time.Now().UTC(), SourceRoot: filepath.ToSlash(dir)}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return set, []error{err}
+	}
+	for _, e := range entries {
+		if !e.IsDir() {
+			continue
+		}
+		doc, err := loadLibrary(dir, e.Name())
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		if doc == nil {
+			continue
+		}
+		for _, fn := range doc.Functions {
+			set.Index = append(set.Index, IndexEntry{Name: fn.Name, File: doc.FilePath, Line: fn.Line})
+		}
+		set.Documents = append(set.Documents, *doc)
+	}
+	sort.SliceStable(set.Index, func(i, j int) bool { return set.Index[i].Name < set.Index[j].Name })
+	return set, errs
+}
+
+// loadLibrary documents the exported functions of library name, or
+// returns nil if it has none or is a command, as the compiler skips those.
+func loadLibrary(dir, name string) (*Documentation, error) {
+	paths, _ := filepath.Glob(filepath.Join(dir, name, "*.go"))
+	sort.Strings(paths)
+	doc := &Documentation{FilePath: name}
+	fset := token.NewFileSet()
+	for _, path := range paths {
+		if strings.HasSuffix(path, "_test.go") {
+			continue
+		}
+		f, err := goparser.ParseFile(fset, path, nil, goparser.ParseComments|goparser.SkipObjectResolution)
+		if err != nil {
+			return nil, fmt.Errorf("runtime library %s: %w", name, err)
+		}
+		if f.Name.Name == "main" {
+			return nil, nil
+		}
+		if f.Doc != nil && doc.Comment == "" {
+			doc.Comment = strings.TrimSpace(f.Doc.Text())
+		}
+		for _, d := range f.Decls {
+			fd, ok := d.(*ast.FuncDecl)
+			if !ok || fd.Recv != nil || !fd.Name.IsExported() {
+				continue
+			}
+			fn := helperDoc(fset, fd)
+			fn.Source = filepath.ToSlash(filepath.Join(name, filepath.Base(path))) + fmt.Sprintf(":%d", fn.Line)
+			doc.Functions = append(doc.Functions, fn)
+		}
+	}
+	if len(doc.Functions) == 0 {
+		return nil, nil
+	}
+	return doc, nil
+}
+
+// helperDoc documents the runtime helper fd.
+func helperDoc(fset *token.FileSet, fd *ast.FuncDecl) FunctionDoc {
+	fn := FunctionDoc{Name: fd.Name.Name, Line: fset.Position(fd.Pos()).Line}
+	if fd.Doc != nil {
+		fn.Comment = strings.TrimSpace(fd.Doc.Text())
+	}
+	var buf bytes.Buffer
+	printer.Fprint(&buf, fset, &ast.FuncDecl{Name: fd.Name, Type: fd.Type})
+	fn.GoSignature = buf.String()
+
+	var problems []string
+	if fd.Type.TypeParams != nil {
+		problems = append(problems, "it is generic")
+	}
+	for _, field := range fd.Type.Params.List {
+		goType := exprString(fset, field.Type)
+		// a variadic helper takes any number of arguments of its type
+		elem, variadic := strings.CutPrefix(goType, "...")
+		t, ok := goTypes[elem]
+		if variadic {
+			t = "..." + t
+		}
+		if !ok {
+			t = goType
+			problems = append(problems, fmt.Sprintf("parameter type %s has no Clockwise equivalent", goType))
+		}
+		names := field.Names
+		if len(names) == 0 {
+			names = []*ast.Ident{{Name: "_"}}
+		}
+		for _, n := range names {
+			fn.Params = append(fn.Params, Param{Name: n.Name, Type: t})
+		}
+	}
+	callable := len(problems) == 0
+
+	if results := fd.Type.Results; results != nil {
+		var goResults []string
+		for _, field := range results.List {
+			for range max(len(field.Names), 1) {
+				goResults = append(goResults, exprString(fset, field.Type))
+			}
+		}
+		if t, ok := goTypes[goResults[0]]; ok && len(goResults) == 1 {
+			fn.ReturnType = t
+		} else {
+			fn.ReturnType = strings.Join(goResults, ", ")
+			if len(goResults) > 1 {
+				fn.ReturnType = "(" + fn.ReturnType + ")"
+			}
+			problems = append(problems, fmt.Sprintf("its result %s has no Clockwise equivalent", fn.ReturnType))
+		}
+	}
+
+	if b, ok := checker.LookupBuiltin(fn.Name); ok && len(b.Params) == len(fn.Params) {
+		for i, t := range b.Params {
+			fn.Params[i].Type = string(t)
+		}
+		fn.ReturnType = string(b.Result)
+	} else if len(problems) == 0 && fn.ReturnType != "" && fn.ReturnType != "int" {
+		// the checker takes the result of a helper it does not know as int
+		problems = append(problems, fmt.Sprintf("the checker does not declare it, so its %s result is taken as int", fn.ReturnType))
+	}
+
+	params := make([]string, len(fn.Params))
+	for i, p := range fn.Params {
+		params[i] = p.Name + ": " + p.Type
+	}
+	fn.Signature = "fn " + fn.Name + "(" + strings.Join(params, ", ") + ")"
+	if fn.ReturnType != "" {
+		fn.Signature += " -> " + fn.ReturnType
+	}
+
+	if len(problems) > 0 {
+		fn.Unusable = "cannot be called from Clockwise: " + strings.Join(problems, "; ")
+		if callable {
+			fn.Unusable = "can only be called as a statement: " + strings.Join(problems, "; ")
+		}
+	}
+	return fn
+}
+
+func exprString(fset *token.FileSet, e ast.Expr) string {
+	var buf bytes.Buffer
+	printer.Fprint(&buf, fset, e)
+	return buf.String()
+}
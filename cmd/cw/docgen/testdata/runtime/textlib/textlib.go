@@ -0,0 +1,34 @@
+// Package textlib is a runtime library for the docgen tests.
+package textlib
+
+import "os"
+
+// GetEnv returns the variable name, which the checker declares as optional.
+func GetEnv(name string) string {
+	return os.Getenv(name)
+}
+
+// Sum adds xs.
+func Sum(xs ...int64) int64 {
+	var n int64
+	for _, x := range xs {
+		n += x
+	}
+	return n
+}
+
+// Ready reports whether the library is set up.
+func Ready() bool {
+	return true
+}
+
+// Fields splits s at spaces.
+func Fields(s string) []string {
+	return nil
+}
+
+func Lookup(m map[string]int, key string) (int, error) {
+	return m[key], nil
+}
+
+func unexported() {}
@@ -0,0 +1,8 @@
+/// greet returns "hi" for <b>everyone</b> & everything.
+fn greet() -> string {
+    return shout();
+}
+
+fn shout() -> string {
+    return "HI";
+}
@@ -0,0 +1,9 @@
+/// Greets from the command line.
+
+import "lib.cw";
+
+/// Prints a greeting & returns 0.
+fn main() -> int {
+    Print(greet());
+    return 0;
+}
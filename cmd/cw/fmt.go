@@ -0,0 +1,292 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"codeberg.org/clockwise-lang/clockwise/cmd/cw/formatter"
+)
+
+// fmtCmd formats Clockwise sources. By default the formatted source is
+// printed; -w rewrites the files, -l lists those whose formatting differs
+// and -d prints unified diffs. --check exits with status 1 if any file
+// would change, so CI can gate on formatting. Errors exit with status 2.
+func fmtCmd() {
+	fs := flag.NewFlagSet("fmt", flag.ExitOnError)
+	write := fs.Bool("w", false, "write result to (source) file instead of stdout")
+	list := fs.Bool("l", false, "list files whose formatting differs")
+	diff := fs.Bool("d", false, "print unified diffs instead of the formatted source")
+	check := fs.Bool("check", false, "exit with status 1 if any file is not formatted")
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: cwc fmt [flags] [path ...]\n")
+		fmt.Fprintf(os.Stderr, "  With no path the current directory is formatted; '-' reads stdin and writes stdout.\n")
+		fs.PrintDefaults()
+	}
+
+	if err := fs.Parse(os.Args[2:]); err != nil {
+		log.Fatalf("Error parsing flags: %v", err)
+	}
+
+	args := fs.Args()
+	if len(args) == 0 {
+		args = []string{"."}
+	}
+
+	failed := false
+	var files []string
+	for _, arg := range args {
+		if arg == "-" {
+			if *write {
+				log.Fatal("cannot use -w with standard input")
+			}
+			files = append(files, arg)
+			continue
+		}
+		info, err := os.Stat(arg)
+		if err != nil {
+			log.Printf("Error reading %s: %v", arg, err)
+			failed = true
+			continue
+		}
+		if info.IsDir() {
+			dirFiles, err := findCWFiles(arg)
+			if err != nil {
+				log.Printf("Error finding .cw files in %s: %v", arg, err)
+				failed = true
+				continue
+			}
+			files = append(files, dirFiles...)
+		} else if strings.HasSuffix(arg, ".cw") {
+			files = append(files, arg)
+		}
+	}
+
+	unformatted := 0
+	for _, file := range files {
+		var data []byte
+		var err error
+		name := file
+		if file == "-" {
+			name = "<standard input>"
+			data, err = io.ReadAll(os.Stdin)
+		} else {
+			data, err = ioutil.ReadFile(file)
+		}
+		if err != nil {
+			log.Printf("Error reading %s: %v", name, err)
+			failed = true
+			continue
+		}
+		out, err := formatter.Format(data)
+		if err != nil {
+			// never rewrite a file that does not parse
+			log.Printf("Error formatting %s: %v", name, err)
+			failed = true
+			continue
+		}
+		changed := !bytes.Equal(out, data)
+		if changed {
+			unformatted++
+		}
+		if *list && changed {
+			fmt.Println(name)
+		}
+		if *diff && changed {
+			fmt.Print(unifiedDiff(filepath.ToSlash(name), string(data), string(out)))
+		}
+		if *write && changed {
+			if err := ioutil.WriteFile(file, out, 0644); err != nil {
+				log.Printf("Error writing %s: %v", file, err)
+				failed = true
+			}
+		}
+		if !*write && !*list && !*diff && !*check {
+			os.Stdout.Write(out)
+		}
+	}
+	if failed {
+		os.Exit(2)
+	}
+	if *check && unformatted > 0 {
+		fmt.Fprintf(os.Stderr, "%d of %d files are not formatted; run 'cwc fmt -w'\n", unformatted, len(files))
+		os.Exit(1)
+	}
+}
+
+func findCWFiles(dir string) ([]string, error) {
+	var files []string
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !info.IsDir() && strings.HasSuffix(path, ".cw") {
+			files = append(files, path)
+		}
+		return nil
+	})
+
+	return files, err
+}
+
+// edit is one line of an edit script: ' ' kept, '-' deleted from the old
+// text or '+' inserted from the new.
+type edit struct {
+	op   byte
+	text string
+}
+
+// diffLines returns the shortest edit script turning a into b, found with
+// Myers' algorithm.
+func diffLines(a, b []string) []edit {
+	n, m := len(a), len(b)
+	max := n + m
+	off := max + 1
+	v := make([]int, 2*max+3)
+	// trace[d] holds v as it was before step d
+	var trace [][]int
+	for d := 0; d <= max; d++ {
+		trace = append(trace, append([]int(nil), v...))
+		for k := -d; k <= d; k += 2 {
+			var x int
+			if k == -d || k != d && v[off+k-1] < v[off+k+1] {
+				x = v[off+k+1]
+			} else {
+				x = v[off+k-1] + 1
+			}
+			y := x - k
+			for x < n && y < m && a[x] == b[y] {
+				x++
+				y++
+			}
+			v[off+k] = x
+			if x >= n && y >= m {
+				return backtrack(trace, a, b, off)
+			}
+		}
+	}
+	return nil
+}
+
+// backtrack walks the trace of diffLines back from the end of both texts.
+func backtrack(trace [][]int, a, b []string, off int) []edit {
+	var rev []edit
+	x, y := len(a), len(b)
+	for d := len(trace) - 1; d >= 0; d-- {
+		v := trace[d]
+		k := x - y
+		var prevK int
+		if k == -d || k != d && v[off+k-1] < v[off+k+1] {
+			prevK = k + 1
+		} else {
+			prevK = k - 1
+		}
+		prevX := v[off+prevK]
+		prevY := prevX - prevK
+		for x > prevX && y > prevY {
+			x--
+			y--
+			rev = append(rev, edit{' ', a[x]})
+		}
+		if d == 0 {
+			break
+		}
+		if x == prevX {
+			y--
+			rev = append(rev, edit{'+', b[y]})
+		} else {
+			x--
+			rev = append(rev, edit{'-', a[x]})
+		}
+	}
+	edits := make([]edit, len(rev))
+	for i, e := range rev {
+		edits[len(rev)-1-i] = e
+	}
+	return edits
+}
+
+// unifiedDiff returns the changes from old to new as a unified diff of
+// name, with three lines of context, as `diff -u` and `git apply` read.
+func unifiedDiff(name, old, new string) string {
+	const context = 3
+	edits := diffLines(splitLines(old), splitLines(new))
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "--- a/%s\n+++ b/%s\n", name, name)
+	// aLine and bLine count the lines of old and new before edit i
+	aLine, bLine := make([]int, len(edits)+1), make([]int, len(edits)+1)
+	for i, e := range edits {
+		aLine[i+1], bLine[i+1] = aLine[i], bLine[i]
+		if e.op != '+' {
+			aLine[i+1]++
+		}
+		if e.op != '-' {
+			bLine[i+1]++
+		}
+	}
+	for i := 0; i < len(edits); {
+		if edits[i].op == ' ' {
+			i++
+			continue
+		}
+		// a hunk runs from context lines before the change to context
+		// lines after the last change that follows within 2*context
+		start := i - context
+		if start < 0 {
+			start = 0
+		}
+		end := i
+		for j := i; j < len(edits) && j <= end+2*context; j++ {
+			if edits[j].op != ' ' {
+				end = j
+			}
+		}
+		stop := end + context + 1
+		if stop > len(edits) {
+			stop = len(edits)
+		}
+		fmt.Fprintf(&sb, "@@ -%s +%s @@\n", hunkRange(aLine[start], aLine[stop]-aLine[start]), hunkRange(bLine[start], bLine[stop]-bLine[start]))
+		for _, e := range edits[start:stop] {
+			sb.WriteByte(e.op)
+			sb.WriteString(e.text)
+			if !strings.HasSuffix(e.text, "\n") {
+				sb.WriteString("\n\\ No newline at end of file\n")
+			}
+		}
+		i = stop
+	}
+	return sb.String()
+}
+
+// hunkRange writes the start,count of a hunk; the start of an empty range
+// is the line before it.
+func hunkRange(start, count int) string {
+	if count == 0 {
+		return fmt.Sprintf("%d,0", start)
+	}
+	if count == 1 {
+		return fmt.Sprint(start + 1)
+	}
+	return fmt.Sprintf("%d,%d", start+1, count)
+}
+
+// splitLines splits s after each newline; the last line lacks one if s
+// does not end in a newline.
+func splitLines(s string) []string {
+	var lines []string
+	for s != "" {
+		i := strings.IndexByte(s, '\n') + 1
+		if i == 0 {
+			i = len(s)
+		}
+		lines = append(lines, s[:i])
+		s = s[i:]
+	}
+	return lines
+}
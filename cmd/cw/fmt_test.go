@@ -0,0 +1,177 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"codeberg.org/clockwise-lang/clockwise/cmd/cw/formatter"
+)
+
+// TestMain runs cwc itself when CWC_TEST_MAIN is set, so the tests can
+// check the output and exit status of a command.
+func TestMain(m *testing.M) {
+	if os.Getenv("CWC_TEST_MAIN") != "" {
+		main()
+		os.Exit(0)
+	}
+	os.Exit(m.Run())
+}
+
+// cwc runs cwc with args and returns its stdout and exit status.
+func cwc(t *testing.T, args ...string) (string, int) {
+	t.Helper()
+	cmd := exec.Command(os.Args[0], args...)
+	cmd.Env = append(os.Environ(), "CWC_TEST_MAIN=1")
+	var stdout bytes.Buffer
+	cmd.Stdout = &stdout
+	err := cmd.Run()
+	if exit, ok := err.(*exec.ExitError); ok {
+		return stdout.String(), exit.ExitCode()
+	} else if err != nil {
+		t.Fatal(err)
+	}
+	return stdout.String(), 0
+}
+
+const unformatted = `fn helper()->int{
+return 1;
+}
+
+fn main() -> int {
+    var a: int = 1;
+    var b: int = 2;
+    var c: int = 3;
+    var d: int = 4;
+    var e: int = 5;
+    var f: int = 6;
+    var g: int = 7;
+    var total:int=a+b+c+d+e+f+g+helper();
+    return total;
+}
+`
+
+const formatted = `fn main() -> int {
+    return 0;
+}
+`
+
+// fixture writes the named sources into a new directory.
+func fixture(t *testing.T, files map[string]string) string {
+	t.Helper()
+	dir := t.TempDir()
+	for name, src := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(src), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+// TestFmtDiff applies the patch printed by -d to the unformatted file and
+// checks that it gives the formatter's result; a formatted file gets no
+// patch.
+func TestFmtDiff(t *testing.T) {
+	dir := fixture(t, map[string]string{"a.cw": unformatted, "b.cw": formatted})
+	want, err := formatter.Format([]byte(unformatted))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(want) == unformatted {
+		t.Fatal("the fixture is already formatted")
+	}
+	out, status := cwc(t, "fmt", "-d", dir)
+	if status != 0 {
+		t.Fatalf("cwc fmt -d exited with status %d", status)
+	}
+	name := filepath.ToSlash(filepath.Join(dir, "a.cw"))
+	header := fmt.Sprintf("--- a/%s\n+++ b/%s\n", name, name)
+	if !strings.HasPrefix(out, header) || strings.Count(out, "--- a/") != 1 {
+		t.Fatalf("cwc fmt -d printed\n%s\nwant one patch of %s", out, name)
+	}
+	if strings.Count(out, "@@ -") < 2 {
+		t.Errorf("cwc fmt -d printed\n%s\nwant a hunk for each end of the file", out)
+	}
+	if got := applyPatch(t, unformatted, out[len(header):]); got != string(want) {
+		t.Errorf("patched file:\n%s\nwant the formatter's result:\n%s", got, want)
+	}
+}
+
+// applyPatch applies the hunks of a unified diff to old.
+func applyPatch(t *testing.T, old, hunks string) string {
+	t.Helper()
+	lines := splitLines(old)
+	var out []string
+	pos := 0
+	var last byte
+	for _, l := range splitLines(hunks) {
+		switch l[0] {
+		case '@':
+			var start, count int
+			if n, _ := fmt.Sscanf(l, "@@ -%d,%d", &start, &count); n == 1 {
+				count = 1
+			}
+			if count > 0 {
+				// a non-empty range counts from 1
+				start--
+			}
+			if start < pos || start > len(lines) {
+				t.Fatalf("hunk %q out of order", l)
+			}
+			out = append(out, lines[pos:start]...)
+			pos = start
+		case ' ', '-':
+			if pos >= len(lines) || lines[pos] != l[1:] {
+				t.Fatalf("patch line %q does not match the file", l)
+			}
+			if l[0] == ' ' {
+				out = append(out, lines[pos])
+			}
+			pos++
+		case '+':
+			out = append(out, l[1:])
+		case '\\':
+			if last == '+' {
+				out[len(out)-1] = strings.TrimSuffix(out[len(out)-1], "\n")
+			}
+		}
+		last = l[0]
+	}
+	return strings.Join(append(out, lines[pos:]...), "")
+}
+
+func TestFmtList(t *testing.T) {
+	dir := fixture(t, map[string]string{"a.cw": unformatted, "b.cw": formatted})
+	out, status := cwc(t, "fmt", "-l", dir)
+	if want := filepath.Join(dir, "a.cw") + "\n"; out != want || status != 0 {
+		t.Errorf("cwc fmt -l printed %q and exited with %d, want %q and 0", out, status, want)
+	}
+}
+
+// TestFmtCheck checks the exit status of --check: 1 if a file would change,
+// 2 if one does not parse, and that it never rewrites a file.
+func TestFmtCheck(t *testing.T) {
+	for _, tt := range []struct {
+		name   string
+		files  map[string]string
+		status int
+	}{
+		{"formatted", map[string]string{"b.cw": formatted}, 0},
+		{"unformatted", map[string]string{"a.cw": unformatted, "b.cw": formatted}, 1},
+		{"parse error", map[string]string{"a.cw": unformatted, "c.cw": "fn main( {\n"}, 2},
+	} {
+		dir := fixture(t, tt.files)
+		if _, status := cwc(t, "fmt", "--check", dir); status != tt.status {
+			t.Errorf("%s: cwc fmt --check exited with status %d, want %d", tt.name, status, tt.status)
+		}
+		for name, src := range tt.files {
+			if data, err := os.ReadFile(filepath.Join(dir, name)); err != nil || string(data) != src {
+				t.Errorf("%s: cwc fmt --check changed %s", tt.name, name)
+			}
+		}
+	}
+}
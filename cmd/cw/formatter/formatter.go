@@ -1,219 +1,298 @@
+// Package formatter prints Clockwise source in its canonical layout:
+// four-space indentation, one statement per line ending in a semicolon,
+// single spaces around binary operators and only the parentheses that
+// precedence needs. Comments and single blank lines are kept.
 package formatter
 
 import (
-	"bytes"
 	"fmt"
-	"go/token"
-	"io"
+	"math"
+	"strconv"
 	"strings"
 
-	"codeberg.org/clockwise-lang/clockwise/parser"
 	"codeberg.org/clockwise-lang/clockwise/lexer"
 	"codeberg.org/clockwise-lang/clockwise/parser"
 )
 
-type Formatter struct {
-	src       []byte
-	fset      *token.FileSet
-	node      ast.Node
-	indent    int
-	lineStart bool
-	output    *bytes.Buffer
-}
+const indent = "    "
 
+// Format parses src and prints it in canonical form. Formatting the result
+// again changes nothing. Source that does not parse is an error, so a file
+// is never rewritten from a partial syntax tree.
 func Format(src []byte) ([]byte, error) {
-	// Create a new lexer and parse the source
-	l := lexer.New(string(src))
-	p := parser.New(l)
-	file, err := p.ParseFile()
+	text := strings.ReplaceAll(string(src), "\r\n", "\n")
+	l := lexer.New(text)
+	tokens := l.Tokenize()
+	p := parser.New(tokens)
+	prog, err := p.ParseProgram()
 	if err != nil {
-		return nil, fmt.Errorf("parsing error: %v", err)
+		return nil, fmt.Errorf("line %d: %w", p.Line(), err)
 	}
-
-	f := &Formatter{
-		src:       src,
-		fset:      token.NewFileSet(),
-		node:      file,
-		indent:    0,
-		lineStart: true,
-		output:    &bytes.Buffer{},
+	// the layout of an expression is not kept, so a comment inside one
+	// could only be moved or joined onto another comment's line
+	for _, c := range l.Comments() {
+		if c.Token == 0 {
+			continue
+		}
+		switch tokens[c.Token-1].Type {
+		case lexer.SEMICOLON, lexer.LBRACE, lexer.RBRACE:
+		default:
+			return nil, fmt.Errorf("line %d: comment inside a statement; move it to a line of its own before the statement", c.Line)
+		}
 	}
-
-	// Format the AST
-	if err := f.formatNode(file); err != nil {
-		return nil, err
+	f := &formatter{src: strings.Split(text, "\n"), comments: l.Comments()}
+	var importLines []int
+	for _, tok := range tokens {
+		if tok.Type == lexer.IMPORT {
+			importLines = append(importLines, tok.Line)
+		}
+	}
+	for i, imp := range prog.Imports {
+		f.flush(importLines[i])
+		f.emit(importLines[i], "import "+strconv.Quote(imp)+";")
+	}
+	for _, fn := range prog.Functions {
+		f.function(fn)
 	}
+	f.flush(math.MaxInt)
+	if len(f.out) == 0 {
+		return nil, nil
+	}
+	return []byte(strings.Join(f.out, "\n") + "\n"), nil
+}
 
-	return f.output.Bytes(), nil
+// formatter accumulates the output lines. Comments are printed when the
+// first line after them is reached.
+type formatter struct {
+	src      []string
+	comments []lexer.Comment
+	out      []string
+	depth    int
+	// blank asks for a blank line before the next line; open marks that
+	// the last line opened a block, which is never followed by one
+	blank bool
+	open  bool
 }
 
-func (f *Formatter) formatNode(node ast.Node) error {
-	switch n := node.(type) {
-	case *ast.File:
-		return f.formatFile(n)
-	case *ast.FuncDecl:
-		return f.formatFuncDecl(n)
-	case *ast.BlockStmt:
-		return f.formatBlockStmt(n)
-	case *ast.ExprStmt:
-		return f.formatExprStmt(n)
-	case *ast.IfStmt:
-		return f.formatIfStmt(n)
-	case *ast.ForStmt:
-		return f.formatForStmt(n)
-	case *ast.ReturnStmt:
-		return f.formatReturnStmt(n)
-	case *ast.VarDecl:
-		return f.formatVarDecl(n)
-	case *ast.AssignStmt:
-		return f.formatAssignStmt(n)
-	case *ast.BinaryExpr:
-		return f.formatBinaryExpr(n)
-	case *ast.Ident:
-		return f.formatIdent(n)
-	case *ast.BasicLit:
-		return f.formatBasicLit(n)
-	default:
-		return fmt.Errorf("unsupported node type: %T", node)
+// emit prints a line that starts at source line, keeping a blank line
+// that precedes it in the source.
+func (f *formatter) emit(line int, text string) {
+	if len(f.out) > 0 && !f.open && (f.blank || line > 1 && strings.TrimSpace(f.src[line-2]) == "") {
+		f.out = append(f.out, "")
 	}
+	f.blank = false
+	f.close(text)
 }
 
-func (f *Formatter) formatFile(file *ast.File) error {
-	// Format package declaration
-	f.printf("package %s\n\n", file.Name.Name)
+// close prints a line that has no source line of its own, such as a
+// closing brace.
+func (f *formatter) close(text string) {
+	f.out = append(f.out, strings.Repeat(indent, f.depth)+text)
+	f.open = strings.HasSuffix(text, "{")
+}
 
-	// Format imports
-	if len(file.Imports) > 0 {
-		f.printf("import (\n")
-		f.indent++
-		for _, imp := range file.Imports {
-			f.printf("\"%s\"\n", imp.Path)
+// flush prints the comments before source line before. A comment that
+// followed code stays at the end of the last line printed.
+func (f *formatter) flush(before int) {
+	for len(f.comments) > 0 && f.comments[0].Line < before {
+		c := f.comments[0]
+		f.comments = f.comments[1:]
+		if c.Trailing && len(f.out) > 0 {
+			f.out[len(f.out)-1] += " " + c.Text
+			continue
 		}
-		f.indent--
-		f.printf(")\n\n")
+		f.emit(c.Line, c.Text)
 	}
+}
 
-	// Format declarations
-	for _, decl := range file.Decls {
-		if err := f.formatNode(decl); err != nil {
-			return err
-		}
-		f.printf("\n")
+func (f *formatter) function(fn *parser.Function) {
+	f.blank = true
+	f.flush(fn.Line)
+	header := "fn " + fn.Name + "() -> " + fn.ReturnType + " {"
+	if fn.Export {
+		header = "export " + header
 	}
-
-	return nil
+	f.emit(fn.Line, header)
+	f.body(fn.Body)
+	f.close("}")
 }
 
-func (f *Formatter) formatFuncDecl(fn *ast.FuncDecl) error {
-	// Function signature
-	f.printf("func %s(", fn.Name.Name)
-
-	// Parameters
-	for i, param := range fn.Type.Params.List {
-		if i > 0 {
-			f.printf(", ")
-		}
-		for j, name := range param.Names {
-			if j > 0 {
-				f.printf(", ")
-			}
-			f.printf("%s ", name.Name)
-		}
-		f.printf("%s", param.Type)
+// body prints the statements of b and the comments before its closing
+// brace, one level deeper.
+func (f *formatter) body(b *parser.BlockStatement) {
+	f.depth++
+	for _, s := range b.Statements {
+		f.statement(s)
 	}
+	f.flush(b.End)
+	f.depth--
+}
 
-	// Return type
-	if fn.Type.Results != nil {
-		f.printf(") ")
-		if len(fn.Type.Results.List) > 1 {
-			f.printf("(")
-		}
-		for i, result := range fn.Type.Results.List {
-			if i > 0 {
-				f.printf(", ")
-			}
-			f.printf("%s", result.Type)
-		}
-		if len(fn.Type.Results.List) > 1 {
-			f.printf(")")
+func (f *formatter) statement(s parser.Statement) {
+	line := parser.LineOf(s)
+	f.flush(line)
+	switch st := s.(type) {
+	case *parser.ReturnStatement:
+		f.emit(line, "return "+expr(st.Value)+";")
+	case *parser.VarStatement:
+		f.emit(line, "var "+st.Name+": "+st.Type+" = "+expr(st.Value)+";")
+	case *parser.ExpressionStatement:
+		f.emit(line, expr(st.Expr)+";")
+	case *parser.DeferStatement:
+		f.emit(line, "defer "+expr(st.Call)+";")
+	case *parser.AssertStatement:
+		args := expr(st.Condition)
+		if st.Message != nil {
+			args += ", " + expr(st.Message)
 		}
-	} else {
-		f.printf(")")
+		f.emit(line, "assert("+args+");")
+	case *parser.IfStatement:
+		f.emit(line, "if "+expr(st.Condition)+" {")
+		f.arms(st.Consequent, st.Alternative)
+	case *parser.IfLetStatement:
+		f.emit(line, "if let "+st.Name+" = "+expr(st.Value)+" {")
+		f.arms(st.Consequent, st.Alternative)
+	case *parser.WhileStatement:
+		f.emit(line, "while "+expr(st.Condition)+" {")
+		f.body(st.Body)
+		f.close("}")
 	}
-
-	// Function body
-	return f.formatNode(fn.Body)
 }
 
-func (f *Formatter) formatBlockStmt(block *ast.BlockStmt) error {
-	f.printf(" {\n")
-	f.indent++
-
-	for _, stmt := range block.List {
-		f.printIndent()
-		if err := f.formatNode(stmt); err != nil {
-			return err
+// arms prints the blocks of an if statement after its header, continuing
+// `else if` chains on the line of the closing brace.
+func (f *formatter) arms(then, els *parser.BlockStatement) {
+	f.body(then)
+	if els == nil {
+		f.close("}")
+		return
+	}
+	if els.End == 0 && len(els.Statements) == 1 {
+		switch st := els.Statements[0].(type) {
+		case *parser.IfStatement:
+			f.close("} else if " + expr(st.Condition) + " {")
+			f.arms(st.Consequent, st.Alternative)
+			return
+		case *parser.IfLetStatement:
+			f.close("} else if let " + st.Name + " = " + expr(st.Value) + " {")
+			f.arms(st.Consequent, st.Alternative)
+			return
 		}
-		f.printf("\n")
 	}
+	f.close("} else {")
+	f.body(els)
+	f.close("}")
+}
 
-	f.indent--
-	f.printIndent()
-	f.printf("}")
+// atom is the precedence of expressions that never need parentheses.
+const atom = math.MaxInt
 
-	return nil
+func precedence(e parser.Expression) int {
+	switch ex := e.(type) {
+	case *parser.InfixExpression:
+		return parser.Precedence(ex.Operator)
+	case *parser.CoalesceExpression:
+		return parser.Precedence("??")
+	}
+	return atom
 }
 
-// Helper methods for formatting
-func (f *Formatter) printf(format string, args ...interface{}) {
-	if f.lineStart {
-		f.printIndent()
+// bitwise reports whether op is a bitwise or shift operator. Mixed with
+// other operators these are parenthesized even where precedence does not
+// require it, so `(x & 1) == 0` keeps its parentheses.
+func bitwise(op string) bool {
+	switch op {
+	case "&", "|", "^", "<<", ">>":
+		return true
 	}
-	fmt.Fprintf(f.output, format, args...)
+	return false
 }
 
-func (f *Formatter) printIndent() {
-	if f.lineStart {
-		f.lineStart = false
-		for i := 0; i < f.indent; i++ {
-			f.output.WriteString("\t")
+func expr(e parser.Expression) string {
+	switch ex := e.(type) {
+	case *parser.IntegerLiteral:
+		return ex.Value
+	case *parser.StringLiteral:
+		if ex.Raw == "" && ex.Value != "" {
+			return quote(ex.Value)
+		}
+		return `"` + ex.Raw + `"`
+	case *parser.InterpolatedString:
+		return `"` + ex.Raw + `"`
+	case *parser.BooleanLiteral:
+		return strconv.FormatBool(ex.Value)
+	case *parser.NoneLiteral:
+		return "none"
+	case *parser.Identifier:
+		return ex.Value
+	case *parser.PrefixExpression:
+		return ex.Operator + operand(ex.Right, precedence(ex.Right) != atom)
+	case *parser.InfixExpression:
+		p := parser.Precedence(ex.Operator)
+		return operand(ex.Left, needParens(ex.Left, ex.Operator, p, false)) + " " + ex.Operator + " " +
+			operand(ex.Right, needParens(ex.Right, ex.Operator, p, true))
+	case *parser.CoalesceExpression:
+		// ?? groups to the right
+		p := parser.Precedence("??")
+		return operand(ex.Left, precedence(ex.Left) <= p) + " ?? " + operand(ex.Right, precedence(ex.Right) < p)
+	case *parser.CallExpression:
+		args := make([]string, len(ex.Args))
+		for i, a := range ex.Args {
+			args[i] = expr(a)
 		}
+		if ex.Index && len(args) == 3 {
+			if end, ok := ex.Args[2].(*parser.IntegerLiteral); ok && end.Value == "-1" {
+				return args[0] + "[" + args[1] + "]"
+			}
+			return args[0] + "[" + args[1] + ", " + args[2] + "]"
+		}
+		return expr(ex.Function) + "(" + strings.Join(args, ", ") + ")"
 	}
+	return ""
 }
 
-// ... (implement other format methods for different AST nodes)
-
-// FormatFile formats a single file
-func FormatFile(filename string) ([]byte, error) {
-	src, err := os.ReadFile(filename)
-	if err != nil {
-		return nil, err
+// needParens reports whether operand e of binary operator op, of
+// precedence p, is parenthesized. Operators group to the left, so a right
+// operand of the same precedence needs them.
+func needParens(e parser.Expression, op string, p int, right bool) bool {
+	ep := precedence(e)
+	if ep < p || right && ep == p {
+		return true
 	}
-
-	res, err := Format(src)
-	if err != nil {
-		return nil, fmt.Errorf("formatting %s: %v", filename, err)
-	}
-
-	if bytes.Equal(src, res) {
-		return nil, nil // No changes
+	if in, ok := e.(*parser.InfixExpression); ok && ep != p {
+		return bitwise(op) || bitwise(in.Operator)
 	}
-
-	return res, nil
+	return false
 }
 
-// Process processes the input and writes the formatted output to w.
-// The results of processing the source are the same as calling Format.
-func Process(filename string, src []byte, w io.Writer) error {
-	res, err := Format(src)
-	if err != nil {
-		return err
+func operand(e parser.Expression, parens bool) string {
+	if parens {
+		return "(" + expr(e) + ")"
 	}
+	return expr(e)
+}
 
-	if src != nil && bytes.Equal(src, res) {
-		return nil // No changes
+// quote writes s as a string literal, for literals without source text.
+func quote(s string) string {
+	var sb strings.Builder
+	sb.WriteByte('"')
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; c {
+		case '\n':
+			sb.WriteString(`\n`)
+		case '\t':
+			sb.WriteString(`\t`)
+		case '\r':
+			sb.WriteString(`\r`)
+		case 0:
+			sb.WriteString(`\0`)
+		case '\\', '"', '$':
+			sb.WriteByte('\\')
+			sb.WriteByte(c)
+		default:
+			sb.WriteByte(c)
+		}
 	}
-
-	_, err = w.Write(res)
-	return err
+	sb.WriteByte('"')
+	return sb.String()
 }
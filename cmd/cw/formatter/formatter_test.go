@@ -0,0 +1,146 @@
+package formatter
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+var formatTests = []struct {
+	name, src, want string
+}{
+	{
+		"layout",
+		"fn main()->int{var x:int=(1+2)*3;if(x>1){Print(\"big\");}else{Print(\"small\");}\nreturn 0;}\n",
+		`fn main() -> int {
+    var x: int = (1 + 2) * 3;
+    if x > 1 {
+        Print("big");
+    } else {
+        Print("small");
+    }
+    return 0;
+}
+`,
+	},
+	{
+		"comments",
+		"// header\n\n/// doc\nfn f() -> int {\n  // leading\n  return 1; // trailing\n}\n",
+		`// header
+
+/// doc
+fn f() -> int {
+    // leading
+    return 1; // trailing
+}
+`,
+	},
+	{
+		"blank lines",
+		"import \"a.cw\";\nfn f() -> int {\n    var a: int = 1;\n\n\n\n    return a;\n}\n\n\nfn main() -> int {\n    return f();\n}\n",
+		`import "a.cw";
+
+fn f() -> int {
+    var a: int = 1;
+
+    return a;
+}
+
+fn main() -> int {
+    return f();
+}
+`,
+	},
+	{
+		"crlf",
+		"fn main() -> int {\r\n\treturn 0; // done\r\n}\r\n",
+		"fn main() -> int {\n    return 0; // done\n}\n",
+	},
+}
+
+func TestFormat(t *testing.T) {
+	for _, tt := range formatTests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Format([]byte(tt.src))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(got) != tt.want {
+				t.Errorf("Format:\n%s\nwant:\n%s", got, tt.want)
+			}
+		})
+	}
+}
+
+// TestIdempotent formats the table's sources and the conformance programs
+// twice: the second pass must change nothing and no comment may be lost.
+func TestIdempotent(t *testing.T) {
+	sources := map[string]string{}
+	for _, tt := range formatTests {
+		sources[tt.name] = tt.src
+	}
+	files, _ := filepath.Glob(filepath.Join("..", "..", "..", "tests", "conformance", "*.cw"))
+	for _, file := range files {
+		src, err := os.ReadFile(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		sources[filepath.Base(file)] = string(src)
+	}
+	for name, src := range sources {
+		t.Run(name, func(t *testing.T) {
+			once, err := Format([]byte(src))
+			if err != nil {
+				t.Fatal(err)
+			}
+			twice, err := Format(once)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(twice) != string(once) {
+				t.Errorf("formatting again changed\n%s\nto\n%s", once, twice)
+			}
+			for _, c := range comments(src) {
+				if !strings.Contains(string(once), c) {
+					t.Errorf("comment %q lost:\n%s", c, once)
+				}
+			}
+		})
+	}
+}
+
+func TestParseError(t *testing.T) {
+	if out, err := Format([]byte("fn main() -> int {\n    return 0\n")); err == nil {
+		t.Errorf("Format of invalid source succeeded:\n%s", out)
+	}
+}
+
+// TestCommentInExpression checks that comments inside a statement, which
+// would be moved or joined onto one line, are refused.
+func TestCommentInExpression(t *testing.T) {
+	for _, src := range []string{
+		"fn main() -> int {\n    var x: int = 1 + // one\n        2; // two\n    return x;\n}\n",
+		"fn main() -> int {\n    var x: int = 1 +\n        // one\n        2;\n    return x;\n}\n",
+		"fn main() -> int {\n    Print(\"a\", // first\n        \"b\");\n    return 0;\n}\n",
+	} {
+		out, err := Format([]byte(src))
+		if err == nil {
+			t.Errorf("Format succeeded:\n%s", out)
+		} else if !strings.HasPrefix(err.Error(), "line ") || !strings.Contains(err.Error(), "comment inside a statement") {
+			t.Errorf("Format error = %v", err)
+		}
+	}
+}
+
+// comments returns what follows each // in src. A // inside a string
+// literal is returned too, which does no harm as literals are kept.
+func comments(src string) []string {
+	var out []string
+	for _, line := range strings.Split(src, "\n") {
+		if i := strings.Index(line, "//"); i >= 0 {
+			out = append(out, strings.TrimSpace(line[i:]))
+		}
+	}
+	return out
+}
@@ -14,8 +14,12 @@ import (
 	cwcompiler "codeberg.org/clockwise-lang/clockwise/cmd/cw/compiler"
 	"codeberg.org/clockwise-lang/clockwise/checker"
 	"codeberg.org/clockwise-lang/clockwise/codegen"
+	"codeberg.org/clockwise-lang/clockwise/interp"
+	"codeberg.org/clockwise-lang/clockwise/ir"
 	"codeberg.org/clockwise-lang/clockwise/lexer"
+	"codeberg.org/clockwise-lang/clockwise/lsp"
 	"codeberg.org/clockwise-lang/clockwise/parser"
+	"codeberg.org/clockwise-lang/clockwise/repl"
 )
 
 const (
@@ -25,17 +29,32 @@ const (
 Usage:
   cwc [command] [flags]
   cwc [input.cw] [flags]
-  cwc build [input.cw] [-o output]
-  cwc run [input.cw] [args...]
-  cwc fmt [input.cw]
+  cwc build [input.cw] [-o output] [--target os/arch]
+  cwc run [--interp] [input.cw] [args...]
+  cwc repl
+  cwc lsp
+  cwc compile --bytecode [input.cw] [-o output.cwb]
+  cwc exec [program.cwb] [args...]
+  cwc disasm [program.cwb]
+  cwc fmt [-w | -l | -d | --check] [input.cw | dir | -]
+  cwc doc [-o dir] [-format markdown|html|json] [--runtime | input.cw | dir]
+  cwc clean
+  cwc targets
   cwc --update
   cwc --help | -h
   cwc --version | -v
+  cwc version -m <binary>
 
 Examples:
   cwc build program.cw -o program
+  cwc build --backend=c program.cw -o program
+  cwc build --target linux/arm64,windows/amd64 -o program program.cw
   cwc run program.cw arg1 arg2
+  cwc run --interp program.cw
+  cwc compile --bytecode script.cw && cwc exec script.cwb
   cwc fmt program.cw
+  cwc fmt --check -d .
+  cwc doc -o docs/ src/
   cwc --update
   cwc --help`
 )
@@ -60,8 +79,24 @@ func main() {
 		buildCmd()
 	case "run":
 		runCmd()
+	case "repl":
+		replCmd()
+	case "lsp":
+		lspCmd()
+	case "compile":
+		compileCmd()
+	case "exec":
+		execCmd()
+	case "disasm":
+		disasmCmd()
 	case "fmt":
 		fmtCmd()
+	case "doc":
+		docCmd()
+	case "clean":
+		cleanCmd()
+	case "targets":
+		targetsCmd()
 	case "--update":
 		updateCmd()
 	case "--help", "-h":
@@ -70,6 +105,8 @@ func main() {
 	case "--version", "-v":
 		printVersion()
 		os.Exit(0)
+	case "version":
+		versionCmd()
 	default:
 		// If no command specified, default to build
 		buildCmd()
@@ -80,8 +117,19 @@ func buildCmd() {
 	fs := flag.NewFlagSet("build", flag.ExitOnError)
 	outputFile := fs.String("o", "", "Output file (default: input filename without extension)")
 	verbose := fs.Bool("v", false, "Enable verbose output")
+	reproducible := fs.Bool("reproducible", false, "Produce byte-identical output for identical inputs (trimmed paths, fixed build ID)")
+	release := fs.Bool("release", false, "Release profile: run the optimizer and strip symbols")
+	debug := fs.Bool("debug", false, "Debug profile: keep DWARF, disable optimizations and inlining")
+	sizeReport := fs.Bool("size-report", false, "Print the output's size broken down by function and runtime module")
+	emit := fs.String("emit", "", "Write an intermediate form instead of building: ir (to -o, default stdout)")
+	backend := fs.String("backend", "go", "Code generator: go, or c to build C99 with the host C compiler (-o file.c writes the C sources)")
+	o0 := fs.Bool("O0", false, "Disable AST optimizations")
+	o1 := fs.Bool("O1", false, "Fold constants and drop unreachable code (default)")
+	o2 := fs.Bool("O2", false, "Also remove unused locals and uncalled functions (default with --release)")
+	var targets targetList
+	fs.Var(&targets, "target", "Target `os/arch` to build for; repeat or separate with commas for several (see 'cwc targets')")
 	fs.Usage = func() {
-		fmt.Fprintf(os.Stderr, "Usage: cwc build [input1.cw input2.cw ...] [-o output]\n")
+		fmt.Fprintf(os.Stderr, "Usage: cwc build [-o output] [-O0|-O1|-O2] [--emit=ir] [--backend=go|c] [--target os/arch] [input1.cw input2.cw ...]\n")
 		fmt.Fprintf(os.Stderr, "  If multiple input files are provided, they will be compiled together.\n")
 		fs.PrintDefaults()
 	}
@@ -99,8 +147,17 @@ func buildCmd() {
 	}
 	inputFiles := args
 
+	if *emit != "" && *emit != "ir" {
+		log.Fatalf("unknown --emit form %q; supported: ir", *emit)
+	}
+	if *backend != "go" && *backend != "c" {
+		log.Fatalf("unknown --backend %q; supported: go, c", *backend)
+	}
+
 	// Set default output file if not specified
-	if *outputFile == "" {
+	if *outputFile == "" && *emit != "" {
+		*outputFile = "-"
+	} else if *outputFile == "" {
 		if len(inputFiles) == 1 {
 			*outputFile = strings.TrimSuffix(inputFiles[0], filepath.Ext(inputFiles[0]))
 		} else {
@@ -108,20 +165,92 @@ func buildCmd() {
 		}
 	}
 
-	// Run the compiler
-	comp := cwcompiler.NewCompiler(inputFiles, *outputFile)
-	comp.Verbose = *verbose
+	if *release && *debug {
+		log.Fatal("--release and --debug cannot be combined")
+	}
+	optLevel := -1
+	for level, set := range []bool{*o0, *o1, *o2} {
+		if set {
+			if optLevel >= 0 {
+				log.Fatal("only one of -O0, -O1 and -O2 may be given")
+			}
+			optLevel = level
+		}
+	}
+	for i, t := range targets {
+		t = cwcompiler.CanonicalTarget(t)
+		targets[i] = t
+		if err := cwcompiler.ValidTarget(t); err != nil {
+			log.Fatal(err)
+		}
+	}
+	if len(targets) == 0 {
+		targets = targetList{""}
+	}
 
-	if err := comp.Compile(); err != nil {
-		log.Fatalf("Compilation failed: %v", err)
+	// Run the compiler once per target
+	for _, t := range targets {
+		out := *outputFile
+		if t != "" {
+			out = cwcompiler.TargetOutput(out, t, len(targets) > 1)
+		}
+		comp := newCompiler(inputFiles, out)
+		comp.Verbose = *verbose
+		comp.Target = t
+		comp.Reproducible = *reproducible
+		if *release {
+			comp.OptLevel = 2
+			comp.Strip = true
+		}
+		if *debug {
+			comp.OptLevel = 0
+			comp.Debug = true
+		}
+		if optLevel >= 0 {
+			comp.OptLevel = optLevel
+		}
+		if *sizeReport {
+			comp.SizeReport = os.Stdout
+		}
+		comp.Emit = *emit
+		comp.Backend = *backend
+
+		if err := comp.Compile(); err != nil {
+			log.Fatalf("Compilation failed: %v", err)
+		}
+	}
+}
+
+// targetList collects --target flags; each may hold several comma-separated
+// os/arch pairs.
+type targetList []string
+
+func (t *targetList) String() string { return strings.Join(*t, ",") }
+
+func (t *targetList) Set(v string) error {
+	for _, pair := range strings.Split(v, ",") {
+		if pair = strings.TrimSpace(pair); pair != "" {
+			*t = append(*t, pair)
+		}
 	}
+	return nil
 }
 
+// targetsCmd lists the supported cross-compilation targets.
+func targetsCmd() {
+	for _, t := range cwcompiler.Targets {
+		fmt.Println(t)
+	}
+}
+
+// runCmd builds and runs a program, or interprets it. Either way cwc exits
+// with the program's exit status, like 'cwc exec'.
 func runCmd() {
 	fs := flag.NewFlagSet("run", flag.ExitOnError)
 	verbose := fs.Bool("v", false, "Enable verbose output")
+	useInterp := fs.Bool("interp", false, "Run with the interpreter instead of building (the default when no Go toolchain is installed)")
 	fs.Usage = func() {
-		fmt.Fprintf(os.Stderr, "Usage: cwc run [input1.cw input2.cw ...] [-- program args...]\n")
+		fmt.Fprintf(os.Stderr, "Usage: cwc run [--interp] [input1.cw input2.cw ...] [-- program args...]\n")
 		fmt.Fprintf(os.Stderr, "  If multiple input files are provided, they will be compiled together.\n")
 		fmt.Fprintf(os.Stderr, "  Use '--' to separate Clockwise files from program arguments.\n")
 		fs.PrintDefaults()
@@ -155,10 +284,23 @@ func runCmd() {
 		}
 	}
 
+	if !*useInterp {
+		if _, err := exec.LookPath("go"); err != nil {
+			if *verbose {
+				fmt.Println("No Go toolchain found; running with the interpreter")
+			}
+			*useInterp = true
+		}
+	}
+	if *useInterp {
+		interpRun(inputFiles, programArgs, *verbose)
+		return
+	}
+
 	tempExe := filepath.Join(os.TempDir(), "cwc-run-*")
 
 	// Compile to temp file
-	comp := cwcompiler.NewCompiler(inputFiles, tempExe)
+	comp := newCompiler(inputFiles, tempExe)
 	comp.Verbose = *verbose
 
 	if err := comp.Compile(); err != nil {
@@ -171,86 +313,102 @@ func runCmd() {
 	cmd.Stdout = os.Stdout
 	cmd.Stderr = os.Stderr
 
-	if err := cmd.Run(); err != nil {
-		log.Fatalf("Program exited with error: %v", err)
+	err := cmd.Run()
+	os.Remove(tempExe)
+	if exit, ok := err.(*exec.ExitError); ok && exit.ExitCode() >= 0 {
+		os.Exit(exit.ExitCode())
+	} else if err != nil {
+		log.Fatalf("Program failed: %v", err)
 	}
+}
 
-	// Clean up
-	os.Remove(tempExe)
+// interpRun checks the input files and runs them with the interpreter,
+// reporting a panic and exiting with main's result like the compiled
+// program. The runtime helpers see args as the program's arguments.
+func interpRun(inputFiles, args []string, verbose bool) {
+	comp := newCompiler(inputFiles, "")
+	comp.Verbose = verbose
+	program, err := comp.Check()
+	if err != nil {
+		log.Fatalf("Compilation failed: %v", err)
+	}
+	in, err := interp.New(program)
+	if err != nil {
+		log.Fatalf("Compilation failed: %v", err)
+	}
+	os.Args = append([]string{inputFiles[0]}, args...)
+	status, err := in.Run()
+	if p, ok := err.(*interp.Panic); ok {
+		fmt.Fprintln(os.Stderr, p)
+	} else if err != nil {
+		log.Fatalf("Interpreter error: %v", err)
+	}
+	os.Exit(status & 0xff)
 }
 
-func fmtCmd() {
-	fs := flag.NewFlagSet("fmt", flag.ExitOnError)
-	write := fs.Bool("w", false, "write result to (source) file instead of stdout")
+// replCmd starts an interactive session. Prompts are only shown when stdin
+// is a terminal, so input can also be piped in.
+func replCmd() {
+	fs := flag.NewFlagSet("repl", flag.ExitOnError)
 	fs.Usage = func() {
-		fmt.Fprintf(os.Stderr, "Usage: cwc fmt [flags] [path ...]\n")
+		fmt.Fprintf(os.Stderr, "Usage: cwc repl\n")
 		fs.PrintDefaults()
 	}
-
 	if err := fs.Parse(os.Args[2:]); err != nil {
 		log.Fatalf("Error parsing flags: %v", err)
 	}
-
-	args := fs.Args()
-	if len(args) == 0 {
-		args = []string{"."}
+	interactive := false
+	if info, err := os.Stdin.Stat(); err == nil && info.Mode()&os.ModeCharDevice != 0 {
+		interactive = true
+		fmt.Printf("Clockwise %s REPL. Type :help for commands, :quit to leave.\n", version)
 	}
-
-	var files []string
-	for _, arg := range args {
-		info, err := os.Stat(arg)
-		if err != nil {
-			log.Printf("Error reading %s: %v", arg, err)
-			continue
-		}
-		if info.IsDir() {
-			dirFiles, err := findCWFiles(arg)
-			if err != nil {
-				log.Printf("Error finding .cw files in %s: %v", arg, err)
-				continue
-			}
-			files = append(files, dirFiles...)
-		} else if strings.HasSuffix(arg, ".cw") {
-			files = append(files, arg)
-		}
+	if err := repl.New(os.Stdout).Run(os.Stdin, interactive); err != nil {
+		log.Fatal(err)
 	}
+}
 
-	for _, file := range files {
-		data, err := ioutil.ReadFile(file)
-		if err != nil {
-			log.Printf("Error reading %s: %v", file, err)
-			continue
-		}
-		// very simple formatter: normalize line endings and trim trailing spaces
-		s := strings.ReplaceAll(string(data), "\r\n", "\n")
-		lines := strings.Split(s, "\n")
-		for i, l := range lines {
-			lines[i] = strings.TrimRight(l, " \t")
-		}
-		out := strings.Join(lines, "\n")
-		if *write {
-			if err := ioutil.WriteFile(file, []byte(out), 0644); err != nil {
-				log.Printf("Error writing %s: %v", file, err)
-			}
-		} else {
-			fmt.Print(out)
-		}
+// lspCmd serves the language server protocol over stdin and stdout for
+// editors.
+func lspCmd() {
+	fs := flag.NewFlagSet("lsp", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: cwc lsp\n")
+		fmt.Fprintf(os.Stderr, "  Speaks the language server protocol on stdin and stdout.\n")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(os.Args[2:]); err != nil {
+		log.Fatalf("Error parsing flags: %v", err)
+	}
+	// without the runtime, helpers are shown by signature only
+	runtimeDir, _ := cwcompiler.FindRuntimeDir()
+	if err := lsp.New(os.Stdin, os.Stdout, runtimeDir).Run(); err != nil {
+		log.Fatal(err)
 	}
 }
 
-func findCWFiles(dir string) ([]string, error) {
-	var files []string
-	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
-		if err != nil {
-			return err
-		}
-		if !info.IsDir() && strings.HasSuffix(path, ".cw") {
-			files = append(files, path)
-		}
-		return nil
-	})
+// newCompiler returns a compiler configured with this build's version and
+// the user's build cache.
+func newCompiler(inputFiles []string, outputFile string) *cwcompiler.Compiler {
+	comp := cwcompiler.NewCompiler(inputFiles, outputFile)
+	comp.Version = version
+	comp.Commit = commit
+	if dir, err := cwcompiler.DefaultCacheDir(); err == nil {
+		comp.CacheDir = dir
+	}
+	return comp
+}
 
-	return files, err
+// cleanCmd removes the build cache.
+func cleanCmd() {
+	dir, err := cwcompiler.DefaultCacheDir()
+	if err != nil {
+		log.Fatalf("Could not locate the build cache: %v", err)
+	}
+	freed, err := cwcompiler.CleanCache(dir)
+	if err != nil {
+		log.Fatalf("Failed to clean %s: %v", dir, err)
+	}
+	fmt.Printf("Removed %s (%d bytes)\n", dir, freed)
 }
 
 func printUsage(err error) {
@@ -260,6 +418,47 @@ func printUsage(err error) {
 	fmt.Fprintf(os.Stderr, "%s\n", appDescription)
 }
 
+// versionCmd prints the compiler version, or with -m the provenance record
+// embedded in executables built by cwc.
+func versionCmd() {
+	fs := flag.NewFlagSet("version", flag.ExitOnError)
+	modules := fs.Bool("m", false, "print the build provenance of the given executables")
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: cwc version [-m binary ...]\n")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(os.Args[2:]); err != nil {
+		log.Fatalf("Error parsing flags: %v", err)
+	}
+	if !*modules {
+		printVersion()
+		return
+	}
+	if fs.NArg() == 0 {
+		fs.Usage()
+		os.Exit(1)
+	}
+	failed := false
+	for _, path := range fs.Args() {
+		p, err := cwcompiler.ReadProvenance(path)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			failed = true
+			continue
+		}
+		fmt.Printf("%s: built by %s %s (commit %s)\n", path, appName, p.Compiler, p.Commit)
+		fmt.Printf("\ttarget\t%s\n", p.Target)
+		fmt.Printf("\treproducible\t%t\n", p.Reproducible)
+		for _, s := range p.Sources {
+			fmt.Printf("\tsource\t%s\tsha256:%s\n", s.Path, s.SHA256)
+		}
+		fmt.Printf("\truntime\t%s\tsha256:%s\n", strings.Join(p.RuntimeLibs, ","), p.Runtime)
+	}
+	if failed {
+		os.Exit(1)
+	}
+}
+
 func printVersion() {
 	fmt.Printf("%s version %s (commit %s, built %s)\n", appName, version, commit, date)
 }
@@ -275,10 +474,17 @@ func doBuild(inPath, outPath string, keep bool) error {
 	if perr != nil {
 		return perr
 	}
+	for _, fn := range program.Functions {
+		fn.File = inPath
+	}
 	if err := checker.CheckProgram(program); err != nil {
 		return err
 	}
-	gosrc := codegen.Generate(program)
+	lowered, err := ir.Lower(program)
+	if err != nil {
+		return err
+	}
+	gosrc := codegen.Generate(lowered, "")
 	tmpDir, err := ioutil.TempDir("", "clockwise-build-")
 	if err != nil {
 		return err
@@ -0,0 +1,299 @@
+// cwconform runs the conformance suite: every program in the suite
+// directory is run with the interpreter, compiled to bytecode, written and
+// read back as a .cwb file and run on the bytecode VM, and built with the Go
+// backend and with the C backend and run. The C build is skipped when no C
+// compiler is found, and for programs using what the C backend does not
+// support yet. Every run must produce the expected stdout (<name>.out),
+// stderr (<name>.err, empty if absent) and exit status (a `// exit: N`
+// comment at the top of the program, 0 if absent). A program that does not
+// compile is expected to print the compile error and exit with status 1
+// every way.
+//
+// Run it from the repository root:
+//
+//	go run ./cmd/cwconform [-update] [-interp-only] [dir]
+package main
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"codeberg.org/clockwise-lang/clockwise/bytecode"
+	cwcompiler "codeberg.org/clockwise-lang/clockwise/cmd/cw/compiler"
+	"codeberg.org/clockwise-lang/clockwise/codegen/cgen"
+	"codeberg.org/clockwise-lang/clockwise/interp"
+	"codeberg.org/clockwise-lang/clockwise/ir"
+	"codeberg.org/clockwise-lang/clockwise/parser"
+)
+
+// result is what one run of a program produced.
+type result struct {
+	stdout, stderr string
+	status         int
+}
+
+var exitComment = regexp.MustCompile(`^// exit: (\d+)`)
+
+func main() {
+	update := flag.Bool("update", false, "record the interpreter's output as the expected output")
+	interpOnly := flag.Bool("interp-only", false, "skip the compiled runs, checking the interpreter and bytecode VM only")
+	runFile := flag.String("run", "", "internal: run one program in-process and exit with its status")
+	mode := flag.String("mode", "interp", "internal: how -run runs the program: interp or vm")
+	flag.Parse()
+
+	if *runFile != "" {
+		os.Exit(runInProcess(*runFile, *mode))
+	}
+
+	dir := filepath.Join("tests", "conformance")
+	if flag.NArg() > 0 {
+		dir = flag.Arg(0)
+	}
+	files, err := filepath.Glob(filepath.Join(dir, "*.cw"))
+	if err != nil || len(files) == 0 {
+		fmt.Fprintf(os.Stderr, "no programs found in %s\n", dir)
+		os.Exit(1)
+	}
+	var backends []string
+	if !*interpOnly {
+		backends = compiledBackends()
+	}
+	work, err := ioutil.TempDir("", "cwconform")
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	defer os.RemoveAll(work)
+
+	failed := 0
+	for _, file := range files {
+		if err := check(file, work, *update, backends); err != nil {
+			fmt.Printf("FAIL %s\n%v\n", file, err)
+			failed++
+			continue
+		}
+		fmt.Printf("ok   %s\n", file)
+	}
+	if failed > 0 {
+		fmt.Printf("%d of %d programs failed\n", failed, len(files))
+		os.Exit(1)
+	}
+}
+
+// compiledBackends returns the back ends whose toolchain is installed, saying
+// which are skipped.
+func compiledBackends() []string {
+	var backends []string
+	if _, err := exec.LookPath("go"); err == nil {
+		backends = append(backends, "go")
+	} else {
+		fmt.Fprintln(os.Stderr, "No Go toolchain found; skipping the Go backend")
+	}
+	if _, err := cwcompiler.FindCCompiler(); err == nil {
+		backends = append(backends, "c")
+	} else {
+		fmt.Fprintln(os.Stderr, "No C compiler found; skipping the C backend")
+	}
+	return backends
+}
+
+// check runs one program with the interpreter, on the bytecode VM and
+// built with each of backends, and compares the runs with the expected
+// result, or first records the interpreter's run as the expected result.
+func check(file, work string, update bool, backends []string) error {
+	cmd, err := inProcess(file, "interp")
+	if err != nil {
+		return err
+	}
+	got, err := run(cmd)
+	if err != nil {
+		return err
+	}
+	base := strings.TrimSuffix(file, ".cw")
+	if update {
+		if err := record(base, got); err != nil {
+			return err
+		}
+	}
+	want, err := expected(file)
+	if err != nil {
+		return err
+	}
+	if err := compare("interpreted", got, want); err != nil {
+		return err
+	}
+	if cmd, err = inProcess(file, "vm"); err != nil {
+		return err
+	}
+	got, err = run(cmd)
+	if err != nil {
+		return err
+	}
+	if err := compare("bytecode", got, want); err != nil {
+		return err
+	}
+	for _, backend := range backends {
+		mode := "compiled with " + backend
+		exe := filepath.Join(work, filepath.Base(base)+"-"+backend)
+		comp := cwcompiler.NewCompiler([]string{file}, exe)
+		comp.Reproducible = true
+		comp.Backend = backend
+		if err := comp.Compile(); errors.Is(err, cgen.ErrUnsupported) {
+			continue
+		} else if err != nil {
+			// a program the checker rejects fails the same way on every back end
+			if err := compare(mode, result{stderr: err.Error() + "\n", status: 1}, want); err != nil {
+				return err
+			}
+			continue
+		}
+		if got, err = run(exec.Command(exe)); err != nil {
+			return err
+		}
+		if err := compare(mode, got, want); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// inProcess returns the command that runs file with runInProcess in a
+// child process, which keeps a panic or exit status of the program from
+// ending the suite.
+var inProcess = func(file, mode string) (*exec.Cmd, error) {
+	self, err := os.Executable()
+	if err != nil {
+		return nil, err
+	}
+	return exec.Command(self, "-run", file, "-mode", mode), nil
+}
+
+// record writes the expected output of the program base.cw.
+func record(base string, res result) error {
+	if err := ioutil.WriteFile(base+".out", []byte(res.stdout), 0644); err != nil {
+		return err
+	}
+	if res.stderr == "" {
+		if err := os.Remove(base + ".err"); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return nil
+	}
+	return ioutil.WriteFile(base+".err", []byte(res.stderr), 0644)
+}
+
+// expected reads the expected result of a program from the files beside it.
+func expected(file string) (result, error) {
+	base := strings.TrimSuffix(file, ".cw")
+	src, err := ioutil.ReadFile(file)
+	if err != nil {
+		return result{}, err
+	}
+	var want result
+	if m := exitComment.FindSubmatch(src); m != nil {
+		want.status, _ = strconv.Atoi(string(m[1]))
+	}
+	out, err := ioutil.ReadFile(base + ".out")
+	if err != nil {
+		return result{}, err
+	}
+	want.stdout = string(out)
+	if errOut, err := ioutil.ReadFile(base + ".err"); err == nil {
+		want.stderr = string(errOut)
+	} else if !os.IsNotExist(err) {
+		return result{}, err
+	}
+	return want, nil
+}
+
+func compare(mode string, got, want result) error {
+	var diffs []string
+	if got.stdout != want.stdout {
+		diffs = append(diffs, fmt.Sprintf("%s stdout:\n%s\nwant:\n%s", mode, got.stdout, want.stdout))
+	}
+	if got.stderr != want.stderr {
+		diffs = append(diffs, fmt.Sprintf("%s stderr:\n%s\nwant:\n%s", mode, got.stderr, want.stderr))
+	}
+	if got.status != want.status {
+		diffs = append(diffs, fmt.Sprintf("%s exit status %d, want %d", mode, got.status, want.status))
+	}
+	if len(diffs) > 0 {
+		return fmt.Errorf("%s", strings.Join(diffs, "\n"))
+	}
+	return nil
+}
+
+// run runs cmd and collects its output and exit status.
+func run(cmd *exec.Cmd) (result, error) {
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	err := cmd.Run()
+	res := result{stdout: stdout.String(), stderr: stderr.String()}
+	if exit, ok := err.(*exec.ExitError); ok {
+		res.status = exit.ExitCode()
+	} else if err != nil {
+		return result{}, err
+	}
+	return res, nil
+}
+
+// runInProcess runs file with the interpreter or the bytecode VM the way a
+// compiled program runs: a panic is reported on stderr with status 2.
+// Compile-time warnings are not part of the program's output and are
+// discarded.
+func runInProcess(file, mode string) int {
+	comp := cwcompiler.NewCompiler([]string{file}, "")
+	comp.Reproducible = true
+	stderr := os.Stderr
+	os.Stderr, _ = os.OpenFile(os.DevNull, os.O_WRONLY, 0)
+	var runner interface{ Run() (int, error) }
+	var err error
+	if mode == "vm" {
+		var program *ir.Program
+		if program, err = comp.Lower(); err == nil {
+			runner, err = loadVM(program)
+		}
+	} else {
+		var program *parser.Program
+		if program, err = comp.Check(); err == nil {
+			runner, err = interp.New(program)
+		}
+	}
+	os.Stderr = stderr
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	status, err := runner.Run()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+	}
+	return status & 0xff
+}
+
+// loadVM compiles program to bytecode and loads it into a VM through the
+// .cwb encoding, so that the file format is covered too.
+func loadVM(program *ir.Program) (*bytecode.VM, error) {
+	prog, err := bytecode.Compile(program)
+	if err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	if err := bytecode.Encode(&buf, prog); err != nil {
+		return nil, err
+	}
+	if prog, err = bytecode.Decode(&buf); err != nil {
+		return nil, err
+	}
+	return bytecode.New(prog)
+}
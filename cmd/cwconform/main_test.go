@@ -0,0 +1,49 @@
+package main
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+)
+
+// TestMain runs the suite from the repository root, where the expected
+// output was recorded. The test binary stands in for cwconform -run when
+// CWCONFORM_RUN names a program.
+func TestMain(m *testing.M) {
+	if file := os.Getenv("CWCONFORM_RUN"); file != "" {
+		os.Exit(runInProcess(file, os.Getenv("CWCONFORM_MODE")))
+	}
+	if err := os.Chdir(filepath.Join("..", "..")); err != nil {
+		panic(err)
+	}
+	inProcess = func(file, mode string) (*exec.Cmd, error) {
+		cmd := exec.Command(os.Args[0])
+		cmd.Env = append(os.Environ(), "CWCONFORM_RUN="+file, "CWCONFORM_MODE="+mode)
+		return cmd, nil
+	}
+	os.Exit(m.Run())
+}
+
+// TestConformance runs every program of the suite with the interpreter,
+// the bytecode VM and, unless -short is given, built with the Go and C
+// backends whose toolchains are installed.
+func TestConformance(t *testing.T) {
+	files, err := filepath.Glob(filepath.Join("tests", "conformance", "*.cw"))
+	if err != nil || len(files) == 0 {
+		t.Fatalf("no programs found: %v", err)
+	}
+	var backends []string
+	if !testing.Short() {
+		backends = compiledBackends()
+	}
+	for _, file := range files {
+		file := file
+		t.Run(filepath.Base(file), func(t *testing.T) {
+			t.Parallel()
+			if err := check(file, t.TempDir(), false, backends); err != nil {
+				t.Error(err)
+			}
+		})
+	}
+}
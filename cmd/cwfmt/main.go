@@ -5,10 +5,11 @@ import (
     "fmt"
     "io/ioutil"
     "os"
-    "strings"
+
+    "codeberg.org/clockwise-lang/clockwise/cmd/cw/formatter"
 )
 
-// cwfmt is a tiny formatter: trims trailing spaces and ensures unix newlines.
+// cwfmt formats one .cw file in place, or into -out; see `cwc fmt`.
 func main() {
     in := flag.String("in", "", "input .cw file")
     out := flag.String("out", "", "output file (defaults to overwrite input)")
@@ -22,19 +23,15 @@ func main() {
         fmt.Fprintln(os.Stderr, err)
         os.Exit(1)
     }
-    s := string(data)
-    // normalize newlines
-    s = strings.ReplaceAll(s, "\r\n", "\n")
-    // trim trailing spaces on each line
-    lines := strings.Split(s, "\n")
-    for i, l := range lines {
-        lines[i] = strings.TrimRight(l, " \t")
+    formatted, err := formatter.Format(data)
+    if err != nil {
+        fmt.Fprintf(os.Stderr, "%s: %v\n", *in, err)
+        os.Exit(1)
     }
-    outS := strings.Join(lines, "\n")
     if *out == "" {
         *out = *in
     }
-    if err := ioutil.WriteFile(*out, []byte(outS), 0644); err != nil {
+    if err := ioutil.WriteFile(*out, formatted, 0644); err != nil {
         fmt.Fprintln(os.Stderr, err)
         os.Exit(1)
     }
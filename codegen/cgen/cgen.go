@@ -0,0 +1,484 @@
+// Package cgen generates portable C99 from the IR. The output is compiled
+// together with the C runtime in RuntimeSource, so programs built this way
+// need only a C compiler.
+package cgen
+
+import (
+    "errors"
+    "fmt"
+    "math/big"
+    "sort"
+    "strings"
+
+    "codeberg.org/clockwise-lang/clockwise/ir"
+)
+
+// cTypes maps Clockwise types to C. Clockwise int is 64 bits wide, like Go's
+// int on the platforms cwc targets.
+var cTypes = map[ir.Type]string{
+    "int":    "int64_t",
+    "i8":     "int8_t",
+    "i16":    "int16_t",
+    "i32":    "int32_t",
+    "i64":    "int64_t",
+    "u8":     "uint8_t",
+    "u16":    "uint16_t",
+    "u32":    "uint32_t",
+    "u64":    "uint64_t",
+    "string": "cw_string",
+    "bool":   "bool",
+}
+
+// builtins maps the runtime helpers available to C programs to their C
+// names.
+var builtins = map[string]string{
+    "Print": "cw_print",
+}
+
+// ErrUnsupported is wrapped by the errors reporting constructs and runtime
+// helpers the C backend cannot generate, as opposed to programs that are
+// wrong.
+var ErrUnsupported = errors.New("not supported by the C backend")
+
+// generator holds the output being built and the function being generated.
+type generator struct {
+    thunks strings.Builder
+    funcs  map[string]bool
+    fn     *ir.Func
+    defers int
+}
+
+// Generate returns C99 source for p, which includes RuntimeHeaderName.
+// Clockwise functions become cwf_<name> so they cannot collide with the C
+// library; main() is left out when CW_NO_MAIN is defined, for embedding the
+// program in a C project. Constructs the C backend does not support yet,
+// such as optionals and most runtime helpers, are reported as errors
+// wrapping ErrUnsupported.
+func Generate(p *ir.Program) (string, error) {
+    g := &generator{funcs: map[string]bool{}}
+    for _, fn := range p.Funcs {
+        g.funcs[fn.Name] = true
+    }
+    fns := append([]*ir.Func(nil), p.Funcs...)
+    sort.SliceStable(fns, func(i, j int) bool { return fns[i].Name < fns[j].Name })
+
+    var protos, bodies strings.Builder
+    hasMain := false
+    for _, fn := range fns {
+        ret, err := cType(fn.Result)
+        if err != nil {
+            return "", fmt.Errorf("function %s: %w", fn.Name, err)
+        }
+        fmt.Fprintf(&protos, "%s cwf_%s(void);\n", ret, fn.Name)
+        body, err := g.genFunc(fn, ret)
+        if err != nil {
+            return "", err
+        }
+        bodies.WriteString(body)
+        if fn.Name == "main" {
+            hasMain = true
+        }
+    }
+
+    var sb strings.Builder
+    sb.WriteString("// Generated by Clockwise transpiler\n")
+    fmt.Fprintf(&sb, "#include \"%s\"\n\n", RuntimeHeaderName)
+    sb.WriteString(protos.String())
+    sb.WriteString("\n")
+    sb.WriteString(g.thunks.String())
+    sb.WriteString(bodies.String())
+    if hasMain {
+        sb.WriteString("#ifndef CW_NO_MAIN\nint main(void) {\n    return (int)cwf_main();\n}\n#endif\n")
+    }
+    return sb.String(), nil
+}
+
+func cType(t ir.Type) (string, error) {
+    if t.IsOptional() {
+        return "", fmt.Errorf("optional type %s is %w yet", t, ErrUnsupported)
+    }
+    if ct, ok := cTypes[t]; ok {
+        return ct, nil
+    }
+    return "", fmt.Errorf("type %s is %w", t, ErrUnsupported)
+}
+
+func cLocal(l *ir.Local) string {
+    if l.Temp {
+        return "t" + l.Name
+    }
+    return "l_" + l.Name
+}
+
+func cLabel(b *ir.Block) string {
+    return fmt.Sprintf("cwB%d", b.Index)
+}
+
+// hasDefer reports whether fn defers any call.
+func hasDefer(fn *ir.Func) bool {
+    for _, b := range fn.Blocks {
+        for _, in := range b.Instrs {
+            if _, ok := in.(*ir.Defer); ok {
+                return true
+            }
+        }
+    }
+    return false
+}
+
+func (g *generator) genFunc(fn *ir.Func, ret string) (string, error) {
+    g.fn = fn
+    var sb strings.Builder
+    sb.WriteString(g.lineDirective(fn.Line))
+    fmt.Fprintf(&sb, "%s cwf_%s(void) {\n", ret, fn.Name)
+    for _, l := range fn.Locals {
+        ct, err := cType(l.Typ)
+        if err != nil {
+            return "", fmt.Errorf("function %s: %w", fn.Name, err)
+        }
+        zero := "0"
+        if l.Typ == ir.String {
+            zero = "{0}"
+        }
+        fmt.Fprintf(&sb, "    %s %s = %s;\n", ct, cLocal(l), zero)
+    }
+    fmt.Fprintf(&sb, "    cw_frame cw_fr = {%s, %s, %d, cw_frames};\n", cString(fn.Name), cString(fn.File), fn.Line)
+    sb.WriteString("    cw_frames = &cw_fr;\n")
+    if hasDefer(fn) {
+        sb.WriteString("    cw_defer *cw_mark = cw_defers;\n")
+    }
+    targets := map[*ir.Block]bool{}
+    terms := make([]string, len(fn.Blocks))
+    for i, b := range fn.Blocks {
+        var next *ir.Block
+        if i+1 < len(fn.Blocks) {
+            next = fn.Blocks[i+1]
+        }
+        t, err := g.genTerm(b.Term, next, targets, ret)
+        if err != nil {
+            return "", fmt.Errorf("%s:%d: %w", fn.File, b.Term.SourceLine(), err)
+        }
+        terms[i] = t
+    }
+    for i, b := range fn.Blocks {
+        if targets[b] {
+            fmt.Fprintf(&sb, "%s:;\n", cLabel(b))
+        }
+        for _, in := range b.Instrs {
+            code, err := g.genInstr(in)
+            if err != nil {
+                return "", fmt.Errorf("%s:%d: %w", fn.File, in.SourceLine(), err)
+            }
+            sb.WriteString(g.lineDirective(in.SourceLine()))
+            sb.WriteString("    " + code + "\n")
+        }
+        if terms[i] != "" {
+            sb.WriteString(g.lineDirective(b.Term.SourceLine()))
+            sb.WriteString("    " + terms[i] + "\n")
+        }
+    }
+    sb.WriteString("}\n\n")
+    return sb.String(), nil
+}
+
+// lineDirective pins the next line of C to a Clockwise source line, so that
+// compiler diagnostics and the positions reported by panics refer to it.
+// Each statement gets its own directive, since C counts lines on from it.
+func (g *generator) lineDirective(line int) string {
+    if line == 0 || g.fn.File == "" {
+        return ""
+    }
+    return fmt.Sprintf("#line %d %s\n", line, cString(g.fn.File))
+}
+
+func (g *generator) genTerm(t ir.Terminator, next *ir.Block, targets map[*ir.Block]bool, ret string) (string, error) {
+    jump := func(b *ir.Block) string {
+        if b == next {
+            return ""
+        }
+        targets[b] = true
+        return fmt.Sprintf("goto %s;", cLabel(b))
+    }
+    switch t := t.(type) {
+    case *ir.Return:
+        v, err := g.value(t.Value)
+        if err != nil {
+            return "", err
+        }
+        if hasDefer(g.fn) {
+            return fmt.Sprintf("{ %s cw_r = %s; cw_defer_run(cw_mark); cw_frames = cw_fr.up; return cw_r; }", ret, v), nil
+        }
+        return fmt.Sprintf("cw_frames = cw_fr.up; return %s;", v), nil
+    case *ir.Panic:
+        msg, err := g.value(t.Msg)
+        if err != nil {
+            return "", err
+        }
+        return fmt.Sprintf("cw_panic_at(%s, CW_AT);", msg), nil
+    case *ir.Jump:
+        return jump(t.Target), nil
+    case *ir.Branch:
+        cond, err := g.value(t.Cond)
+        if err != nil {
+            return "", err
+        }
+        if t.Then == next {
+            targets[t.Else] = true
+            return fmt.Sprintf("if (!%s) goto %s;", cond, cLabel(t.Else)), nil
+        }
+        targets[t.Then] = true
+        code := fmt.Sprintf("if (%s) goto %s;", cond, cLabel(t.Then))
+        if j := jump(t.Else); j != "" {
+            code += " " + j
+        }
+        return code, nil
+    }
+    return "", fmt.Errorf("unsupported terminator %T", t)
+}
+
+func (g *generator) genInstr(in ir.Instr) (string, error) {
+    vals, err := g.operands(in)
+    if err != nil {
+        return "", err
+    }
+    switch in := in.(type) {
+    case *ir.Copy:
+        return fmt.Sprintf("%s = %s;", cLocal(in.Dst), vals[0]), nil
+    case *ir.BinOp:
+        code, err := binOp(in, vals[0], vals[1])
+        if err != nil {
+            return "", err
+        }
+        return fmt.Sprintf("%s = %s;", cLocal(in.Dst), code), nil
+    case *ir.UnOp:
+        if in.Op == "!" {
+            return fmt.Sprintf("%s = !%s;", cLocal(in.Dst), vals[0]), nil
+        }
+        ct, _ := cType(in.Dst.Typ)
+        if in.Op == "-" {
+            // negate in unsigned arithmetic, which wraps like Go
+            return fmt.Sprintf("%s = (%s)(0 - (uint64_t)%s);", cLocal(in.Dst), ct, vals[0]), nil
+        }
+        return fmt.Sprintf("%s = (%s)~%s;", cLocal(in.Dst), ct, vals[0]), nil
+    case *ir.Convert:
+        ct, _ := cType(in.Dst.Typ)
+        return fmt.Sprintf("%s = (%s)%s;", cLocal(in.Dst), ct, vals[0]), nil
+    case *ir.ToString:
+        switch {
+        case in.X.Type() == ir.Bool:
+            return fmt.Sprintf("%s = cw_btoa(%s);", cLocal(in.Dst), vals[0]), nil
+        case unsigned(in.X.Type()):
+            return fmt.Sprintf("%s = cw_utoa((uint64_t)%s);", cLocal(in.Dst), vals[0]), nil
+        }
+        return fmt.Sprintf("%s = cw_itoa((int64_t)%s);", cLocal(in.Dst), vals[0]), nil
+    case *ir.Concat:
+        return fmt.Sprintf("%s = cw_concat(%d, %s);", cLocal(in.Dst), len(vals), strings.Join(vals, ", ")), nil
+    case *ir.Call:
+        call, err := g.call(in, vals)
+        if err != nil {
+            return "", err
+        }
+        // the line is the caller's entry in a backtrace
+        at := fmt.Sprintf("cw_fr.line = %d; ", in.SourceLine())
+        if in.Dst == nil {
+            return at + call + ";", nil
+        }
+        return fmt.Sprintf("%s%s = %s;", at, cLocal(in.Dst), call), nil
+    case *ir.Defer:
+        return g.deferCall(in.Call, vals)
+    case *ir.Some, *ir.IsNone, *ir.Unwrap:
+        return "", fmt.Errorf("optionals are %w yet", ErrUnsupported)
+    }
+    return "", fmt.Errorf("unsupported instruction %T", in)
+}
+
+// operands renders the values an instruction reads.
+func (g *generator) operands(in ir.Instr) ([]string, error) {
+    var vs []ir.Value
+    switch in := in.(type) {
+    case *ir.Copy:
+        vs = []ir.Value{in.Src}
+    case *ir.BinOp:
+        vs = []ir.Value{in.X, in.Y}
+    case *ir.UnOp:
+        vs = []ir.Value{in.X}
+    case *ir.Convert:
+        vs = []ir.Value{in.X}
+    case *ir.ToString:
+        vs = []ir.Value{in.X}
+    case *ir.Concat:
+        vs = in.Parts
+    case *ir.Call:
+        vs = in.Args
+    case *ir.Defer:
+        vs = in.Call.Args
+    }
+    out := make([]string, len(vs))
+    for i, v := range vs {
+        s, err := g.value(v)
+        if err != nil {
+            return nil, err
+        }
+        out[i] = s
+    }
+    return out, nil
+}
+
+// callee returns the C function a call invokes.
+func (g *generator) callee(in *ir.Call) (string, error) {
+    if in.Missing != "" {
+        return "", fmt.Errorf("runtime helper %s returns an optional, which is %w yet", in.Func, ErrUnsupported)
+    }
+    if name, ok := builtins[in.Func]; ok {
+        return name, nil
+    }
+    if g.funcs[in.Func] {
+        return "cwf_" + in.Func, nil
+    }
+    return "", fmt.Errorf("runtime helper %s is %w", in.Func, ErrUnsupported)
+}
+
+func (g *generator) call(in *ir.Call, args []string) (string, error) {
+    fn, err := g.callee(in)
+    if err != nil {
+        return "", err
+    }
+    return fmt.Sprintf("%s(%s)", fn, strings.Join(args, ", ")), nil
+}
+
+// deferCall pushes a deferred call. Each defer site gets a thunk that
+// unpacks the arguments, which are evaluated now, and makes the call.
+func (g *generator) deferCall(in *ir.Call, args []string) (string, error) {
+    fn, err := g.callee(in)
+    if err != nil {
+        return "", err
+    }
+    g.defers++
+    name := fmt.Sprintf("cwd_%s_%d", g.fn.Name, g.defers)
+    fields := make([]string, len(in.Args))
+    inits := make([]string, len(in.Args))
+    params := make([]string, len(in.Args))
+    for i, a := range in.Args {
+        ct, err := cType(a.Type())
+        if err != nil {
+            return "", err
+        }
+        fields[i] = fmt.Sprintf(" %s a%d;", ct, i)
+        inits[i] = fmt.Sprintf(" d->a%d = %s;", i, args[i])
+        params[i] = fmt.Sprintf("d->a%d", i)
+    }
+    fmt.Fprintf(&g.thunks, "struct %s {%s char unused; };\n", name, strings.Join(fields, ""))
+    fmt.Fprintf(&g.thunks, "static void %s(void *p) {\n    struct %s *d = p;\n    (void)d;\n    %s(%s);\n}\n\n",
+        name, name, fn, strings.Join(params, ", "))
+    return fmt.Sprintf("{ struct %s *d = cw_alloc(sizeof *d);%s cw_defer_push(%s, d); }", name, strings.Join(inits, ""), name), nil
+}
+
+func unsigned(t ir.Type) bool {
+    return strings.HasPrefix(string(t), "u")
+}
+
+// binOp renders a binary operation with Go's semantics. Integer arithmetic
+// is done in uint64_t, where overflow wraps instead of being undefined, and
+// truncated to the operand type.
+func binOp(in *ir.BinOp, x, y string) (string, error) {
+    t := in.X.Type()
+    switch t {
+    case ir.String:
+        switch in.Op {
+        case "+":
+            return fmt.Sprintf("cw_concat(2, %s, %s)", x, y), nil
+        case "==", "!=", "<", ">", "<=", ">=":
+            return fmt.Sprintf("cw_str_cmp(%s, %s) %s 0", x, y, in.Op), nil
+        }
+    case ir.Bool:
+        switch in.Op {
+        case "==", "!=", "&&", "||":
+            return fmt.Sprintf("%s %s %s", x, in.Op, y), nil
+        }
+    }
+    if !t.IsInteger() {
+        return "", fmt.Errorf("operator %s on %s is %w", in.Op, t, ErrUnsupported)
+    }
+    ct, _ := cType(t)
+    switch in.Op {
+    case "==", "!=", "<", ">", "<=", ">=":
+        return fmt.Sprintf("%s %s %s", x, in.Op, y), nil
+    case "+", "-", "*":
+        return fmt.Sprintf("(%s)((uint64_t)%s %s (uint64_t)%s)", ct, x, in.Op, y), nil
+    case "&", "|", "^":
+        return fmt.Sprintf("(%s)(%s %s %s)", ct, x, in.Op, y), nil
+    case "/", "%":
+        helper := map[string]string{"/": "div", "%": "mod"}[in.Op]
+        if unsigned(t) {
+            return fmt.Sprintf("(%s)cw_%su(%s, %s, CW_AT)", ct, helper, x, y), nil
+        }
+        return fmt.Sprintf("(%s)cw_%ss(%s, %s, CW_AT)", ct, helper, x, y), nil
+    case "<<", ">>":
+        count := fmt.Sprintf("(uint64_t)%s", y)
+        if !unsigned(in.Y.Type()) {
+            if _, ok := in.Y.(*ir.Const); !ok {
+                count = fmt.Sprintf("cw_shift_count(%s, CW_AT)", y)
+            }
+        }
+        switch {
+        case in.Op == "<<":
+            return fmt.Sprintf("(%s)cw_shl((uint64_t)%s, %s)", ct, x, count), nil
+        case unsigned(t):
+            return fmt.Sprintf("(%s)cw_shr((uint64_t)%s, %s)", ct, x, count), nil
+        }
+        return fmt.Sprintf("(%s)cw_sar((int64_t)%s, %s)", ct, x, count), nil
+    }
+    return "", fmt.Errorf("operator %s on %s is %w", in.Op, t, ErrUnsupported)
+}
+
+var minInt64 = new(big.Int).Lsh(big.NewInt(-1), 63)
+
+func (g *generator) value(v ir.Value) (string, error) {
+    switch v := v.(type) {
+    case *ir.Local:
+        return cLocal(v), nil
+    case *ir.Const:
+        switch {
+        case v.Typ.IsOptional():
+            return "", fmt.Errorf("none is %w yet", ErrUnsupported)
+        case v.Typ == ir.String:
+            return fmt.Sprintf("CW_LIT(%s)", cString(v.Str)), nil
+        case v.Typ == ir.Bool:
+            return fmt.Sprint(v.Bool), nil
+        case unsigned(v.Typ):
+            return fmt.Sprintf("UINT64_C(%s)", v.Int), nil
+        case v.Int.Cmp(minInt64) == 0:
+            // -9223372036854775808 is not a valid C literal
+            return "INT64_MIN", nil
+        case v.Int.Sign() < 0:
+            return fmt.Sprintf("(-INT64_C(%s))", new(big.Int).Neg(v.Int)), nil
+        }
+        return fmt.Sprintf("INT64_C(%s)", v.Int), nil
+    }
+    return "", fmt.Errorf("unsupported value %T", v)
+}
+
+// cString quotes s as a C string literal. Bytes outside printable ASCII are
+// written as three-digit octal escapes, which cannot run into a following
+// digit, and '?' is escaped so that no trigraph is formed.
+func cString(s string) string {
+    var sb strings.Builder
+    sb.WriteByte('"')
+    for i := 0; i < len(s); i++ {
+        switch c := s[i]; {
+        case c == '"' || c == '\\' || c == '?':
+            sb.WriteByte('\\')
+            sb.WriteByte(c)
+        case c == '\n':
+            sb.WriteString(`\n`)
+        case c == '\t':
+            sb.WriteString(`\t`)
+        case c < 0x20 || c >= 0x7f:
+            fmt.Fprintf(&sb, "\\%03o", c)
+        default:
+            sb.WriteByte(c)
+        }
+    }
+    sb.WriteByte('"')
+    return sb.String()
+}
@@ -0,0 +1,266 @@
+package cgen
+
+// RuntimeHeaderName and RuntimeSourceName are the file names the C runtime
+// is written under; generated programs include the header by this name.
+const (
+    RuntimeHeaderName = "cw_stdio.h"
+    RuntimeSourceName = "cw_stdio.c"
+)
+
+// RuntimeHeader declares the C runtime used by programs built with
+// --backend=c. runtime/gen_stdlib writes both to runtime/c.
+const RuntimeHeader = `// Clockwise C runtime (generated by runtime/gen_stdlib; do not edit)
+#ifndef CW_STDIO_H
+#define CW_STDIO_H
+
+#include <stdbool.h>
+#include <stddef.h>
+#include <stdint.h>
+
+// cw_string is an immutable string: len bytes at ptr, not NUL-terminated.
+typedef struct {
+    const char *ptr;
+    size_t len;
+} cw_string;
+
+// CW_LIT makes a cw_string from a C string literal.
+#define CW_LIT(s) ((cw_string){(s), sizeof(s) - 1})
+
+// CW_NORETURN marks functions that do not return, where the compiler
+// supports it.
+#if defined(__GNUC__) || defined(__clang__)
+#define CW_NORETURN __attribute__((noreturn))
+#else
+#define CW_NORETURN
+#endif
+
+// CW_AT passes the current source position to helpers that may panic.
+#define CW_AT __FILE__, __LINE__, __func__
+
+int64_t cw_print(cw_string s);
+
+cw_string cw_concat(int n, ...);
+int cw_str_cmp(cw_string a, cw_string b);
+cw_string cw_itoa(int64_t v);
+cw_string cw_utoa(uint64_t v);
+cw_string cw_btoa(bool v);
+
+// Integer operations with Go's semantics: division by zero panics, the
+// most negative value divided by -1 wraps, and shifts by at least the
+// width of the operand yield 0 (or -1 for negative values shifted right).
+int64_t cw_divs(int64_t a, int64_t b, const char *file, int line, const char *fn);
+int64_t cw_mods(int64_t a, int64_t b, const char *file, int line, const char *fn);
+uint64_t cw_divu(uint64_t a, uint64_t b, const char *file, int line, const char *fn);
+uint64_t cw_modu(uint64_t a, uint64_t b, const char *file, int line, const char *fn);
+uint64_t cw_shift_count(int64_t n, const char *file, int line, const char *fn);
+uint64_t cw_shl(uint64_t x, uint64_t n);
+uint64_t cw_shr(uint64_t x, uint64_t n);
+int64_t cw_sar(int64_t x, uint64_t n);
+
+// Deferred calls form one stack shared by all frames. A function records the
+// top on entry and runs the calls above it before returning; a panic runs
+// them all.
+typedef struct cw_defer cw_defer;
+extern cw_defer *cw_defers;
+void *cw_alloc(size_t n);
+void cw_defer_push(void (*fn)(void *), void *args);
+void cw_defer_run(cw_defer *mark);
+
+// The active Clockwise calls form a list, innermost first, for the
+// backtrace of a panic. A function pushes its frame on entry and pops it on
+// return, and records the line of each call it makes.
+typedef struct cw_frame {
+    const char *fn;
+    const char *file;
+    int line;
+    struct cw_frame *up;
+} cw_frame;
+extern cw_frame *cw_frames;
+
+CW_NORETURN void cw_panic_at(cw_string msg, const char *file, int line, const char *fn);
+
+#endif
+`
+
+// RuntimeSource implements RuntimeHeader. Strings are never freed; Clockwise
+// programs are short-lived and allocate little.
+const RuntimeSource = `// Clockwise C runtime (generated by runtime/gen_stdlib; do not edit)
+#include "cw_stdio.h"
+
+#include <inttypes.h>
+#include <stdarg.h>
+#include <stdio.h>
+#include <stdlib.h>
+#include <string.h>
+
+int64_t cw_print(cw_string s) {
+    fwrite(s.ptr, 1, s.len, stdout);
+    return 0;
+}
+
+void *cw_alloc(size_t n) {
+    void *p = malloc(n ? n : 1);
+    if (p == NULL) {
+        fputs("fatal error: out of memory\n", stderr);
+        exit(2);
+    }
+    return p;
+}
+
+cw_string cw_concat(int n, ...) {
+    va_list ap;
+    size_t len = 0;
+    va_start(ap, n);
+    for (int i = 0; i < n; i++) {
+        len += va_arg(ap, cw_string).len;
+    }
+    va_end(ap);
+    char *buf = cw_alloc(len);
+    size_t off = 0;
+    va_start(ap, n);
+    for (int i = 0; i < n; i++) {
+        cw_string s = va_arg(ap, cw_string);
+        if (s.len > 0) {
+            memcpy(buf + off, s.ptr, s.len);
+        }
+        off += s.len;
+    }
+    va_end(ap);
+    return (cw_string){buf, len};
+}
+
+int cw_str_cmp(cw_string a, cw_string b) {
+    size_t n = a.len < b.len ? a.len : b.len;
+    int c = n > 0 ? memcmp(a.ptr, b.ptr, n) : 0;
+    if (c != 0) {
+        return c;
+    }
+    return (a.len > b.len) - (a.len < b.len);
+}
+
+static cw_string cw_format(const char *buf) {
+    size_t len = strlen(buf);
+    char *out = cw_alloc(len);
+    memcpy(out, buf, len);
+    return (cw_string){out, len};
+}
+
+cw_string cw_itoa(int64_t v) {
+    char buf[32];
+    snprintf(buf, sizeof buf, "%" PRId64, v);
+    return cw_format(buf);
+}
+
+cw_string cw_utoa(uint64_t v) {
+    char buf[32];
+    snprintf(buf, sizeof buf, "%" PRIu64, v);
+    return cw_format(buf);
+}
+
+cw_string cw_btoa(bool v) {
+    return v ? CW_LIT("true") : CW_LIT("false");
+}
+
+int64_t cw_divs(int64_t a, int64_t b, const char *file, int line, const char *fn) {
+    if (b == 0) {
+        cw_panic_at(CW_LIT("runtime error: integer divide by zero"), file, line, fn);
+    }
+    if (b == -1) {
+        return (int64_t)(0 - (uint64_t)a);
+    }
+    return a / b;
+}
+
+int64_t cw_mods(int64_t a, int64_t b, const char *file, int line, const char *fn) {
+    if (b == 0) {
+        cw_panic_at(CW_LIT("runtime error: integer divide by zero"), file, line, fn);
+    }
+    if (b == -1) {
+        return 0;
+    }
+    return a % b;
+}
+
+uint64_t cw_divu(uint64_t a, uint64_t b, const char *file, int line, const char *fn) {
+    if (b == 0) {
+        cw_panic_at(CW_LIT("runtime error: integer divide by zero"), file, line, fn);
+    }
+    return a / b;
+}
+
+uint64_t cw_modu(uint64_t a, uint64_t b, const char *file, int line, const char *fn) {
+    if (b == 0) {
+        cw_panic_at(CW_LIT("runtime error: integer divide by zero"), file, line, fn);
+    }
+    return a % b;
+}
+
+uint64_t cw_shift_count(int64_t n, const char *file, int line, const char *fn) {
+    if (n < 0) {
+        cw_panic_at(CW_LIT("runtime error: negative shift amount"), file, line, fn);
+    }
+    return (uint64_t)n;
+}
+
+uint64_t cw_shl(uint64_t x, uint64_t n) {
+    return n >= 64 ? 0 : x << n;
+}
+
+uint64_t cw_shr(uint64_t x, uint64_t n) {
+    return n >= 64 ? 0 : x >> n;
+}
+
+int64_t cw_sar(int64_t x, uint64_t n) {
+    if (n >= 64) {
+        return x < 0 ? -1 : 0;
+    }
+    return x >> n;
+}
+
+struct cw_defer {
+    void (*fn)(void *);
+    void *args;
+    cw_defer *next;
+};
+
+cw_defer *cw_defers = NULL;
+
+void cw_defer_push(void (*fn)(void *), void *args) {
+    cw_defer *d = cw_alloc(sizeof *d);
+    d->fn = fn;
+    d->args = args;
+    d->next = cw_defers;
+    cw_defers = d;
+}
+
+void cw_defer_run(cw_defer *mark) {
+    while (cw_defers != mark) {
+        cw_defer *d = cw_defers;
+        cw_defers = d->next;
+        d->fn(d->args);
+    }
+}
+
+cw_frame *cw_frames = NULL;
+
+void cw_panic_at(cw_string msg, const char *file, int line, const char *fn) {
+    if (cw_frames != NULL) {
+        cw_frames->line = line;
+    }
+    // deferred calls run first, as in the Go backend
+    cw_defer_run(NULL);
+    fflush(stdout);
+    // Clockwise functions are emitted as cwf_<name>
+    if (strncmp(fn, "cwf_", 4) == 0) {
+        fn += 4;
+    }
+    fprintf(stderr, "%s:%d: panic in %s: %.*s\n", file, line, fn, (int)msg.len, msg.ptr);
+    if (cw_frames != NULL) {
+        fputs("backtrace:\n", stderr);
+        for (cw_frame *f = cw_frames; f != NULL; f = f->up) {
+            fprintf(stderr, "    %s at %s:%d\n", f->fn, f->file, f->line);
+        }
+    }
+    exit(2);
+}
+`
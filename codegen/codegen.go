@@ -2,93 +2,322 @@ package codegen
 
 import (
     "fmt"
+    "sort"
+    "strconv"
     "strings"
-    "codeberg.org/clockwise-lang/clockwise/parser"
+
+    "codeberg.org/clockwise-lang/clockwise/ir"
 )
 
-// Generate produces Go source for the given program. The CLI will write this
-// into a temporary module along with the runtime Go file and run `go build`.
-func Generate(p *parser.Program) string {
+// generator tracks the Go packages and support helpers referenced by the
+// emitted code so that only those end up in the output.
+type generator struct {
+    imports map[string]bool
+    helpers map[string]bool
+    // file is the source file of the function being generated, or "" if
+    // unknown, in which case no line directives are emitted.
+    file string
+    // goos is the target operating system, "" for the host. It decides how
+    // exported functions are made available.
+    goos string
+}
+
+// Generate produces Go source for the given program, built for goos ("" for
+// the host). The CLI will write this into a temporary module along with the
+// runtime Go file and run `go build`.
+func Generate(p *ir.Program, goos string) string {
+    g := &generator{imports: map[string]bool{}, helpers: map[string]bool{}, goos: goos}
+    body := g.genFunctions(p)
+    if exports := exportedFuncs(p); len(exports) > 0 && (goos == "js" || goos == "wasip1") {
+        body += supportMarker + g.genExports(exports)
+        if !hasFunc(p, "main") {
+            body += g.genLibraryMain()
+        }
+    }
+
     var sb strings.Builder
     sb.WriteString("// Generated by Clockwise transpiler\n")
     sb.WriteString("package main\n\n")
-    sb.WriteString("import (\n\t\"fmt\"\n)\n\n")
-
-    for _, fn := range p.Functions {
-        cRet := "int"
-        if fn.ReturnType != "" {
-            cRet = mapType(fn.ReturnType)
+    if len(g.imports) > 0 {
+        pkgs := make([]string, 0, len(g.imports))
+        for pkg := range g.imports {
+            pkgs = append(pkgs, pkg)
         }
-        if fn.Name == "main" {
-            sb.WriteString(fmt.Sprintf("func main() %s {\n", cRet))
-            for _, st := range fn.Body.Statements {
-                sb.WriteString(genStatement(st))
-            }
-            sb.WriteString("}\n\n")
-            continue
+        sort.Strings(pkgs)
+        sb.WriteString("import (\n")
+        for _, pkg := range pkgs {
+            sb.WriteString(fmt.Sprintf("\t\"%s\"\n", pkg))
         }
-        sb.WriteString(fmt.Sprintf("func %s() %s {\n", fn.Name, cRet))
-        for _, st := range fn.Body.Statements {
-            sb.WriteString(genStatement(st))
+        sb.WriteString(")\n\n")
+    }
+    sb.WriteString(body)
+    // the support code below has no Clockwise location
+    sb.WriteString(supportMarker)
+    sb.WriteString(g.genHelpers())
+    return resolveLineMarkers(sb.String())
+}
+
+func (g *generator) genFunctions(p *ir.Program) string {
+    // emit functions by name so the output does not depend on the order
+    // the source files were given in
+    fns := append([]*ir.Func(nil), p.Funcs...)
+    sort.SliceStable(fns, func(i, j int) bool { return fns[i].Name < fns[j].Name })
+    var sb strings.Builder
+    for _, fn := range fns {
+        g.file = fn.File
+        name := fn.Name
+        if name == "main" {
+            // Go's main cannot return a value. The body runs in cwMain so
+            // that its deferred calls complete before os.Exit is reached,
+            // and cwTrap reports any panic that escapes it.
+            name = "cwMain"
+            g.useTrap()
+            sb.WriteString(supportMarker)
+            sb.WriteString(g.genMain(len(exportedFuncs(p)) > 0))
         }
+        sb.WriteString(g.genMarker(fn.Line))
+        sb.WriteString(fmt.Sprintf("func %s() %s {\n", name, mapType(string(fn.Result))))
+        sb.WriteString(g.genBody(fn))
         sb.WriteString("}\n\n")
     }
 
     return sb.String()
 }
 
-func genStatement(s parser.Statement) string {
-    switch st := s.(type) {
-    case *parser.ReturnStatement:
-        return fmt.Sprintf("return %s\n", genExpr(st.Value))
-    case *parser.ExpressionStatement:
-        return fmt.Sprintf("%s\n", genExpr(st.Expr))
-    case *parser.VarStatement:
-        ctype := mapType(st.Type)
-        return fmt.Sprintf("var %s %s = %s\n", st.Name, ctype, genExpr(st.Value))
-    default:
-        return "// unsupported stmt\n"
-    }
-}
-
-func genExpr(e parser.Expression) string {
-    switch ex := e.(type) {
-    case *parser.IntegerLiteral:
-        return ex.Value
-    case *parser.StringLiteral:
-        esc := EscapeString(ex.Value)
-        return fmt.Sprintf("\"%s\"", esc)
-    case *parser.Identifier:
-        return ex.Value
-    case *parser.CallExpression:
-        if id, ok := ex.Function.(*parser.Identifier); ok {
-            fname := id.Value
-            if fname == "print" {
-                if len(ex.Args) == 1 {
-                    return fmt.Sprintf("Print(%s)", genExpr(ex.Args[0]))
-                }
-            }
-            var args []string
-            for _, a := range ex.Args {
-                args = append(args, genExpr(a))
+// genBody lowers the blocks of fn to Go statements joined by gotos. Every
+// local is declared up front, since a goto may not jump over a declaration;
+// labels are only emitted for blocks something jumps to.
+func (g *generator) genBody(fn *ir.Func) string {
+    var sb strings.Builder
+    if len(fn.Locals) > 0 {
+        names := make([]string, len(fn.Locals))
+        blanks := make([]string, len(fn.Locals))
+        for i, l := range fn.Locals {
+            names[i] = goLocal(l)
+            blanks[i] = "_"
+            sb.WriteString(fmt.Sprintf("var %s %s\n", names[i], mapType(string(l.Typ))))
+        }
+        // Clockwise allows unused locals; Go does not
+        sb.WriteString(fmt.Sprintf("%s = %s\n", strings.Join(blanks, ", "), strings.Join(names, ", ")))
+    }
+    terms := make([]string, len(fn.Blocks))
+    targets := map[*ir.Block]bool{}
+    for i, b := range fn.Blocks {
+        var next *ir.Block
+        if i+1 < len(fn.Blocks) {
+            next = fn.Blocks[i+1]
+        }
+        terms[i] = g.genTerm(b.Term, next, targets)
+    }
+    line := 0
+    for i, b := range fn.Blocks {
+        if targets[b] {
+            sb.WriteString(fmt.Sprintf("%s:\n", goLabel(b)))
+        }
+        for _, in := range b.Instrs {
+            if in.SourceLine() != line {
+                line = in.SourceLine()
+                sb.WriteString(g.genMarker(line))
             }
-            return fmt.Sprintf("%s(%s)", fname, strings.Join(args, ", "))
+            sb.WriteString(g.genInstr(in))
+        }
+        if b.Term.SourceLine() != line {
+            line = b.Term.SourceLine()
+            sb.WriteString(g.genMarker(line))
         }
-        return "/* unsupported call */"
-    case *parser.InfixExpression:
-        return fmt.Sprintf("(%s %s %s)", genExpr(ex.Left), ex.Operator, genExpr(ex.Right))
-    default:
-        return "0"
+        sb.WriteString(terms[i])
     }
+    return sb.String()
 }
 
-func mapType(t string) string {
-    switch t {
+// genMain renders the Go main function, which runs the Clockwise main. On js
+// a program with exports registers them first and keeps running after a
+// successful main, so that the host can go on calling them.
+func (g *generator) genMain(exports bool) string {
+    if exports && g.goos == "js" {
+        return "func main() {\ndefer cwTrap()\ncwExport()\nif code := cwMain(); code != 0 {\nos.Exit(code)\n}\nselect {}\n}\n\n"
+    }
+    return "func main() {\ndefer cwTrap()\nos.Exit(cwMain())\n}\n\n"
+}
+
+// genLibraryMain renders the Go main function of a program without a
+// Clockwise main, which only provides exports. wasip1 reactors never run
+// it.
+func (g *generator) genLibraryMain() string {
+    if g.goos == "js" {
+        return "func main() {\ncwExport()\nselect {}\n}\n\n"
+    }
+    return "func main() {}\n\n"
+}
+
+func hasFunc(p *ir.Program, name string) bool {
+    for _, fn := range p.Funcs {
+        if fn.Name == name {
+            return true
+        }
+    }
+    return false
+}
+
+func goLocal(l *ir.Local) string {
+    if l.Temp {
+        return "cwT" + l.Name
+    }
+    return l.Name
+}
+
+func goLabel(b *ir.Block) string {
+    return fmt.Sprintf("cwB%d", b.Index)
+}
+
+// genTerm renders a terminator. Control falling through to next needs no
+// goto; every block jumped to is recorded in targets.
+func (g *generator) genTerm(t ir.Terminator, next *ir.Block, targets map[*ir.Block]bool) string {
+    jump := func(b *ir.Block) string {
+        if b == next {
+            return ""
+        }
+        targets[b] = true
+        return fmt.Sprintf("goto %s\n", goLabel(b))
+    }
+    switch t := t.(type) {
+    case *ir.Return:
+        return fmt.Sprintf("return %s\n", g.genValue(t.Value))
+    case *ir.Panic:
+        return fmt.Sprintf("panic(%s)\n", g.genValue(t.Msg))
+    case *ir.Jump:
+        return jump(t.Target)
+    case *ir.Branch:
+        cond := g.genValue(t.Cond)
+        if t.Then == next {
+            targets[t.Else] = true
+            return fmt.Sprintf("if !%s {\ngoto %s\n}\n", cond, goLabel(t.Else))
+        }
+        targets[t.Then] = true
+        return fmt.Sprintf("if %s {\ngoto %s\n}\n%s", cond, goLabel(t.Then), jump(t.Else))
+    }
+    panic(fmt.Sprintf("codegen: unknown terminator %T", t))
+}
+
+func (g *generator) genInstr(in ir.Instr) string {
+    switch in := in.(type) {
+    case *ir.Copy:
+        return fmt.Sprintf("%s = %s\n", goLocal(in.Dst), g.genValue(in.Src))
+    case *ir.BinOp:
+        return fmt.Sprintf("%s = %s %s %s\n", goLocal(in.Dst), g.genValue(in.X), in.Op, g.genValue(in.Y))
+    case *ir.UnOp:
+        op := in.Op
+        if op == "~" {
+            // Go spells bitwise complement as unary ^
+            op = "^"
+        }
+        return fmt.Sprintf("%s = %s%s\n", goLocal(in.Dst), op, g.genValue(in.X))
+    case *ir.Convert:
+        return fmt.Sprintf("%s = %s(%s)\n", goLocal(in.Dst), mapType(string(in.Dst.Typ)), g.genValue(in.X))
+    case *ir.Some:
+        g.helpers["cwSome"] = true
+        return fmt.Sprintf("%s = cwSome[%s](%s)\n", goLocal(in.Dst), mapType(string(in.Dst.Typ.Elem())), g.genValue(in.X))
+    case *ir.IsNone:
+        return fmt.Sprintf("%s = %s == nil\n", goLocal(in.Dst), g.genValue(in.X))
+    case *ir.Unwrap:
+        return fmt.Sprintf("%s = *%s\n", goLocal(in.Dst), g.genValue(in.X))
+    case *ir.ToString:
+        return fmt.Sprintf("%s = %s\n", goLocal(in.Dst), g.genToString(in.X))
+    case *ir.Concat:
+        parts := make([]string, len(in.Parts))
+        for i, p := range in.Parts {
+            parts[i] = g.genValue(p)
+        }
+        // Go allocates the result of an n-ary concatenation once
+        return fmt.Sprintf("%s = %s\n", goLocal(in.Dst), strings.Join(parts, " + "))
+    case *ir.Call:
+        call := g.genCall(in)
+        if in.Dst == nil {
+            return call + "\n"
+        }
+        if in.Missing != "" {
+            // the helper signals "absent" with a sentinel value
+            g.helpers["cwOptional"] = true
+            call = fmt.Sprintf("cwOptional(%s, %s)", call, in.Missing)
+        }
+        return fmt.Sprintf("%s = %s\n", goLocal(in.Dst), call)
+    case *ir.Defer:
+        // Go's defer likewise evaluates the arguments immediately
+        return fmt.Sprintf("defer %s\n", g.genCall(in.Call))
+    }
+    panic(fmt.Sprintf("codegen: unknown instruction %T", in))
+}
+
+func (g *generator) genCall(in *ir.Call) string {
+    args := make([]string, len(in.Args))
+    for i, a := range in.Args {
+        args[i] = g.genValue(a)
+    }
+    return fmt.Sprintf("%s(%s)", in.Func, strings.Join(args, ", "))
+}
+
+// genToString formats an integer or bool with strconv.
+func (g *generator) genToString(v ir.Value) string {
+    g.imports["strconv"] = true
+    code := g.genValue(v)
+    switch v.Type() {
     case "int":
-        return "int"
-    case "string":
-        return "string"
-    default:
-        return "int"
+        return fmt.Sprintf("strconv.Itoa(%s)", code)
+    case "i8", "i16", "i32", "i64":
+        return fmt.Sprintf("strconv.FormatInt(int64(%s), 10)", code)
+    case "u8", "u16", "u32", "u64":
+        return fmt.Sprintf("strconv.FormatUint(uint64(%s), 10)", code)
+    }
+    return fmt.Sprintf("strconv.FormatBool(%s)", code)
+}
+
+// genValue renders an operand. Constants are written as untyped Go
+// constants, which take the type of the context like Clockwise constants.
+func (g *generator) genValue(v ir.Value) string {
+    switch v := v.(type) {
+    case *ir.Local:
+        return goLocal(v)
+    case *ir.Const:
+        switch {
+        case v.Typ.IsOptional():
+            return "nil"
+        case v.Typ == ir.String:
+            return strconv.Quote(v.Str)
+        case v.Typ == ir.Bool:
+            return strconv.FormatBool(v.Bool)
+        }
+        return v.Int.String()
+    }
+    panic(fmt.Sprintf("codegen: unknown value %T", v))
+}
+
+// goTypes maps Clockwise type names to their Go spelling.
+var goTypes = map[string]string{
+    "int":    "int",
+    "string": "string",
+    "bool":   "bool",
+    "i8":     "int8",
+    "i16":    "int16",
+    "i32":    "int32",
+    "i64":    "int64",
+    "u8":     "uint8",
+    "u16":    "uint16",
+    "u32":    "uint32",
+    "u64":    "uint64",
+    "byte":   "byte",
+    "rune":   "rune",
+}
+
+// mapType returns the Go spelling of a Clockwise type. Optionals `T?` are
+// pointers to T. Unknown names are passed through unchanged so that a type
+// the checker let slip fails loudly in `go build` instead of silently
+// becoming an int.
+func mapType(t string) string {
+    if elem, ok := strings.CutSuffix(t, "?"); ok {
+        return "*" + mapType(elem)
+    }
+    if gt, ok := goTypes[t]; ok {
+        return gt
     }
+    return t
 }
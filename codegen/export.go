@@ -0,0 +1,87 @@
+package codegen
+
+import (
+    "fmt"
+    "strings"
+
+    "codeberg.org/clockwise-lang/clockwise/ir"
+)
+
+// wasmExportTypes maps the Clockwise types an `export fn` may return on
+// wasip1 to the Go types //go:wasmexport accepts. Strings have no WASI
+// representation and are rejected by CheckExports.
+var wasmExportTypes = map[ir.Type]string{
+    "int":  "int64",
+    "i8":   "int32",
+    "i16":  "int32",
+    "i32":  "int32",
+    "i64":  "int64",
+    "u8":   "uint32",
+    "u16":  "uint32",
+    "u32":  "uint32",
+    "u64":  "uint64",
+    "bool": "bool",
+}
+
+// exportedFuncs returns the functions declared `export fn`.
+func exportedFuncs(p *ir.Program) []*ir.Func {
+    var out []*ir.Func
+    for _, fn := range p.Funcs {
+        if fn.Export {
+            out = append(out, fn)
+        }
+    }
+    return out
+}
+
+// CheckExports reports exported functions the host of goos cannot call.
+// Exports only take effect on the WebAssembly targets, so other targets
+// accept any. A JavaScript host receives any value, with none as null.
+func CheckExports(p *ir.Program, goos string) error {
+    for _, fn := range exportedFuncs(p) {
+        switch goos {
+        case "js":
+            if _, ok := goTypes[string(fn.Result.Elem())]; !ok {
+                return fmt.Errorf("%s:%d: exported function %s returns %s, which JavaScript hosts cannot receive",
+                    fn.File, fn.Line, fn.Name, fn.Result)
+            }
+        case "wasip1":
+            if _, ok := wasmExportTypes[fn.Result]; !ok {
+                return fmt.Errorf("%s:%d: exported function %s returns %s, which WASI hosts cannot receive; return an integer or bool",
+                    fn.File, fn.Line, fn.Name, fn.Result)
+            }
+        }
+    }
+    return nil
+}
+
+// genExports makes the exported functions callable from the host. On js
+// they are set as properties of the global object; on wasip1 each gets a
+// //go:wasmexport wrapper, and the compiler builds a reactor module.
+func (g *generator) genExports(fns []*ir.Func) string {
+    var sb strings.Builder
+    g.useTrap()
+    switch g.goos {
+    case "js":
+        g.imports["syscall/js"] = true
+        sb.WriteString("// cwExport registers the exported functions with the JavaScript host.\n")
+        sb.WriteString("func cwExport() {\n")
+        for _, fn := range fns {
+            sb.WriteString(fmt.Sprintf("js.Global().Set(%q, js.FuncOf(func(js.Value, []js.Value) any {\ndefer cwTrap()\n", fn.Name))
+            if fn.Result.IsOptional() {
+                // js.ValueOf cannot convert a pointer
+                sb.WriteString(fmt.Sprintf("if v := %s(); v != nil {\nreturn *v\n}\nreturn js.Null()\n", fn.Name))
+            } else {
+                sb.WriteString(fmt.Sprintf("return %s()\n", fn.Name))
+            }
+            sb.WriteString("}))\n")
+        }
+        sb.WriteString("}\n\n")
+    case "wasip1":
+        for _, fn := range fns {
+            sb.WriteString(fmt.Sprintf("//go:wasmexport %s\nfunc cwExport_%s() %s {\ndefer cwTrap()\nreturn %s(%s())\n}\n\n",
+                fn.Name, fn.Name, wasmExportTypes[fn.Result], wasmExportTypes[fn.Result], fn.Name))
+        }
+    }
+    return sb.String()
+}
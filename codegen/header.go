@@ -1,8 +1,7 @@
 package codegen
 
-import "time"
-
-// MakeHeader returns a standard generated-file header comment.
+// MakeHeader returns a standard generated-file header comment. It carries no
+// timestamp so that identical programs generate identical source.
 func MakeHeader() string {
-    return "// Generated by Clockwise transpiler - do not edit\n// Generated: " + time.Now().UTC().Format(time.RFC3339) + "\n\n"
+    return "// Generated by Clockwise transpiler - do not edit\n\n"
 }
@@ -0,0 +1,49 @@
+package codegen
+
+import (
+    "sort"
+    "strings"
+)
+
+// helperSources holds the Go support functions emitted on demand. Optionals
+// `T?` are represented as *T, with nil for none.
+var helperSources = map[string]string{
+    "cwSome": `// cwSome wraps a value into a non-empty optional.
+func cwSome[T any](v T) *T {
+	return &v
+}
+`,
+    "cwOptional": `// cwOptional turns a runtime helper's "missing" sentinel into none.
+func cwOptional[T comparable](v T, missing T) *T {
+	if v == missing {
+		return nil
+	}
+	return &v
+}
+`,
+    "cwTrap": trapSource,
+    "cwUnwrap": `// cwUnwrap returns the value inside an optional and whether there is one.
+func cwUnwrap[T any](v *T) (T, bool) {
+	if v == nil {
+		var zero T
+		return zero, false
+	}
+	return *v, true
+}
+`,
+}
+
+// genHelpers renders the support functions used by the generated code.
+func (g *generator) genHelpers() string {
+    names := make([]string, 0, len(g.helpers))
+    for name := range g.helpers {
+        names = append(names, name)
+    }
+    sort.Strings(names)
+    var sb strings.Builder
+    for _, name := range names {
+        sb.WriteString(helperSources[name])
+        sb.WriteString("\n")
+    }
+    return sb.String()
+}
@@ -0,0 +1,122 @@
+package codegen
+
+import (
+    "fmt"
+    "strings"
+)
+
+// lineMarker prefixes the marker lines genBody emits wherever the source line
+// changes. resolveLineMarkers turns them into Go `//line` directives so that
+// toolchain errors and stack traces refer to the Clockwise sources.
+const lineMarker = "//cw:line "
+
+// supportFile names the generated support code (main wrapper and helpers) in
+// line directives; it has no Clockwise source.
+const supportFile = "cw_support.go"
+
+// trapSource is the handler installed by the generated main. It recovers a
+// panic, whether from `panic`, a failed `assert` or a runtime fault in a
+// helper, and reports it in terms of the Clockwise program.
+const trapSource = `// cwTrap reports a panic with its Clockwise location and backtrace, then
+// exits with status 2 like an unrecovered Go panic.
+func cwTrap() {
+	r := recover()
+	if r == nil {
+		return
+	}
+	pcs := make([]uintptr, 64)
+	frames := runtime.CallersFrames(pcs[:runtime.Callers(1, pcs)])
+	var trace [][2]string
+	for {
+		f, more := frames.Next()
+		if strings.HasSuffix(f.File, ".cw") {
+			// -trimpath prefixes relative source names with the module path
+			file := strings.TrimPrefix(f.File, "cwtemp/")
+			trace = append(trace, [2]string{cwFuncName(f.Function), fmt.Sprintf("%s:%d", file, f.Line)})
+		}
+		if !more {
+			break
+		}
+	}
+	if len(trace) == 0 {
+		fmt.Fprintf(os.Stderr, "panic: %v\n", r)
+		os.Exit(2)
+	}
+	fmt.Fprintf(os.Stderr, "%s: panic in %s: %v\nbacktrace:\n", trace[0][1], trace[0][0], r)
+	for _, t := range trace {
+		fmt.Fprintf(os.Stderr, "    %s at %s\n", t[0], t[1])
+	}
+	os.Exit(2)
+}
+
+// cwFuncName turns a Go function name such as main.work.func1 back into the
+// Clockwise function it was generated from.
+func cwFuncName(name string) string {
+	name = strings.TrimPrefix(name, "main.")
+	if i := strings.Index(name, "."); i >= 0 {
+		name = name[:i]
+	}
+	if name == "cwMain" {
+		return "main"
+	}
+	return name
+}
+`
+
+// useTrap adds cwTrap and the packages it needs to the output.
+func (g *generator) useTrap() {
+    g.helpers["cwTrap"] = true
+    for _, pkg := range []string{"fmt", "os", "runtime", "strings"} {
+        g.imports[pkg] = true
+    }
+}
+
+// supportMarker starts a run of support code.
+const supportMarker = lineMarker + "0\n"
+
+// genMarker returns the line marker for a source line, or "" if it is not
+// known.
+func (g *generator) genMarker(line int) string {
+    if line == 0 || g.file == "" {
+        return ""
+    }
+    return fmt.Sprintf("%s%d %s\n", lineMarker, line, g.file)
+}
+
+// resolveLineMarkers replaces the markers in src with `//line` directives.
+// Every line of a statement is pinned to the statement's Clockwise line, since
+// a single statement may expand to several Go lines; lines outside any
+// statement are attributed to supportFile at their real position.
+func resolveLineMarkers(src string) string {
+    var out strings.Builder
+    goLine := 0
+    emit := func(s string) {
+        out.WriteString(s)
+        goLine++
+    }
+    directive := ""
+    for _, l := range strings.SplitAfter(src, "\n") {
+        if l == "" {
+            continue
+        }
+        if m, ok := strings.CutPrefix(l, lineMarker); ok {
+            line, file, _ := strings.Cut(strings.TrimSpace(m), " ")
+            if file != "" {
+                directive = fmt.Sprintf("//line %s:%s\n", file, line)
+                continue
+            }
+            // the blank line keeps gofmt from merging the directive into a
+            // following doc comment
+            directive = ""
+            emit(fmt.Sprintf("//line %s:%d\n\n", supportFile, goLine+2))
+            goLine++
+            continue
+        }
+        code := strings.TrimSpace(l)
+        if directive != "" && code != "" && code != "}" {
+            emit(directive)
+        }
+        emit(l)
+    }
+    return out.String()
+}
@@ -0,0 +1,590 @@
+// Package interp evaluates checked Clockwise programs directly, walking the
+// AST instead of generating code. It follows the semantics of compiled
+// programs: integers wrap at the width of their type, deferred calls run on
+// return and on panic, runtime helpers are the same Go functions, and a
+// panic is reported with the same location and backtrace.
+package interp
+
+import (
+    "fmt"
+    "strings"
+
+    "codeberg.org/clockwise-lang/clockwise/checker"
+    "codeberg.org/clockwise-lang/clockwise/ir"
+    "codeberg.org/clockwise-lang/clockwise/parser"
+)
+
+// MaxDepth bounds the call depth, where a compiled program would run out of
+// stack.
+const MaxDepth = 100000
+
+// Interpreter runs one checked program.
+type Interpreter struct {
+    funcs  map[string]*parser.Function
+    frames []*frame
+}
+
+// frame is the state of one active function call.
+type frame struct {
+    fn     *parser.Function
+    line   int
+    scopes []map[string]Value
+    defers []func() error
+}
+
+// Frame is one entry of a panic backtrace.
+type Frame struct {
+    Func string
+    File string
+    Line int
+}
+
+// Panic is a Clockwise panic that reached the top of the program: a `panic`
+// statement, a failed assert, a run-time fault or a panicking helper. Trace
+// lists the active calls, innermost first.
+type Panic struct {
+    Msg   string
+    Trace []Frame
+}
+
+// Error formats the panic like the handler in compiled programs.
+func (p *Panic) Error() string {
+    if len(p.Trace) == 0 {
+        return "panic: " + p.Msg
+    }
+    var sb strings.Builder
+    fmt.Fprintf(&sb, "%s:%d: panic in %s: %s\nbacktrace:", p.Trace[0].File, p.Trace[0].Line, p.Trace[0].Func, p.Msg)
+    for _, f := range p.Trace {
+        fmt.Fprintf(&sb, "\n    %s at %s:%d", f.Func, f.File, f.Line)
+    }
+    return sb.String()
+}
+
+// New prepares p, which must have passed the checker, for running. Calls to
+// runtime helpers the interpreter has no native implementation for are
+// reported here rather than when they are reached.
+func New(p *parser.Program) (*Interpreter, error) {
+    in := &Interpreter{funcs: map[string]*parser.Function{}}
+    for _, fn := range p.Functions {
+        in.funcs[fn.Name] = fn
+    }
+    var missing []string
+    seen := map[string]bool{}
+    for _, fn := range p.Functions {
+        missing = append(missing, in.unsupported(fn, fn.File, fn.Line, seen)...)
+    }
+    if len(missing) > 0 {
+        return nil, fmt.Errorf("%s", strings.Join(missing, "\n"))
+    }
+    return in, nil
+}
+
+// unsupported lists the calls below n of runtime helpers that have no
+// native implementation, skipping names already in seen.
+func (in *Interpreter) unsupported(n parser.Node, file string, line int, seen map[string]bool) []string {
+    var missing []string
+    in.missingCalls(n, line, seen, func(name string, line int) {
+        missing = append(missing, fmt.Sprintf("%s:%d: runtime helper %s is not available in the interpreter", file, line, name))
+    })
+    return missing
+}
+
+// Undefined returns the first function called below n that is neither
+// declared nor a runtime helper with a native implementation.
+func (in *Interpreter) Undefined(n parser.Node) (name string, ok bool) {
+    in.missingCalls(n, 0, map[string]bool{}, func(fn string, _ int) {
+        if !ok {
+            name, ok = fn, true
+        }
+    })
+    return name, ok
+}
+
+// missingCalls calls report with the name and line of each call below n of
+// a runtime helper that has no native implementation, skipping names
+// already in seen.
+func (in *Interpreter) missingCalls(n parser.Node, line int, seen map[string]bool, report func(name string, line int)) {
+    parser.Walk(n, nodeFunc(func(n parser.Node) {
+        if l := parser.LineOf(n); l != 0 {
+            line = l
+        }
+        call, ok := n.(*parser.CallExpression)
+        if !ok {
+            return
+        }
+        id, ok := call.Function.(*parser.Identifier)
+        if !ok || seen[id.Value] || in.funcs[id.Value] != nil || id.Value == "panic" || checker.IsConversion(call) || strings.HasSuffix(id.Value, "?") {
+            return
+        }
+        seen[id.Value] = true
+        if _, ok := natives[helperName(id.Value)]; !ok {
+            report(id.Value, line)
+        }
+    }))
+}
+
+// helperName maps the print builtin to the Print helper.
+func helperName(name string) string {
+    if name == "print" {
+        return "Print"
+    }
+    return name
+}
+
+// Run calls main and returns its result, the program's exit status. A panic
+// is returned as a *Panic once every deferred call has run.
+func (in *Interpreter) Run() (int, error) {
+    main, ok := in.funcs["main"]
+    if !ok {
+        return 0, fmt.Errorf("function main is not declared")
+    }
+    v, err := in.callFunc(main)
+    if err != nil {
+        return 2, err
+    }
+    return int(v.Int()), nil
+}
+
+// Call calls the function name, which takes no arguments, and returns its
+// result.
+func (in *Interpreter) Call(name string) (Value, error) {
+    fn, ok := in.funcs[name]
+    if !ok {
+        return Value{}, fmt.Errorf("function %s is not declared", name)
+    }
+    return in.callFunc(fn)
+}
+
+// Env holds the variables of an interactive session, which live outside
+// any function.
+type Env map[string]Value
+
+// Exec runs s, which must have passed checker.CheckStatement, outside any
+// function as the statement at line of an interactive session named file.
+// A variable it declares is added to env, replacing one of the same name,
+// and calls it defers run once it is done. For an expression statement the
+// expression's value is returned with ok set.
+func (in *Interpreter) Exec(env Env, s parser.Statement, file string, line int) (v Value, ok bool, err error) {
+    if n, isNode := s.(parser.Node); isNode {
+        if missing := in.unsupported(n, file, line, map[string]bool{}); len(missing) > 0 {
+            return Value{}, false, fmt.Errorf("%s", strings.Join(missing, "\n"))
+        }
+    }
+    f := &frame{fn: &parser.Function{Name: "repl", File: file}, line: line, scopes: []map[string]Value{env, {}}}
+    in.frames = append(in.frames, f)
+    if es, isExpr := s.(*parser.ExpressionStatement); isExpr && !isPanicStatement(es) {
+        v, err = in.eval(es.Expr, "")
+        ok = err == nil
+    } else {
+        _, err = in.exec(s)
+    }
+    for i := len(f.defers) - 1; i >= 0; i-- {
+        if derr := f.defers[i](); derr != nil {
+            err = derr
+        }
+    }
+    in.frames = in.frames[:len(in.frames)-1]
+    if err != nil {
+        return Value{}, false, err
+    }
+    for name, v := range f.scopes[1] {
+        env[name] = v
+    }
+    return v, ok, nil
+}
+
+func isPanicStatement(es *parser.ExpressionStatement) bool {
+    call, ok := es.Expr.(*parser.CallExpression)
+    return ok && isPanic(call)
+}
+
+func (in *Interpreter) top() *frame { return in.frames[len(in.frames)-1] }
+
+// panic raises a Clockwise panic at the current position.
+func (in *Interpreter) panic(msg string) error {
+    p := &Panic{Msg: msg}
+    for i := len(in.frames) - 1; i >= 0; i-- {
+        f := in.frames[i]
+        p.Trace = append(p.Trace, Frame{Func: f.fn.Name, File: f.fn.File, Line: f.line})
+    }
+    return p
+}
+
+// fault reports a construct the checker should have rejected.
+func (in *Interpreter) fault(format string, args ...interface{}) error {
+    f := in.top()
+    return fmt.Errorf("%s:%d: %s", f.fn.File, f.line, fmt.Sprintf(format, args...))
+}
+
+func (in *Interpreter) callFunc(fn *parser.Function) (Value, error) {
+    if len(in.frames) >= MaxDepth {
+        return Value{}, in.panic("stack overflow")
+    }
+    f := &frame{fn: fn, line: fn.Line}
+    in.frames = append(in.frames, f)
+    ret, err := in.execBlock(fn.Body)
+    // deferred calls run last-in, first-out, also when panicking; one that
+    // panics replaces the panic in flight
+    for i := len(f.defers) - 1; i >= 0; i-- {
+        if derr := f.defers[i](); derr != nil {
+            err = derr
+        }
+    }
+    in.frames = in.frames[:len(in.frames)-1]
+    if err != nil {
+        return Value{}, err
+    }
+    if ret == nil {
+        return Value{}, fmt.Errorf("%s: missing return at end of function %s", fn.File, fn.Name)
+    }
+    return *ret, nil
+}
+
+func (in *Interpreter) lookup(name string) (Value, bool) {
+    scopes := in.top().scopes
+    for i := len(scopes) - 1; i >= 0; i-- {
+        if v, ok := scopes[i][name]; ok {
+            return v, true
+        }
+    }
+    return Value{}, false
+}
+
+func (in *Interpreter) declare(name string, v Value) {
+    scopes := in.top().scopes
+    scopes[len(scopes)-1][name] = v
+}
+
+// execBlock runs the statements of b in a new scope. A non-nil result means
+// the function returned.
+func (in *Interpreter) execBlock(b *parser.BlockStatement) (*Value, error) {
+    return in.execScoped(b, nil)
+}
+
+// execScoped runs b in a new scope that starts with the given bindings.
+func (in *Interpreter) execScoped(b *parser.BlockStatement, bind map[string]Value) (*Value, error) {
+    f := in.top()
+    if bind == nil {
+        bind = map[string]Value{}
+    }
+    f.scopes = append(f.scopes, bind)
+    defer func() { f.scopes = f.scopes[:len(f.scopes)-1] }()
+    for _, s := range b.Statements {
+        if line := parser.LineOf(s); line != 0 {
+            f.line = line
+        }
+        ret, err := in.exec(s)
+        if ret != nil || err != nil {
+            return ret, err
+        }
+    }
+    return nil, nil
+}
+
+func (in *Interpreter) exec(s parser.Statement) (*Value, error) {
+    switch st := s.(type) {
+    case *parser.ReturnStatement:
+        t, err := ir.ResolveType(in.top().fn.ReturnType)
+        if err != nil {
+            return nil, err
+        }
+        v, err := in.eval(st.Value, t)
+        if err != nil {
+            return nil, err
+        }
+        return &v, nil
+    case *parser.VarStatement:
+        t, err := ir.ResolveType(st.Type)
+        if err != nil {
+            return nil, err
+        }
+        v, err := in.eval(st.Value, t)
+        if err != nil {
+            return nil, err
+        }
+        in.declare(st.Name, v)
+    case *parser.ExpressionStatement:
+        if call, ok := st.Expr.(*parser.CallExpression); ok && isPanic(call) {
+            if len(call.Args) != 1 {
+                return nil, in.fault("panic expects 1 argument")
+            }
+            msg, err := in.eval(call.Args[0], ir.String)
+            if err != nil {
+                return nil, err
+            }
+            return nil, in.panic(msg.Str)
+        }
+        _, err := in.eval(st.Expr, "")
+        return nil, err
+    case *parser.IfStatement:
+        line := in.top().line
+        cond, err := in.eval(st.Condition, ir.Bool)
+        if err != nil {
+            return nil, err
+        }
+        var ret *Value
+        if cond.Bool {
+            ret, err = in.execBlock(st.Consequent)
+        } else if st.Alternative != nil {
+            ret, err = in.execBlock(st.Alternative)
+        }
+        if ret == nil && err == nil {
+            in.top().line = line
+        }
+        return ret, err
+    case *parser.IfLetStatement:
+        line := in.top().line
+        v, err := in.eval(st.Value, "")
+        if err != nil {
+            return nil, err
+        }
+        if !v.Type.IsOptional() {
+            return nil, in.fault("if let %s: value of type %s is not optional", st.Name, v.Type)
+        }
+        var ret *Value
+        if v.Elem != nil {
+            ret, err = in.execScoped(st.Consequent, map[string]Value{st.Name: *v.Elem})
+        } else if st.Alternative != nil {
+            ret, err = in.execBlock(st.Alternative)
+        }
+        if ret == nil && err == nil {
+            in.top().line = line
+        }
+        return ret, err
+    case *parser.WhileStatement:
+        line := in.top().line
+        for {
+            in.top().line = line
+            cond, err := in.eval(st.Condition, ir.Bool)
+            if err != nil || !cond.Bool {
+                return nil, err
+            }
+            if ret, err := in.execBlock(st.Body); ret != nil || err != nil {
+                return ret, err
+            }
+        }
+    case *parser.DeferStatement:
+        call, ok := st.Call.(*parser.CallExpression)
+        if !ok {
+            return nil, in.fault("defer requires a function call")
+        }
+        // the arguments are evaluated now, the call made on return
+        run, err := in.prepareCall(call)
+        if err != nil {
+            return nil, err
+        }
+        f := in.top()
+        f.defers = append(f.defers, func() error {
+            _, err := run()
+            return err
+        })
+    case *parser.AssertStatement:
+        cond, err := in.eval(st.Condition, ir.Bool)
+        if err != nil || cond.Bool {
+            return nil, err
+        }
+        msg := "assertion failed"
+        if st.Message != nil {
+            m, err := in.eval(st.Message, ir.String)
+            if err != nil {
+                return nil, err
+            }
+            msg += ": " + m.Str
+        }
+        return nil, in.panic(msg)
+    default:
+        return nil, in.fault("cannot run statement %T", s)
+    }
+    return nil, nil
+}
+
+// eval evaluates e. want is the type the context expects, or "" if it
+// imposes none; integer constants take it, like in the IR lowering.
+func (in *Interpreter) eval(e parser.Expression, want ir.Type) (Value, error) {
+    if c, ok, err := checker.ConstValue(e); err != nil {
+        return Value{}, in.fault("%v", err)
+    } else if ok {
+        t := ir.Int
+        if want.Elem().IsInteger() {
+            t = want.Elem()
+        }
+        return ConstValue(t, c), nil
+    }
+    switch ex := e.(type) {
+    case *parser.StringLiteral:
+        return StringValue(ex.Value), nil
+    case *parser.BooleanLiteral:
+        return BoolValue(ex.Value), nil
+    case *parser.NoneLiteral:
+        if !want.IsOptional() {
+            return Value{}, in.fault("none used where %s is expected", want)
+        }
+        return Value{Type: want}, nil
+    case *parser.Identifier:
+        if v, ok := in.lookup(ex.Value); ok {
+            return v, nil
+        }
+        return Value{}, in.fault("undefined: %s", ex.Value)
+    case *parser.InterpolatedString:
+        var sb strings.Builder
+        for _, part := range ex.Parts {
+            v, err := in.eval(part, "")
+            if err != nil {
+                return Value{}, err
+            }
+            sb.WriteString(v.String())
+        }
+        return StringValue(sb.String()), nil
+    case *parser.InfixExpression:
+        return in.infix(ex, want)
+    case *parser.PrefixExpression:
+        x, err := in.eval(ex.Right, want)
+        if err != nil {
+            return Value{}, err
+        }
+        return in.result(Unary(ex.Operator, x))
+    case *parser.CoalesceExpression:
+        return in.coalesce(ex)
+    case *parser.CallExpression:
+        return in.call(ex)
+    }
+    return Value{}, in.fault("cannot evaluate expression %T", e)
+}
+
+// infix evaluates a binary operation. A constant operand takes the type of
+// the other operand, or for a shifted constant the type of the context.
+func (in *Interpreter) infix(ex *parser.InfixExpression, want ir.Type) (Value, error) {
+    var x, y Value
+    var err error
+    shift := ex.Operator == "<<" || ex.Operator == ">>"
+    if _, leftConst, _ := checker.ConstValue(ex.Left); leftConst {
+        if y, err = in.eval(ex.Right, ""); err != nil {
+            return Value{}, err
+        }
+        leftWant := y.Type
+        if shift {
+            leftWant = want
+        }
+        if x, err = in.eval(ex.Left, leftWant); err != nil {
+            return Value{}, err
+        }
+    } else {
+        if x, err = in.eval(ex.Left, ""); err != nil {
+            return Value{}, err
+        }
+        rightWant := x.Type
+        if shift {
+            rightWant = ""
+        }
+        if y, err = in.eval(ex.Right, rightWant); err != nil {
+            return Value{}, err
+        }
+    }
+    return in.result(Binary(ex.Operator, x, y))
+}
+
+// coalesce evaluates `left ?? right`; right only when left is none.
+func (in *Interpreter) coalesce(ex *parser.CoalesceExpression) (Value, error) {
+    left, err := in.eval(ex.Left, "")
+    if err != nil {
+        return Value{}, err
+    }
+    t, err := ir.ResolveType(ex.Type)
+    if err != nil {
+        return Value{}, err
+    }
+    if left.Elem == nil {
+        return in.eval(ex.Right, t)
+    }
+    if t.IsOptional() {
+        return left, nil
+    }
+    return *left.Elem, nil
+}
+
+func isPanic(call *parser.CallExpression) bool {
+    id, ok := call.Function.(*parser.Identifier)
+    return ok && id.Value == "panic"
+}
+
+// call evaluates a conversion, or a call of a function or runtime helper.
+func (in *Interpreter) call(c *parser.CallExpression) (Value, error) {
+    id, ok := c.Function.(*parser.Identifier)
+    if !ok {
+        return Value{}, in.fault("cannot call %T", c.Function)
+    }
+    if isPanic(c) {
+        return Value{}, in.fault("panic does not produce a value")
+    }
+    if t, ok, err := ir.OptionalConversion(c); err != nil {
+        return Value{}, err
+    } else if ok {
+        x, err := in.eval(c.Args[0], t.Elem())
+        if err != nil {
+            return Value{}, err
+        }
+        return Value{Type: t, Elem: &x}, nil
+    }
+    if checker.IsConversion(c) {
+        t, err := ir.ResolveType(id.Value)
+        if err != nil {
+            return Value{}, err
+        }
+        if len(c.Args) != 1 {
+            return Value{}, in.fault("conversion to %s takes one argument", t)
+        }
+        x, err := in.eval(c.Args[0], t)
+        if err != nil {
+            return Value{}, err
+        }
+        return Convert(x, t), nil
+    }
+    run, err := in.prepareCall(c)
+    if err != nil {
+        return Value{}, err
+    }
+    return run()
+}
+
+// prepareCall evaluates the arguments of a call to a function or runtime
+// helper and returns a function that makes the call.
+func (in *Interpreter) prepareCall(c *parser.CallExpression) (func() (Value, error), error) {
+    name := helperName(c.Function.(*parser.Identifier).Value)
+    if fn, ok := in.funcs[name]; ok {
+        return func() (Value, error) { return in.callFunc(fn) }, nil
+    }
+    native, ok := natives[name]
+    b, known := checker.LookupBuiltin(name)
+    if !ok || !known {
+        return nil, in.fault("runtime helper %s is not available in the interpreter", name)
+    }
+    if len(c.Args) != len(b.Params) {
+        return nil, in.fault("%s expects %d arguments, got %d", name, len(b.Params), len(c.Args))
+    }
+    args := make([]Value, len(c.Args))
+    for i, a := range c.Args {
+        v, err := in.eval(a, ir.Type(b.Params[i]))
+        if err != nil {
+            return nil, err
+        }
+        args[i] = v
+    }
+    return func() (Value, error) {
+        return in.result(CallNative(native, args, ir.Type(b.Result), b.Missing))
+    }, nil
+}
+
+// result turns the error of an operation into a panic or fault at the
+// current position.
+func (in *Interpreter) result(v Value, err error) (Value, error) {
+    if re, ok := err.(RuntimeError); ok {
+        return Value{}, in.panic(string(re))
+    } else if err != nil {
+        return Value{}, in.fault("%v", err)
+    }
+    return v, nil
+}
+
+type nodeFunc func(parser.Node)
+
+func (f nodeFunc) VisitNode(n parser.Node) { f(n) }
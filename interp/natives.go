@@ -0,0 +1,191 @@
+package interp
+
+import (
+    "fmt"
+    "math/big"
+    "strconv"
+
+    "codeberg.org/clockwise-lang/clockwise/ir"
+    baselib "codeberg.org/clockwise-lang/clockwise/runtime/baselib"
+    complib "codeberg.org/clockwise-lang/clockwise/runtime/complib"
+    crc32lib "codeberg.org/clockwise-lang/clockwise/runtime/crc32lib"
+    cryptolib "codeberg.org/clockwise-lang/clockwise/runtime/cryptolib"
+    cwlib "codeberg.org/clockwise-lang/clockwise/runtime/cwlib"
+    dnslib "codeberg.org/clockwise-lang/clockwise/runtime/dnslib"
+    environs "codeberg.org/clockwise-lang/clockwise/runtime/environs"
+    fileutil "codeberg.org/clockwise-lang/clockwise/runtime/fileutil"
+    fs "codeberg.org/clockwise-lang/clockwise/runtime/fs"
+    gziplib "codeberg.org/clockwise-lang/clockwise/runtime/gziplib"
+    hexlib "codeberg.org/clockwise-lang/clockwise/runtime/hexlib"
+    hmaclib "codeberg.org/clockwise-lang/clockwise/runtime/hmaclib"
+    httputil "codeberg.org/clockwise-lang/clockwise/runtime/httputil"
+    inilib "codeberg.org/clockwise-lang/clockwise/runtime/inilib"
+    jsonlib "codeberg.org/clockwise-lang/clockwise/runtime/jsonlib"
+    mathlib "codeberg.org/clockwise-lang/clockwise/runtime/mathlib"
+    metriclib "codeberg.org/clockwise-lang/clockwise/runtime/metriclib"
+    netlib "codeberg.org/clockwise-lang/clockwise/runtime/netlib"
+    osenv "codeberg.org/clockwise-lang/clockwise/runtime/osenv"
+    pathlib "codeberg.org/clockwise-lang/clockwise/runtime/pathlib"
+    randlib "codeberg.org/clockwise-lang/clockwise/runtime/randlib"
+    regexlib "codeberg.org/clockwise-lang/clockwise/runtime/regexlib"
+    statlib "codeberg.org/clockwise-lang/clockwise/runtime/statlib"
+    stringslib "codeberg.org/clockwise-lang/clockwise/runtime/stringslib"
+    stringx "codeberg.org/clockwise-lang/clockwise/runtime/stringx"
+    tempfilelib "codeberg.org/clockwise-lang/clockwise/runtime/tempfilelib"
+    timelib "codeberg.org/clockwise-lang/clockwise/runtime/timelib"
+    timeparse "codeberg.org/clockwise-lang/clockwise/runtime/timeparse"
+    urlxlib "codeberg.org/clockwise-lang/clockwise/runtime/urlxlib"
+    uuidlib "codeberg.org/clockwise-lang/clockwise/runtime/uuidlib"
+    validate "codeberg.org/clockwise-lang/clockwise/runtime/validate"
+)
+
+// Native is a runtime helper implemented in Go. It receives arguments already
+// converted to the parameter types the checker declares for the helper and
+// returns the helper's raw result; a "missing" sentinel is turned into none
+// by the caller.
+type Native func(args []Value) Value
+
+// natives binds the runtime helpers the checker knows to the same Go
+// functions compiled programs link, from the library `cwc build` would pick
+// for each name. Each entry goes through a typed adapter, so no reflection
+// is involved.
+var natives = map[string]Native{
+    "Print":           strToInt(cwlib.Print),
+    "Sconcat":         strStrToStr(cwlib.Sconcat),
+    "Concat":          strStrToStr(stringslib.Concat),
+    "ToUpper":         strToStr(stringslib.ToUpper),
+    "Trim":            strToStr(stringx.Trim),
+    "Slice":           strIntIntToStr(stringx.Slice),
+    "SplitFirstTwo":   strStrToStr(stringx.SplitFirstTwo),
+    "HexEncode":       strToStr(hexlib.HexEncode),
+    "HexDecode":       strToStr(hexlib.HexDecode),
+    "Base64Encode":    strToStr(baselib.Base64Encode),
+    "Base64Decode":    strToStr(baselib.Base64Decode),
+    "UUIDv4":          toStr(uuidlib.UUIDv4),
+    "JSONEscape":      strToStr(jsonlib.JSONEscape),
+    "CRC32Hex":        strToStr(crc32lib.CRC32Hex),
+    "SHA256Hex":       strToStr(cryptolib.SHA256Hex),
+    "HMACSHA256":      strStrToStr(hmaclib.HMACSHA256),
+    "URLEncode":       strToStr(urlxlib.URLEncode),
+    "URLDecode":       strToStr(urlxlib.URLDecode),
+    "JoinURL":         strStrToStr(httputil.JoinURL),
+    "GzipBase64":      strToStr(complib.GzipBase64),
+    "Gzip":            strToStr(gziplib.Gzip),
+    "Gunzip":          strToStr(gziplib.Gunzip),
+    "HttpGet":         strToStr(netlib.HttpGet),
+    "HttpPost":        strStrToStr(netlib.HttpPost),
+    "LookupHost":      strToStr(dnslib.LookupHost),
+    "NowISO":          toStr(timelib.NowISO),
+    "ParseISO":        strToStr(timeparse.ParseISO),
+    "GetEnv":          strToStr(environs.GetEnv),
+    "Getenv":          strToStr(osenv.Getenv),
+    "ParseINI":        strStrToStr(inilib.ParseINI),
+    "RegexReplaceAll": strStrStrToStr(regexlib.RegexReplaceAll),
+    "BaseName":        strToStr(pathlib.BaseName),
+    "DirName":         strToStr(pathlib.DirName),
+    "CreateTemp":      strToStr(tempfilelib.CreateTemp),
+    "WriteTemp":       strStrToStr(tempfilelib.WriteTemp),
+    "Remove":          strToInt(tempfilelib.Remove),
+    "Abs":             intToInt(mathlib.Abs),
+    "Min":             intIntToInt(mathlib.Min),
+    "Max":             intIntToInt(mathlib.Max),
+    "SumInts":         intIntToInt(statlib.SumInts),
+    "MeanInts":        intIntToInt(statlib.MeanInts),
+    "RandInt":         intToInt(randlib.RandInt),
+    "FileSize":        strToI64(fileutil.FileSize),
+    "TimestampMs":     toI64(metriclib.TimestampMs),
+    "IsEmail":         strToBool(validate.IsEmail),
+    "Exists":          strToBool(fs.Exists),
+    "RegexMatch":      strStrToBool(regexlib.RegexMatch),
+    "FormatISO":       i64ToStr(timeparse.FormatISO),
+}
+
+// LookupNative returns the Go implementation of the named runtime helper.
+func LookupNative(name string) (Native, bool) {
+    f, ok := natives[name]
+    return f, ok
+}
+
+// CallNative calls a runtime helper whose checked result type is result. A
+// Go panic in the helper is returned as a RuntimeError, and the helper's
+// "missing" sentinel, a Go literal, becomes none.
+func CallNative(f Native, args []Value, result ir.Type, missing string) (v Value, err error) {
+    defer func() {
+        if r := recover(); r != nil {
+            err = RuntimeError(fmt.Sprint(r))
+        }
+    }()
+    v = f(args)
+    if !result.IsOptional() {
+        return v, nil
+    }
+    if equal(v, sentinel(result.Elem(), missing)) {
+        return Value{Type: result}, nil
+    }
+    elem := v
+    return Value{Type: result, Elem: &elem}, nil
+}
+
+// sentinel parses the Go literal a helper returns to mean "absent".
+func sentinel(t ir.Type, missing string) Value {
+    if s, err := strconv.Unquote(missing); err == nil {
+        return StringValue(s)
+    }
+    n, _ := new(big.Int).SetString(missing, 10)
+    if n == nil {
+        n = new(big.Int)
+    }
+    return ConstValue(t, n)
+}
+
+func toStr(f func() string) Native {
+    return func(a []Value) Value { return StringValue(f()) }
+}
+
+func strToStr(f func(string) string) Native {
+    return func(a []Value) Value { return StringValue(f(a[0].Str)) }
+}
+
+func strStrToStr(f func(string, string) string) Native {
+    return func(a []Value) Value { return StringValue(f(a[0].Str, a[1].Str)) }
+}
+
+func strStrStrToStr(f func(string, string, string) string) Native {
+    return func(a []Value) Value { return StringValue(f(a[0].Str, a[1].Str, a[2].Str)) }
+}
+
+func strIntIntToStr(f func(string, int, int) string) Native {
+    return func(a []Value) Value { return StringValue(f(a[0].Str, int(a[1].Int()), int(a[2].Int()))) }
+}
+
+func i64ToStr(f func(int64) string) Native {
+    return func(a []Value) Value { return StringValue(f(a[0].Int())) }
+}
+
+func strToInt(f func(string) int) Native {
+    return func(a []Value) Value { return IntValue(ir.Int, int64(f(a[0].Str))) }
+}
+
+func intToInt(f func(int) int) Native {
+    return func(a []Value) Value { return IntValue(ir.Int, int64(f(int(a[0].Int())))) }
+}
+
+func intIntToInt(f func(int, int) int) Native {
+    return func(a []Value) Value { return IntValue(ir.Int, int64(f(int(a[0].Int()), int(a[1].Int())))) }
+}
+
+func strToI64(f func(string) int64) Native {
+    return func(a []Value) Value { return IntValue("i64", f(a[0].Str)) }
+}
+
+func toI64(f func() int64) Native {
+    return func(a []Value) Value { return IntValue("i64", f()) }
+}
+
+func strToBool(f func(string) bool) Native {
+    return func(a []Value) Value { return BoolValue(f(a[0].Str)) }
+}
+
+func strStrToBool(f func(string, string) bool) Native {
+    return func(a []Value) Value { return BoolValue(f(a[0].Str, a[1].Str)) }
+}
@@ -0,0 +1,101 @@
+package interp
+
+import (
+    "fmt"
+
+    "codeberg.org/clockwise-lang/clockwise/ir"
+)
+
+// RuntimeError is a run-time fault of a Clockwise program, such as dividing
+// by zero. It becomes a Clockwise panic at the faulting position.
+type RuntimeError string
+
+func (e RuntimeError) Error() string { return string(e) }
+
+// Integer operations are done on the 64-bit patterns and truncated to the
+// operand type, which wraps exactly like the narrower Go arithmetic the
+// compiled program performs.
+
+// Binary applies an infix operator to two operands of the same type (for
+// shifts, y is the count and may have any integer type).
+func Binary(op string, x, y Value) (Value, error) {
+    switch op {
+    case "==":
+        return BoolValue(equal(x, y)), nil
+    case "!=":
+        return BoolValue(!equal(x, y)), nil
+    case "<":
+        return BoolValue(less(x, y)), nil
+    case ">":
+        return BoolValue(less(y, x)), nil
+    case "<=":
+        return BoolValue(!less(y, x)), nil
+    case ">=":
+        return BoolValue(!less(x, y)), nil
+    }
+    t := x.Type
+    if t == ir.String && op == "+" {
+        return StringValue(x.Str + y.Str), nil
+    }
+    if !t.IsInteger() {
+        return Value{}, fmt.Errorf("operator %s is not defined on %s", op, t)
+    }
+    var r uint64
+    switch op {
+    case "+":
+        r = x.Bits + y.Bits
+    case "-":
+        r = x.Bits - y.Bits
+    case "*":
+        r = x.Bits * y.Bits
+    case "&":
+        r = x.Bits & y.Bits
+    case "|":
+        r = x.Bits | y.Bits
+    case "^":
+        r = x.Bits ^ y.Bits
+    case "/", "%":
+        if y.Bits == 0 {
+            return Value{}, RuntimeError("runtime error: integer divide by zero")
+        }
+        switch {
+        case signed(t) && op == "/":
+            r = uint64(int64(x.Bits) / int64(y.Bits))
+        case signed(t):
+            r = uint64(int64(x.Bits) % int64(y.Bits))
+        case op == "/":
+            r = x.Bits / y.Bits
+        default:
+            r = x.Bits % y.Bits
+        }
+    case "<<", ">>":
+        if signed(y.Type) && int64(y.Bits) < 0 {
+            return Value{}, RuntimeError("runtime error: negative shift amount")
+        }
+        switch {
+        case op == "<<":
+            r = x.Bits << y.Bits
+        case signed(t):
+            r = uint64(int64(x.Bits) >> y.Bits)
+        default:
+            r = x.Bits >> y.Bits
+        }
+    default:
+        return Value{}, fmt.Errorf("unknown operator %s", op)
+    }
+    return Value{Type: t, Bits: normalize(t, r)}, nil
+}
+
+// Unary applies a prefix operator.
+func Unary(op string, x Value) (Value, error) {
+    if !x.Type.IsInteger() {
+        return Value{}, fmt.Errorf("operator %s is not defined on %s", op, x.Type)
+    }
+    switch op {
+    case "-":
+        return Value{Type: x.Type, Bits: normalize(x.Type, -x.Bits)}, nil
+    case "~":
+        return Value{Type: x.Type, Bits: normalize(x.Type, ^x.Bits)}, nil
+    }
+    return Value{}, fmt.Errorf("unknown operator %s", op)
+}
@@ -0,0 +1,122 @@
+package interp
+
+import (
+    "fmt"
+    "math/big"
+    "strconv"
+
+    "codeberg.org/clockwise-lang/clockwise/ir"
+)
+
+// Value is a Clockwise value at run time. Integers of every type are held as
+// the 64-bit two's complement pattern of their value, sign- or zero-extended
+// according to Type, so that converting Bits to the Go type of the same
+// width gives the value back.
+type Value struct {
+    Type ir.Type
+    Bits uint64
+    Str  string
+    Bool bool
+    // Elem is the value inside a non-empty optional; nil means none.
+    Elem *Value
+}
+
+// IntValue returns the value v of integer type t.
+func IntValue(t ir.Type, v int64) Value {
+    return Value{Type: t, Bits: normalize(t, uint64(v))}
+}
+
+// StringValue returns a string value.
+func StringValue(s string) Value {
+    return Value{Type: ir.String, Str: s}
+}
+
+// BoolValue returns a bool value.
+func BoolValue(b bool) Value {
+    return Value{Type: ir.Bool, Bool: b}
+}
+
+// Int returns the value of a signed integer.
+func (v Value) Int() int64 { return int64(v.Bits) }
+
+// signed reports whether t is a signed integer type.
+func signed(t ir.Type) bool {
+    return t.IsInteger() && t[0] != 'u'
+}
+
+// normalize truncates bits to the width of t and sign- or zero-extends the
+// result back to 64 bits.
+func normalize(t ir.Type, bits uint64) uint64 {
+    switch t {
+    case "i8":
+        return uint64(int8(bits))
+    case "i16":
+        return uint64(int16(bits))
+    case "i32":
+        return uint64(int32(bits))
+    case "u8":
+        return uint64(uint8(bits))
+    case "u16":
+        return uint64(uint16(bits))
+    case "u32":
+        return uint64(uint32(bits))
+    }
+    return bits
+}
+
+// ConstValue returns the integer constant c as a value of type t. The checker
+// has made sure that it fits.
+func ConstValue(t ir.Type, c *big.Int) Value {
+    if signed(t) {
+        return Value{Type: t, Bits: uint64(c.Int64())}
+    }
+    return Value{Type: t, Bits: c.Uint64()}
+}
+
+// Convert converts the integer x to type t, truncating or extending like
+// Go's conversions.
+func Convert(x Value, t ir.Type) Value {
+    return Value{Type: t, Bits: normalize(t, x.Bits)}
+}
+
+// String formats v as interpolation does.
+func (v Value) String() string {
+    switch {
+    case v.Type == ir.String:
+        return v.Str
+    case v.Type == ir.Bool:
+        return strconv.FormatBool(v.Bool)
+    case signed(v.Type):
+        return strconv.FormatInt(int64(v.Bits), 10)
+    case v.Type.IsInteger():
+        return strconv.FormatUint(v.Bits, 10)
+    case v.Type.IsOptional():
+        if v.Elem == nil {
+            return "none"
+        }
+        return v.Elem.String()
+    }
+    return fmt.Sprintf("<%s>", v.Type)
+}
+
+// equal reports whether two values of the same type are equal.
+func equal(x, y Value) bool {
+    switch x.Type {
+    case ir.String:
+        return x.Str == y.Str
+    case ir.Bool:
+        return x.Bool == y.Bool
+    }
+    return x.Bits == y.Bits
+}
+
+// less reports whether x < y for two integers or strings of the same type.
+func less(x, y Value) bool {
+    switch {
+    case x.Type == ir.String:
+        return x.Str < y.Str
+    case signed(x.Type):
+        return int64(x.Bits) < int64(y.Bits)
+    }
+    return x.Bits < y.Bits
+}
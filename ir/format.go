@@ -0,0 +1,88 @@
+package ir
+
+import (
+    "fmt"
+    "strings"
+)
+
+func (i *Copy) String() string  { return fmt.Sprintf("%s = %s", i.Dst, i.Src) }
+func (i *BinOp) String() string { return fmt.Sprintf("%s = %s %s %s", i.Dst, i.X, i.Op, i.Y) }
+func (i *UnOp) String() string  { return fmt.Sprintf("%s = %s%s", i.Dst, i.Op, i.X) }
+
+func (i *Convert) String() string  { return fmt.Sprintf("%s = convert %s %s", i.Dst, i.Dst.Typ, i.X) }
+func (i *Some) String() string     { return fmt.Sprintf("%s = some %s", i.Dst, i.X) }
+func (i *IsNone) String() string   { return fmt.Sprintf("%s = isnone %s", i.Dst, i.X) }
+func (i *Unwrap) String() string   { return fmt.Sprintf("%s = unwrap %s", i.Dst, i.X) }
+func (i *ToString) String() string { return fmt.Sprintf("%s = tostring %s", i.Dst, i.X) }
+func (i *Concat) String() string   { return fmt.Sprintf("%s = concat %s", i.Dst, joinValues(i.Parts)) }
+func (i *Defer) String() string    { return "defer " + i.Call.String() }
+
+func (i *Call) String() string {
+    call := fmt.Sprintf("call %s(%s)", i.Func, joinValues(i.Args))
+    if i.Missing != "" {
+        call += " missing " + i.Missing
+    }
+    if i.Dst == nil {
+        return call
+    }
+    return fmt.Sprintf("%s = %s", i.Dst, call)
+}
+
+func (t *Jump) String() string   { return "jump " + t.Target.String() }
+func (t *Branch) String() string { return fmt.Sprintf("branch %s, %s, %s", t.Cond, t.Then, t.Else) }
+func (t *Return) String() string { return "return " + t.Value.String() }
+func (t *Panic) String() string  { return "panic " + t.Msg.String() }
+
+func joinValues(vs []Value) string {
+    out := make([]string, len(vs))
+    for i, v := range vs {
+        out[i] = v.String()
+    }
+    return strings.Join(out, ", ")
+}
+
+// Format renders p as text, one instruction per line with its source line,
+// for `cwc build --emit=ir`.
+func Format(p *Program) string {
+    var sb strings.Builder
+    for i, fn := range p.Funcs {
+        if i > 0 {
+            sb.WriteString("\n")
+        }
+        sb.WriteString(fn.String())
+    }
+    return sb.String()
+}
+
+func (fn *Func) String() string {
+    var sb strings.Builder
+    if fn.Export {
+        sb.WriteString("export ")
+    }
+    fmt.Fprintf(&sb, "func %s() %s", fn.Name, fn.Result)
+    if fn.File != "" {
+        fmt.Fprintf(&sb, "  ; %s:%d", fn.File, fn.Line)
+    }
+    sb.WriteString("\n")
+    for _, l := range fn.Locals {
+        fmt.Fprintf(&sb, "    local %s %s\n", l, l.Typ)
+    }
+    for _, b := range fn.Blocks {
+        fmt.Fprintf(&sb, "%s:\n", b)
+        for _, in := range b.Instrs {
+            writeLine(&sb, in.String(), in.SourceLine())
+        }
+        if b.Term != nil {
+            writeLine(&sb, b.Term.String(), b.Term.SourceLine())
+        }
+    }
+    return sb.String()
+}
+
+func writeLine(sb *strings.Builder, text string, line int) {
+    if line == 0 {
+        fmt.Fprintf(sb, "    %s\n", text)
+        return
+    }
+    fmt.Fprintf(sb, "    %-40s ; line %d\n", text, line)
+}
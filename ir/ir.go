@@ -0,0 +1,246 @@
+// Package ir defines the typed intermediate representation between the
+// checked AST and the code generators: three-address code over explicitly
+// typed locals and temporaries, grouped into basic blocks that end in a
+// single control-flow terminator.
+package ir
+
+import (
+    "fmt"
+    "math/big"
+    "strings"
+)
+
+// Type is a Clockwise type: int, the sized integers, string, bool, or an
+// optional `T?` of one of them.
+type Type string
+
+const (
+    Int    Type = "int"
+    String Type = "string"
+    Bool   Type = "bool"
+)
+
+// integerTypes lists the integer types.
+var integerTypes = map[Type]bool{
+    "int": true, "i8": true, "i16": true, "i32": true, "i64": true,
+    "u8": true, "u16": true, "u32": true, "u64": true,
+}
+
+// IsInteger reports whether t is int or a sized integer type.
+func (t Type) IsInteger() bool { return integerTypes[t] }
+
+// IsOptional reports whether t is an optional `T?`.
+func (t Type) IsOptional() bool { return strings.HasSuffix(string(t), "?") }
+
+// Elem returns the type contained in an optional, or t itself.
+func (t Type) Elem() Type { return Type(strings.TrimSuffix(string(t), "?")) }
+
+// Program is a lowered Clockwise program.
+type Program struct {
+    Funcs []*Func
+}
+
+// Func is one function. Its locals include both the source variables and the
+// temporaries introduced by lowering; Blocks[0] is the entry block.
+type Func struct {
+    Name   string
+    Result Type
+    File   string
+    Line   int
+    // Export is set for functions the host may call in WebAssembly builds.
+    Export bool
+    Locals []*Local
+    Blocks []*Block
+}
+
+// Block is a basic block: straight-line instructions followed by a
+// terminator.
+type Block struct {
+    Index  int
+    Instrs []Instr
+    Term   Terminator
+}
+
+func (b *Block) String() string { return fmt.Sprintf("b%d", b.Index) }
+
+// Value is an instruction operand: a constant or a local.
+type Value interface {
+    Type() Type
+    String() string
+}
+
+// Const is a constant of type Typ. Integer constants hold their exact value
+// in Int, strings in Str and booleans in Bool; an optional constant is none.
+type Const struct {
+    Typ  Type
+    Int  *big.Int
+    Str  string
+    Bool bool
+}
+
+func (c *Const) Type() Type { return c.Typ }
+
+func (c *Const) String() string {
+    switch {
+    case c.Typ.IsOptional():
+        return "none"
+    case c.Typ == String:
+        return fmt.Sprintf("%q", c.Str)
+    case c.Typ == Bool:
+        return fmt.Sprint(c.Bool)
+    }
+    return c.Int.String()
+}
+
+// Local is a variable of a function. Temporaries are numbered and never
+// named in the source.
+type Local struct {
+    Name string
+    Typ  Type
+    Temp bool
+}
+
+func (l *Local) Type() Type { return l.Typ }
+
+func (l *Local) String() string {
+    if l.Temp {
+        return "%" + l.Name
+    }
+    return l.Name
+}
+
+// Pos is embedded in instructions and terminators to record the source line
+// they were lowered from; 0 if unknown.
+type Pos struct {
+    Line int
+}
+
+func (p Pos) SourceLine() int { return p.Line }
+
+// Instr is a non-branching instruction.
+type Instr interface {
+    SourceLine() int
+    String() string
+}
+
+// Copy assigns Src to Dst.
+type Copy struct {
+    Pos
+    Dst *Local
+    Src Value
+}
+
+// BinOp computes X Op Y. Comparisons yield bool; every other operator yields
+// the operand type.
+type BinOp struct {
+    Pos
+    Dst  *Local
+    Op   string
+    X, Y Value
+}
+
+// UnOp computes Op X, where Op is "-" or "~".
+type UnOp struct {
+    Pos
+    Dst *Local
+    Op  string
+    X   Value
+}
+
+// Convert converts the integer X to the integer type of Dst.
+type Convert struct {
+    Pos
+    Dst *Local
+    X   Value
+}
+
+// Some wraps X into the optional Dst.
+type Some struct {
+    Pos
+    Dst *Local
+    X   Value
+}
+
+// IsNone sets the bool Dst to whether the optional X is none.
+type IsNone struct {
+    Pos
+    Dst *Local
+    X   Value
+}
+
+// Unwrap extracts the value of the optional X, which must not be none.
+type Unwrap struct {
+    Pos
+    Dst *Local
+    X   Value
+}
+
+// ToString formats the integer or bool X in decimal or as true/false.
+type ToString struct {
+    Pos
+    Dst *Local
+    X   Value
+}
+
+// Concat joins string Parts.
+type Concat struct {
+    Pos
+    Dst   *Local
+    Parts []Value
+}
+
+// Call calls a Clockwise function or runtime helper; Dst is nil if the
+// result is unused. Missing is the sentinel a helper with an optional result
+// returns for none, in the syntax of the Go runtime (see checker.Builtin).
+type Call struct {
+    Pos
+    Dst     *Local
+    Func    string
+    Args    []Value
+    Missing string
+}
+
+// Defer schedules Call to run when the function returns; its arguments are
+// evaluated now.
+type Defer struct {
+    Pos
+    Call *Call
+}
+
+// Terminator ends a block.
+type Terminator interface {
+    SourceLine() int
+    String() string
+    // Succs returns the blocks control may continue in.
+    Succs() []*Block
+}
+
+// Jump continues in Target.
+type Jump struct {
+    Pos
+    Target *Block
+}
+
+// Branch continues in Then if Cond is true and in Else otherwise.
+type Branch struct {
+    Pos
+    Cond       Value
+    Then, Else *Block
+}
+
+// Return leaves the function with Value.
+type Return struct {
+    Pos
+    Value Value
+}
+
+// Panic aborts the program with the string Msg.
+type Panic struct {
+    Pos
+    Msg Value
+}
+
+func (t *Jump) Succs() []*Block   { return []*Block{t.Target} }
+func (t *Branch) Succs() []*Block { return []*Block{t.Then, t.Else} }
+func (t *Return) Succs() []*Block { return nil }
+func (t *Panic) Succs() []*Block  { return nil }
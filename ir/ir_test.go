@@ -0,0 +1,252 @@
+package ir
+
+import (
+    "reflect"
+    "testing"
+
+    "codeberg.org/clockwise-lang/clockwise/checker"
+    "codeberg.org/clockwise-lang/clockwise/lexer"
+    "codeberg.org/clockwise-lang/clockwise/parser"
+)
+
+// loweringTests give the IR of each program as lowered and after the -O1
+// passes, with the changes those report. An empty optimized means the
+// passes leave the program as it is.
+var loweringTests = []struct {
+    name      string
+    src       string
+    lowered   string
+    optimized string
+    changes   []string
+}{
+    {
+        name: "optionals and temporaries",
+        src: `fn main() -> int {
+    var x: int = 1 + 2;
+    var s: string? = GetEnv("X");
+    if let v = s {
+        Print("${v} ${x}");
+    }
+    var n: i64? = FileSize("f");
+    return int(n ?? i64(x));
+}
+`,
+        lowered: `func main() int
+    local x int
+    local %1 string?
+    local s string?
+    local %2 bool
+    local v string
+    local %3 string
+    local %4 string
+    local %5 i64?
+    local n i64?
+    local %6 i64
+    local %7 bool
+    local %8 i64
+    local %9 int
+b0:
+    x = 3                                    ; line 2
+    %1 = call GetEnv("X") missing ""         ; line 3
+    s = %1                                   ; line 3
+    %2 = isnone s                            ; line 4
+    branch %2, b2, b1                        ; line 4
+b1:
+    v = unwrap s                             ; line 4
+    %3 = tostring x                          ; line 5
+    %4 = concat v, " ", %3                   ; line 5
+    call Print(%4)                           ; line 5
+    jump b2                                  ; line 4
+b2:
+    %5 = call FileSize("f") missing -1       ; line 7
+    n = %5                                   ; line 7
+    %7 = isnone n                            ; line 8
+    branch %7, b3, b4                        ; line 8
+b3:
+    %8 = convert i64 x                       ; line 8
+    %6 = %8                                  ; line 8
+    jump b5                                  ; line 8
+b4:
+    %6 = unwrap n                            ; line 8
+    jump b5                                  ; line 8
+b5:
+    %9 = convert int %6                      ; line 8
+    return %9                                ; line 8
+`,
+        optimized: `func main() int
+    local x int
+    local s string?
+    local %2 bool
+    local v string
+    local %3 string
+    local %4 string
+    local n i64?
+    local %6 i64
+    local %7 bool
+    local %9 int
+b0:
+    x = 3                                    ; line 2
+    s = call GetEnv("X") missing ""          ; line 3
+    %2 = isnone s                            ; line 4
+    branch %2, b2, b1                        ; line 4
+b1:
+    v = unwrap s                             ; line 4
+    %3 = tostring x                          ; line 5
+    %4 = concat v, " ", %3                   ; line 5
+    call Print(%4)                           ; line 5
+    jump b2                                  ; line 4
+b2:
+    n = call FileSize("f") missing -1        ; line 7
+    %7 = isnone n                            ; line 8
+    branch %7, b3, b4                        ; line 8
+b3:
+    %6 = convert i64 x                       ; line 8
+    jump b5                                  ; line 8
+b4:
+    %6 = unwrap n                            ; line 8
+    jump b5                                  ; line 8
+b5:
+    %9 = convert int %6                      ; line 8
+    return %9                                ; line 8
+`,
+        changes: []string{
+            "coalesce-temps: main: removed 3 temporaries",
+        },
+    },
+    {
+        name: "constant branches",
+        src: `fn main() -> int {
+    if true {
+        Print("a");
+    } else {
+        Print("b");
+    }
+    while false {
+        Print("c");
+    }
+    var y: int = 2 * 3;
+    return y;
+}
+`,
+        lowered: `func main() int
+    local y int
+b0:
+    branch true, b1, b3                      ; line 2
+b1:
+    call Print("a")                          ; line 3
+    jump b2                                  ; line 2
+b2:
+    jump b4                                  ; line 7
+b3:
+    call Print("b")                          ; line 5
+    jump b2                                  ; line 2
+b4:
+    branch false, b5, b6                     ; line 7
+b5:
+    call Print("c")                          ; line 8
+    jump b4                                  ; line 7
+b6:
+    y = 6                                    ; line 10
+    return y                                 ; line 11
+`,
+        optimized: `func main() int
+    local y int
+b0:
+    call Print("a")                          ; line 3
+    y = 6                                    ; line 10
+    return y                                 ; line 11
+`,
+        changes: []string{
+            "fold-branches: main: b0 always continues in b1",
+            "fold-branches: main: b4 always continues in b6",
+            "fold-branches: main: removed 2 unreachable blocks",
+            "thread-jumps: main: threaded 2 jumps",
+            "thread-jumps: main: removed 2 unreachable blocks",
+            "merge-blocks: main: merged 2 blocks",
+        },
+    },
+    {
+        name: "defer, assert and conversions",
+        src: `fn f() -> u8 {
+    defer Print("d");
+    assert(1 < 2, "m");
+    return u8(3);
+}
+fn main() -> int {
+    return int(f());
+}
+`,
+        lowered: `func f() u8
+    local %1 bool
+    local %2 string
+b0:
+    defer call Print("d")                    ; line 2
+    %1 = 1 < 2                               ; line 3
+    branch %1, b1, b2                        ; line 3
+b1:
+    return 3                                 ; line 4
+b2:
+    %2 = concat "assertion failed: ", "m"    ; line 3
+    panic %2                                 ; line 3
+
+func main() int
+    local %1 u8
+    local %2 int
+b0:
+    %1 = call f()                            ; line 7
+    %2 = convert int %1                      ; line 7
+    return %2                                ; line 7
+`,
+    },
+}
+
+func lower(t *testing.T, src string) *Program {
+    t.Helper()
+    prog, err := parser.New(lexer.New(src).Tokenize()).ParseProgram()
+    if err != nil {
+        t.Fatal(err)
+    }
+    if err := checker.CheckProgram(prog); err != nil {
+        t.Fatal(err)
+    }
+    p, err := Lower(prog)
+    if err != nil {
+        t.Fatal(err)
+    }
+    return p
+}
+
+func TestLower(t *testing.T) {
+    for _, tt := range loweringTests {
+        t.Run(tt.name, func(t *testing.T) {
+            if got := Format(lower(t, tt.src)); got != tt.lowered {
+                t.Errorf("lowered to\n%s\nwant\n%s", got, tt.lowered)
+            }
+        })
+    }
+}
+
+func TestOptimize(t *testing.T) {
+    for _, tt := range loweringTests {
+        t.Run(tt.name, func(t *testing.T) {
+            p := lower(t, tt.src)
+            if changes := Optimize(p, 0); len(changes) != 0 || Format(p) != tt.lowered {
+                t.Errorf("-O0 changed the program: %q\n%s", changes, Format(p))
+            }
+            changes := Optimize(p, 1)
+            if !reflect.DeepEqual(changes, tt.changes) {
+                t.Errorf("changes = %q, want %q", changes, tt.changes)
+            }
+            want := tt.optimized
+            if want == "" {
+                want = tt.lowered
+            }
+            if got := Format(p); got != want {
+                t.Errorf("optimized to\n%s\nwant\n%s", got, want)
+            }
+            if again := Optimize(p, 1); len(again) != 0 {
+                t.Errorf("optimizing again changed %q", again)
+            }
+        })
+    }
+}
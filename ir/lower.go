@@ -0,0 +1,594 @@
+package ir
+
+import (
+    "fmt"
+    "strconv"
+    "strings"
+
+    "codeberg.org/clockwise-lang/clockwise/checker"
+    "codeberg.org/clockwise-lang/clockwise/parser"
+)
+
+// Lower translates a checked program into IR. Integer constant expressions
+// are evaluated exactly and take the type of the context they are used in.
+// Constructs the lowering does not know are reported as errors rather than
+// guessed at.
+func Lower(p *parser.Program) (*Program, error) {
+    funcs := map[string]*parser.Function{}
+    for _, fn := range p.Functions {
+        funcs[fn.Name] = fn
+    }
+    out := &Program{}
+    for _, fn := range p.Functions {
+        l := &lowerer{funcs: funcs}
+        f, err := l.lowerFunc(fn)
+        if err != nil {
+            return nil, fmt.Errorf("in function %s: %w", fn.Name, err)
+        }
+        out.Funcs = append(out.Funcs, f)
+    }
+    return out, nil
+}
+
+// lowerer holds the state for lowering one function: the block being filled,
+// the scopes mapping source names to locals and the source line of the
+// statement being lowered.
+type lowerer struct {
+    funcs  map[string]*parser.Function
+    fn     *Func
+    cur    *Block
+    scopes []map[string]*Local
+    // names holds every local name taken in the function, so shadowing
+    // declarations get distinct locals
+    names map[string]bool
+    temps int
+    line  int
+}
+
+func (l *lowerer) lowerFunc(fn *parser.Function) (*Func, error) {
+    result, err := ResolveType(fn.ReturnType)
+    if err != nil {
+        return nil, err
+    }
+    l.fn = &Func{Name: fn.Name, Result: result, File: fn.File, Line: fn.Line, Export: fn.Export}
+    l.names = map[string]bool{}
+    parser.Walk(fn, nodeFunc(func(n parser.Node) {
+        switch st := n.(type) {
+        case *parser.VarStatement:
+            l.names[st.Name] = true
+        case *parser.IfLetStatement:
+            l.names[st.Name] = true
+        }
+    }))
+    l.cur = l.newBlock()
+    if err := l.block(fn.Body); err != nil {
+        return nil, err
+    }
+    reachable := reachableBlocks(l.fn)
+    for _, b := range l.fn.Blocks {
+        if b.Term == nil && reachable[b] {
+            return nil, fmt.Errorf("missing return at end of function")
+        }
+    }
+    removeBlocks(l.fn, reachable)
+    return l.fn, nil
+}
+
+// ResolveType canonicalizes a source type name; the parser leaves it empty
+// where the language defaults to int.
+func ResolveType(name string) (Type, error) {
+    if name == "" {
+        return Int, nil
+    }
+    t, err := checker.ParseType(name)
+    return Type(t), err
+}
+
+// OptionalConversion reports whether call is a conversion to an optional
+// `T?(x)`, which the checker inserts where a T is used as a T?, and returns
+// the optional type.
+func OptionalConversion(call *parser.CallExpression) (Type, bool, error) {
+    id, ok := call.Function.(*parser.Identifier)
+    if !ok || !strings.HasSuffix(id.Value, "?") || len(call.Args) != 1 {
+        return "", false, nil
+    }
+    t, err := ResolveType(id.Value)
+    return t, true, err
+}
+
+func (l *lowerer) newBlock() *Block {
+    b := &Block{Index: len(l.fn.Blocks)}
+    l.fn.Blocks = append(l.fn.Blocks, b)
+    return b
+}
+
+func (l *lowerer) emit(in Instr) {
+    l.cur.Instrs = append(l.cur.Instrs, in)
+}
+
+// terminate ends the current block with t and continues in next. Code
+// following a return or panic goes into a fresh block nothing jumps to.
+func (l *lowerer) terminate(t Terminator, next *Block) {
+    l.cur.Term = t
+    if next == nil {
+        next = l.newBlock()
+    }
+    l.cur = next
+}
+
+func (l *lowerer) pos() Pos { return Pos{Line: l.line} }
+
+func (l *lowerer) temp(t Type) *Local {
+    l.temps++
+    local := &Local{Name: strconv.Itoa(l.temps), Typ: t, Temp: true}
+    l.fn.Locals = append(l.fn.Locals, local)
+    return local
+}
+
+// declare creates the local for a source variable in the innermost scope.
+func (l *lowerer) declare(name string, t Type) *Local {
+    unique := name
+    if l.lookup(name) != nil || l.taken(name) {
+        for i := 2; ; i++ {
+            unique = fmt.Sprintf("%s_%d", name, i)
+            if !l.names[unique] {
+                break
+            }
+        }
+        l.names[unique] = true
+    }
+    local := &Local{Name: unique, Typ: t}
+    l.fn.Locals = append(l.fn.Locals, local)
+    l.scopes[len(l.scopes)-1][name] = local
+    return local
+}
+
+// taken reports whether a local named name was already created.
+func (l *lowerer) taken(name string) bool {
+    for _, local := range l.fn.Locals {
+        if !local.Temp && local.Name == name {
+            return true
+        }
+    }
+    return false
+}
+
+func (l *lowerer) lookup(name string) *Local {
+    for i := len(l.scopes) - 1; i >= 0; i-- {
+        if local, ok := l.scopes[i][name]; ok {
+            return local
+        }
+    }
+    return nil
+}
+
+func (l *lowerer) block(b *parser.BlockStatement) error {
+    l.scopes = append(l.scopes, map[string]*Local{})
+    defer func() { l.scopes = l.scopes[:len(l.scopes)-1] }()
+    for _, s := range b.Statements {
+        if line := parser.LineOf(s); line != 0 {
+            l.line = line
+        }
+        if err := l.statement(s); err != nil {
+            return err
+        }
+    }
+    return nil
+}
+
+func (l *lowerer) statement(s parser.Statement) error {
+    switch st := s.(type) {
+    case *parser.ReturnStatement:
+        v, err := l.expr(st.Value, l.fn.Result)
+        if err != nil {
+            return err
+        }
+        l.terminate(&Return{Pos: l.pos(), Value: v}, nil)
+    case *parser.VarStatement:
+        t, err := ResolveType(st.Type)
+        if err != nil {
+            return err
+        }
+        v, err := l.expr(st.Value, t)
+        if err != nil {
+            return err
+        }
+        l.emit(&Copy{Pos: l.pos(), Dst: l.declare(st.Name, t), Src: v})
+    case *parser.ExpressionStatement:
+        if call, ok := st.Expr.(*parser.CallExpression); ok {
+            if isPanic(call) {
+                if len(call.Args) != 1 {
+                    return fmt.Errorf("panic expects 1 argument")
+                }
+                msg, err := l.expr(call.Args[0], String)
+                if err != nil {
+                    return err
+                }
+                l.terminate(&Panic{Pos: l.pos(), Msg: msg}, nil)
+                return nil
+            }
+            _, err := l.call(call, "", false)
+            return err
+        }
+        _, err := l.expr(st.Expr, "")
+        return err
+    case *parser.IfStatement:
+        cond, err := l.expr(st.Condition, Bool)
+        if err != nil {
+            return err
+        }
+        then, join := l.newBlock(), l.newBlock()
+        els := join
+        if st.Alternative != nil {
+            els = l.newBlock()
+        }
+        l.terminate(&Branch{Pos: l.pos(), Cond: cond, Then: then, Else: els}, then)
+        if err := l.branch(st.Consequent, join); err != nil {
+            return err
+        }
+        if st.Alternative != nil {
+            l.cur = els
+            if err := l.branch(st.Alternative, join); err != nil {
+                return err
+            }
+        }
+        l.cur = join
+    case *parser.IfLetStatement:
+        return l.ifLet(st)
+    case *parser.WhileStatement:
+        head := l.newBlock()
+        l.terminate(&Jump{Pos: l.pos(), Target: head}, head)
+        cond, err := l.expr(st.Condition, Bool)
+        if err != nil {
+            return err
+        }
+        body, exit := l.newBlock(), l.newBlock()
+        l.terminate(&Branch{Pos: l.pos(), Cond: cond, Then: body, Else: exit}, body)
+        line := l.line
+        if err := l.block(st.Body); err != nil {
+            return err
+        }
+        l.line = line
+        l.terminate(&Jump{Pos: l.pos(), Target: head}, exit)
+    case *parser.DeferStatement:
+        call, ok := st.Call.(*parser.CallExpression)
+        if !ok {
+            return fmt.Errorf("defer requires a function call")
+        }
+        // the result is discarded, so a helper's "missing" sentinel needs
+        // no translation
+        c, _, err := l.callInstr(call)
+        if err != nil {
+            return err
+        }
+        c.Missing = ""
+        l.emit(&Defer{Pos: l.pos(), Call: c})
+    case *parser.AssertStatement:
+        return l.assert(st)
+    default:
+        return fmt.Errorf("cannot lower statement %T", s)
+    }
+    return nil
+}
+
+// branch lowers one arm of an if statement, which continues in join.
+func (l *lowerer) branch(b *parser.BlockStatement, join *Block) error {
+    line := l.line
+    if err := l.block(b); err != nil {
+        return err
+    }
+    l.line = line
+    l.terminate(&Jump{Pos: l.pos(), Target: join}, join)
+    return nil
+}
+
+// ifLet lowers `if let name = value { } else { }` to a none test; the then
+// arm binds name to the unwrapped value.
+func (l *lowerer) ifLet(st *parser.IfLetStatement) error {
+    v, err := l.expr(st.Value, "")
+    if err != nil {
+        return err
+    }
+    if !v.Type().IsOptional() {
+        return fmt.Errorf("if let %s: value of type %s is not optional", st.Name, v.Type())
+    }
+    none := l.temp(Bool)
+    l.emit(&IsNone{Pos: l.pos(), Dst: none, X: v})
+    then, join := l.newBlock(), l.newBlock()
+    els := join
+    if st.Alternative != nil {
+        els = l.newBlock()
+    }
+    l.terminate(&Branch{Pos: l.pos(), Cond: none, Then: els, Else: then}, then)
+    l.scopes = append(l.scopes, map[string]*Local{})
+    l.emit(&Unwrap{Pos: l.pos(), Dst: l.declare(st.Name, v.Type().Elem()), X: v})
+    err = l.branch(st.Consequent, join)
+    l.scopes = l.scopes[:len(l.scopes)-1]
+    if err != nil {
+        return err
+    }
+    if st.Alternative != nil {
+        l.cur = els
+        if err := l.branch(st.Alternative, join); err != nil {
+            return err
+        }
+    }
+    l.cur = join
+    return nil
+}
+
+// assert lowers `assert(cond, msg)` to a branch to a block that panics.
+func (l *lowerer) assert(st *parser.AssertStatement) error {
+    cond, err := l.expr(st.Condition, Bool)
+    if err != nil {
+        return err
+    }
+    ok, fail := l.newBlock(), l.newBlock()
+    l.terminate(&Branch{Pos: l.pos(), Cond: cond, Then: ok, Else: fail}, fail)
+    var msg Value = &Const{Typ: String, Str: "assertion failed"}
+    if st.Message != nil {
+        m, err := l.expr(st.Message, String)
+        if err != nil {
+            return err
+        }
+        joined := l.temp(String)
+        l.emit(&Concat{Pos: l.pos(), Dst: joined, Parts: []Value{&Const{Typ: String, Str: "assertion failed: "}, m}})
+        msg = joined
+    }
+    l.terminate(&Panic{Pos: l.pos(), Msg: msg}, ok)
+    return nil
+}
+
+// expr lowers e and returns the value holding its result. want is the type
+// the context expects, or "" if it imposes none; only constants use it.
+func (l *lowerer) expr(e parser.Expression, want Type) (Value, error) {
+    if v, ok, err := checker.ConstValue(e); err != nil {
+        return nil, err
+    } else if ok {
+        t := Int
+        if want.Elem().IsInteger() {
+            t = want.Elem()
+        }
+        return &Const{Typ: t, Int: v}, nil
+    }
+    switch ex := e.(type) {
+    case *parser.StringLiteral:
+        return &Const{Typ: String, Str: ex.Value}, nil
+    case *parser.BooleanLiteral:
+        return &Const{Typ: Bool, Bool: ex.Value}, nil
+    case *parser.NoneLiteral:
+        if !want.IsOptional() {
+            return nil, fmt.Errorf("none used where %s is expected", want)
+        }
+        return &Const{Typ: want}, nil
+    case *parser.Identifier:
+        if local := l.lookup(ex.Value); local != nil {
+            return local, nil
+        }
+        return nil, fmt.Errorf("undefined: %s", ex.Value)
+    case *parser.InterpolatedString:
+        return l.interpolation(ex)
+    case *parser.InfixExpression:
+        return l.infix(ex, want)
+    case *parser.PrefixExpression:
+        x, err := l.expr(ex.Right, want)
+        if err != nil {
+            return nil, err
+        }
+        dst := l.temp(x.Type())
+        l.emit(&UnOp{Pos: l.pos(), Dst: dst, Op: ex.Operator, X: x})
+        return dst, nil
+    case *parser.CoalesceExpression:
+        return l.coalesce(ex)
+    case *parser.CallExpression:
+        return l.call(ex, want, true)
+    }
+    return nil, fmt.Errorf("cannot lower expression %T", e)
+}
+
+// isComparison reports whether op yields a bool from two operands.
+func isComparison(op string) bool {
+    switch op {
+    case "==", "!=", "<", ">", "<=", ">=":
+        return true
+    }
+    return false
+}
+
+// infix lowers a binary operation. A constant operand adopts the type of the
+// other operand; it has no effects, so evaluating the other operand first
+// does not change the program's behaviour.
+func (l *lowerer) infix(ex *parser.InfixExpression, want Type) (Value, error) {
+    var x, y Value
+    var err error
+    shift := ex.Operator == "<<" || ex.Operator == ">>"
+    if _, leftConst, _ := checker.ConstValue(ex.Left); leftConst {
+        if y, err = l.expr(ex.Right, ""); err != nil {
+            return nil, err
+        }
+        leftWant := y.Type()
+        if shift {
+            // the shifted constant takes the type of the result
+            leftWant = want
+        }
+        if x, err = l.expr(ex.Left, leftWant); err != nil {
+            return nil, err
+        }
+    } else {
+        if x, err = l.expr(ex.Left, ""); err != nil {
+            return nil, err
+        }
+        rightWant := x.Type()
+        if shift {
+            rightWant = ""
+        }
+        if y, err = l.expr(ex.Right, rightWant); err != nil {
+            return nil, err
+        }
+    }
+    t := x.Type()
+    if isComparison(ex.Operator) {
+        t = Bool
+    }
+    dst := l.temp(t)
+    l.emit(&BinOp{Pos: l.pos(), Dst: dst, Op: ex.Operator, X: x, Y: y})
+    return dst, nil
+}
+
+// interpolation lowers an interpolated string to the concatenation of its
+// parts, formatting the parts that are not strings.
+func (l *lowerer) interpolation(s *parser.InterpolatedString) (Value, error) {
+    parts := make([]Value, 0, len(s.Parts))
+    for _, part := range s.Parts {
+        v, err := l.expr(part, "")
+        if err != nil {
+            return nil, err
+        }
+        switch {
+        case v.Type() == String:
+        case v.Type() == Bool || v.Type().IsInteger():
+            str := l.temp(String)
+            l.emit(&ToString{Pos: l.pos(), Dst: str, X: v})
+            v = str
+        default:
+            return nil, fmt.Errorf("cannot interpolate value of type %s", v.Type())
+        }
+        parts = append(parts, v)
+    }
+    if len(parts) == 0 {
+        return &Const{Typ: String}, nil
+    }
+    dst := l.temp(String)
+    l.emit(&Concat{Pos: l.pos(), Dst: dst, Parts: parts})
+    return dst, nil
+}
+
+// coalesce lowers `left ?? right` so that right is only evaluated when left
+// is none.
+func (l *lowerer) coalesce(ex *parser.CoalesceExpression) (Value, error) {
+    left, err := l.expr(ex.Left, "")
+    if err != nil {
+        return nil, err
+    }
+    t, err := ResolveType(ex.Type)
+    if err != nil {
+        return nil, err
+    }
+    dst := l.temp(t)
+    none := l.temp(Bool)
+    l.emit(&IsNone{Pos: l.pos(), Dst: none, X: left})
+    useRight, useLeft, join := l.newBlock(), l.newBlock(), l.newBlock()
+    l.terminate(&Branch{Pos: l.pos(), Cond: none, Then: useRight, Else: useLeft}, useLeft)
+    if t.IsOptional() {
+        l.emit(&Copy{Pos: l.pos(), Dst: dst, Src: left})
+    } else {
+        l.emit(&Unwrap{Pos: l.pos(), Dst: dst, X: left})
+    }
+    l.terminate(&Jump{Pos: l.pos(), Target: join}, useRight)
+    right, err := l.expr(ex.Right, t)
+    if err != nil {
+        return nil, err
+    }
+    l.emit(&Copy{Pos: l.pos(), Dst: dst, Src: right})
+    l.terminate(&Jump{Pos: l.pos(), Target: join}, join)
+    return dst, nil
+}
+
+func isPanic(call *parser.CallExpression) bool {
+    id, ok := call.Function.(*parser.Identifier)
+    return ok && id.Value == "panic"
+}
+
+// call lowers a call expression. Conversions become Convert or Some
+// instructions; other calls a Call whose result goes to a new temporary when
+// result is set.
+func (l *lowerer) call(c *parser.CallExpression, want Type, result bool) (Value, error) {
+    id, ok := c.Function.(*parser.Identifier)
+    if !ok {
+        return nil, fmt.Errorf("cannot lower call of %T", c.Function)
+    }
+    if isPanic(c) {
+        return nil, fmt.Errorf("panic does not produce a value")
+    }
+    if t, ok, err := OptionalConversion(c); err != nil {
+        return nil, err
+    } else if ok {
+        x, err := l.expr(c.Args[0], t.Elem())
+        if err != nil {
+            return nil, err
+        }
+        dst := l.temp(t)
+        l.emit(&Some{Pos: l.pos(), Dst: dst, X: x})
+        return dst, nil
+    }
+    if checker.IsConversion(c) {
+        t, err := ResolveType(id.Value)
+        if err != nil {
+            return nil, err
+        }
+        if len(c.Args) != 1 {
+            return nil, fmt.Errorf("conversion to %s takes one argument", t)
+        }
+        x, err := l.expr(c.Args[0], t)
+        if err != nil {
+            return nil, err
+        }
+        if k, ok := x.(*Const); ok {
+            return &Const{Typ: t, Int: k.Int}, nil
+        }
+        dst := l.temp(t)
+        l.emit(&Convert{Pos: l.pos(), Dst: dst, X: x})
+        return dst, nil
+    }
+    in, t, err := l.callInstr(c)
+    if err != nil {
+        return nil, err
+    }
+    if result {
+        in.Dst = l.temp(t)
+    }
+    l.emit(in)
+    return in.Dst, nil
+}
+
+// callInstr lowers the arguments of a call to a function or runtime helper
+// and returns the call, not yet emitted and without a destination, along
+// with its result type. Helpers the checker has no signature for return int.
+func (l *lowerer) callInstr(c *parser.CallExpression) (*Call, Type, error) {
+    name := c.Function.(*parser.Identifier).Value
+    if name == "print" {
+        name = "Print"
+    }
+    in := &Call{Func: name}
+    t := Int
+    var params []Type
+    if fn, ok := l.funcs[name]; ok {
+        var err error
+        if t, err = ResolveType(fn.ReturnType); err != nil {
+            return nil, "", err
+        }
+    } else if b, ok := checker.LookupBuiltin(name); ok {
+        for _, p := range b.Params {
+            params = append(params, Type(p))
+        }
+        t = Type(b.Result)
+        in.Missing = b.Missing
+    }
+    for i, a := range c.Args {
+        var want Type
+        if i < len(params) {
+            want = params[i]
+        }
+        v, err := l.expr(a, want)
+        if err != nil {
+            return nil, "", err
+        }
+        in.Args = append(in.Args, v)
+    }
+    in.Pos = l.pos()
+    return in, t, nil
+}
+
+type nodeFunc func(parser.Node)
+
+func (f nodeFunc) VisitNode(n parser.Node) { f(n) }
@@ -0,0 +1,312 @@
+package ir
+
+import "fmt"
+
+// Pass is one optimization over the IR of a function. Passes run in order
+// when the optimization level is at least Level; Run returns a description
+// of each change it made.
+type Pass struct {
+    Name  string
+    Level int
+    Run   func(fn *Func) []string
+}
+
+// Passes is the IR optimization pipeline, run after the AST passes.
+var Passes = []Pass{
+    {Name: "fold-branches", Level: 1, Run: foldBranches},
+    {Name: "thread-jumps", Level: 1, Run: threadJumps},
+    {Name: "merge-blocks", Level: 1, Run: mergeBlocks},
+    {Name: "coalesce-temps", Level: 1, Run: coalesceTemps},
+}
+
+// Optimize runs the passes enabled at level over every function of p and
+// reports what they changed.
+func Optimize(p *Program, level int) []string {
+    var changes []string
+    for _, pass := range Passes {
+        if level < pass.Level {
+            continue
+        }
+        for _, fn := range p.Funcs {
+            for _, c := range pass.Run(fn) {
+                changes = append(changes, fmt.Sprintf("%s: %s: %s", pass.Name, fn.Name, c))
+            }
+        }
+    }
+    return changes
+}
+
+// reachableBlocks returns the blocks control can reach from the entry block.
+func reachableBlocks(fn *Func) map[*Block]bool {
+    seen := map[*Block]bool{}
+    var visit func(b *Block)
+    visit = func(b *Block) {
+        if seen[b] {
+            return
+        }
+        seen[b] = true
+        if b.Term != nil {
+            for _, s := range b.Term.Succs() {
+                visit(s)
+            }
+        }
+    }
+    visit(fn.Blocks[0])
+    return seen
+}
+
+// removeBlocks drops the blocks not in keep and renumbers the rest, and
+// returns how many were dropped.
+func removeBlocks(fn *Func, keep map[*Block]bool) int {
+    kept := fn.Blocks[:0]
+    for _, b := range fn.Blocks {
+        if keep[b] {
+            b.Index = len(kept)
+            kept = append(kept, b)
+        }
+    }
+    removed := len(fn.Blocks) - len(kept)
+    fn.Blocks = kept
+    return removed
+}
+
+// removeUnreachable drops blocks the other passes disconnected.
+func removeUnreachable(fn *Func) []string {
+    if n := removeBlocks(fn, reachableBlocks(fn)); n > 0 {
+        return []string{fmt.Sprintf("removed %d unreachable blocks", n)}
+    }
+    return nil
+}
+
+// foldBranches turns branches on a constant condition into jumps.
+func foldBranches(fn *Func) []string {
+    var changes []string
+    for _, b := range fn.Blocks {
+        br, ok := b.Term.(*Branch)
+        if !ok {
+            continue
+        }
+        k, ok := br.Cond.(*Const)
+        if !ok {
+            continue
+        }
+        target := br.Else
+        if k.Bool {
+            target = br.Then
+        }
+        b.Term = &Jump{Pos: br.Pos, Target: target}
+        changes = append(changes, fmt.Sprintf("%s always continues in %s", b, target))
+    }
+    return append(changes, removeUnreachable(fn)...)
+}
+
+// threadJumps retargets jumps and branches to empty blocks that only jump on.
+func threadJumps(fn *Func) []string {
+    forward := func(b *Block) *Block {
+        // bounded so that an empty infinite loop terminates the search
+        for i := 0; i < len(fn.Blocks); i++ {
+            j, ok := b.Term.(*Jump)
+            if !ok || len(b.Instrs) > 0 || j.Target == b {
+                break
+            }
+            b = j.Target
+        }
+        return b
+    }
+    threaded := 0
+    for _, b := range fn.Blocks {
+        switch t := b.Term.(type) {
+        case *Jump:
+            if to := forward(t.Target); to != t.Target {
+                t.Target = to
+                threaded++
+            }
+        case *Branch:
+            if to := forward(t.Then); to != t.Then {
+                t.Then = to
+                threaded++
+            }
+            if to := forward(t.Else); to != t.Else {
+                t.Else = to
+                threaded++
+            }
+        }
+    }
+    if threaded == 0 {
+        return nil
+    }
+    return append([]string{fmt.Sprintf("threaded %d jumps", threaded)}, removeUnreachable(fn)...)
+}
+
+// mergeBlocks appends a block to its only predecessor when that predecessor
+// jumps straight to it.
+func mergeBlocks(fn *Func) []string {
+    preds := map[*Block]int{}
+    for _, b := range fn.Blocks {
+        for _, s := range b.Term.Succs() {
+            preds[s]++
+        }
+    }
+    merged := 0
+    for _, b := range fn.Blocks {
+        for {
+            j, ok := b.Term.(*Jump)
+            if !ok || j.Target == b || j.Target == fn.Blocks[0] || preds[j.Target] != 1 {
+                break
+            }
+            next := j.Target
+            b.Instrs = append(b.Instrs, next.Instrs...)
+            b.Term = next.Term
+            // next is now unreachable; keep it from being merged again
+            next.Instrs, next.Term = nil, &Jump{Target: next}
+            preds[next] = 0
+            merged++
+        }
+    }
+    if merged == 0 {
+        return nil
+    }
+    removeUnreachable(fn)
+    return []string{fmt.Sprintf("merged %d blocks", merged)}
+}
+
+// coalesceTemps removes copies of a temporary into a variable right after
+// the instruction computing it, by computing into the variable directly.
+func coalesceTemps(fn *Func) []string {
+    uses := map[*Local]int{}
+    for _, b := range fn.Blocks {
+        for _, in := range b.Instrs {
+            for _, v := range operands(in) {
+                if l, ok := v.(*Local); ok {
+                    uses[l]++
+                }
+            }
+        }
+        for _, v := range termOperands(b.Term) {
+            if l, ok := v.(*Local); ok {
+                uses[l]++
+            }
+        }
+    }
+    removed := map[*Local]bool{}
+    for _, b := range fn.Blocks {
+        out := b.Instrs[:0]
+        for _, in := range b.Instrs {
+            cp, ok := in.(*Copy)
+            if ok && len(out) > 0 {
+                src, isLocal := cp.Src.(*Local)
+                if prev := dest(out[len(out)-1]); isLocal && src.Temp && prev == src && uses[src] == 1 && src.Typ == cp.Dst.Typ {
+                    setDest(out[len(out)-1], cp.Dst)
+                    removed[src] = true
+                    continue
+                }
+            }
+            out = append(out, in)
+        }
+        b.Instrs = out
+    }
+    if len(removed) == 0 {
+        return nil
+    }
+    locals := fn.Locals[:0]
+    for _, l := range fn.Locals {
+        if !removed[l] {
+            locals = append(locals, l)
+        }
+    }
+    fn.Locals = locals
+    return []string{fmt.Sprintf("removed %d temporaries", len(removed))}
+}
+
+// operands returns the values an instruction reads.
+func operands(in Instr) []Value {
+    switch i := in.(type) {
+    case *Copy:
+        return []Value{i.Src}
+    case *BinOp:
+        return []Value{i.X, i.Y}
+    case *UnOp:
+        return []Value{i.X}
+    case *Convert:
+        return []Value{i.X}
+    case *Some:
+        return []Value{i.X}
+    case *IsNone:
+        return []Value{i.X}
+    case *Unwrap:
+        return []Value{i.X}
+    case *ToString:
+        return []Value{i.X}
+    case *Concat:
+        return i.Parts
+    case *Call:
+        return i.Args
+    case *Defer:
+        return i.Call.Args
+    }
+    return nil
+}
+
+func termOperands(t Terminator) []Value {
+    switch i := t.(type) {
+    case *Branch:
+        return []Value{i.Cond}
+    case *Return:
+        return []Value{i.Value}
+    case *Panic:
+        return []Value{i.Msg}
+    }
+    return nil
+}
+
+// dest returns the local an instruction writes, or nil.
+func dest(in Instr) *Local {
+    switch i := in.(type) {
+    case *Copy:
+        return i.Dst
+    case *BinOp:
+        return i.Dst
+    case *UnOp:
+        return i.Dst
+    case *Convert:
+        return i.Dst
+    case *Some:
+        return i.Dst
+    case *IsNone:
+        return i.Dst
+    case *Unwrap:
+        return i.Dst
+    case *ToString:
+        return i.Dst
+    case *Concat:
+        return i.Dst
+    case *Call:
+        return i.Dst
+    }
+    return nil
+}
+
+func setDest(in Instr, l *Local) {
+    switch i := in.(type) {
+    case *Copy:
+        i.Dst = l
+    case *BinOp:
+        i.Dst = l
+    case *UnOp:
+        i.Dst = l
+    case *Convert:
+        i.Dst = l
+    case *Some:
+        i.Dst = l
+    case *IsNone:
+        i.Dst = l
+    case *Unwrap:
+        i.Dst = l
+    case *ToString:
+        i.Dst = l
+    case *Concat:
+        i.Dst = l
+    case *Call:
+        i.Dst = l
+    }
+}
@@ -1,5 +1,21 @@
 package lexer
 
+// Comment is a `//` comment, which Tokenize skips. Text includes the
+// slashes. Trailing marks a comment that follows a token on its line, and
+// Token is the index of the token after the comment.
+type Comment struct {
+    Line     int
+    Text     string
+    Trailing bool
+    Token    int
+}
+
+// Comments returns the comments Tokenize skipped, in source order, for
+// tools that print source back.
+func (l *Lexer) Comments() []Comment {
+    return l.comments
+}
+
 // ConsumeBlockComment consumes a C-style block comment from input starting
 // at the given position. It returns the index after the closing '*/' or the
 // original pos if no block comment was found. This helper is intentionally
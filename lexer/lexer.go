@@ -1,7 +1,9 @@
 package lexer
 
 import (
+    "strings"
     "unicode"
+    "unicode/utf8"
 )
 
 type Lexer struct {
@@ -9,15 +11,24 @@ type Lexer struct {
     position     int
     readPosition int
     ch           rune
+    line         int
+    comments     []Comment
+    // trivia keeps the source text of tokens and the whitespace and
+    // comments around them; spans holds the offsets of each token's text
+    trivia bool
+    spans  [][2]int
 }
 
 func New(input string) *Lexer {
-    l := &Lexer{input: input}
+    l := &Lexer{input: input, line: 1}
     l.readChar()
     return l
 }
 
 func (l *Lexer) readChar() {
+    if l.ch == '\n' {
+        l.line++
+    }
     if l.readPosition >= len(l.input) {
         l.ch = 0
     } else {
@@ -36,9 +47,17 @@ func (l *Lexer) peekChar() rune {
 
 func (l *Lexer) Tokenize() []Token {
     var tokens []Token
+    start := 0
     for {
+        // every token ends where the loop comes back after appending it
+        if l.trivia && len(tokens) > len(l.spans) {
+            l.spans = append(l.spans, [2]int{start, min(l.position, len(l.input))})
+        }
         l.skipWhitespace()
+        // an unterminated string reads one past the end
+        start = min(l.position, len(l.input))
         var tok Token
+        line := l.line
         switch l.ch {
         case '=':
             if l.peekChar() == '=' {
@@ -72,21 +91,52 @@ func (l *Lexer) Tokenize() []Token {
         case '/':
             if l.peekChar() == '/' {
                 // line comment
+                start := l.position
+                trailing := len(tokens) > 0 && tokens[len(tokens)-1].Line == line
                 l.readChar()
                 l.readChar()
                 for l.ch != '\n' && l.ch != 0 {
                     l.readChar()
                 }
+                text := strings.TrimRight(l.input[start:l.position], " \t\r")
+                l.comments = append(l.comments, Comment{Line: line, Text: text, Trailing: trailing, Token: len(tokens)})
                 l.readChar()
                 continue
             }
             tok = Token{Type: SLASH, Lit: string(l.ch)}
         case '*':
             tok = Token{Type: ASTERISK, Lit: string(l.ch)}
+        case '?':
+            if l.peekChar() == '?' {
+                l.readChar()
+                tok = Token{Type: COALESCE, Lit: "??"}
+            } else {
+                tok = Token{Type: QUESTION, Lit: string(l.ch)}
+            }
+        case '%':
+            tok = Token{Type: PERCENT, Lit: string(l.ch)}
+        case '&':
+            tok = Token{Type: AMP, Lit: string(l.ch)}
+        case '|':
+            tok = Token{Type: PIPE, Lit: string(l.ch)}
+        case '^':
+            tok = Token{Type: CARET, Lit: string(l.ch)}
+        case '~':
+            tok = Token{Type: TILDE, Lit: string(l.ch)}
         case '<':
-            tok = Token{Type: LT, Lit: string(l.ch)}
+            if l.peekChar() == '<' {
+                l.readChar()
+                tok = Token{Type: SHL, Lit: "<<"}
+            } else {
+                tok = Token{Type: LT, Lit: string(l.ch)}
+            }
         case '>':
-            tok = Token{Type: GT, Lit: string(l.ch)}
+            if l.peekChar() == '>' {
+                l.readChar()
+                tok = Token{Type: SHR, Lit: ">>"}
+            } else {
+                tok = Token{Type: GT, Lit: string(l.ch)}
+            }
         case ';':
             tok = Token{Type: SEMICOLON, Lit: string(l.ch)}
         case ',':
@@ -108,30 +158,40 @@ func (l *Lexer) Tokenize() []Token {
         case '.':
             tok = Token{Type: DOT, Lit: string(l.ch)}
         case '"':
-            tok.Type = STRING
-            tok.Lit = l.readString()
+            tok = l.readString()
         case 0:
             tok.Lit = ""
             tok.Type = EOF
+            tok.Line = line
             tokens = append(tokens, tok)
+            if l.trivia {
+                l.spans = append(l.spans, [2]int{start, start})
+                l.attachTrivia(tokens)
+            }
             return tokens
         default:
             if isLetter(l.ch) {
                 lit := l.readIdentifier()
                 tok.Type = LookupIdent(lit)
                 tok.Lit = lit
+                tok.Line = line
                 tokens = append(tokens, tok)
                 continue
             } else if isDigit(l.ch) {
                 lit := l.readNumber()
                 tok.Type = INT
+                if _, ok := IntValue(lit); !ok {
+                    tok.Type = ILLEGAL
+                }
                 tok.Lit = lit
+                tok.Line = line
                 tokens = append(tokens, tok)
                 continue
             } else {
                 tok = Token{Type: ILLEGAL, Lit: string(l.ch)}
             }
         }
+        tok.Line = line
         tokens = append(tokens, tok)
         l.readChar()
     }
@@ -151,24 +211,104 @@ func (l *Lexer) readIdentifier() string {
     return l.input[pos:l.position]
 }
 
+// readNumber scans an integer literal including any base prefix, digit
+// separators and trailing letters, so malformed literals such as `0b102` or
+// `12ab` surface as a single token for IntValue to reject.
 func (l *Lexer) readNumber() string {
     pos := l.position
-    for isDigit(l.ch) {
+    for isDigit(l.ch) || isLetter(l.ch) {
         l.readChar()
     }
     return l.input[pos:l.position]
 }
 
-func (l *Lexer) readString() string {
-    // assume starting quote was consumed
+// readString scans a double-quoted literal starting at the opening quote,
+// decoding escape sequences and splitting out `${expr}` segments. Plain
+// literals become STRING tokens; literals with at least one embedded
+// expression become INTERP tokens whose Parts carry the text segments and the
+// tokens of each expression.
+func (l *Lexer) readString() Token {
     l.readChar()
-    pos := l.position
+    start := l.position
+    var parts []StringPart
+    var sb strings.Builder
     for l.ch != '"' && l.ch != 0 {
+        switch {
+        case l.ch == '\\':
+            l.readChar()
+            if l.ch == 0 {
+                continue
+            }
+            // the lexer reads bytes; escape the whole character, and keep
+            // a byte that is not UTF-8 as it is
+            ch, size := utf8.DecodeRuneInString(l.input[l.position:])
+            if ch == utf8.RuneError && size == 1 {
+                sb.WriteByte('\\')
+                sb.WriteByte(byte(l.ch))
+                break
+            }
+            for ; size > 1; size-- {
+                l.readChar()
+            }
+            sb.WriteString(unescape(ch))
+        case l.ch == '$' && l.peekChar() == '{':
+            parts = append(parts, StringPart{Text: sb.String()})
+            sb.Reset()
+            l.readChar()
+            l.readChar()
+            line := l.line
+            src, ok := l.readInterpolation()
+            if !ok {
+                return Token{Type: ILLEGAL, Lit: "unterminated ${ in string literal"}
+            }
+            sub := New(src)
+            sub.line = line
+            parts = append(parts, StringPart{Tokens: sub.Tokenize(), IsExpr: true})
+        default:
+            // copy bytes verbatim so multi-byte UTF-8 sequences survive
+            sb.WriteByte(byte(l.ch))
+        }
         l.readChar()
     }
-    s := l.input[pos:l.position]
     // closing quote will be consumed by caller loop's readChar
-    return s
+    if parts == nil {
+        return Token{Type: STRING, Lit: sb.String(), Raw: l.input[start:l.position]}
+    }
+    parts = append(parts, StringPart{Text: sb.String()})
+    return Token{Type: INTERP, Lit: l.input[start:l.position], Parts: parts}
+}
+
+// readInterpolation returns the source of an embedded `${...}` expression.
+// It expects the opening `${` to be consumed and leaves l.ch on the closing
+// brace. Nested braces and string literals inside the expression are skipped
+// so `${f("}")}` works as expected.
+func (l *Lexer) readInterpolation() (string, bool) {
+    pos := l.position
+    depth := 0
+    for l.ch != 0 {
+        switch l.ch {
+        case '{':
+            depth++
+        case '}':
+            if depth == 0 {
+                return l.input[pos:l.position], true
+            }
+            depth--
+        case '"':
+            l.readChar()
+            for l.ch != '"' && l.ch != 0 {
+                if l.ch == '\\' {
+                    l.readChar()
+                }
+                l.readChar()
+            }
+            if l.ch == 0 {
+                return "", false
+            }
+        }
+        l.readChar()
+    }
+    return "", false
 }
 
 func isLetter(ch rune) bool {
@@ -178,3 +318,22 @@ func isLetter(ch rune) bool {
 func isDigit(ch rune) bool {
     return '0' <= ch && ch <= '9'
 }
+
+// unescape decodes the character following a backslash in a string literal.
+// Unknown escapes are kept verbatim.
+func unescape(ch rune) string {
+    switch ch {
+    case 'n':
+        return "\n"
+    case 't':
+        return "\t"
+    case 'r':
+        return "\r"
+    case '0':
+        return "\x00"
+    case '\\', '"', '$':
+        return string(ch)
+    default:
+        return "\\" + string(ch)
+    }
+}
@@ -1,6 +1,10 @@
 package lexer
 
-import "unicode"
+import (
+    "math/big"
+    "strings"
+    "unicode"
+)
 
 // readNumber scans a numeric literal (supports integers and simple floats)
 // starting at pos in input and returns the literal and new position.
@@ -22,3 +26,61 @@ func readNumber(input string, pos int) (string, int) {
     }
     return input[pos:i], i
 }
+
+// IntValue returns the value of an integer literal. Literals are decimal
+// unless prefixed with 0x (hex), 0o (octal) or 0b (binary); a single
+// underscore may separate digits, and may follow a base prefix. A leading
+// zero does not make a literal octal. The result is false when lit is not a
+// well-formed literal.
+func IntValue(lit string) (*big.Int, bool) {
+    neg := strings.HasPrefix(lit, "-")
+    if neg {
+        lit = lit[1:]
+    }
+    base := 10
+    digits := lit
+    if len(lit) > 1 && lit[0] == '0' {
+        switch lit[1] {
+        case 'x', 'X':
+            base = 16
+        case 'o', 'O':
+            base = 8
+        case 'b', 'B':
+            base = 2
+        }
+        if base != 10 {
+            digits = lit[2:]
+        }
+    }
+    if !validUnderscores(digits, base != 10) {
+        return nil, false
+    }
+    v, ok := new(big.Int).SetString(strings.ReplaceAll(digits, "_", ""), base)
+    if !ok {
+        return nil, false
+    }
+    if neg {
+        v.Neg(v)
+    }
+    return v, true
+}
+
+// validUnderscores reports whether every underscore in digits sits between
+// two digits (or directly after a base prefix when prefixed is set).
+func validUnderscores(digits string, prefixed bool) bool {
+    if digits == "" {
+        return false
+    }
+    prevUnderscore := !prefixed
+    for i := 0; i < len(digits); i++ {
+        if digits[i] == '_' {
+            if prevUnderscore {
+                return false
+            }
+            prevUnderscore = true
+            continue
+        }
+        prevUnderscore = false
+    }
+    return !prevUnderscore
+}
@@ -7,6 +7,29 @@ type TokenType string
 type Token struct {
     Type TokenType
     Lit  string
+    // Line is the 1-based source line the token starts on.
+    Line int
+    // Parts holds the segments of an INTERP token, in source order.
+    Parts []StringPart
+    // Raw is the source text of a STRING token between its quotes, before
+    // escape sequences are decoded. The Lit of an INTERP token is already
+    // its source text.
+    Raw string
+    // Text is the token as written, and Leading and Trailing the
+    // whitespace and comments before and after it; set only by a lexer
+    // made with NewWithTrivia. Trailing runs to the end of the token's
+    // line, and Leading holds the rest, including line breaks.
+    Text     string
+    Leading  string
+    Trailing string
+}
+
+// StringPart is one segment of an interpolated string literal: either plain
+// text or the tokens of an embedded `${expr}` (terminated by EOF).
+type StringPart struct {
+    Text   string
+    Tokens []Token
+    IsExpr bool
 }
 
 const (
@@ -14,8 +37,9 @@ const (
     EOF     TokenType = "EOF"
 
     IDENT  TokenType = "IDENT"  // add, foobar, x, y, ...
-    INT    TokenType = "INT"    // 1343456
+    INT    TokenType = "INT"    // 1343456, 0xFF, 0o17, 0b1010, 1_000_000
     STRING TokenType = "STRING" // "foobar"
+    INTERP TokenType = "INTERP" // "hello ${name}"
 
     ASSIGN   TokenType = "="
     PLUS     TokenType = "+"
@@ -23,6 +47,13 @@ const (
     BANG     TokenType = "!"
     ASTERISK TokenType = "*"
     SLASH    TokenType = "/"
+    PERCENT  TokenType = "%"
+    AMP      TokenType = "&"
+    PIPE     TokenType = "|"
+    CARET    TokenType = "^"
+    TILDE    TokenType = "~"
+    SHL      TokenType = "<<"
+    SHR      TokenType = ">>"
     LT       TokenType = "<"
     GT       TokenType = ">"
     EQ       TokenType = "=="
@@ -40,6 +71,8 @@ const (
     DOT      TokenType = "."
     COLON    TokenType = ":"
     ARROW    TokenType = "->"
+    QUESTION TokenType = "?"
+    COALESCE TokenType = "??"
 
     FUNCTION TokenType = "FUNCTION"
     VAR      TokenType = "VAR"
@@ -50,6 +83,11 @@ const (
     TRUE     TokenType = "TRUE"
     FALSE    TokenType = "FALSE"
     IMPORT   TokenType = "IMPORT"
+    NONE     TokenType = "NONE"
+    LET      TokenType = "LET"
+    DEFER    TokenType = "DEFER"
+    ASSERT   TokenType = "ASSERT"
+    EXPORT   TokenType = "EXPORT"
 )
 
 var keywords = map[string]TokenType{
@@ -62,6 +100,11 @@ var keywords = map[string]TokenType{
     "true":   TRUE,
     "false":  FALSE,
     "import": IMPORT,
+    "none":   NONE,
+    "let":    LET,
+    "defer":  DEFER,
+    "assert": ASSERT,
+    "export": EXPORT,
 }
 
 // LookupIdent checks if an identifier is a reserved keyword
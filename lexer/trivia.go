@@ -0,0 +1,96 @@
+package lexer
+
+import "strings"
+
+// NewWithTrivia returns a lexer whose tokens keep their source text in
+// Text and the whitespace and comments around them in Leading and
+// Trailing, so that Source prints the input back byte for byte. Tools that
+// rewrite source use it; compilation does not need it.
+func NewWithTrivia(input string) *Lexer {
+    l := New(input)
+    l.trivia = true
+    return l
+}
+
+// attachTrivia splits the text between tokens: up to the end of its line
+// it trails the token before, and the rest leads the token after. The EOF
+// token leads with whatever follows the last token.
+func (l *Lexer) attachTrivia(tokens []Token) {
+    prev := 0
+    for i := range tokens {
+        span := l.spans[i]
+        gap := l.input[prev:span[0]]
+        if i > 0 {
+            end := strings.IndexByte(gap, '\n')
+            if end < 0 {
+                end = len(gap)
+            }
+            tokens[i-1].Trailing = gap[:end]
+            gap = gap[end:]
+        }
+        tokens[i].Leading = gap
+        tokens[i].Text = l.input[span[0]:span[1]]
+        prev = span[1]
+    }
+}
+
+// Source prints tokens lexed with trivia back as the text they came from.
+func Source(tokens []Token) string {
+    var sb strings.Builder
+    for _, t := range tokens {
+        sb.WriteString(t.Leading)
+        sb.WriteString(t.Text)
+        sb.WriteString(t.Trailing)
+    }
+    return sb.String()
+}
+
+// LeadingComments returns the comments on the lines before t, slashes
+// included.
+func (t Token) LeadingComments() []string {
+    return triviaComments(t.Leading)
+}
+
+// DocComment returns the `///` comments on the lines directly above t,
+// without their slashes, or nil. A blank line or a plain comment ends a
+// doc comment.
+func (t Token) DocComment() []string {
+    lines := strings.Split(t.Leading, "\n")
+    // the last line is the indentation before t
+    i := len(lines) - 1
+    for i > 0 && strings.HasPrefix(strings.TrimSpace(lines[i-1]), "///") {
+        i--
+    }
+    var doc []string
+    for _, line := range lines[i : len(lines)-1] {
+        doc = append(doc, DocText(line))
+    }
+    return doc
+}
+
+// DocText returns the text of a `///` comment line, keeping any
+// indentation after the space that follows the slashes.
+func DocText(line string) string {
+    text := strings.TrimPrefix(strings.TrimSpace(line), "///")
+    return strings.TrimPrefix(text, " ")
+}
+
+// TrailingComment returns the comment after t on its line, or "".
+func (t Token) TrailingComment() string {
+    if c := triviaComments(t.Trailing); len(c) > 0 {
+        return c[0]
+    }
+    return ""
+}
+
+// triviaComments returns the comments in trivia, which holds only
+// whitespace and comments.
+func triviaComments(trivia string) []string {
+    var out []string
+    for _, line := range strings.Split(trivia, "\n") {
+        if c := strings.TrimSpace(line); c != "" {
+            out = append(out, c)
+        }
+    }
+    return out
+}
@@ -0,0 +1,227 @@
+package lsp
+
+import (
+    "net/url"
+    "path/filepath"
+    "strings"
+    "unicode/utf16"
+    "unicode/utf8"
+
+    "codeberg.org/clockwise-lang/clockwise/lexer"
+    "codeberg.org/clockwise-lang/clockwise/parser"
+)
+
+// document is a source file open in the editor. Each change re-lexes and
+// re-parses only this document; the syntax tree of the last version that
+// parsed is kept for navigation while the text is being edited.
+type document struct {
+    uri     string
+    path    string
+    version int
+    text    string
+    lines   []string
+
+    // tokens and prog are from the last version that parsed, and ends
+    // holds the line of the closing brace of each of prog's functions
+    tokens []lexer.Token
+    prog   *parser.Program
+    ends   map[*parser.Function]int
+
+    // err is the parse error of the current text, found on errLine
+    err     error
+    errLine int
+}
+
+func newDocument(uri string, version int, text string) *document {
+    d := &document{uri: uri, path: uriPath(uri), version: version}
+    d.setText(text)
+    return d
+}
+
+// setText replaces the text of d and parses it.
+func (d *document) setText(text string) {
+    d.text = text
+    d.lines = strings.Split(text, "\n")
+    tokens := lexer.New(text).Tokenize()
+    p := parser.New(tokens)
+    prog, err := p.ParseProgram()
+    if err != nil {
+        d.err, d.errLine = err, p.Line()
+        return
+    }
+    d.err = nil
+    d.tokens, d.prog = tokens, prog
+    for _, fn := range prog.Functions {
+        fn.File = d.path
+    }
+    d.ends = functionEnds(tokens, prog)
+}
+
+// functionEnds matches the functions of prog, in order, to the lines of
+// their closing braces.
+func functionEnds(tokens []lexer.Token, prog *parser.Program) map[*parser.Function]int {
+    ends := map[*parser.Function]int{}
+    i, depth := 0, 0
+    for _, tok := range tokens {
+        switch tok.Type {
+        case lexer.LBRACE:
+            depth++
+        case lexer.RBRACE:
+            depth--
+            if depth == 0 && i < len(prog.Functions) {
+                ends[prog.Functions[i]] = tok.Line
+                i++
+            }
+        }
+    }
+    return ends
+}
+
+// edit replaces the text in r with text.
+func (d *document) edit(r span, text string) {
+    start, end := d.offset(r.Start), d.offset(r.End)
+    if end < start {
+        end = start
+    }
+    d.setText(d.text[:start] + text + d.text[end:])
+}
+
+// offset converts a position to a byte offset in the text.
+func (d *document) offset(p position) int {
+    off := 0
+    for i := 0; i < p.Line && i < len(d.lines); i++ {
+        off += len(d.lines[i]) + 1
+    }
+    if p.Line >= len(d.lines) {
+        return len(d.text)
+    }
+    return off + byteColumn(d.lines[p.Line], p.Character)
+}
+
+// line returns 1-based source line n, or "" past the end.
+func (d *document) line(n int) string {
+    if n < 1 || n > len(d.lines) {
+        return ""
+    }
+    return strings.TrimSuffix(d.lines[n-1], "\r")
+}
+
+// lineSpan covers the text of 1-based source line n, without its
+// indentation.
+func (d *document) lineSpan(n int) span {
+    text := d.line(n)
+    trimmed := strings.TrimLeft(text, " \t")
+    return span{
+        Start: position{Line: n - 1, Character: utf16Column(text, len(text)-len(trimmed))},
+        End:   position{Line: n - 1, Character: utf16Column(text, len(text))},
+    }
+}
+
+// wordAt returns the identifier at p and its span, and the part of it
+// before p.
+func (d *document) wordAt(p position) (word, prefix string, r span) {
+    if p.Line >= len(d.lines) {
+        return "", "", span{}
+    }
+    text := d.line(p.Line + 1)
+    at := byteColumn(text, p.Character)
+    start, end := at, at
+    for start > 0 && isIdent(text[start-1]) {
+        start--
+    }
+    for end < len(text) && isIdent(text[end]) {
+        end++
+    }
+    r = span{
+        Start: position{Line: p.Line, Character: utf16Column(text, start)},
+        End:   position{Line: p.Line, Character: utf16Column(text, end)},
+    }
+    return text[start:end], text[start:at], r
+}
+
+// nameSpan finds name as a whole word on 1-based line n, for placing a
+// declaration; it covers the start of the line if name is not there.
+func (d *document) nameSpan(n int, name string) span {
+    text := d.line(n)
+    for i := 0; ; {
+        j := strings.Index(text[i:], name)
+        if j < 0 {
+            break
+        }
+        start, end := i+j, i+j+len(name)
+        if (start == 0 || !isIdent(text[start-1])) && (end == len(text) || !isIdent(text[end])) {
+            return span{
+                Start: position{Line: n - 1, Character: utf16Column(text, start)},
+                End:   position{Line: n - 1, Character: utf16Column(text, end)},
+            }
+        }
+        i = start + 1
+    }
+    return span{Start: position{Line: n - 1}, End: position{Line: n - 1}}
+}
+
+// function returns the function of the last parse whose body contains
+// 1-based line n.
+func (d *document) function(n int) *parser.Function {
+    if d.prog == nil {
+        return nil
+    }
+    for _, fn := range d.prog.Functions {
+        if fn.Line <= n && n <= d.ends[fn] {
+            return fn
+        }
+    }
+    return nil
+}
+
+func isIdent(c byte) bool {
+    return c == '_' || 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || '0' <= c && c <= '9'
+}
+
+// utf16Column converts a byte offset in line to a count of UTF-16 code
+// units, the unit of LSP characters.
+func utf16Column(line string, off int) int {
+    n := 0
+    for _, r := range line[:off] {
+        n += utf16.RuneLen(r)
+    }
+    return n
+}
+
+// byteColumn converts a count of UTF-16 code units in line to a byte
+// offset, clamped to the line.
+func byteColumn(line string, col int) int {
+    off := 0
+    for col > 0 && off < len(line) {
+        r, size := utf8.DecodeRuneInString(line[off:])
+        col -= utf16.RuneLen(r)
+        off += size
+    }
+    return off
+}
+
+// uriPath converts a file:// URI to a path; other URIs are used as is.
+func uriPath(uri string) string {
+    u, err := url.Parse(uri)
+    if err != nil || u.Scheme != "file" {
+        return uri
+    }
+    path := u.Path
+    // file:///C:/dir names a Windows drive
+    if len(path) >= 3 && path[0] == '/' && path[2] == ':' {
+        path = path[1:]
+    }
+    return filepath.FromSlash(path)
+}
+
+// pathURI converts a path to a file:// URI.
+func pathURI(path string) string {
+    if abs, err := filepath.Abs(path); err == nil {
+        path = abs
+    }
+    path = filepath.ToSlash(path)
+    if !strings.HasPrefix(path, "/") {
+        path = "/" + path
+    }
+    return (&url.URL{Scheme: "file", Path: path}).String()
+}
@@ -0,0 +1,75 @@
+package lsp
+
+import (
+    "bytes"
+    "go/ast"
+    "go/parser"
+    "go/printer"
+    "go/token"
+    "os"
+    "path/filepath"
+    "sort"
+    "strings"
+)
+
+// helper documents a runtime helper from its Go source.
+type helper struct {
+    // decl is the Go declaration, such as `func Trim(s string) string`
+    decl   string
+    doc    string
+    params []string
+    lib    string
+    file   string
+    line   int
+}
+
+// loadHelpers reads the exported functions of the helper libraries under
+// dir, the runtime directory. Where two libraries declare a helper of the
+// same name, the first in directory order is kept, as in a build.
+func loadHelpers(dir string) map[string]*helper {
+    helpers := map[string]*helper{}
+    if dir == "" {
+        return helpers
+    }
+    entries, err := os.ReadDir(dir)
+    if err != nil {
+        return helpers
+    }
+    fset := token.NewFileSet()
+    for _, e := range entries {
+        if !e.IsDir() {
+            continue
+        }
+        files, _ := filepath.Glob(filepath.Join(dir, e.Name(), "*.go"))
+        sort.Strings(files)
+        for _, path := range files {
+            if strings.HasSuffix(path, "_test.go") {
+                continue
+            }
+            f, err := parser.ParseFile(fset, path, nil, parser.ParseComments)
+            if err != nil || f.Name.Name == "main" {
+                continue
+            }
+            for _, d := range f.Decls {
+                fd, ok := d.(*ast.FuncDecl)
+                if !ok || fd.Recv != nil || !fd.Name.IsExported() || helpers[fd.Name.Name] != nil {
+                    continue
+                }
+                h := &helper{lib: e.Name(), file: path, line: fset.Position(fd.Pos()).Line}
+                if fd.Doc != nil {
+                    h.doc = strings.TrimSpace(fd.Doc.Text())
+                }
+                for _, field := range fd.Type.Params.List {
+                    for _, name := range field.Names {
+                        h.params = append(h.params, name.Name)
+                    }
+                }
+                var buf bytes.Buffer
+                printer.Fprint(&buf, fset, &ast.FuncDecl{Name: fd.Name, Type: fd.Type})
+                h.decl = buf.String()
+                helpers[fd.Name.Name] = h
+            }
+        }
+    }
+    return helpers
+}
@@ -0,0 +1,170 @@
+package lsp
+
+import (
+    "bufio"
+    "encoding/json"
+    "fmt"
+    "io"
+    "net/textproto"
+    "strconv"
+)
+
+// message is a JSON-RPC 2.0 request, response or notification. Requests
+// and responses carry an ID; notifications do not.
+type message struct {
+    JSONRPC string          `json:"jsonrpc"`
+    ID      json.RawMessage `json:"id,omitempty"`
+    Method  string          `json:"method,omitempty"`
+    Params  json.RawMessage `json:"params,omitempty"`
+}
+
+// rpcError is the error of a failed request.
+type rpcError struct {
+    Code    int    `json:"code"`
+    Message string `json:"message"`
+}
+
+func (e *rpcError) Error() string { return e.Message }
+
+// JSON-RPC and LSP error codes.
+const (
+    codeParseError     = -32700
+    codeInvalidParams  = -32602
+    codeMethodNotFound = -32601
+    codeInvalidRequest = -32600
+)
+
+// readMessage reads one message framed by a Content-Length header.
+func readMessage(r *bufio.Reader) (*message, error) {
+    header, err := textproto.NewReader(r).ReadMIMEHeader()
+    if err != nil {
+        return nil, err
+    }
+    n, err := strconv.Atoi(header.Get("Content-Length"))
+    if err != nil {
+        return nil, fmt.Errorf("invalid Content-Length %q", header.Get("Content-Length"))
+    }
+    body := make([]byte, n)
+    if _, err := io.ReadFull(r, body); err != nil {
+        return nil, err
+    }
+    var m message
+    if err := json.Unmarshal(body, &m); err != nil {
+        return nil, &rpcError{Code: codeParseError, Message: err.Error()}
+    }
+    return &m, nil
+}
+
+// writeMessage frames and writes one message. result or err is set for a
+// response, method and params for a notification.
+func writeMessage(w io.Writer, v map[string]interface{}) error {
+    v["jsonrpc"] = "2.0"
+    body, err := json.Marshal(v)
+    if err != nil {
+        return err
+    }
+    _, err = fmt.Fprintf(w, "Content-Length: %d\r\n\r\n%s", len(body), body)
+    return err
+}
+
+// The subset of the protocol's types the server uses. Lines and characters
+// are 0-based; characters count UTF-16 code units.
+
+type position struct {
+    Line      int `json:"line"`
+    Character int `json:"character"`
+}
+
+type span struct {
+    Start position `json:"start"`
+    End   position `json:"end"`
+}
+
+type location struct {
+    URI   string `json:"uri"`
+    Range span   `json:"range"`
+}
+
+type textDocumentItem struct {
+    URI     string `json:"uri"`
+    Version int    `json:"version"`
+    Text    string `json:"text"`
+}
+
+type textDocumentIdentifier struct {
+    URI string `json:"uri"`
+}
+
+type textDocumentPositionParams struct {
+    TextDocument textDocumentIdentifier `json:"textDocument"`
+    Position     position               `json:"position"`
+}
+
+type didOpenParams struct {
+    TextDocument textDocumentItem `json:"textDocument"`
+}
+
+type didChangeParams struct {
+    TextDocument textDocumentItem `json:"textDocument"`
+    // ContentChanges replace Range, or the whole text when Range is nil.
+    ContentChanges []struct {
+        Range *span  `json:"range"`
+        Text  string `json:"text"`
+    } `json:"contentChanges"`
+}
+
+type documentParams struct {
+    TextDocument textDocumentIdentifier `json:"textDocument"`
+}
+
+// Diagnostic severities.
+const (
+    severityError   = 1
+    severityWarning = 2
+)
+
+type diagnostic struct {
+    Range    span   `json:"range"`
+    Severity int    `json:"severity"`
+    Source   string `json:"source"`
+    Message  string `json:"message"`
+}
+
+type markupContent struct {
+    Kind  string `json:"kind"`
+    Value string `json:"value"`
+}
+
+type hover struct {
+    Contents markupContent `json:"contents"`
+    Range    *span         `json:"range,omitempty"`
+}
+
+// Completion item and symbol kinds.
+const (
+    completionFunction = 3
+    completionVariable = 6
+    symbolFunction     = 12
+    symbolVariable     = 13
+)
+
+type completionItem struct {
+    Label         string         `json:"label"`
+    Kind          int            `json:"kind"`
+    Detail        string         `json:"detail,omitempty"`
+    Documentation *markupContent `json:"documentation,omitempty"`
+}
+
+type documentSymbol struct {
+    Name           string           `json:"name"`
+    Detail         string           `json:"detail,omitempty"`
+    Kind           int              `json:"kind"`
+    Range          span             `json:"range"`
+    SelectionRange span             `json:"selectionRange"`
+    Children       []documentSymbol `json:"children,omitempty"`
+}
+
+type textEdit struct {
+    Range   span   `json:"range"`
+    NewText string `json:"newText"`
+}
@@ -0,0 +1,624 @@
+// Package lsp implements `cwc lsp`, a language server for Clockwise over
+// stdio. It reports diagnostics from the parser and checker, shows types
+// and runtime helper documentation on hover, finds definitions across
+// imported files, completes names, lists document symbols and formats
+// documents.
+package lsp
+
+import (
+    "bufio"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "io"
+    "os"
+    "path/filepath"
+    "sort"
+    "strings"
+    "time"
+
+    "codeberg.org/clockwise-lang/clockwise/checker"
+    "codeberg.org/clockwise-lang/clockwise/cmd/cw/formatter"
+    "codeberg.org/clockwise-lang/clockwise/parser"
+    "codeberg.org/clockwise-lang/clockwise/parser/transform"
+)
+
+// Server is a language server reading requests from one connection.
+type Server struct {
+    in  *bufio.Reader
+    out io.Writer
+    // docs holds the documents open in the editor by URI; files caches
+    // imported files that are not, by path
+    docs     map[string]*document
+    files    map[string]*diskFile
+    helpers  map[string]*helper
+    shutdown bool
+}
+
+// diskFile is an imported file read from disk, reread when it changes.
+type diskFile struct {
+    mod time.Time
+    doc *document
+}
+
+// New returns a server that reads from in and writes to out. runtimeDir
+// is the runtime helper directory that hover and completion document
+// helpers from; without it they only show signatures.
+func New(in io.Reader, out io.Writer, runtimeDir string) *Server {
+    return &Server{
+        in:      bufio.NewReader(in),
+        out:     out,
+        docs:    map[string]*document{},
+        files:   map[string]*diskFile{},
+        helpers: loadHelpers(runtimeDir),
+    }
+}
+
+// Run serves requests until the client sends exit. Exiting without a
+// shutdown request first, or losing the connection, is an error.
+func (s *Server) Run() error {
+    for {
+        m, err := readMessage(s.in)
+        if re, ok := err.(*rpcError); ok {
+            s.respond(nil, nil, re)
+            continue
+        } else if err == io.EOF {
+            return fmt.Errorf("connection closed without exit")
+        } else if err != nil {
+            return err
+        }
+        if m.Method == "exit" {
+            if !s.shutdown {
+                return fmt.Errorf("exit without shutdown")
+            }
+            return nil
+        }
+        result, err := s.handle(m)
+        if m.ID == nil {
+            continue
+        }
+        if err != nil {
+            re, ok := err.(*rpcError)
+            if !ok {
+                re = &rpcError{Code: codeInvalidRequest, Message: err.Error()}
+            }
+            s.respond(m.ID, nil, re)
+        } else {
+            s.respond(m.ID, result, nil)
+        }
+    }
+}
+
+func (s *Server) respond(id json.RawMessage, result interface{}, err *rpcError) {
+    msg := map[string]interface{}{"id": id}
+    if err != nil {
+        msg["error"] = err
+    } else {
+        msg["result"] = result
+    }
+    writeMessage(s.out, msg)
+}
+
+func (s *Server) notify(method string, params interface{}) {
+    writeMessage(s.out, map[string]interface{}{"method": method, "params": params})
+}
+
+// decode unmarshals the params of m into v.
+func decode(m *message, v interface{}) error {
+    if err := json.Unmarshal(m.Params, v); err != nil {
+        return &rpcError{Code: codeInvalidParams, Message: err.Error()}
+    }
+    return nil
+}
+
+func (s *Server) handle(m *message) (interface{}, error) {
+    switch m.Method {
+    case "initialize":
+        return map[string]interface{}{
+            "capabilities": map[string]interface{}{
+                // 2: changes arrive as edits to ranges of the text
+                "textDocumentSync":           map[string]interface{}{"openClose": true, "change": 2},
+                "hoverProvider":              true,
+                "definitionProvider":         true,
+                "completionProvider":         map[string]interface{}{},
+                "documentSymbolProvider":     true,
+                "documentFormattingProvider": true,
+            },
+            "serverInfo": map[string]string{"name": "cwc lsp"},
+        }, nil
+    case "shutdown":
+        s.shutdown = true
+        return nil, nil
+    case "textDocument/didOpen":
+        var p didOpenParams
+        if err := decode(m, &p); err != nil {
+            return nil, err
+        }
+        d := newDocument(p.TextDocument.URI, p.TextDocument.Version, p.TextDocument.Text)
+        s.docs[d.uri] = d
+        s.changed(d)
+    case "textDocument/didChange":
+        var p didChangeParams
+        if err := decode(m, &p); err != nil {
+            return nil, err
+        }
+        d := s.docs[p.TextDocument.URI]
+        if d == nil {
+            return nil, nil
+        }
+        for _, c := range p.ContentChanges {
+            if c.Range == nil {
+                d.setText(c.Text)
+            } else {
+                d.edit(*c.Range, c.Text)
+            }
+        }
+        d.version = p.TextDocument.Version
+        s.changed(d)
+    case "textDocument/didClose":
+        var p documentParams
+        if err := decode(m, &p); err != nil {
+            return nil, err
+        }
+        if d := s.docs[p.TextDocument.URI]; d != nil {
+            delete(s.docs, d.uri)
+            s.notify("textDocument/publishDiagnostics", map[string]interface{}{"uri": d.uri, "diagnostics": []diagnostic{}})
+            s.changed(d)
+        }
+    case "textDocument/hover":
+        return s.positionRequest(m, s.hover)
+    case "textDocument/definition":
+        return s.positionRequest(m, s.definition)
+    case "textDocument/completion":
+        return s.positionRequest(m, s.completion)
+    case "textDocument/documentSymbol":
+        var p documentParams
+        if err := decode(m, &p); err != nil {
+            return nil, err
+        }
+        if d := s.docs[p.TextDocument.URI]; d != nil {
+            return symbols(d), nil
+        }
+    case "textDocument/formatting":
+        var p documentParams
+        if err := decode(m, &p); err != nil {
+            return nil, err
+        }
+        if d := s.docs[p.TextDocument.URI]; d != nil {
+            return formatting(d), nil
+        }
+    default:
+        if m.ID != nil && m.Method != "initialized" {
+            return nil, &rpcError{Code: codeMethodNotFound, Message: "method not supported: " + m.Method}
+        }
+    }
+    return nil, nil
+}
+
+// positionRequest decodes the params of a request about a position in an
+// open document and answers it with f.
+func (s *Server) positionRequest(m *message, f func(*document, position) interface{}) (interface{}, error) {
+    var p textDocumentPositionParams
+    if err := decode(m, &p); err != nil {
+        return nil, err
+    }
+    d := s.docs[p.TextDocument.URI]
+    if d == nil {
+        return nil, nil
+    }
+    return f(d, p.Position), nil
+}
+
+// changed publishes the diagnostics of d, now edited, opened or closed,
+// and of the other open documents, which may import it. Only d was
+// re-parsed; the others are checked again from their last parse.
+func (s *Server) changed(d *document) {
+    uris := []string{}
+    for uri := range s.docs {
+        uris = append(uris, uri)
+    }
+    sort.Strings(uris)
+    for _, uri := range uris {
+        o := s.docs[uri]
+        if o != d && !s.imports(o, d.path) {
+            continue
+        }
+        s.notify("textDocument/publishDiagnostics", map[string]interface{}{
+            "uri":         o.uri,
+            "version":     o.version,
+            "diagnostics": s.diagnostics(o),
+        })
+    }
+}
+
+// file returns the source of path: the open document, or the file on disk.
+func (s *Server) file(path string) (*document, error) {
+    for _, d := range s.docs {
+        if d.path == path {
+            return d, nil
+        }
+    }
+    info, err := os.Stat(path)
+    if err != nil {
+        return nil, err
+    }
+    if f := s.files[path]; f != nil && f.mod.Equal(info.ModTime()) {
+        return f.doc, nil
+    }
+    src, err := os.ReadFile(path)
+    if err != nil {
+        return nil, err
+    }
+    d := newDocument(pathURI(path), 0, string(src))
+    d.path = path
+    s.files[path] = &diskFile{mod: info.ModTime(), doc: d}
+    return d, nil
+}
+
+// program combines the last parse of d with the files it imports, directly
+// or not. The syntax trees are parsed afresh from the tokens, since the
+// checker rewrites them. An import that cannot be loaded is left out and
+// its error returned under the import of d that leads to it.
+func (s *Server) program(d *document) (*parser.Program, map[string]error) {
+    prog := &parser.Program{}
+    errs := map[string]error{}
+    if d.prog == nil {
+        return prog, errs
+    }
+    seen := map[string]bool{d.path: true}
+    s.add(prog, d)
+    for _, imp := range d.prog.Imports {
+        if err := s.addImport(prog, seen, filepath.Dir(d.path), imp); err != nil {
+            errs[imp] = err
+        }
+    }
+    return prog, errs
+}
+
+// add appends fresh syntax trees of the functions of d to prog.
+func (s *Server) add(prog *parser.Program, d *document) {
+    p, _ := parser.New(d.tokens).ParseProgram()
+    for _, fn := range p.Functions {
+        fn.File = d.path
+    }
+    prog.Functions = append(prog.Functions, p.Functions...)
+}
+
+func (s *Server) addImport(prog *parser.Program, seen map[string]bool, dir, imp string) error {
+    path := imp
+    if !filepath.IsAbs(path) {
+        path = filepath.Join(dir, imp)
+    }
+    if seen[path] {
+        return nil
+    }
+    seen[path] = true
+    d, err := s.file(path)
+    if err != nil {
+        return err
+    }
+    if d.err != nil {
+        return fmt.Errorf("parse error in %s: %w", imp, d.err)
+    }
+    s.add(prog, d)
+    for _, next := range d.prog.Imports {
+        if err := s.addImport(prog, seen, filepath.Dir(path), next); err != nil {
+            return err
+        }
+    }
+    return nil
+}
+
+// imports reports whether d imports path, directly or not.
+func (s *Server) imports(d *document, path string) bool {
+    prog, _ := s.program(d)
+    for _, fn := range prog.Functions {
+        if fn.File == path {
+            return true
+        }
+    }
+    return false
+}
+
+// diagnostics returns the errors in d: the parse error, or the imports
+// that fail to load, duplicate functions and checker errors. Without
+// errors it returns the warnings of the optimization passes, as a build
+// would print them.
+func (s *Server) diagnostics(d *document) []diagnostic {
+    diags := []diagnostic{}
+    report := func(line, severity int, msg string) {
+        diags = append(diags, diagnostic{Range: d.lineSpan(line), Severity: severity, Source: "cwc", Message: msg})
+    }
+    if d.err != nil {
+        report(d.errLine, severityError, d.err.Error())
+        return diags
+    }
+    prog, errs := s.program(d)
+    for _, imp := range d.prog.Imports {
+        if err, ok := errs[imp]; ok {
+            report(importLine(d, imp), severityError, fmt.Sprintf("cannot import %s: %v", imp, err))
+        }
+    }
+    declared := map[string]string{}
+    for _, fn := range prog.Functions {
+        if file, ok := declared[fn.Name]; ok && fn.File == d.path {
+            report(fn.Line, severityError, fmt.Sprintf("duplicate function name '%s' found in multiple files", fn.Name))
+            if file == d.path {
+                diags[len(diags)-1].Message = fmt.Sprintf("%s redeclared in this file", fn.Name)
+            }
+        } else if !ok {
+            declared[fn.Name] = fn.File
+        }
+    }
+    failed := checker.CheckFunctions(prog)
+    for _, fn := range prog.Functions {
+        if err, ok := failed[fn]; ok && fn.File == d.path {
+            report(errorLine(fn, err), severityError, err.Error())
+        }
+    }
+    if len(diags) > 0 {
+        return diags
+    }
+    for _, w := range transform.Optimize(prog, 1).Warnings {
+        if w.File == d.path {
+            report(w.Line, severityWarning, w.Msg)
+        }
+    }
+    return diags
+}
+
+// errorLine returns the line of the statement of fn a checker error was
+// found at, or that of fn.
+func errorLine(fn *parser.Function, err error) int {
+    var le *checker.LineError
+    if errors.As(err, &le) && le.Line > 0 {
+        return le.Line
+    }
+    return fn.Line
+}
+
+// importLine returns the line of d that imports imp.
+func importLine(d *document, imp string) int {
+    quoted := `"` + imp + `"`
+    for i := range d.lines {
+        if text := d.line(i + 1); strings.Contains(text, "import") && strings.Contains(text, quoted) {
+            return i + 1
+        }
+    }
+    return 1
+}
+
+// lookupFunction finds a function of prog by name.
+func lookupFunction(prog *parser.Program, name string) *parser.Function {
+    for _, fn := range prog.Functions {
+        if fn.Name == name {
+            return fn
+        }
+    }
+    return nil
+}
+
+// scope returns the variables visible on 1-based line n of d.
+func (s *Server) scope(d *document, prog *parser.Program, n int) map[string]checker.Type {
+    fn := d.function(n)
+    if fn == nil {
+        return nil
+    }
+    for _, f := range prog.Functions {
+        if f.Name == fn.Name && f.File == d.path {
+            return checker.ScopeAt(prog, f, n)
+        }
+    }
+    return nil
+}
+
+func (s *Server) hover(d *document, p position) interface{} {
+    word, _, r := d.wordAt(p)
+    if word == "" {
+        return nil
+    }
+    prog, _ := s.program(d)
+    var text string
+    if t, ok := s.scope(d, prog, p.Line+1)[word]; ok {
+        text = "```clockwise\nvar " + word + ": " + string(t) + "\n```"
+    } else if fn := lookupFunction(prog, word); fn != nil {
+        text = "```clockwise\n" + signature(fn) + "\n```"
+        if fn.File != d.path {
+            text += "\n\nDeclared in `" + filepath.Base(fn.File) + "`."
+        }
+    } else if s.helperSignature(word) != "" {
+        text = s.helperDoc(word)
+    } else {
+        return nil
+    }
+    return hover{Contents: markupContent{Kind: "markdown", Value: text}, Range: &r}
+}
+
+func signature(fn *parser.Function) string {
+    sig := "fn " + fn.Name + "() -> " + fn.ReturnType
+    if fn.Export {
+        sig = "export " + sig
+    }
+    return sig
+}
+
+// helperSignature writes the Clockwise signature of a runtime helper, with
+// parameter names from its Go source. A helper the checker does not know
+// is shown with its Go declaration.
+func (s *Server) helperSignature(name string) string {
+    h := s.helpers[name]
+    b, ok := checker.LookupBuiltin(name)
+    if !ok {
+        if h == nil {
+            return ""
+        }
+        return h.decl
+    }
+    params := make([]string, len(b.Params))
+    for i, t := range b.Params {
+        params[i] = string(t)
+        if h != nil && i < len(h.params) {
+            params[i] = h.params[i] + ": " + params[i]
+        }
+    }
+    return fmt.Sprintf("fn %s(%s) -> %s", name, strings.Join(params, ", "), b.Result)
+}
+
+// helperDoc documents a runtime helper in markdown.
+func (s *Server) helperDoc(name string) string {
+    lang := "clockwise"
+    if _, ok := checker.LookupBuiltin(name); !ok {
+        lang = "go"
+    }
+    text := "```" + lang + "\n" + s.helperSignature(name) + "\n```"
+    h := s.helpers[name]
+    if h != nil && h.doc != "" {
+        text += "\n\n" + h.doc
+    }
+    if h != nil {
+        text += "\n\nRuntime helper from `" + h.lib + "`."
+    }
+    if lang == "go" {
+        text += " Its arguments are not checked and calls are assumed to return int."
+    }
+    return text
+}
+
+func (s *Server) definition(d *document, p position) interface{} {
+    word, _, _ := d.wordAt(p)
+    if word == "" {
+        return nil
+    }
+    n := p.Line + 1
+    prog, _ := s.program(d)
+    if _, ok := s.scope(d, prog, n)[word]; ok {
+        // the nearest declaration above; shadowed ones come earlier
+        decl := 0
+        declarations(d.function(n).Body, func(name, _ string, line int) {
+            if name == word && line <= n {
+                decl = line
+            }
+        })
+        if decl > 0 {
+            return location{URI: d.uri, Range: d.nameSpan(decl, word)}
+        }
+    }
+    if fn := lookupFunction(prog, word); fn != nil {
+        src := d
+        if fn.File != d.path {
+            var err error
+            if src, err = s.file(fn.File); err != nil {
+                return nil
+            }
+        }
+        return location{URI: src.uri, Range: src.nameSpan(fn.Line, word)}
+    }
+    if h := s.helpers[word]; h != nil {
+        start := position{Line: h.line - 1}
+        return location{URI: pathURI(h.file), Range: span{Start: start, End: start}}
+    }
+    return nil
+}
+
+// declarations calls f with the name, declared type and line of each
+// variable declared in b, in source order. The type of an `if let`
+// binding is left empty, as it is only known from the checker.
+func declarations(b *parser.BlockStatement, f func(name, typ string, line int)) {
+    if b == nil {
+        return
+    }
+    for _, st := range b.Statements {
+        switch st := st.(type) {
+        case *parser.VarStatement:
+            f(st.Name, st.Type, st.Line)
+        case *parser.IfStatement:
+            declarations(st.Consequent, f)
+            declarations(st.Alternative, f)
+        case *parser.IfLetStatement:
+            f(st.Name, "", st.Line)
+            declarations(st.Consequent, f)
+            declarations(st.Alternative, f)
+        case *parser.WhileStatement:
+            declarations(st.Body, f)
+        }
+    }
+}
+
+func (s *Server) completion(d *document, p position) interface{} {
+    items := []completionItem{}
+    prog, _ := s.program(d)
+    scope := s.scope(d, prog, p.Line+1)
+    var names []string
+    for name := range scope {
+        names = append(names, name)
+    }
+    sort.Strings(names)
+    for _, name := range names {
+        items = append(items, completionItem{Label: name, Kind: completionVariable, Detail: string(scope[name])})
+    }
+    for _, fn := range prog.Functions {
+        items = append(items, completionItem{Label: fn.Name, Kind: completionFunction, Detail: signature(fn)})
+    }
+    seen := map[string]bool{}
+    names = checker.Builtins()
+    for name := range s.helpers {
+        names = append(names, name)
+    }
+    sort.Strings(names)
+    for _, name := range names {
+        if seen[name] || lookupFunction(prog, name) != nil {
+            continue
+        }
+        seen[name] = true
+        items = append(items, completionItem{
+            Label:         name,
+            Kind:          completionFunction,
+            Detail:        s.helperSignature(name),
+            Documentation: &markupContent{Kind: "markdown", Value: s.helperDoc(name)},
+        })
+    }
+    return items
+}
+
+// symbols lists the functions of d with the variables they declare.
+func symbols(d *document) []documentSymbol {
+    out := []documentSymbol{}
+    if d.prog == nil {
+        return out
+    }
+    for _, fn := range d.prog.Functions {
+        end := d.ends[fn]
+        sym := documentSymbol{
+            Name:   fn.Name,
+            Detail: "() -> " + fn.ReturnType,
+            Kind:   symbolFunction,
+            Range: span{
+                Start: position{Line: fn.Line - 1},
+                End:   position{Line: end - 1, Character: utf16Column(d.line(end), len(d.line(end)))},
+            },
+            SelectionRange: d.nameSpan(fn.Line, fn.Name),
+        }
+        declarations(fn.Body, func(name, typ string, line int) {
+            r := d.nameSpan(line, name)
+            sym.Children = append(sym.Children, documentSymbol{Name: name, Detail: typ, Kind: symbolVariable, Range: r, SelectionRange: r})
+        })
+        out = append(out, sym)
+    }
+    return out
+}
+
+// formatting returns the edit that formats d as `cwc fmt` does, or none
+// while d does not parse.
+func formatting(d *document) []textEdit {
+    formatted, err := formatter.Format([]byte(d.text))
+    if err != nil || string(formatted) == d.text {
+        return []textEdit{}
+    }
+    last := len(d.lines)
+    return []textEdit{{
+        Range: span{
+            End: position{Line: last - 1, Character: utf16Column(d.lines[last-1], len(d.lines[last-1]))},
+        },
+        NewText: string(formatted),
+    }}
+}
@@ -0,0 +1,218 @@
+package lsp
+
+import (
+    "bufio"
+    "bytes"
+    "encoding/json"
+    "io"
+    "net/textproto"
+    "os"
+    "path/filepath"
+    "strconv"
+    "strings"
+    "testing"
+)
+
+const mainSrc = `import "lib.cw";
+
+fn main() -> int {
+    var greeting: string = greet();
+    Print(greeting);
+    return 0;
+}
+`
+
+const libSrc = `fn greet() -> string {
+    return "hi";
+}
+`
+
+// reply is a message from the server: a response or a notification.
+type reply struct {
+    ID     *int
+    Method string
+    Params json.RawMessage
+    Result json.RawMessage
+    Error  *rpcError
+}
+
+// exchange sends the client messages to a server and returns what it
+// wrote: responses by request id and the notifications in order.
+func exchange(t *testing.T, msgs []map[string]interface{}) (map[int]*reply, []*reply) {
+    t.Helper()
+    var in bytes.Buffer
+    for _, m := range msgs {
+        if err := writeMessage(&in, m); err != nil {
+            t.Fatal(err)
+        }
+    }
+    var out bytes.Buffer
+    if err := New(&in, &out, filepath.Join("..", "runtime")).Run(); err != nil {
+        t.Fatalf("Run: %v", err)
+    }
+    responses := map[int]*reply{}
+    var notes []*reply
+    r := bufio.NewReader(&out)
+    for {
+        header, err := textproto.NewReader(r).ReadMIMEHeader()
+        if err == io.EOF {
+            break
+        } else if err != nil {
+            t.Fatal(err)
+        }
+        n, _ := strconv.Atoi(header.Get("Content-Length"))
+        body := make([]byte, n)
+        if _, err := io.ReadFull(r, body); err != nil {
+            t.Fatal(err)
+        }
+        m := &reply{}
+        if err := json.Unmarshal(body, m); err != nil {
+            t.Fatal(err)
+        }
+        if m.ID == nil {
+            notes = append(notes, m)
+        } else {
+            responses[*m.ID] = m
+        }
+    }
+    return responses, notes
+}
+
+func request(id int, method string, params interface{}) map[string]interface{} {
+    return map[string]interface{}{"id": id, "method": method, "params": params}
+}
+
+func notification(method string, params interface{}) map[string]interface{} {
+    return map[string]interface{}{"method": method, "params": params}
+}
+
+func at(uri string, line, char int) map[string]interface{} {
+    return map[string]interface{}{
+        "textDocument": map[string]string{"uri": uri},
+        "position":     position{Line: line, Character: char},
+    }
+}
+
+// decodeResult unmarshals the result of the response to request id into v.
+func decodeResult(t *testing.T, responses map[int]*reply, id int, v interface{}) {
+    t.Helper()
+    m := responses[id]
+    if m == nil {
+        t.Fatalf("no response to request %d", id)
+    }
+    if m.Error != nil {
+        t.Fatalf("request %d failed: %s", id, m.Error.Message)
+    }
+    if err := json.Unmarshal(m.Result, v); err != nil {
+        t.Fatalf("request %d: %v", id, err)
+    }
+}
+
+// TestSession scripts an editor opening a file that imports another from
+// disk, asking for hovers and definitions, then editing in an error.
+func TestSession(t *testing.T) {
+    dir := t.TempDir()
+    if err := os.WriteFile(filepath.Join(dir, "lib.cw"), []byte(libSrc), 0o644); err != nil {
+        t.Fatal(err)
+    }
+    mainURI := pathURI(filepath.Join(dir, "main.cw"))
+    responses, notes := exchange(t, []map[string]interface{}{
+        request(1, "initialize", map[string]interface{}{"rootUri": pathURI(dir)}),
+        notification("initialized", map[string]interface{}{}),
+        notification("textDocument/didOpen", map[string]interface{}{
+            "textDocument": map[string]interface{}{"uri": mainURI, "languageId": "clockwise", "version": 1, "text": mainSrc},
+        }),
+        // greeting in Print(greeting)
+        request(2, "textDocument/hover", at(mainURI, 4, 12)),
+        // greet in greet()
+        request(3, "textDocument/hover", at(mainURI, 3, 28)),
+        request(4, "textDocument/hover", at(mainURI, 4, 5)),
+        request(5, "textDocument/definition", at(mainURI, 3, 28)),
+        request(6, "textDocument/definition", at(mainURI, 4, 12)),
+        notification("textDocument/didChange", map[string]interface{}{
+            "textDocument": map[string]interface{}{"uri": mainURI, "version": 2},
+            "contentChanges": []map[string]interface{}{{
+                "range": span{Start: position{Line: 5, Character: 11}, End: position{Line: 5, Character: 12}},
+                "text":  `"zero"`,
+            }},
+        }),
+        request(7, "textDocument/unknown", at(mainURI, 0, 0)),
+        request(8, "shutdown", nil),
+        notification("exit", nil),
+    })
+
+    var init struct {
+        Capabilities map[string]interface{}
+    }
+    decodeResult(t, responses, 1, &init)
+    for _, c := range []string{"hoverProvider", "definitionProvider"} {
+        if init.Capabilities[c] != true {
+            t.Errorf("capability %s = %v, want true", c, init.Capabilities[c])
+        }
+    }
+
+    var h hover
+    decodeResult(t, responses, 2, &h)
+    if want := "```clockwise\nvar greeting: string\n```"; h.Contents.Value != want {
+        t.Errorf("hover on a variable = %q, want %q", h.Contents.Value, want)
+    }
+    if want := (span{Start: position{Line: 4, Character: 10}, End: position{Line: 4, Character: 18}}); h.Range == nil || *h.Range != want {
+        t.Errorf("hover range = %v, want %v", h.Range, want)
+    }
+    decodeResult(t, responses, 3, &h)
+    if want := "```clockwise\nfn greet() -> string\n```\n\nDeclared in `lib.cw`."; h.Contents.Value != want {
+        t.Errorf("hover on an imported function = %q, want %q", h.Contents.Value, want)
+    }
+    decodeResult(t, responses, 4, &h)
+    if !strings.HasPrefix(h.Contents.Value, "```clockwise\nfn Print(") || !strings.Contains(h.Contents.Value, "Runtime helper from") {
+        t.Errorf("hover on a runtime helper = %q", h.Contents.Value)
+    }
+
+    var loc location
+    decodeResult(t, responses, 5, &loc)
+    want := location{URI: pathURI(filepath.Join(dir, "lib.cw")), Range: span{Start: position{Line: 0, Character: 3}, End: position{Line: 0, Character: 8}}}
+    if loc != want {
+        t.Errorf("definition of an imported function = %+v, want %+v", loc, want)
+    }
+    decodeResult(t, responses, 6, &loc)
+    want = location{URI: mainURI, Range: span{Start: position{Line: 3, Character: 8}, End: position{Line: 3, Character: 16}}}
+    if loc != want {
+        t.Errorf("definition of a variable = %+v, want %+v", loc, want)
+    }
+
+    if m := responses[7]; m == nil || m.Error == nil || m.Error.Code != codeMethodNotFound {
+        t.Errorf("unknown method response = %+v, want a method not found error", m)
+    }
+    if m := responses[8]; m == nil || m.Error != nil {
+        t.Errorf("shutdown response = %+v", m)
+    }
+
+    var published []struct {
+        URI         string
+        Version     int
+        Diagnostics []diagnostic
+    }
+    for _, n := range notes {
+        if n.Method != "textDocument/publishDiagnostics" {
+            continue
+        }
+        var p struct {
+            URI         string
+            Version     int
+            Diagnostics []diagnostic
+        }
+        if err := json.Unmarshal(n.Params, &p); err != nil {
+            t.Fatal(err)
+        }
+        published = append(published, p)
+    }
+    if len(published) != 2 {
+        t.Fatalf("published diagnostics %d times, want on open and on change: %+v", len(published), published)
+    }
+    if len(published[0].Diagnostics) != 0 {
+        t.Errorf("diagnostics on open = %+v, want none", published[0].Diagnostics)
+    }
+    if d := published[1].Diagnostics; published[1].Version != 2 || len(d) != 1 || d[0].Severity != severityError || d[0].Range.Start.Line != 5 {
+        t.Errorf("diagnostics after returning a string from main = %+v, want an error on line 5", published[1])
+    }
+}
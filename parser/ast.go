@@ -5,6 +5,10 @@ package parser
 type Program struct {
     Functions []*Function
     Imports   []string
+    // Comments holds the comments of trivia tokens that belong to no
+    // function or statement, such as those around imports or inside an
+    // expression, in source order.
+    Comments []string
 }
 
 func (p *Program) Children() []Node {
@@ -21,9 +25,16 @@ type Node interface{
 }
 
 type Function struct {
+    Pos
     Name string
     ReturnType string
     Body *BlockStatement
+    // File is the source file the function was parsed from; set by the
+    // compiler driver since the parser only sees tokens.
+    File string
+    // Export marks a function declared `export fn`, which WebAssembly
+    // builds make callable from the host.
+    Export bool
 }
 
 func (f *Function) Children() []Node {
@@ -36,8 +47,45 @@ func (f *Function) Children() []Node {
 type Statement interface{}
 type Expression interface{}
 
+// Pos records the source line a function or statement starts on, and the
+// comments attached to it when it was parsed from trivia tokens.
+type Pos struct {
+    Line     int
+    Comments *Comments
+}
+
+// Comments are the comments around a node, slashes included: Leading on
+// the lines before it and Trailing at the end of its first line. Doc is
+// the text of the `///` comments directly above it.
+type Comments struct {
+    Leading  []string
+    Trailing string
+    Doc      []string
+}
+
+func (p *Pos) Position() *Pos { return p }
+
+// Positioned is implemented by nodes that embed Pos.
+type Positioned interface {
+    Position() *Pos
+}
+
+// LineOf returns the source line of n, or 0 if it has no position.
+func LineOf(n interface{}) int {
+    if p, ok := n.(Positioned); ok {
+        return p.Position().Line
+    }
+    return 0
+}
+
 type BlockStatement struct {
     Statements []Statement
+    // End is the line of the closing brace; 0 for the block the parser
+    // makes around the `if` of an `else if`.
+    End int
+    // Comments are those on the lines before the closing brace, after the
+    // last statement.
+    Comments []string
 }
 
 func (b *BlockStatement) Children() []Node {
@@ -51,6 +99,7 @@ func (b *BlockStatement) Children() []Node {
 }
 
 type ReturnStatement struct {
+    Pos
     Value Expression
 }
 
@@ -62,6 +111,7 @@ func (r *ReturnStatement) Children() []Node {
 }
 
 type ExpressionStatement struct {
+    Pos
     Expr Expression
 }
 
@@ -73,6 +123,7 @@ func (e *ExpressionStatement) Children() []Node {
 }
 
 type VarStatement struct {
+    Pos
     Name  string
     Type  string
     Value Expression
@@ -85,6 +136,37 @@ func (v *VarStatement) Children() []Node {
     return nil
 }
 
+// DeferStatement schedules Call to run when the enclosing function returns.
+type DeferStatement struct {
+    Pos
+    Call Expression
+}
+
+func (d *DeferStatement) Children() []Node {
+    if n, ok := d.Call.(Node); ok {
+        return []Node{n}
+    }
+    return nil
+}
+
+// AssertStatement panics with Message when Condition is false. Message may
+// be nil.
+type AssertStatement struct {
+    Pos
+    Condition Expression
+    Message   Expression
+}
+
+func (a *AssertStatement) Children() []Node {
+    out := []Node{}
+    for _, e := range []Expression{a.Condition, a.Message} {
+        if n, ok := e.(Node); ok {
+            out = append(out, n)
+        }
+    }
+    return out
+}
+
 type IntegerLiteral struct {
     Value string
 }
@@ -93,10 +175,44 @@ func (i *IntegerLiteral) Children() []Node { return nil }
 
 type StringLiteral struct {
     Value string
+    // Raw is the literal as written between the quotes, escapes included.
+    Raw string
 }
 
 func (s *StringLiteral) Children() []Node { return nil }
 
+// InterpolatedString is a string literal with embedded `${expr}` segments.
+// Parts alternate between *StringLiteral text and embedded expressions.
+// PartTypes is filled in by the checker with the type of each part so code
+// generators can pick the right conversion.
+type InterpolatedString struct {
+    Parts     []Expression
+    PartTypes []string
+    // Raw is the literal as written between the quotes.
+    Raw string
+}
+
+func (s *InterpolatedString) Children() []Node {
+    out := []Node{}
+    for _, p := range s.Parts {
+        if n, ok := p.(Node); ok {
+            out = append(out, n)
+        }
+    }
+    return out
+}
+
+type BooleanLiteral struct {
+    Value bool
+}
+
+func (b *BooleanLiteral) Children() []Node { return nil }
+
+// NoneLiteral is the `none` value of an optional type.
+type NoneLiteral struct{}
+
+func (n *NoneLiteral) Children() []Node { return nil }
+
 type Identifier struct {
     Value string
 }
@@ -106,6 +222,9 @@ func (id *Identifier) Children() []Node { return nil }
 type CallExpression struct {
     Function Expression
     Args     []Expression
+    // Index marks a call of Slice written as `s[start, end]`, or `s[start]`
+    // with end the literal -1.
+    Index bool
 }
 
 func (c *CallExpression) Children() []Node {
@@ -121,6 +240,19 @@ func (c *CallExpression) Children() []Node {
     return out
 }
 
+// PrefixExpression is a unary operator applied to an operand: `-x` or `~x`.
+type PrefixExpression struct {
+    Operator string
+    Right    Expression
+}
+
+func (pe *PrefixExpression) Children() []Node {
+    if n, ok := pe.Right.(Node); ok {
+        return []Node{n}
+    }
+    return nil
+}
+
 type InfixExpression struct {
     Left     Expression
     Operator string
@@ -137,3 +269,23 @@ func (in *InfixExpression) Children() []Node {
     }
     return out
 }
+
+// CoalesceExpression is `Left ?? Right`: the value inside the optional Left,
+// or Right when Left is none. Right is only evaluated when needed. Type is
+// filled in by the checker with the type of the whole expression.
+type CoalesceExpression struct {
+    Left  Expression
+    Right Expression
+    Type  string
+}
+
+func (c *CoalesceExpression) Children() []Node {
+    out := []Node{}
+    if n, ok := c.Left.(Node); ok {
+        out = append(out, n)
+    }
+    if n, ok := c.Right.(Node); ok {
+        out = append(out, n)
+    }
+    return out
+}
@@ -4,71 +4,128 @@ import "codeberg.org/clockwise-lang/clockwise/lexer"
 
 // IfStatement represents a simple if/else statement.
 type IfStatement struct {
+    Pos
     Condition Expression
     Consequent *BlockStatement
     Alternative *BlockStatement
 }
 
+func (i *IfStatement) Children() []Node {
+    out := []Node{}
+    if n, ok := i.Condition.(Node); ok {
+        out = append(out, n)
+    }
+    out = append(out, i.Consequent)
+    if i.Alternative != nil {
+        out = append(out, i.Alternative)
+    }
+    return out
+}
+
+// IfLetStatement unwraps an optional: `if let Name = Value { ... }` runs
+// Consequent with Name bound to the contained value, or Alternative when
+// Value is none.
+type IfLetStatement struct {
+    Pos
+    Name string
+    Value Expression
+    Consequent *BlockStatement
+    Alternative *BlockStatement
+}
+
+func (i *IfLetStatement) Children() []Node {
+    out := []Node{}
+    if n, ok := i.Value.(Node); ok {
+        out = append(out, n)
+    }
+    out = append(out, i.Consequent)
+    if i.Alternative != nil {
+        out = append(out, i.Alternative)
+    }
+    return out
+}
+
 // WhileStatement represents a while loop.
 type WhileStatement struct {
+    Pos
     Condition Expression
     Body *BlockStatement
 }
 
-// parse helpers: these are small API additions; parser will call them
-// if extended control parsing is desired.
-func (p *Parser) parseIf() (Statement, error) {
-    // expects current token is IF
-    if _, err := p.expect(lexer.IF); err != nil {
-        return nil, err
-    }
-    // expect '(' condition ')'
-    if _, err := p.expect(lexer.LPAREN); err != nil {
-        return nil, err
-    }
-    cond, err := p.parseExpression()
-    if err != nil {
-        return nil, err
-    }
-    if _, err := p.expect(lexer.RPAREN); err != nil {
-        return nil, err
+func (w *WhileStatement) Children() []Node {
+    out := []Node{}
+    if n, ok := w.Condition.(Node); ok {
+        out = append(out, n)
     }
+    return append(out, w.Body)
+}
+
+// parseBlock parses `{ statements }`.
+func (p *Parser) parseBlock() (*BlockStatement, error) {
     if _, err := p.expect(lexer.LBRACE); err != nil {
         return nil, err
     }
-    cons := &BlockStatement{}
+    block := &BlockStatement{}
     for p.cur().Type != lexer.RBRACE && p.cur().Type != lexer.EOF {
         st, err := p.parseStatement()
         if err != nil {
             return nil, err
         }
         if st != nil {
-            cons.Statements = append(cons.Statements, st)
+            block.Statements = append(block.Statements, st)
         }
     }
+    block.End = p.cur().Line
+    block.Comments = p.closing()
     if _, err := p.expect(lexer.RBRACE); err != nil {
         return nil, err
     }
-    var alt *BlockStatement
-    if p.cur().Type == lexer.ELSE {
+    return block, nil
+}
+
+// parseIf parses `if cond { } else { }`, `if let name = expr { }` and
+// `else if` chains. Parentheses around the condition are optional.
+func (p *Parser) parseIf() (Statement, error) {
+    // expects current token is IF
+    if _, err := p.expect(lexer.IF); err != nil {
+        return nil, err
+    }
+    var letName string
+    if p.cur().Type == lexer.LET {
         p.next()
-        if _, err := p.expect(lexer.LBRACE); err != nil {
+        nameTok, err := p.expect(lexer.IDENT)
+        if err != nil {
+            return nil, err
+        }
+        if _, err := p.expect(lexer.ASSIGN); err != nil {
             return nil, err
         }
-        alt = &BlockStatement{}
-        for p.cur().Type != lexer.RBRACE && p.cur().Type != lexer.EOF {
-            st, err := p.parseStatement()
+        letName = nameTok.Lit
+    }
+    cond, err := p.parseExpression()
+    if err != nil {
+        return nil, err
+    }
+    cons, err := p.parseBlock()
+    if err != nil {
+        return nil, err
+    }
+    var alt *BlockStatement
+    if p.cur().Type == lexer.ELSE {
+        p.next()
+        if p.cur().Type == lexer.IF {
+            nested, err := p.parseStatement()
             if err != nil {
                 return nil, err
             }
-            if st != nil {
-                alt.Statements = append(alt.Statements, st)
-            }
-        }
-        if _, err := p.expect(lexer.RBRACE); err != nil {
+            alt = &BlockStatement{Statements: []Statement{nested}}
+        } else if alt, err = p.parseBlock(); err != nil {
             return nil, err
         }
     }
+    if letName != "" {
+        return &IfLetStatement{Name: letName, Value: cond, Consequent: cons, Alternative: alt}, nil
+    }
     return &IfStatement{Condition: cond, Consequent: cons, Alternative: alt}, nil
 }
 
@@ -76,30 +133,12 @@ func (p *Parser) parseWhile() (Statement, error) {
     if _, err := p.expect(lexer.WHILE); err != nil {
         return nil, err
     }
-    if _, err := p.expect(lexer.LPAREN); err != nil {
-        return nil, err
-    }
     cond, err := p.parseExpression()
     if err != nil {
         return nil, err
     }
-    if _, err := p.expect(lexer.RPAREN); err != nil {
-        return nil, err
-    }
-    if _, err := p.expect(lexer.LBRACE); err != nil {
-        return nil, err
-    }
-    body := &BlockStatement{}
-    for p.cur().Type != lexer.RBRACE && p.cur().Type != lexer.EOF {
-        st, err := p.parseStatement()
-        if err != nil {
-            return nil, err
-        }
-        if st != nil {
-            body.Statements = append(body.Statements, st)
-        }
-    }
-    if _, err := p.expect(lexer.RBRACE); err != nil {
+    body, err := p.parseBlock()
+    if err != nil {
         return nil, err
     }
     return &WhileStatement{Condition: cond, Body: body}, nil
@@ -8,10 +8,24 @@ import (
 type Parser struct {
     tokens []lexer.Token
     pos    int
+    // trivia is set for tokens from lexer.NewWithTrivia; leading and
+    // trailing mark the tokens whose comments are already attached
+    trivia   bool
+    leading  []bool
+    trailing []bool
 }
 
 func New(tokens []lexer.Token) *Parser {
-    return &Parser{tokens: tokens, pos: 0}
+    p := &Parser{tokens: tokens, pos: 0}
+    for _, tok := range tokens {
+        if tok.Text != "" || tok.Leading != "" {
+            p.trivia = true
+            p.leading = make([]bool, len(tokens))
+            p.trailing = make([]bool, len(tokens))
+            break
+        }
+    }
+    return p
 }
 
 func (p *Parser) cur() lexer.Token {
@@ -26,6 +40,12 @@ func (p *Parser) next() lexer.Token {
     return p.cur()
 }
 
+// Line returns the source line of the current token; after a failed parse,
+// the line the error was found on.
+func (p *Parser) Line() int {
+    return p.cur().Line
+}
+
 func (p *Parser) expect(tt lexer.TokenType) (lexer.Token, error) {
     tok := p.cur()
     if tok.Type != tt {
@@ -38,6 +58,7 @@ func (p *Parser) expect(tt lexer.TokenType) (lexer.Token, error) {
 func (p *Parser) ParseProgram() (*Program, error) {
     prog := &Program{}
     for p.cur().Type != lexer.EOF {
+        start := p.pos
         if p.cur().Type == lexer.IMPORT {
             importPath, err := p.parseImport()
             if err != nil {
@@ -46,11 +67,26 @@ func (p *Parser) ParseProgram() (*Program, error) {
             prog.Imports = append(prog.Imports, importPath)
             continue
         }
+        if p.cur().Type == lexer.EXPORT {
+            p.next()
+            if p.cur().Type != lexer.FUNCTION {
+                return nil, fmt.Errorf("expected fn after export, got %s (%s)", p.cur().Type, p.cur().Lit)
+            }
+            fn, err := p.parseFunction()
+            if err != nil {
+                return nil, err
+            }
+            fn.Export = true
+            p.attach(&fn.Pos, start)
+            prog.Functions = append(prog.Functions, fn)
+            continue
+        }
         if p.cur().Type == lexer.FUNCTION || p.cur().Type == lexer.IDENT && p.cur().Lit == "fn" {
             fn, err := p.parseFunction()
             if err != nil {
                 return nil, err
             }
+            p.attach(&fn.Pos, start)
             prog.Functions = append(prog.Functions, fn)
             continue
         }
@@ -72,11 +108,32 @@ func (p *Parser) ParseProgram() (*Program, error) {
         // skip unknown/illegal tokens
         p.next()
     }
+    prog.Comments = p.unattached()
     return prog, nil
 }
 
+// ParseStatements parses a sequence of statements outside any function, as
+// typed at an interactive prompt. The semicolon after the last one may be
+// left out.
+func (p *Parser) ParseStatements() ([]Statement, error) {
+    var stmts []Statement
+    for p.cur().Type != lexer.EOF {
+        if p.cur().Type == lexer.SEMICOLON {
+            p.next()
+            continue
+        }
+        st, err := p.parseStatement()
+        if err != nil {
+            return nil, err
+        }
+        stmts = append(stmts, st)
+    }
+    return stmts, nil
+}
+
 func (p *Parser) parseFunction() (*Function, error) {
     // expect 'fn'
+    line := p.cur().Line
     if p.cur().Type == lexer.IDENT && p.cur().Lit == "fn" {
         p.tokens[p.pos].Type = lexer.FUNCTION
     }
@@ -94,16 +151,15 @@ func (p *Parser) parseFunction() (*Function, error) {
     if _, err := p.expect(lexer.RPAREN); err != nil {
         return nil, err
     }
-    // optional return type: -> IDENT
+    // optional return type: -> TYPE
     retType := "int"
     if p.cur().Type == lexer.ARROW {
         p.next()
-        if p.cur().Type == lexer.IDENT {
-            retType = p.cur().Lit
-            p.next()
-        } else {
-            return nil, fmt.Errorf("expected return type identifier after '->'")
+        t, err := p.parseType()
+        if err != nil {
+            return nil, fmt.Errorf("expected return type after '->': %w", err)
         }
+        retType = t
     }
     // body
     if _, err := p.expect(lexer.LBRACE); err != nil {
@@ -119,18 +175,55 @@ func (p *Parser) parseFunction() (*Function, error) {
             body.Statements = append(body.Statements, stmt)
         }
     }
+    body.End = p.cur().Line
+    body.Comments = p.closing()
     if _, err := p.expect(lexer.RBRACE); err != nil {
         return nil, err
     }
-    return &Function{Name: nameTok.Lit, ReturnType: retType, Body: body}, nil
+    return &Function{Pos: Pos{Line: line}, Name: nameTok.Lit, ReturnType: retType, Body: body}, nil
+}
+
+// parseType parses a type name, optionally followed by `?` to make it
+// optional, and returns its spelling (e.g. "string?").
+func (p *Parser) parseType() (string, error) {
+    tok, err := p.expect(lexer.IDENT)
+    if err != nil {
+        return "", err
+    }
+    if p.cur().Type == lexer.QUESTION {
+        p.next()
+        return tok.Lit + "?", nil
+    }
+    return tok.Lit, nil
 }
 
 func (p *Parser) parseStatement() (Statement, error) {
+    start := p.pos
+    line := p.cur().Line
+    s, err := p.parseStatementAt()
+    if n, ok := s.(Positioned); ok {
+        n.Position().Line = line
+        if err == nil {
+            p.attach(n.Position(), start)
+        }
+    }
+    return s, err
+}
+
+func (p *Parser) parseStatementAt() (Statement, error) {
     switch p.cur().Type {
     case lexer.RETURN:
         return p.parseReturn()
     case lexer.VAR:
         return p.parseVar()
+    case lexer.IF:
+        return p.parseIf()
+    case lexer.WHILE:
+        return p.parseWhile()
+    case lexer.DEFER:
+        return p.parseDefer()
+    case lexer.ASSERT:
+        return p.parseAssert()
     default:
         return p.parseExpressionStatement()
     }
@@ -158,12 +251,11 @@ func (p *Parser) parseVar() (Statement, error) {
     varType := "int"
     if p.cur().Type == lexer.COLON {
         p.next()
-        if p.cur().Type == lexer.IDENT {
-            varType = p.cur().Lit
-            p.next()
-        } else {
-            return nil, fmt.Errorf("expected type identifier after ':'")
+        t, err := p.parseType()
+        if err != nil {
+            return nil, fmt.Errorf("expected type after ':': %w", err)
         }
+        varType = t
     }
     if _, err := p.expect(lexer.ASSIGN); err != nil {
         return nil, err
@@ -178,6 +270,46 @@ func (p *Parser) parseVar() (Statement, error) {
     return &VarStatement{Name: nameTok.Lit, Type: varType, Value: expr}, nil
 }
 
+// parseDefer parses `defer <expr>;`. The checker requires the expression to
+// be a call.
+func (p *Parser) parseDefer() (Statement, error) {
+    p.next()
+    expr, err := p.parseExpression()
+    if err != nil {
+        return nil, err
+    }
+    if p.cur().Type == lexer.SEMICOLON {
+        p.next()
+    }
+    return &DeferStatement{Call: expr}, nil
+}
+
+// parseAssert parses `assert(cond)` or `assert(cond, msg)`.
+func (p *Parser) parseAssert() (Statement, error) {
+    p.next()
+    if _, err := p.expect(lexer.LPAREN); err != nil {
+        return nil, err
+    }
+    cond, err := p.parseExpression()
+    if err != nil {
+        return nil, err
+    }
+    st := &AssertStatement{Condition: cond}
+    if p.cur().Type == lexer.COMMA {
+        p.next()
+        if st.Message, err = p.parseExpression(); err != nil {
+            return nil, err
+        }
+    }
+    if _, err := p.expect(lexer.RPAREN); err != nil {
+        return nil, err
+    }
+    if p.cur().Type == lexer.SEMICOLON {
+        p.next()
+    }
+    return st, nil
+}
+
 func (p *Parser) parseExpressionStatement() (Statement, error) {
     expr, err := p.parseExpression()
     if err != nil {
@@ -193,17 +325,37 @@ func (p *Parser) parseExpression() (Expression, error) {
     return p.parseExpressionWithPrecedence(0)
 }
 
+// precedences orders the binary operators from loosest to tightest binding.
+// Bitwise operators bind tighter than comparisons, so `x & 1 == 0` means
+// `(x & 1) == 0`.
 var precedences = map[lexer.TokenType]int{
-    lexer.EQ:       1,
-    lexer.NOT_EQ:   1,
-    lexer.LT:       2,
-    lexer.GT:       2,
-    lexer.PLUS:     3,
-    lexer.MINUS:    3,
-    lexer.SLASH:    4,
-    lexer.ASTERISK: 4,
+    lexer.COALESCE: 1,
+    lexer.EQ:       2,
+    lexer.NOT_EQ:   2,
+    lexer.LT:       3,
+    lexer.GT:       3,
+    lexer.PIPE:     4,
+    lexer.CARET:    5,
+    lexer.AMP:      6,
+    lexer.SHL:      7,
+    lexer.SHR:      7,
+    lexer.PLUS:     8,
+    lexer.MINUS:    8,
+    lexer.SLASH:    9,
+    lexer.ASTERISK: 9,
+    lexer.PERCENT:  9,
+}
+
+// Precedence returns the binding strength of binary operator op as
+// written, such as "+" or "??", from 1 for the loosest; 0 if op is not a
+// binary operator.
+func Precedence(op string) int {
+    return precedences[lexer.TokenType(op)]
 }
 
+// prefixPrecedence binds unary operators tighter than any binary operator.
+const prefixPrecedence = 10
+
 func (p *Parser) parseExpressionWithPrecedence(precedence int) (Expression, error) {
     tok := p.cur()
     var left Expression
@@ -254,7 +406,7 @@ func (p *Parser) parseExpressionWithPrecedence(precedence int) (Expression, erro
             }
             // build call: Slice(ident, startExpr, endExpr)
             args := []Expression{ident, startExpr, endExpr}
-            left = &CallExpression{Function: &Identifier{Value: "Slice"}, Args: args}
+            left = &CallExpression{Function: &Identifier{Value: "Slice"}, Args: args, Index: true}
         } else {
             left = ident
         }
@@ -263,9 +415,29 @@ func (p *Parser) parseExpressionWithPrecedence(precedence int) (Expression, erro
         p.next()
         left = lit
     case lexer.STRING:
-        lit := &StringLiteral{Value: tok.Lit}
+        lit := &StringLiteral{Value: tok.Lit, Raw: tok.Raw}
         p.next()
         left = lit
+    case lexer.TRUE, lexer.FALSE:
+        p.next()
+        left = &BooleanLiteral{Value: tok.Type == lexer.TRUE}
+    case lexer.NONE:
+        p.next()
+        left = &NoneLiteral{}
+    case lexer.INTERP:
+        interp, err := parseInterpolation(tok)
+        if err != nil {
+            return nil, err
+        }
+        p.next()
+        left = interp
+    case lexer.MINUS, lexer.TILDE:
+        p.next()
+        right, err := p.parseExpressionWithPrecedence(prefixPrecedence)
+        if err != nil {
+            return nil, err
+        }
+        left = &PrefixExpression{Operator: tok.Lit, Right: right}
     case lexer.LPAREN:
         p.next()
         expr, err := p.parseExpression()
@@ -287,6 +459,15 @@ func (p *Parser) parseExpressionWithPrecedence(precedence int) (Expression, erro
         }
         opTok := p.cur()
         p.next()
+        if opTok.Type == lexer.COALESCE {
+            // right-associative: a ?? b ?? c is a ?? (b ?? c)
+            right, err := p.parseExpressionWithPrecedence(curPrec - 1)
+            if err != nil {
+                return nil, err
+            }
+            left = &CoalesceExpression{Left: left, Right: right}
+            continue
+        }
         right, err := p.parseExpressionWithPrecedence(curPrec)
         if err != nil {
             return nil, err
@@ -318,3 +499,31 @@ func (p *Parser) parseImport() (string, error) {
     
     return importPath, nil
 }
+
+// parseInterpolation builds an InterpolatedString from an INTERP token. Each
+// embedded expression was lexed separately, so it is parsed with its own
+// parser and must consume all of its tokens.
+func parseInterpolation(tok lexer.Token) (*InterpolatedString, error) {
+    interp := &InterpolatedString{Raw: tok.Lit}
+    for _, part := range tok.Parts {
+        if !part.IsExpr {
+            if part.Text != "" {
+                interp.Parts = append(interp.Parts, &StringLiteral{Value: part.Text})
+            }
+            continue
+        }
+        sub := New(part.Tokens)
+        if sub.cur().Type == lexer.EOF {
+            return nil, fmt.Errorf("empty ${} in string literal \"%s\"", tok.Lit)
+        }
+        expr, err := sub.parseExpression()
+        if err != nil {
+            return nil, fmt.Errorf("in ${} of string literal \"%s\": %w", tok.Lit, err)
+        }
+        if sub.cur().Type != lexer.EOF {
+            return nil, fmt.Errorf("unexpected %s (%s) in ${} of string literal \"%s\"", sub.cur().Type, sub.cur().Lit, tok.Lit)
+        }
+        interp.Parts = append(interp.Parts, expr)
+    }
+    return interp, nil
+}
@@ -1,14 +1,23 @@
 package parser
 
-import "strings"
+import (
+    "strconv"
+    "strings"
+)
 
-// PrettyPrint produces a simple human-readable representation of the AST.
+// PrettyPrint produces a simple human-readable representation of the AST:
+// one statement per line, indented by block, with expressions written as
+// parenthesized prefix forms such as `(+ 1 (* x 2))` so that grouping is
+// explicit.
 func PrettyPrint(p *Program) string {
     if p == nil {
         return "<nil>"
     }
     var sb strings.Builder
     for _, f := range p.Functions {
+        if f.Export {
+            sb.WriteString("export ")
+        }
         sb.WriteString("fn ")
         sb.WriteString(f.Name)
         sb.WriteString("() -> ")
@@ -19,22 +28,104 @@ func PrettyPrint(p *Program) string {
         }
         sb.WriteString(" {\n")
         if f.Body != nil {
-            for _, s := range f.Body.Statements {
-                switch st := s.(type) {
-                case *ReturnStatement:
-                    sb.WriteString("  return ...\n")
-                case *VarStatement:
-                    sb.WriteString("  var ")
-                    sb.WriteString(st.Name)
-                    sb.WriteString(" : ")
-                    sb.WriteString(st.Type)
-                    sb.WriteString("\n")
-                default:
-                    sb.WriteString("  stmt\n")
-                }
-            }
+            prettyBlock(&sb, f.Body, 1)
         }
         sb.WriteString("}\n")
     }
     return sb.String()
 }
+
+// PrettyPrintStatements writes statements that are not part of a function,
+// such as REPL input, in the form of PrettyPrint.
+func PrettyPrintStatements(stmts []Statement) string {
+    var sb strings.Builder
+    for _, s := range stmts {
+        prettyStatement(&sb, s, 0)
+    }
+    return sb.String()
+}
+
+func prettyBlock(sb *strings.Builder, b *BlockStatement, depth int) {
+    for _, s := range b.Statements {
+        prettyStatement(sb, s, depth)
+    }
+}
+
+func prettyStatement(sb *strings.Builder, s Statement, depth int) {
+    indent := strings.Repeat("  ", depth)
+    sb.WriteString(indent)
+    switch st := s.(type) {
+    case *ReturnStatement:
+        sb.WriteString("return " + prettyExpr(st.Value) + "\n")
+    case *VarStatement:
+        sb.WriteString("var " + st.Name + " : " + st.Type + " = " + prettyExpr(st.Value) + "\n")
+    case *ExpressionStatement:
+        sb.WriteString(prettyExpr(st.Expr) + "\n")
+    case *DeferStatement:
+        sb.WriteString("defer " + prettyExpr(st.Call) + "\n")
+    case *AssertStatement:
+        sb.WriteString("assert " + prettyExpr(st.Condition))
+        if st.Message != nil {
+            sb.WriteString(" " + prettyExpr(st.Message))
+        }
+        sb.WriteString("\n")
+    case *IfStatement:
+        sb.WriteString("if " + prettyExpr(st.Condition) + " {\n")
+        prettyArms(sb, st.Consequent, st.Alternative, depth)
+    case *IfLetStatement:
+        sb.WriteString("if let " + st.Name + " = " + prettyExpr(st.Value) + " {\n")
+        prettyArms(sb, st.Consequent, st.Alternative, depth)
+    case *WhileStatement:
+        sb.WriteString("while " + prettyExpr(st.Condition) + " {\n")
+        prettyBlock(sb, st.Body, depth+1)
+        sb.WriteString(indent + "}\n")
+    default:
+        sb.WriteString("stmt\n")
+    }
+}
+
+func prettyArms(sb *strings.Builder, then, els *BlockStatement, depth int) {
+    indent := strings.Repeat("  ", depth)
+    prettyBlock(sb, then, depth+1)
+    if els != nil {
+        sb.WriteString(indent + "} else {\n")
+        prettyBlock(sb, els, depth+1)
+    }
+    sb.WriteString(indent + "}\n")
+}
+
+func prettyExpr(e Expression) string {
+    switch ex := e.(type) {
+    case *IntegerLiteral:
+        return ex.Value
+    case *StringLiteral:
+        return strconv.Quote(ex.Value)
+    case *BooleanLiteral:
+        return strconv.FormatBool(ex.Value)
+    case *NoneLiteral:
+        return "none"
+    case *Identifier:
+        return ex.Value
+    case *InterpolatedString:
+        parts := []string{"interp"}
+        for _, p := range ex.Parts {
+            parts = append(parts, prettyExpr(p))
+        }
+        return "(" + strings.Join(parts, " ") + ")"
+    case *PrefixExpression:
+        return "(" + ex.Operator + " " + prettyExpr(ex.Right) + ")"
+    case *InfixExpression:
+        return "(" + ex.Operator + " " + prettyExpr(ex.Left) + " " + prettyExpr(ex.Right) + ")"
+    case *CoalesceExpression:
+        return "(?? " + prettyExpr(ex.Left) + " " + prettyExpr(ex.Right) + ")"
+    case *CallExpression:
+        parts := []string{"call", prettyExpr(ex.Function)}
+        for _, a := range ex.Args {
+            parts = append(parts, prettyExpr(a))
+        }
+        return "(" + strings.Join(parts, " ") + ")"
+    case nil:
+        return "<nil>"
+    }
+    return "expr"
+}
@@ -0,0 +1,402 @@
+package transform
+
+import (
+    "fmt"
+    "math/big"
+    "strings"
+
+    "codeberg.org/clockwise-lang/clockwise/checker"
+    pr "codeberg.org/clockwise-lang/clockwise/parser"
+    putils "codeberg.org/clockwise-lang/clockwise/parser/utils"
+)
+
+// Pass is one optimization over a checked program. Passes run in order when
+// the optimization level is at least Level.
+type Pass struct {
+    Name  string
+    Level int
+    Run   func(p *pr.Program, r *Report)
+}
+
+// Passes is the optimization pipeline: -O1 folds constants and drops
+// unreachable statements, -O2 also removes unused locals and functions.
+var Passes = []Pass{
+    {Name: "fold", Level: 1, Run: foldConstants},
+    {Name: "unreachable", Level: 1, Run: dropUnreachable},
+    {Name: "unused-vars", Level: 2, Run: removeUnusedVars},
+    {Name: "prune-funcs", Level: 2, Run: pruneFunctions},
+}
+
+// Warning is a diagnostic found while optimizing.
+type Warning struct {
+    File string
+    Line int
+    Msg  string
+}
+
+// Report collects what the passes changed and the warnings they found.
+type Report struct {
+    Changes  []string
+    Warnings []Warning
+    pass     string
+}
+
+func (r *Report) changed(format string, args ...interface{}) {
+    r.Changes = append(r.Changes, r.pass+": "+fmt.Sprintf(format, args...))
+}
+
+// Optimize runs the passes enabled at level over p in place. Unreachable
+// code is reported at every level, even when it is not removed.
+func Optimize(p *pr.Program, level int) *Report {
+    r := &Report{}
+    if level < 1 {
+        r.pass = "unreachable"
+        warnUnreachable(p, r)
+        return r
+    }
+    for _, pass := range Passes {
+        if level >= pass.Level {
+            r.pass = pass.Name
+            pass.Run(p, r)
+        }
+    }
+    return r
+}
+
+// forEachBlock calls f for every block in fn, outermost first.
+func forEachBlock(b *pr.BlockStatement, f func(*pr.BlockStatement)) {
+    if b == nil {
+        return
+    }
+    f(b)
+    for _, s := range b.Statements {
+        switch st := s.(type) {
+        case *pr.IfStatement:
+            forEachBlock(st.Consequent, f)
+            forEachBlock(st.Alternative, f)
+        case *pr.IfLetStatement:
+            forEachBlock(st.Consequent, f)
+            forEachBlock(st.Alternative, f)
+        case *pr.WhileStatement:
+            forEachBlock(st.Body, f)
+        }
+    }
+}
+
+// rewriteStatement replaces every expression held directly by s with the
+// result of f.
+func rewriteStatement(s pr.Statement, f func(pr.Expression) pr.Expression) {
+    switch st := s.(type) {
+    case *pr.ReturnStatement:
+        st.Value = f(st.Value)
+    case *pr.VarStatement:
+        st.Value = f(st.Value)
+    case *pr.ExpressionStatement:
+        st.Expr = f(st.Expr)
+    case *pr.IfStatement:
+        st.Condition = f(st.Condition)
+    case *pr.IfLetStatement:
+        st.Value = f(st.Value)
+    case *pr.WhileStatement:
+        st.Condition = f(st.Condition)
+    case *pr.DeferStatement:
+        st.Call = f(st.Call)
+    case *pr.AssertStatement:
+        st.Condition = f(st.Condition)
+        if st.Message != nil {
+            st.Message = f(st.Message)
+        }
+    }
+}
+
+// rewriteExpr rebuilds e bottom-up, replacing each subexpression with the
+// result of f.
+func rewriteExpr(e pr.Expression, f func(pr.Expression) pr.Expression) pr.Expression {
+    switch ex := e.(type) {
+    case *pr.InfixExpression:
+        ex.Left = rewriteExpr(ex.Left, f)
+        ex.Right = rewriteExpr(ex.Right, f)
+    case *pr.PrefixExpression:
+        ex.Right = rewriteExpr(ex.Right, f)
+    case *pr.CallExpression:
+        for i, a := range ex.Args {
+            ex.Args[i] = rewriteExpr(a, f)
+        }
+    case *pr.CoalesceExpression:
+        ex.Left = rewriteExpr(ex.Left, f)
+        ex.Right = rewriteExpr(ex.Right, f)
+    case *pr.InterpolatedString:
+        for i, part := range ex.Parts {
+            ex.Parts[i] = rewriteExpr(part, f)
+        }
+    }
+    return f(e)
+}
+
+// foldConstants replaces integer constant expressions with their value and
+// concatenations of string literals with a single literal.
+func foldConstants(p *pr.Program, r *Report) {
+    for _, fn := range p.Functions {
+        folded := 0
+        fold := func(e pr.Expression) pr.Expression {
+            out := foldExpr(e)
+            if out != e {
+                folded++
+            }
+            return out
+        }
+        forEachBlock(fn.Body, func(b *pr.BlockStatement) {
+            for _, s := range b.Statements {
+                rewriteStatement(s, func(e pr.Expression) pr.Expression { return rewriteExpr(e, fold) })
+            }
+        })
+        if folded > 0 {
+            r.changed("%s: folded %d constant expressions", fn.Name, folded)
+        }
+    }
+}
+
+// foldExpr folds e if its operands are already literals.
+func foldExpr(e pr.Expression) pr.Expression {
+    switch ex := e.(type) {
+    case *pr.InfixExpression:
+        if v, ok, err := checker.ConstValue(ex); ok && err == nil {
+            return intConst(v)
+        }
+        l, lok, _ := checker.ConstValue(ex.Left)
+        r, rok, _ := checker.ConstValue(ex.Right)
+        if lok && rok {
+            if b, ok := compare(ex.Operator, l.Cmp(r)); ok {
+                return &pr.BooleanLiteral{Value: b}
+            }
+        }
+        ls, lok := ex.Left.(*pr.StringLiteral)
+        rs, rok := ex.Right.(*pr.StringLiteral)
+        if lok && rok {
+            switch ex.Operator {
+            case "+":
+                return &pr.StringLiteral{Value: ls.Value + rs.Value}
+            case "==", "!=", "<", ">", "<=", ">=":
+                b, _ := compare(ex.Operator, strings.Compare(ls.Value, rs.Value))
+                return &pr.BooleanLiteral{Value: b}
+            }
+        }
+    case *pr.PrefixExpression:
+        if _, ok := ex.Right.(*pr.IntegerLiteral); ok && ex.Operator == "-" {
+            // already folded
+            return e
+        }
+        if v, ok, err := checker.ConstValue(ex); ok && err == nil {
+            return intConst(v)
+        }
+    case *pr.InterpolatedString:
+        var sb strings.Builder
+        for _, part := range ex.Parts {
+            switch lit := part.(type) {
+            case *pr.StringLiteral:
+                sb.WriteString(lit.Value)
+            case *pr.BooleanLiteral:
+                sb.WriteString(fmt.Sprint(lit.Value))
+            default:
+                v, ok, err := checker.ConstValue(part)
+                if !ok || err != nil {
+                    return e
+                }
+                sb.WriteString(v.String())
+            }
+        }
+        return &pr.StringLiteral{Value: sb.String()}
+    }
+    return e
+}
+
+// intConst returns the literal for v. Literals are unsigned, so negative
+// values keep their minus sign as a prefix expression.
+func intConst(v *big.Int) pr.Expression {
+    if v.Sign() < 0 {
+        return &pr.PrefixExpression{Operator: "-", Right: &pr.IntegerLiteral{Value: new(big.Int).Neg(v).String()}}
+    }
+    return &pr.IntegerLiteral{Value: v.String()}
+}
+
+// compare evaluates a comparison operator given the sign of left-right.
+func compare(op string, cmp int) (bool, bool) {
+    switch op {
+    case "==":
+        return cmp == 0, true
+    case "!=":
+        return cmp != 0, true
+    case "<":
+        return cmp < 0, true
+    case ">":
+        return cmp > 0, true
+    case "<=":
+        return cmp <= 0, true
+    case ">=":
+        return cmp >= 0, true
+    }
+    return false, false
+}
+
+// terminates reports whether control never continues past s.
+func terminates(s pr.Statement) bool {
+    switch st := s.(type) {
+    case *pr.ReturnStatement:
+        return true
+    case *pr.ExpressionStatement:
+        if call, ok := st.Expr.(*pr.CallExpression); ok {
+            if id, ok := call.Function.(*pr.Identifier); ok && id.Value == "panic" {
+                return true
+            }
+        }
+    }
+    return false
+}
+
+// unreachableFrom returns the index of the first statement in b that follows
+// a return or panic, or -1 if every statement can run.
+func unreachableFrom(b *pr.BlockStatement) int {
+    for i, s := range b.Statements {
+        if terminates(s) && i+1 < len(b.Statements) {
+            return i + 1
+        }
+    }
+    return -1
+}
+
+// warnUnreachable reports unreachable statements without removing them.
+func warnUnreachable(p *pr.Program, r *Report) {
+    for _, fn := range p.Functions {
+        forEachBlock(fn.Body, func(b *pr.BlockStatement) {
+            if i := unreachableFrom(b); i >= 0 {
+                r.Warnings = append(r.Warnings, Warning{File: fn.File, Line: pr.LineOf(b.Statements[i]), Msg: "unreachable code"})
+            }
+        })
+    }
+}
+
+// dropUnreachable warns about and removes statements after a return or panic.
+func dropUnreachable(p *pr.Program, r *Report) {
+    warnUnreachable(p, r)
+    for _, fn := range p.Functions {
+        dropped := 0
+        forEachBlock(fn.Body, func(b *pr.BlockStatement) {
+            if i := unreachableFrom(b); i >= 0 {
+                dropped += len(b.Statements) - i
+                b.Statements = b.Statements[:i]
+            }
+        })
+        if dropped > 0 {
+            r.changed("%s: removed %d unreachable statements", fn.Name, dropped)
+        }
+    }
+}
+
+// removeUnusedVars deletes local variables that are never referenced and
+// whose initializer cannot fail or have side effects. Removing one variable
+// can leave another unused, so it repeats until nothing changes.
+func removeUnusedVars(p *pr.Program, r *Report) {
+    for _, fn := range p.Functions {
+        var removed []string
+        for {
+            uses := map[string]int{}
+            pr.Walk(fn, visitorFunc(func(n pr.Node) {
+                if id, ok := n.(*pr.Identifier); ok {
+                    uses[id.Value]++
+                }
+            }))
+            before := len(removed)
+            forEachBlock(fn.Body, func(b *pr.BlockStatement) {
+                kept := b.Statements[:0]
+                for _, s := range b.Statements {
+                    if v, ok := s.(*pr.VarStatement); ok && uses[v.Name] == 0 && pure(v.Value) {
+                        removed = append(removed, v.Name)
+                        continue
+                    }
+                    kept = append(kept, s)
+                }
+                b.Statements = kept
+            })
+            if len(removed) == before {
+                break
+            }
+        }
+        if len(removed) > 0 {
+            r.changed("%s: removed unused variables %s", fn.Name, strings.Join(removed, ", "))
+        }
+    }
+}
+
+// pure reports whether evaluating e has no effect beyond producing its
+// value: it calls nothing but conversions and cannot divide by zero.
+func pure(e pr.Expression) bool {
+    switch ex := e.(type) {
+    case *pr.IntegerLiteral, *pr.StringLiteral, *pr.BooleanLiteral, *pr.NoneLiteral, *pr.Identifier:
+        return true
+    case *pr.PrefixExpression:
+        return pure(ex.Right)
+    case *pr.InfixExpression:
+        if ex.Operator == "/" || ex.Operator == "%" {
+            if v, ok, _ := checker.ConstValue(ex.Right); !ok || v.Sign() == 0 {
+                return false
+            }
+        }
+        return pure(ex.Left) && pure(ex.Right)
+    case *pr.CoalesceExpression:
+        return pure(ex.Left) && pure(ex.Right)
+    case *pr.InterpolatedString:
+        for _, part := range ex.Parts {
+            if !pure(part) {
+                return false
+            }
+        }
+        return true
+    case *pr.CallExpression:
+        return checker.IsConversion(ex) && len(ex.Args) == 1 && pure(ex.Args[0])
+    }
+    return false
+}
+
+// pruneFunctions removes non-exported functions that cannot be reached from
+// main or from an exported function.
+func pruneFunctions(p *pr.Program, r *Report) {
+    byName := map[string]*pr.Function{}
+    for _, fn := range p.Functions {
+        byName[fn.Name] = fn
+    }
+    live := map[string]bool{}
+    var visit func(fn *pr.Function)
+    visit = func(fn *pr.Function) {
+        if live[fn.Name] {
+            return
+        }
+        live[fn.Name] = true
+        pr.Walk(fn, visitorFunc(func(n pr.Node) {
+            call, ok := n.(*pr.CallExpression)
+            if !ok {
+                return
+            }
+            if id, ok := call.Function.(*pr.Identifier); ok && byName[id.Value] != nil {
+                visit(byName[id.Value])
+            }
+        }))
+    }
+    for _, fn := range p.Functions {
+        if fn.Name == "main" || fn.Export || putils.IsExported(fn.Name) {
+            visit(fn)
+        }
+    }
+    kept := p.Functions[:0]
+    for _, fn := range p.Functions {
+        if live[fn.Name] {
+            kept = append(kept, fn)
+            continue
+        }
+        r.changed("removed uncalled function %s", fn.Name)
+    }
+    p.Functions = kept
+}
+
+type visitorFunc func(pr.Node)
+
+func (f visitorFunc) VisitNode(n pr.Node) { f(n) }
@@ -2,9 +2,9 @@ package transform
 
 import pr "codeberg.org/clockwise-lang/clockwise/parser"
 
-// Simplify is a tiny example transform that could be used to lower complex
-// constructs to simpler ones. For now it is a noop but provides a hook for
-// future passes.
+// Simplify runs the default (-O1) optimization passes over prog and returns
+// it. Use Optimize to pick the level and see what changed.
 func Simplify(prog *pr.Program) *pr.Program {
+    Optimize(prog, 1)
     return prog
 }
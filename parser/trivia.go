@@ -0,0 +1,91 @@
+package parser
+
+import (
+    "fmt"
+
+    "codeberg.org/clockwise-lang/clockwise/lexer"
+)
+
+// File is a source file parsed from trivia tokens: the syntax tree with
+// its comments attached, and the tokens it was parsed from, which print
+// the file back unchanged.
+type File struct {
+    Program *Program
+    Tokens  []lexer.Token
+}
+
+// ParseFile lexes src keeping its whitespace and comments and parses it.
+// Errors carry the line they were found on.
+func ParseFile(src string) (*File, error) {
+    tokens := lexer.NewWithTrivia(src).Tokenize()
+    p := New(tokens)
+    prog, err := p.ParseProgram()
+    if err != nil {
+        return nil, fmt.Errorf("line %d: %w", p.Line(), err)
+    }
+    return &File{Program: prog, Tokens: tokens}, nil
+}
+
+// Source prints the file byte for byte as it was parsed. Tools that
+// rewrite part of a file edit the Text of its tokens and print the rest
+// as it was.
+func (f *File) Source() string {
+    return lexer.Source(f.Tokens)
+}
+
+// attach gives pos the comments of the node made from the tokens from
+// start up to the current one: those on the lines before its first token
+// and the one ending its first line. A comment goes to the innermost node,
+// which is parsed first.
+func (p *Parser) attach(pos *Pos, start int) {
+    if !p.trivia || start >= p.pos {
+        return
+    }
+    c := &Comments{}
+    if !p.leading[start] {
+        p.leading[start] = true
+        c.Leading = p.tokens[start].LeadingComments()
+        c.Doc = p.tokens[start].DocComment()
+    }
+    last := start
+    for i := start; i < p.pos && p.tokens[i].Line == p.tokens[start].Line; i++ {
+        last = i
+    }
+    if !p.trailing[last] {
+        p.trailing[last] = true
+        c.Trailing = p.tokens[last].TrailingComment()
+    }
+    if len(c.Leading) > 0 || c.Trailing != "" {
+        pos.Comments = c
+    }
+}
+
+// closing returns the comments before the closing brace at the current
+// token.
+func (p *Parser) closing() []string {
+    if !p.trivia || p.pos >= len(p.tokens) || p.leading[p.pos] {
+        return nil
+    }
+    p.leading[p.pos] = true
+    return p.tokens[p.pos].LeadingComments()
+}
+
+// unattached returns the comments attach and closing did not take, in
+// source order.
+func (p *Parser) unattached() []string {
+    if !p.trivia {
+        return nil
+    }
+    var out []string
+    for i, tok := range p.tokens {
+        if !p.leading[i] {
+            out = append(out, tok.LeadingComments()...)
+        }
+        if !p.trailing[i] {
+            if c := tok.TrailingComment(); c != "" {
+                out = append(out, c)
+            }
+        }
+    }
+    return out
+}
@@ -0,0 +1,80 @@
+package parser
+
+import (
+    "os"
+    "path/filepath"
+    "reflect"
+    "testing"
+)
+
+// roundTrips holds sources whose layout the lexer must not normalise.
+var roundTrips = map[string]string{
+    "empty":            "",
+    "no final newline": "fn main() -> int { return 0; }",
+    "tabs and spaces":  "fn main()\t->  int {\n\t  var x: int = 0x1F_FF;   \n\treturn x;\n}\n\n\n",
+    "crlf":             "// header\r\nfn main() -> int {\r\n    return 0; // done\r\n}\r\n",
+    "comments":         "/// doc\n/// more\nfn f() -> int {\n    // leading\n\n    // second\n    return 1; // trailing\n    // dangling\n}\n// end",
+    "strings":          "fn main() -> int {\n    Print(\"h\\u00e9 \\é ${1 + 2} \\${x} \\\"q\\\" // not a comment\");\n    return 0;\n}\n",
+    "multibyte":        "// héllo wörld ✓\nfn main() -> int {\n    Print(\"✓\"); // ✓\n    return 0;\n}\n",
+    "imports":          "import \"a.cw\";  // lib\nexport fn f() -> string? {\n    return none;\n}\n",
+}
+
+func TestSourceRoundTrip(t *testing.T) {
+    sources := map[string]string{}
+    for name, src := range roundTrips {
+        sources[name] = src
+    }
+    files, _ := filepath.Glob(filepath.Join("..", "tests", "conformance", "*.cw"))
+    for _, file := range files {
+        src, err := os.ReadFile(file)
+        if err != nil {
+            t.Fatal(err)
+        }
+        sources[filepath.Base(file)] = string(src)
+    }
+    for name, src := range sources {
+        t.Run(name, func(t *testing.T) {
+            f, err := ParseFile(src)
+            if err != nil {
+                t.Fatal(err)
+            }
+            if got := f.Source(); got != src {
+                t.Errorf("Source() = %q, want %q", got, src)
+            }
+        })
+    }
+}
+
+func TestCommentAttachment(t *testing.T) {
+    f, err := ParseFile(roundTrips["comments"])
+    if err != nil {
+        t.Fatal(err)
+    }
+    fn := f.Program.Functions[0]
+    if fn.Comments == nil || !reflect.DeepEqual(fn.Comments.Leading, []string{"/// doc", "/// more"}) {
+        t.Fatalf("function comments = %+v, want the /// comments leading", fn.Comments)
+    }
+    if !reflect.DeepEqual(fn.Comments.Doc, []string{"doc", "more"}) {
+        t.Errorf("function doc = %q, want [doc more]", fn.Comments.Doc)
+    }
+    ret, ok := fn.Body.Statements[0].(*ReturnStatement)
+    if !ok {
+        t.Fatalf("statement is %T, want *ReturnStatement", fn.Body.Statements[0])
+    }
+    want := &Comments{Leading: []string{"// leading", "// second"}, Trailing: "// trailing"}
+    if !reflect.DeepEqual(ret.Comments, want) {
+        t.Errorf("return comments = %+v, want %+v", ret.Comments, want)
+    }
+    if !reflect.DeepEqual(fn.Body.Comments, []string{"// dangling"}) {
+        t.Errorf("block comments = %q, want the dangling comment", fn.Body.Comments)
+    }
+    if !reflect.DeepEqual(f.Program.Comments, []string{"// end"}) {
+        t.Errorf("unattached comments = %q, want the last comment", f.Program.Comments)
+    }
+}
+
+func TestParseFileError(t *testing.T) {
+    if _, err := ParseFile("fn main() -> int {\n    return 0;\n"); err == nil {
+        t.Error("ParseFile of invalid source succeeded")
+    }
+}
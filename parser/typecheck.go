@@ -1,23 +1,38 @@
 package parser
 
-import "fmt"
+import (
+    "fmt"
+    "strings"
+)
+
+// builtinTypes lists the type names CheckTypes accepts.
+var builtinTypes = map[string]bool{
+    "int": true, "string": true, "bool": true, "byte": true, "rune": true,
+    "i8": true, "i16": true, "i32": true, "i64": true,
+    "u8": true, "u16": true, "u32": true, "u64": true,
+}
+
+// isBuiltinType reports whether t names a builtin type or an optional of one.
+func isBuiltinType(t string) bool {
+    return builtinTypes[strings.TrimSuffix(t, "?")]
+}
 
 // CheckTypes performs a very small type-check pass validating declared types
 // used in variable declarations and function return types. It's intentionally
-// conservative and only recognizes "int" and "string" for now.
+// conservative and only recognizes the builtin scalar types.
 func CheckTypes(p *Program) error {
     if p == nil {
         return fmt.Errorf("nil program")
     }
     for _, f := range p.Functions {
-        if f.ReturnType != "" && f.ReturnType != "int" && f.ReturnType != "string" {
+        if f.ReturnType != "" && !isBuiltinType(f.ReturnType) {
             return fmt.Errorf("unsupported return type '%s' in function %s", f.ReturnType, f.Name)
         }
         // walk body for var statements
         if f.Body != nil {
             for _, st := range f.Body.Statements {
                 if vs, ok := st.(*VarStatement); ok {
-                    if vs.Type != "" && vs.Type != "int" && vs.Type != "string" {
+                    if vs.Type != "" && !isBuiltinType(vs.Type) {
                         return fmt.Errorf("unsupported var type '%s' for %s in function %s", vs.Type, vs.Name, f.Name)
                     }
                 }
@@ -0,0 +1,332 @@
+// Package repl implements the interactive session of `cwc repl`. Input is
+// checked and run in process by package interp: function definitions and
+// variables persist for the session, and a bare expression prints its value
+// and type.
+package repl
+
+import (
+    "bufio"
+    "fmt"
+    "io"
+    "os"
+    "strconv"
+    "strings"
+
+    "codeberg.org/clockwise-lang/clockwise/checker"
+    "codeberg.org/clockwise-lang/clockwise/interp"
+    "codeberg.org/clockwise-lang/clockwise/ir"
+    "codeberg.org/clockwise-lang/clockwise/lexer"
+    "codeberg.org/clockwise-lang/clockwise/parser"
+)
+
+// file names the session in positions and panic reports.
+const file = "<repl>"
+
+const help = `Enter statements, expressions or fn definitions; an expression prints its
+value and type. Input continues over several lines while braces are open.
+Commands:
+  :type <expr>     show the type of an expression without evaluating it
+  :ast <input>     show the syntax tree of an expression or statements
+  :load <file.cw>  define the functions of a file
+  :reset           forget all definitions and variables
+  :help            show this help
+  :quit            leave the session (or end the input)
+`
+
+// Session is one interactive session.
+type Session struct {
+    out   io.Writer
+    funcs []*parser.Function
+    env   interp.Env
+    in    *interp.Interpreter
+    // line counts the lines read, so positions are unique in the session
+    line int
+}
+
+// New starts an empty session that writes results to out.
+func New(out io.Writer) *Session {
+    s := &Session{out: out}
+    s.Reset()
+    return s
+}
+
+// Reset forgets every function and variable.
+func (s *Session) Reset() {
+    s.funcs = nil
+    s.env = interp.Env{}
+    s.in, _ = interp.New(&parser.Program{})
+}
+
+// Run reads input from r until it ends or :quit is entered, evaluating each
+// complete input. When prompt is set it writes a prompt before each line.
+func (s *Session) Run(r io.Reader, prompt bool) error {
+    sc := bufio.NewScanner(r)
+    var pending []string
+    for {
+        if prompt {
+            if len(pending) == 0 {
+                fmt.Fprint(s.out, ">>> ")
+            } else {
+                fmt.Fprint(s.out, "... ")
+            }
+        }
+        if !sc.Scan() {
+            if prompt {
+                fmt.Fprintln(s.out)
+            }
+            return sc.Err()
+        }
+        pending = append(pending, sc.Text())
+        src := strings.Join(pending, "\n")
+        if Incomplete(src) {
+            continue
+        }
+        pending = nil
+        if strings.TrimSpace(src) == ":quit" {
+            return nil
+        }
+        if err := s.Eval(src); err != nil {
+            fmt.Fprintf(s.out, "error: %v\n", err)
+        }
+    }
+}
+
+// Incomplete reports whether src has more opening than closing braces,
+// ignoring those in string literals and comments, so that the input
+// continues on the next line.
+func Incomplete(src string) bool {
+    depth := 0
+    inString := false
+    for i := 0; i < len(src); i++ {
+        switch c := src[i]; {
+        case inString && c == '\\':
+            i++
+        case c == '"':
+            inString = !inString
+        case inString:
+        case c == '/' && i+1 < len(src) && src[i+1] == '/':
+            for i < len(src) && src[i] != '\n' {
+                i++
+            }
+        case c == '{':
+            depth++
+        case c == '}':
+            depth--
+        }
+    }
+    return depth > 0
+}
+
+// Eval evaluates one complete input: a command, fn definitions, or
+// statements.
+func (s *Session) Eval(src string) error {
+    start := s.line + 1
+    s.line += strings.Count(src, "\n") + 1
+    trimmed := strings.TrimSpace(src)
+    if strings.HasPrefix(trimmed, ":") {
+        cmd, arg, _ := strings.Cut(trimmed, " ")
+        return s.command(cmd, strings.TrimSpace(arg))
+    }
+    if trimmed == "" {
+        return nil
+    }
+    tokens := lexer.New(src).Tokenize()
+    for i := range tokens {
+        tokens[i].Line += start - 1
+    }
+    first := tokens[0]
+    if first.Type == lexer.FUNCTION || first.Type == lexer.EXPORT || first.Type == lexer.IMPORT {
+        prog, err := parser.New(tokens).ParseProgram()
+        if err != nil {
+            return err
+        }
+        if len(prog.Imports) > 0 {
+            return fmt.Errorf("import is not supported in the REPL; use :load")
+        }
+        return s.define(prog.Functions, file)
+    }
+    stmts, err := parser.New(tokens).ParseStatements()
+    if err != nil {
+        return err
+    }
+    for _, st := range stmts {
+        if err := s.exec(st); err != nil {
+            return err
+        }
+    }
+    return nil
+}
+
+func (s *Session) command(cmd, arg string) error {
+    switch cmd {
+    case ":help":
+        fmt.Fprint(s.out, help)
+    case ":reset":
+        s.Reset()
+        fmt.Fprintln(s.out, "session cleared")
+    case ":load":
+        if arg == "" {
+            return fmt.Errorf(":load needs a file name")
+        }
+        return s.load(arg)
+    case ":type":
+        e, err := parseExpr(arg)
+        if err != nil {
+            return err
+        }
+        st := &parser.ExpressionStatement{Expr: e}
+        t, err := checker.CheckStatement(s.program(), s.types(), st)
+        if err != nil {
+            return err
+        }
+        if err := s.undefined(st); err != nil {
+            return err
+        }
+        fmt.Fprintln(s.out, t)
+    case ":ast":
+        stmts, err := parser.New(lexer.New(arg).Tokenize()).ParseStatements()
+        if err != nil {
+            return err
+        }
+        fmt.Fprint(s.out, parser.PrettyPrintStatements(stmts))
+    default:
+        return fmt.Errorf("unknown command %s; try :help", cmd)
+    }
+    return nil
+}
+
+// parseExpr parses src as a single expression.
+func parseExpr(src string) (parser.Expression, error) {
+    stmts, err := parser.New(lexer.New(src).Tokenize()).ParseStatements()
+    if err != nil {
+        return nil, err
+    }
+    if len(stmts) != 1 {
+        return nil, fmt.Errorf("expected an expression")
+    }
+    es, ok := stmts[0].(*parser.ExpressionStatement)
+    if !ok {
+        return nil, fmt.Errorf("expected an expression")
+    }
+    return es.Expr, nil
+}
+
+// load defines the functions of a source file.
+func (s *Session) load(path string) error {
+    src, err := os.ReadFile(path)
+    if err != nil {
+        return err
+    }
+    prog, err := parser.New(lexer.New(string(src)).Tokenize()).ParseProgram()
+    if err != nil {
+        return fmt.Errorf("parse error in %s: %w", path, err)
+    }
+    if err := s.define(prog.Functions, path); err != nil {
+        return err
+    }
+    fmt.Fprintf(s.out, "loaded %d functions from %s\n", len(prog.Functions), path)
+    return nil
+}
+
+// define adds functions to the session, replacing those of the same name.
+// The session is unchanged if the functions do not check.
+func (s *Session) define(fns []*parser.Function, path string) error {
+    funcs := append([]*parser.Function(nil), s.funcs...)
+    for _, fn := range fns {
+        fn.File = path
+        replaced := false
+        for i, old := range funcs {
+            if old.Name == fn.Name {
+                funcs[i], replaced = fn, true
+            }
+        }
+        if !replaced {
+            funcs = append(funcs, fn)
+        }
+    }
+    prog := &parser.Program{Functions: funcs}
+    if err := checker.CheckProgram(prog); err != nil {
+        return err
+    }
+    in, err := interp.New(prog)
+    if err != nil {
+        return err
+    }
+    s.funcs, s.in = funcs, in
+    return nil
+}
+
+func (s *Session) program() *parser.Program {
+    return &parser.Program{Functions: s.funcs}
+}
+
+// types returns the checker's view of the session variables.
+func (s *Session) types() map[string]checker.Type {
+    types := map[string]checker.Type{}
+    for name, v := range s.env {
+        types[name] = checker.Type(v.Type)
+    }
+    return types
+}
+
+// exec checks and runs one statement, printing the value of an expression
+// other than a call of Print.
+func (s *Session) exec(st parser.Statement) error {
+    t, err := checker.CheckStatement(s.program(), s.types(), st)
+    if err != nil {
+        return err
+    }
+    if err := s.undefined(st); err != nil {
+        return err
+    }
+    if t == checker.TNone {
+        fmt.Fprintln(s.out, "none : none")
+        return nil
+    }
+    v, ok, err := s.in.Exec(s.env, st, file, parser.LineOf(st))
+    if err != nil {
+        return err
+    }
+    if ok && !isPrint(st) {
+        fmt.Fprintf(s.out, "%s : %s\n", format(v), v.Type)
+    }
+    return nil
+}
+
+// undefined reports a call in st that the session cannot make. The checker
+// takes any unknown name for a runtime helper returning int, but the session
+// only has its own functions and the helpers the interpreter implements.
+func (s *Session) undefined(st parser.Statement) error {
+    n, ok := st.(parser.Node)
+    if !ok {
+        return nil
+    }
+    name, ok := s.in.Undefined(n)
+    if !ok {
+        return nil
+    }
+    if _, known := checker.LookupBuiltin(name); known {
+        return fmt.Errorf("runtime helper %s is not available in the interpreter", name)
+    }
+    return fmt.Errorf("undefined function %s", name)
+}
+
+func isPrint(st parser.Statement) bool {
+    call, ok := st.(*parser.ExpressionStatement).Expr.(*parser.CallExpression)
+    if !ok {
+        return false
+    }
+    id, ok := call.Function.(*parser.Identifier)
+    return ok && (id.Value == "Print" || id.Value == "print")
+}
+
+// format writes a value as it would appear in source: strings quoted.
+func format(v interp.Value) string {
+    if v.Type == ir.String {
+        return strconv.Quote(v.Str)
+    }
+    if v.Type.IsOptional() && v.Elem != nil {
+        return format(*v.Elem)
+    }
+    return v.String()
+}
@@ -0,0 +1,78 @@
+package repl
+
+import (
+    "bytes"
+    "os"
+    "path/filepath"
+    "strings"
+    "testing"
+)
+
+// TestSession feeds a scripted session through the reader: definitions
+// over several lines, expressions, every command and inputs that fail, which
+// must leave the session usable.
+func TestSession(t *testing.T) {
+    lib := filepath.Join(t.TempDir(), "lib.cw")
+    if err := os.WriteFile(lib, []byte("fn answer() -> int {\n    return 42;\n}\n"), 0o644); err != nil {
+        t.Fatal(err)
+    }
+    script := []string{
+        ":help",
+        "var x: int = 40;",
+        "x + 2",
+        "fn twice() -> string {",
+        `    return "ab" + "ab";`,
+        "}",
+        "twice()",
+        ":type twice()",
+        ":type x < 3",
+        ":type nope()",
+        "nope()",
+        ":ast 1 + 2 * x",
+        "var s: string = 1;",
+        ":load " + lib,
+        "answer() - x",
+        ":bogus",
+        ":reset",
+        "x",
+        ":type twice()",
+        ":quit",
+        "x",
+    }
+    want := help + `42 : int
+"abab" : string
+string
+bool
+error: undefined function nope
+error: undefined function nope
+(+ 1 (* 2 x))
+error: variable s: cannot use int value as string
+loaded 1 functions from ` + lib + `
+2 : int
+error: unknown command :bogus; try :help
+session cleared
+error: undefined: x
+error: undefined function twice
+`
+    var out bytes.Buffer
+    if err := New(&out).Run(strings.NewReader(strings.Join(script, "\n")+"\n"), false); err != nil {
+        t.Fatal(err)
+    }
+    if out.String() != want {
+        t.Errorf("session output:\n%s\nwant:\n%s", out.String(), want)
+    }
+}
+
+func TestIncomplete(t *testing.T) {
+    for src, want := range map[string]bool{
+        "fn f() -> int {":               true,
+        "fn f() -> int { return 1; }":   false,
+        `var s: string = "{";`:          false,
+        "if true { // }":                true,
+        "while x {\n    if y {\n    }": true,
+    } {
+        if got := Incomplete(src); got != want {
+            t.Errorf("Incomplete(%q) = %v, want %v", src, got, want)
+        }
+    }
+}
@@ -0,0 +1,179 @@
+// Clockwise C runtime (generated by runtime/gen_stdlib; do not edit)
+#include "cw_stdio.h"
+
+#include <inttypes.h>
+#include <stdarg.h>
+#include <stdio.h>
+#include <stdlib.h>
+#include <string.h>
+
+int64_t cw_print(cw_string s) {
+    fwrite(s.ptr, 1, s.len, stdout);
+    return 0;
+}
+
+void *cw_alloc(size_t n) {
+    void *p = malloc(n ? n : 1);
+    if (p == NULL) {
+        fputs("fatal error: out of memory\n", stderr);
+        exit(2);
+    }
+    return p;
+}
+
+cw_string cw_concat(int n, ...) {
+    va_list ap;
+    size_t len = 0;
+    va_start(ap, n);
+    for (int i = 0; i < n; i++) {
+        len += va_arg(ap, cw_string).len;
+    }
+    va_end(ap);
+    char *buf = cw_alloc(len);
+    size_t off = 0;
+    va_start(ap, n);
+    for (int i = 0; i < n; i++) {
+        cw_string s = va_arg(ap, cw_string);
+        if (s.len > 0) {
+            memcpy(buf + off, s.ptr, s.len);
+        }
+        off += s.len;
+    }
+    va_end(ap);
+    return (cw_string){buf, len};
+}
+
+int cw_str_cmp(cw_string a, cw_string b) {
+    size_t n = a.len < b.len ? a.len : b.len;
+    int c = n > 0 ? memcmp(a.ptr, b.ptr, n) : 0;
+    if (c != 0) {
+        return c;
+    }
+    return (a.len > b.len) - (a.len < b.len);
+}
+
+static cw_string cw_format(const char *buf) {
+    size_t len = strlen(buf);
+    char *out = cw_alloc(len);
+    memcpy(out, buf, len);
+    return (cw_string){out, len};
+}
+
+cw_string cw_itoa(int64_t v) {
+    char buf[32];
+    snprintf(buf, sizeof buf, "%" PRId64, v);
+    return cw_format(buf);
+}
+
+cw_string cw_utoa(uint64_t v) {
+    char buf[32];
+    snprintf(buf, sizeof buf, "%" PRIu64, v);
+    return cw_format(buf);
+}
+
+cw_string cw_btoa(bool v) {
+    return v ? CW_LIT("true") : CW_LIT("false");
+}
+
+int64_t cw_divs(int64_t a, int64_t b, const char *file, int line, const char *fn) {
+    if (b == 0) {
+        cw_panic_at(CW_LIT("runtime error: integer divide by zero"), file, line, fn);
+    }
+    if (b == -1) {
+        return (int64_t)(0 - (uint64_t)a);
+    }
+    return a / b;
+}
+
+int64_t cw_mods(int64_t a, int64_t b, const char *file, int line, const char *fn) {
+    if (b == 0) {
+        cw_panic_at(CW_LIT("runtime error: integer divide by zero"), file, line, fn);
+    }
+    if (b == -1) {
+        return 0;
+    }
+    return a % b;
+}
+
+uint64_t cw_divu(uint64_t a, uint64_t b, const char *file, int line, const char *fn) {
+    if (b == 0) {
+        cw_panic_at(CW_LIT("runtime error: integer divide by zero"), file, line, fn);
+    }
+    return a / b;
+}
+
+uint64_t cw_modu(uint64_t a, uint64_t b, const char *file, int line, const char *fn) {
+    if (b == 0) {
+        cw_panic_at(CW_LIT("runtime error: integer divide by zero"), file, line, fn);
+    }
+    return a % b;
+}
+
+uint64_t cw_shift_count(int64_t n, const char *file, int line, const char *fn) {
+    if (n < 0) {
+        cw_panic_at(CW_LIT("runtime error: negative shift amount"), file, line, fn);
+    }
+    return (uint64_t)n;
+}
+
+uint64_t cw_shl(uint64_t x, uint64_t n) {
+    return n >= 64 ? 0 : x << n;
+}
+
+uint64_t cw_shr(uint64_t x, uint64_t n) {
+    return n >= 64 ? 0 : x >> n;
+}
+
+int64_t cw_sar(int64_t x, uint64_t n) {
+    if (n >= 64) {
+        return x < 0 ? -1 : 0;
+    }
+    return x >> n;
+}
+
+struct cw_defer {
+    void (*fn)(void *);
+    void *args;
+    cw_defer *next;
+};
+
+cw_defer *cw_defers = NULL;
+
+void cw_defer_push(void (*fn)(void *), void *args) {
+    cw_defer *d = cw_alloc(sizeof *d);
+    d->fn = fn;
+    d->args = args;
+    d->next = cw_defers;
+    cw_defers = d;
+}
+
+void cw_defer_run(cw_defer *mark) {
+    while (cw_defers != mark) {
+        cw_defer *d = cw_defers;
+        cw_defers = d->next;
+        d->fn(d->args);
+    }
+}
+
+cw_frame *cw_frames = NULL;
+
+void cw_panic_at(cw_string msg, const char *file, int line, const char *fn) {
+    if (cw_frames != NULL) {
+        cw_frames->line = line;
+    }
+    // deferred calls run first, as in the Go backend
+    cw_defer_run(NULL);
+    fflush(stdout);
+    // Clockwise functions are emitted as cwf_<name>
+    if (strncmp(fn, "cwf_", 4) == 0) {
+        fn += 4;
+    }
+    fprintf(stderr, "%s:%d: panic in %s: %.*s\n", file, line, fn, (int)msg.len, msg.ptr);
+    if (cw_frames != NULL) {
+        fputs("backtrace:\n", stderr);
+        for (cw_frame *f = cw_frames; f != NULL; f = f->up) {
+            fprintf(stderr, "    %s at %s:%d\n", f->fn, f->file, f->line);
+        }
+    }
+    exit(2);
+}
@@ -0,0 +1,71 @@
+// Clockwise C runtime (generated by runtime/gen_stdlib; do not edit)
+#ifndef CW_STDIO_H
+#define CW_STDIO_H
+
+#include <stdbool.h>
+#include <stddef.h>
+#include <stdint.h>
+
+// cw_string is an immutable string: len bytes at ptr, not NUL-terminated.
+typedef struct {
+    const char *ptr;
+    size_t len;
+} cw_string;
+
+// CW_LIT makes a cw_string from a C string literal.
+#define CW_LIT(s) ((cw_string){(s), sizeof(s) - 1})
+
+// CW_NORETURN marks functions that do not return, where the compiler
+// supports it.
+#if defined(__GNUC__) || defined(__clang__)
+#define CW_NORETURN __attribute__((noreturn))
+#else
+#define CW_NORETURN
+#endif
+
+// CW_AT passes the current source position to helpers that may panic.
+#define CW_AT __FILE__, __LINE__, __func__
+
+int64_t cw_print(cw_string s);
+
+cw_string cw_concat(int n, ...);
+int cw_str_cmp(cw_string a, cw_string b);
+cw_string cw_itoa(int64_t v);
+cw_string cw_utoa(uint64_t v);
+cw_string cw_btoa(bool v);
+
+// Integer operations with Go's semantics: division by zero panics, the
+// most negative value divided by -1 wraps, and shifts by at least the
+// width of the operand yield 0 (or -1 for negative values shifted right).
+int64_t cw_divs(int64_t a, int64_t b, const char *file, int line, const char *fn);
+int64_t cw_mods(int64_t a, int64_t b, const char *file, int line, const char *fn);
+uint64_t cw_divu(uint64_t a, uint64_t b, const char *file, int line, const char *fn);
+uint64_t cw_modu(uint64_t a, uint64_t b, const char *file, int line, const char *fn);
+uint64_t cw_shift_count(int64_t n, const char *file, int line, const char *fn);
+uint64_t cw_shl(uint64_t x, uint64_t n);
+uint64_t cw_shr(uint64_t x, uint64_t n);
+int64_t cw_sar(int64_t x, uint64_t n);
+
+// Deferred calls form one stack shared by all frames. A function records the
+// top on entry and runs the calls above it before returning; a panic runs
+// them all.
+typedef struct cw_defer cw_defer;
+extern cw_defer *cw_defers;
+void *cw_alloc(size_t n);
+void cw_defer_push(void (*fn)(void *), void *args);
+void cw_defer_run(cw_defer *mark);
+
+// The active Clockwise calls form a list, innermost first, for the
+// backtrace of a panic. A function pushes its frame on entry and pops it on
+// return, and records the line of each call it makes.
+typedef struct cw_frame {
+    const char *fn;
+    const char *file;
+    int line;
+    struct cw_frame *up;
+} cw_frame;
+extern cw_frame *cw_frames;
+
+CW_NORETURN void cw_panic_at(cw_string msg, const char *file, int line, const char *fn);
+
+#endif
@@ -1,3 +1,6 @@
+// WebAssembly hosts offer no name resolution.
+//go:build !wasm
+
 package runtimelib
 
 import (
@@ -1,3 +1,6 @@
+// Processes cannot be started from WebAssembly.
+//go:build !wasm
+
 package runtimelib
 
 import (
@@ -1,33 +1,35 @@
-package runtimelib
+package main
 
 import (
     "flag"
     "fmt"
     "os"
     "path/filepath"
+
+    "codeberg.org/clockwise-lang/clockwise/codegen/cgen"
 )
 
-// gen_stdlib writes a small C stdlib into runtime/cw_stdio.c. This allows
-// using Go to author and regenerate the C runtime sources deterministically.
+// gen_stdlib writes the C runtime used by --backend=c into runtime/c, apart
+// from the runtime Go package. cwc embeds the same sources; the files on
+// disk are for building generated C by hand and for review.
 func main() {
-    out := flag.String("out", "runtime/cw_stdio.c", "output C runtime path")
+    out := flag.String("out", "runtime/c", "output directory for the C runtime")
     flag.Parse()
 
-    data := `// Clockwise runtime (generated)
-#include <stdio.h>
-
-void cw_print(const char *s) {
-    fputs(s, stdout);
-}
-`
-
-    if err := os.MkdirAll(filepath.Dir(*out), 0755); err != nil {
+    if err := os.MkdirAll(*out, 0755); err != nil {
         fmt.Fprintln(os.Stderr, "failed to create runtime dir:", err)
         os.Exit(1)
     }
-    if err := os.WriteFile(*out, []byte(data), 0644); err != nil {
-        fmt.Fprintln(os.Stderr, "failed to write runtime file:", err)
-        os.Exit(1)
+    files := []struct{ name, data string }{
+        {cgen.RuntimeHeaderName, cgen.RuntimeHeader},
+        {cgen.RuntimeSourceName, cgen.RuntimeSource},
+    }
+    for _, f := range files {
+        path := filepath.Join(*out, f.name)
+        if err := os.WriteFile(path, []byte(f.data), 0644); err != nil {
+            fmt.Fprintln(os.Stderr, "failed to write runtime file:", err)
+            os.Exit(1)
+        }
+        fmt.Println("wrote", path)
     }
-    fmt.Println("wrote", *out)
 }
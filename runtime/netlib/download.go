@@ -0,0 +1,26 @@
+// DownloadFile also needs a filesystem, which js/wasm lacks.
+//go:build !wasm
+
+package runtimelib
+
+import (
+    "io"
+    "net/http"
+    "os"
+)
+
+// DownloadFile downloads url to path; returns error or nil
+func DownloadFile(url, path string) error {
+    resp, err := http.Get(url)
+    if err != nil {
+        return err
+    }
+    defer resp.Body.Close()
+    out, err := os.Create(path)
+    if err != nil {
+        return err
+    }
+    defer out.Close()
+    _, err = io.Copy(out, resp.Body)
+    return err
+}
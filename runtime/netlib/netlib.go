@@ -1,10 +1,12 @@
+// The HTTP helpers dial TCP connections, and WASI preview 1 has no socket API.
+//go:build !wasip1
+
 package runtimelib
 
 import (
     "bytes"
     "io"
     "net/http"
-    "os"
 )
 
 // Simple HTTP GET returning body as string (empty on error)
@@ -48,19 +50,3 @@ func HttpPost(url, body string) string {
     }
     return string(b)
 }
-
-// DownloadFile downloads url to path; returns error or nil
-func DownloadFile(url, path string) error {
-    resp, err := http.Get(url)
-    if err != nil {
-        return err
-    }
-    defer resp.Body.Close()
-    out, err := os.Create(path)
-    if err != nil {
-        return err
-    }
-    defer out.Close()
-    _, err = io.Copy(out, resp.Body)
-    return err
-}
@@ -1,3 +1,7 @@
+// net/http's default client sends requests through fetch on js/wasm but has
+// no transport it can use on wasip1.
+//go:build !wasip1
+
 package runtimelib
 
 import (
@@ -0,0 +1,13 @@
+// exit: 1
+// Functions take no arguments; passing one is an error even in a branch
+// that never runs.
+fn one() -> int {
+    return 1;
+}
+
+fn main() -> int {
+    if false {
+        Print("${one(1)}\n");
+    }
+    return 0;
+}
@@ -0,0 +1,15 @@
+// exit: 2
+// A failed assert panics with its message.
+fn check() -> int {
+    var n: u8 = 3;
+    assert(n == 3, "unreachable");
+    assert(n > 3);
+    return 0;
+}
+
+fn main() -> int {
+    defer Print("deferred in main\n");
+    var r: int = check();
+    assert(r == 1, "not reached");
+    return r;
+}
@@ -0,0 +1,29 @@
+// exit: 3
+// Control flow, scoping and the exit status of main.
+fn classify() -> string {
+    var n: int = 15;
+    if n % 15 == 0 {
+        return "fizzbuzz";
+    } else if n % 5 == 0 {
+        return "buzz";
+    }
+    return "other";
+}
+
+fn main() -> int {
+    var x: int = 1;
+    if x == 1 {
+        var x: string = "shadowed";
+        Print("${x}\n");
+    }
+    Print("${x} ${classify()}\n");
+    while x > 5 {
+        Print("never\n");
+    }
+    if true {
+        Print("then\n");
+    } else {
+        Print("else\n");
+    }
+    return 3;
+}
@@ -0,0 +1,23 @@
+// Deferred calls run last-in, first-out with arguments evaluated early.
+fn label() -> string {
+    Print("label evaluated\n");
+    return "deferred with label\n";
+}
+
+fn work() -> int {
+    defer Print("work: first registered\n");
+    defer Print(label());
+    defer print("work: last registered\n");
+    if true {
+        Print("work: returning\n");
+        return 1;
+    }
+    return 0;
+}
+
+fn main() -> int {
+    defer Print("main done\n");
+    var r: int = work();
+    Print("work=${r}\n");
+    return 0;
+}
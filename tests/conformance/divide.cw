@@ -0,0 +1,18 @@
+// exit: 2
+// Dividing by zero at run time is a panic at the dividing line.
+fn zero() -> int {
+    return 0;
+}
+
+fn divide() -> int {
+    defer Print("cleanup\n");
+    var d: int = zero();
+    return 10 / d;
+}
+
+fn main() -> int {
+    Print("before\n");
+    var r: int = divide();
+    Print("after ${r}\n");
+    return 0;
+}
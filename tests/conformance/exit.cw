@@ -0,0 +1,7 @@
+// exit: 42
+// main's result is the exit status; deferred calls still run.
+fn main() -> int {
+    defer Print("bye\n");
+    var code: u8 = 42;
+    return code;
+}
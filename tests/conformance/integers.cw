@@ -0,0 +1,35 @@
+// Integer arithmetic wraps at the width of each type.
+fn main() -> int {
+    var a: i8 = 127;
+    var b: i8 = a + 1;
+    var c: u8 = 255;
+    var d: u8 = c + 1;
+    var e: i16 = -32768;
+    var f: i16 = e - 1;
+    var g: u16 = 0;
+    var h: u16 = g - 1;
+    var i: i32 = 2147483647;
+    var j: i32 = i * 2;
+    var k: u32 = 4294967295;
+    var l: u32 = k * k;
+    var m: int = -9223372036854775807 - 1;
+    var n: int = m - 1;
+    var o: u64 = 18446744073709551615;
+    var p: u64 = o + 2;
+    Print("i8 ${b} u8 ${d} i16 ${f} u16 ${h}\n");
+    Print("i32 ${j} u32 ${l} int ${n} u64 ${p}\n");
+    var q: int = -7;
+    Print("div ${q / 2} ${q % 2} ${7 / -2} ${7 % -2}\n");
+    var r: u8 = 200;
+    Print("udiv ${r / 3} ${r % 7}\n");
+    Print("neg ${-a} ${-m} ${~c} ${~q}\n");
+    Print("bits ${q & 12} ${q | 3} ${q ^ 5}\n");
+    var s: int = 1;
+    Print("shl ${s << 63} ${s << 64} ${1 << 40}\n");
+    Print("shr ${m >> 1} ${m >> 100} ${o >> 60}\n");
+    var t: i8 = -1;
+    Print("conv ${u8(t)} ${u16(t)} ${i64(t)} ${u64(t)} ${i8(u8(t))}\n");
+    var u: int = 300;
+    Print("trunc ${u8(u)} ${i8(u)} ${u16(u * 1000)}\n");
+    return 0;
+}
@@ -0,0 +1,13 @@
+// exit: 1
+// Loop conditions are not evaluated when checking that a function returns,
+// so a function ending in `while true` needs a return after the loop.
+fn first() -> int {
+    var n: int = 5;
+    while true {
+        return n;
+    }
+}
+
+fn main() -> int {
+    return first();
+}
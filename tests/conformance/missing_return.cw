@@ -0,0 +1,16 @@
+// exit: 1
+// A function that can run off its end is rejected before anything runs,
+// even when the end is never reached.
+fn sign() -> int {
+    var n: int = 3;
+    if n > 0 {
+        return 1;
+    } else if n < 0 {
+        return -1;
+    }
+}
+
+fn main() -> int {
+    Print("never printed\n");
+    return sign();
+}
@@ -0,0 +1,38 @@
+// Optionals: none, ??, if let and helpers that may have no result.
+fn nothing() -> string? {
+    return none;
+}
+
+fn seven() -> u8? {
+    return 7;
+}
+
+fn fallback() -> string {
+    Print("fallback evaluated\n");
+    return "fb";
+}
+
+fn main() -> int {
+    var a: string = nothing() ?? "default";
+    var b: u8 = seven() ?? 0;
+    var c: string = nothing() ?? GetEnv("CLOCKWISE_CONFORMANCE_UNSET") ?? "last";
+    var d: string = Base64Decode("aGk=") ?? fallback();
+    var e: string = Base64Decode("!!") ?? fallback();
+    Print("${a} ${b} ${c} ${d} ${e}\n");
+    if let v = seven() {
+        Print("got ${v}\n");
+    } else {
+        Print("none\n");
+    }
+    if let v = nothing() {
+        Print("got ${v}\n");
+    } else {
+        Print("none\n");
+    }
+    var size: i64 = FileSize("/nonexistent/clockwise") ?? -1;
+    Print("size ${size}\n");
+    var maybe: int? = none;
+    var chained: int? = maybe ?? none;
+    Print("${chained ?? 5}\n");
+    return 0;
+}
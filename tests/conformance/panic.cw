@@ -0,0 +1,17 @@
+// exit: 2
+// A panic runs the pending deferred calls, then reports a backtrace.
+fn inner() -> int {
+    defer Print("inner cleanup\n");
+    panic("something broke");
+}
+
+fn outer() -> int {
+    defer Print("outer cleanup\n");
+    var v: int = inner();
+    return v;
+}
+
+fn main() -> int {
+    Print("start\n");
+    return outer();
+}
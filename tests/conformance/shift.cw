@@ -0,0 +1,13 @@
+// exit: 2
+// Shifting by a negative count panics.
+fn count() -> int {
+    return -1;
+}
+
+fn main() -> int {
+    var one: int = 1;
+    Print("${one << 3}\n");
+    var n: int = count();
+    Print("${one << n}\n");
+    return 0;
+}
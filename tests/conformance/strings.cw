@@ -0,0 +1,25 @@
+// Strings, interpolation, comparisons and string helpers.
+fn greet() -> string {
+    return "Hello";
+}
+
+fn main() -> int {
+    var name: string = "Clockwise";
+    var n: u8 = 42;
+    var ok: bool = name == "Clockwise";
+    Print("${greet()}, ${name}! n=${n} ok=${ok} \${literal}\n");
+    Print("cmp ${"abc" < "abd"} ${"b" > "abc"} ${"x" != "x"}\n");
+    var joined: string = name + " " + "lang";
+    Print(joined + "\n");
+    Print(ToUpper(joined) + "\n");
+    Print("[" + Trim("  padded  ") + "]\n");
+    Print(Slice("abcdef", 1, 4) + "\n");
+    Print(HexEncode("hi") + " " + Base64Encode("hi") + "\n");
+    Print(SHA256Hex("abc") + "\n");
+    Print(CRC32Hex("abc") + " " + JSONEscape("a\"b\n") + "\n");
+    Print(URLEncode("a b&c") + " " + JoinURL("http://x.io/", "/y") + "\n");
+    Print("${Abs(-5)} ${Min(3, 9)} ${Max(3, 9)} ${IsEmail("a@b.io")}\n");
+    Print("${RegexMatch("^a+b$", "aaab")} " + RegexReplaceAll("[0-9]+", "a1b22", "#") + "\n");
+    print("done\n");
+    return 0;
+}
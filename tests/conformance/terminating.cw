@@ -0,0 +1,21 @@
+// Functions may end in an if whose arms all return or panic.
+fn classify() -> string {
+    var n: int = 7;
+    if n % 2 == 0 {
+        return "even";
+    } else if n > 5 {
+        return "big odd";
+    } else {
+        panic("small odd");
+    }
+}
+
+fn main() -> int {
+    var o: string? = GetEnv("CW_CONFORMANCE_UNSET");
+    if let v = o {
+        return 1;
+    } else {
+        Print("${classify()}\n");
+        return 0;
+    }
+}